@@ -1,12 +1,32 @@
 package main
 
 import (
+	"context"
+	stdLog "log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/imperatorofdwelling/Website-backend/config"
 	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
 )
 
 func main() {
 	cfg := config.LoadConfig("")
-	log := logger.New(logger.EnvLocal)
-	cfg.Run(log)
+	log, logCloser, err := logger.New(cfg.Env, cfg.Logger)
+	if err != nil {
+		stdLog.Fatal(err)
+	}
+	defer logCloser.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err = cfg.Run(ctx, log); err != nil {
+		log.Error("service failed", slog.String("error", err.Error()))
+		// Deferred calls aren't run by os.Exit
+		_ = logCloser.Close()
+		os.Exit(1)
+	}
 }
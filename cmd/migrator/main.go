@@ -2,44 +2,166 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/imperatorofdwelling/Website-backend/config"
 	"log"
-)
+	"os"
+	"strconv"
 
-const (
-	migrationsPath = "./pkg/repository/postgres/migrations"
+	"github.com/imperatorofdwelling/Website-backend/config"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/golang-migrate/migrate/v4"
 )
 
+const usage = `usage: migrator [--dry-run] [command]
+
+commands:
+  up [N]     apply N or all pending migrations (default)
+  down [N]   roll back N migrations, 1 by default
+  goto V     migrate up or down to version V
+  force V    set version V without running migrations and clear dirty state (-1 is no version)
+  version    print applied version
+  status     print applied and pending migrations
+`
+
+// migrator applies migrations embedded into the binary, so it works from any directory.
+// With --dry-run SQL of migrations that would be run is printed and the database isn't changed.
 func main() {
-	cfg := config.LoadConfig("")
-	dbCfg := cfg.PostgresSQLConfig
-
-	url := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
-		dbCfg.DBUsername,
-		dbCfg.DBPassword,
-		dbCfg.DBHost,
-		dbCfg.DBPort,
-		dbCfg.DBName,
-		dbCfg.DBSSLMode,
-	)
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		url,
-	)
+	dryRun := flag.Bool("dry-run", false, "print SQL of migrations instead of running them")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	args := parseArgs()
+
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	arg, err := parseArg(command, args)
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	cfg := config.LoadConfig("", config.SectionPostgres)
+	m, err := postgres.NewMigrate(cfg.PostgresSQLConfig)
 	if err != nil {
-		log.Printf("%+v\n", dbCfg)
 		log.Println("failed to connect to DB")
 		log.Fatal(err)
 	}
-	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("no migrations to apply")
+	defer m.Close()
+
+	switch command {
+	case "version":
+		err = printVersion(m)
+	case "status":
+		err = printStatus(m)
+	case "force":
+		if *dryRun {
+			fmt.Printf("version would be forced to %v\n", arg)
 			return
 		}
+		err = m.Force(arg)
+	default:
+		if *dryRun {
+			err = printPlan(m, command, arg)
+		} else {
+			err = run(m, command, arg)
+		}
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no migrations to apply")
+		return
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("migrations applied successfully")
+	if !*dryRun && command != "version" && command != "status" {
+		if err = printVersion(m); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// parseArgs parses flags placed before, between and after the positional arguments
+func parseArgs() []string {
+	var args []string
+	rest := os.Args[1:]
+	for {
+		_ = flag.CommandLine.Parse(rest)
+		rest = flag.Args()
+		if len(rest) == 0 {
+			return args
+		}
+		args, rest = append(args, rest[0]), rest[1:]
+	}
+}
+
+// parseArg returns numeric argument of the command, it's 0 if the argument is optional and omitted
+func parseArg(command string, args []string) (int, error) {
+	switch command {
+	case "version", "status":
+		if len(args) > 0 {
+			return 0, fmt.Errorf("%v has no arguments", command)
+		}
+		return 0, nil
+	case "up", "down":
+		if len(args) == 0 {
+			if command == "down" {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case "goto", "force":
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%v needs version", command)
+		}
+	default:
+		return 0, fmt.Errorf("unknown command %q", command)
+	}
+	if len(args) > 1 {
+		return 0, fmt.Errorf("%v has one argument", command)
+	}
+	n, err := strconv.Atoi(args[0])
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("%v: %w", command, err)
+	case command == "force" && n < -1, command == "goto" && n < 0, (command == "up" || command == "down") && n <= 0:
+		return 0, fmt.Errorf("%v: invalid argument %v", command, n)
+	}
+	return n, nil
+}
+
+func run(m *migrate.Migrate, command string, arg int) error {
+	switch command {
+	case "up":
+		if arg == 0 {
+			return m.Up()
+		}
+		return m.Steps(arg)
+	case "down":
+		return m.Steps(-arg)
+	case "goto":
+		return m.Migrate(uint(arg))
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		fmt.Printf("version %v (dirty, fix the database and force the version)\n", version)
+		return nil
+	}
+	fmt.Printf("version %v\n", version)
+	return nil
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+type migration struct {
+	version uint
+	name    string
+}
+
+// step is migration run up or down
+type step struct {
+	migration
+	up bool
+}
+
+// listMigrations returns embedded migrations in ascending order
+func listMigrations(src source.Driver) ([]migration, error) {
+	var migrations []migration
+	version, err := src.First()
+	for err == nil {
+		m := migration{version: version}
+		if m.name, err = readName(src, version); err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+func readName(src source.Driver, version uint) (string, error) {
+	r, name, err := src.ReadUp(version)
+	if err != nil {
+		return "", err
+	}
+	return name, r.Close()
+}
+
+// current returns the applied version and its index in migrations, -1 if nothing is applied
+func current(m *migrate.Migrate, migrations []migration) (uint, int, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, -1, nil
+	}
+	if err != nil {
+		return 0, -1, err
+	}
+	if dirty {
+		return version, -1, migrate.ErrDirty{Version: int(version)}
+	}
+	for i, mg := range migrations {
+		if mg.version == version {
+			return version, i, nil
+		}
+	}
+	return version, -1, fmt.Errorf("applied version %v isn't among the migrations", version)
+}
+
+func printStatus(m *migrate.Migrate) error {
+	src, err := postgres.MigrationsSource()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	migrations, err := listMigrations(src)
+	if err != nil {
+		return err
+	}
+	if err = printVersion(m); err != nil {
+		return err
+	}
+	fmt.Printf("versions supported by the service %v-%v\n\n", postgres.SchemaVersion, postgres.MaxSchemaVersion)
+
+	version, _, err := m.Version()
+	applied := err == nil
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	for _, mg := range migrations {
+		state := "pending"
+		if applied && mg.version <= version {
+			state = "applied"
+		}
+		fmt.Printf("%-8v %06d %v\n", state, mg.version, mg.name)
+	}
+	return nil
+}
+
+// plan returns migrations which are run by the command
+func plan(migrations []migration, index int, command string, arg int) ([]step, error) {
+	var steps []step
+	down := func(to int) {
+		for i := index; i > to; i-- {
+			steps = append(steps, step{migration: migrations[i]})
+		}
+	}
+	up := func(to int) {
+		for i := index + 1; i <= to; i++ {
+			steps = append(steps, step{migration: migrations[i], up: true})
+		}
+	}
+
+	switch command {
+	case "up":
+		to := len(migrations) - 1
+		if arg > 0 && index+arg < to {
+			to = index + arg
+		}
+		up(to)
+	case "down":
+		down(max(index-arg, -1))
+	case "goto":
+		target := -1
+		for i, mg := range migrations {
+			if mg.version == uint(arg) {
+				target = i
+			}
+		}
+		if target == -1 {
+			return nil, fmt.Errorf("there is no migration %v", arg)
+		}
+		up(target)
+		down(target)
+	default:
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+	if len(steps) == 0 {
+		return nil, migrate.ErrNoChange
+	}
+	return steps, nil
+}
+
+// printPlan prints SQL of migrations which would be run by the command
+func printPlan(m *migrate.Migrate, command string, arg int) error {
+	src, err := postgres.MigrationsSource()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	migrations, err := listMigrations(src)
+	if err != nil {
+		return err
+	}
+	_, index, err := current(m, migrations)
+	if err != nil {
+		return err
+	}
+	steps, err := plan(migrations, index, command, arg)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range steps {
+		read, direction := src.ReadDown, "down"
+		if s.up {
+			read, direction = src.ReadUp, "up"
+		}
+		r, _, err := read(s.version)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("-- %06d_%v.%v.sql\n", s.version, s.name, direction)
+		_, err = io.Copy(os.Stdout, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
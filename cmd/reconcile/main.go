@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/config"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/reconcile"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// reconcile compares YooKassa payments, payouts and refunds created in [from, to) with local records
+// and saves the discrepancy report as JSON and CSV. Safe status mismatches are corrected unless -dry-run is set.
+//
+// Dates are UTC days (YYYY-MM-DD), by default the previous day is reconciled.
+func main() {
+	yesterday, today := reconcile.Day(time.Now())
+	fromFlag := flag.String("from", yesterday.Format(time.DateOnly), "first reconciled day (UTC)")
+	toFlag := flag.String("to", "", "last reconciled day (UTC), -from by default")
+	out := flag.String("out", "", "directory of the report, reconcile.reportDir by default")
+	dryRun := flag.Bool("dry-run", false, "only report discrepancies, don't correct statuses")
+	flag.Parse()
+
+	from, err := time.Parse(time.DateOnly, *fromFlag)
+	if err != nil {
+		log.Fatalf("invalid -from: %v", err)
+	}
+	to := from
+	if *toFlag != "" {
+		if to, err = time.Parse(time.DateOnly, *toFlag); err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+	}
+	if to.Before(from) {
+		log.Fatal("-to is before -from")
+	}
+	// The last day is included
+	to = to.AddDate(0, 0, 1)
+	if to.After(today) {
+		log.Printf("warning: %v isn't over yet, recent objects may be reported as discrepancies", today.Format(time.DateOnly))
+	}
+
+	cfg := config.LoadConfig("", config.SectionLogger, config.SectionPostgres, config.SectionYooKassa,
+		config.SectionReconcile)
+	logs, logCloser, err := logger.New(cfg.Env, cfg.Logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logCloser.Close()
+
+	if err = postgres.InitPostgresDB(cfg.PostgresSQLConfig); err != nil {
+		log.Println("failed to connect to DB")
+		log.Fatal(err)
+	}
+	db, _ := postgres.GetDB()
+	defer postgres.Disconnect()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reconciler := reconcile.NewReconciler(logs, endpoints.NewYooKassaSource(yookassa.NewClient(cfg.YooKassa)), postgres.NewLogRepository(db),
+		postgres.NewAuditRepository(db), !*dryRun)
+	// Corrected final statuses are recorded in the ledger like the ones found by status checks
+	splitter := endpoints.NewSplitter(logs, postgres.NewLedgerRepository(db), postgres.NewCommissionRepository(db))
+	reconciler.SetFinalHook(splitter.OnReconciledStatus)
+	report, err := reconciler.Run(ctx, from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir := *out
+	if dir == "" {
+		dir = cfg.Reconcile.ReportDir
+	}
+	name := fmt.Sprintf("reconcile-%v", from.Format(time.DateOnly))
+	if last := to.AddDate(0, 0, -1); !last.Equal(from) {
+		name += "-" + last.Format(time.DateOnly)
+	}
+	paths, err := reconcile.SaveReport(dir, name, report)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%v discrepancies, %v fixed, report: %v\n", len(report.Discrepancies), report.Fixed, paths)
+	if report.Unaudited > 0 {
+		log.Fatalf("%v fixes aren't audited, see unaudited discrepancies of the report", report.Unaudited)
+	}
+}
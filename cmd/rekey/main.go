@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/imperatorofdwelling/Website-backend/config"
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// rekey generates a new data key and re-encrypts all card synonyms with it.
+//
+// To rotate the master key, configure the new one (CARD_MASTER_KEY / CARD_MASTER_KEY_FILE)
+// and pass the previous one with -previous-master-key-file, so that old data keys can be unwrapped.
+func main() {
+	previousID := flag.String("previous-master-key-id", "default", "ID of the previous master key")
+	previousFile := flag.String("previous-master-key-file", "", "path to the previous master keyfile")
+	generate := flag.Bool("generate", false, "print a new random master key and exit")
+	flag.Parse()
+
+	if *generate {
+		key, err := keyring.GenerateKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	cfg := config.LoadConfig("", config.SectionPostgres, config.SectionKeyring)
+	if err := postgres.InitPostgresDB(cfg.PostgresSQLConfig); err != nil {
+		log.Println("failed to connect to DB")
+		log.Fatal(err)
+	}
+	db, _ := postgres.GetDB()
+	defer postgres.Disconnect()
+
+	ctx := context.Background()
+	master, err := keyring.LoadMasterKey(cfg.KeyringConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	masters := []*keyring.MasterKey{master}
+	if *previousFile != "" {
+		previous, err := keyring.LoadMasterKey(&keyring.Config{
+			MasterKeyID:   *previousID,
+			MasterKeyFile: *previousFile,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		masters = append(masters, previous)
+	}
+
+	ring, err := keyring.New(ctx, postgres.NewKeyRepository(db), masters...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyID, err := ring.Rotate(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetCardCipher(ring)
+
+	updated, err := db.ReEncryptRefillableCards(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("new data key %v is active, %v cards re-encrypted\n", keyID, updated)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/config"
+	"github.com/imperatorofdwelling/Website-backend/internal/reports"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// report exports a financial report as CSV or XLSX. Rows are written as they are selected,
+// so large reports aren't loaded into memory.
+//
+// Dates are UTC days (YYYY-MM-DD), both are included. By default the previous month is exported.
+func main() {
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	name := flag.String("report", reports.Turnover, "report: "+reports.Names())
+	from := flag.String("from", thisMonth.AddDate(0, -1, 0).Format(time.DateOnly), "first day (UTC)")
+	to := flag.String("to", thisMonth.AddDate(0, 0, -1).Format(time.DateOnly), "last day (UTC)")
+	currency := flag.String("currency", "", "currency, all currencies by default")
+	period := flag.String("period", "day", "period of turnover and commissions: day, week or month")
+	format := flag.String("format", reports.FormatCSV, "format: csv or xlsx")
+	out := flag.String("out", "", "output file, <report>-<from>-<to>.<format> by default, - is stdout")
+	flag.Parse()
+
+	if !reports.IsReport(*name) {
+		log.Fatal(reports.ErrUnknownReport)
+	}
+	filter, err := reports.ParseFilter(*from, *to, *currency, *period)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := config.LoadConfig("", config.SectionPostgres)
+	if err = postgres.InitPostgresDB(cfg.PostgresSQLConfig); err != nil {
+		log.Println("failed to connect to DB")
+		log.Fatal(err)
+	}
+	db, _ := postgres.GetDB()
+	defer postgres.Disconnect()
+
+	path := *out
+	if path == "" {
+		path = reports.FileName(*name, filter, *format)
+	}
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	rw, err := reports.NewWriter(w, *format, *name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = reports.Write(context.Background(), postgres.NewReportRepository(db), *name, filter, rw)
+	if err = errors.Join(err, rw.Close()); err != nil {
+		log.Fatal(err)
+	}
+	if path != "-" {
+		fmt.Printf("%v report is written to %v\n", *name, path)
+	}
+}
@@ -1,93 +1,385 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
 
 	"log/slog"
 
+	"github.com/imperatorofdwelling/Website-backend/internal/billing"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
 	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/reconcile"
 	"github.com/imperatorofdwelling/Website-backend/internal/server/http"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
 
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/redis"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+const (
+	// ConfigPathEnv points to YAML config, if it's set the file must exist
+	ConfigPathEnv     = "CONFIG_PATH"
+	defaultConfigPath = "config.yaml"
+	defaultEnvPath    = ".env"
+)
+
+// Sections of Config by YAML key. Binaries other than the service validate only the sections they use.
+const (
+	SectionLogger    = "logger"
+	SectionPostgres  = "postgres"
+	SectionKeyring   = "keyring"
+	SectionYooKassa  = "yookassa"
+	SectionReconcile = "reconcile"
+)
+
+// Config is loaded in order: defaults (`default` tags), optional YAML file,
+// environment variables (`env` tags, .env file is loaded into environment first).
 type Config struct {
-	Server            *http.ServerConfig
-	PostgresSQLConfig *postgres.PostgresSQLConfig
-	RedisConfig       *redis.RedisConfig
+	// Env is the logger environment: local or prod
+	Env               string                      `yaml:"env" env:"APP_ENV" default:"local"`
+	Logger            *logger.Config              `yaml:"logger"`
+	Server            *http.ServerConfig          `yaml:"server"`
+	PostgresSQLConfig *postgres.PostgresSQLConfig `yaml:"postgres"`
+	RedisConfig       *redis.RedisConfig          `yaml:"redis"`
+	KeyringConfig     *keyring.Config             `yaml:"keyring"`
+	YooKassa          *metrics.YooKassaConfig     `yaml:"yookassa"`
+	Checker           *metrics.CheckerConfig      `yaml:"checker"`
+	Tracing           *tracing.Config             `yaml:"tracing"`
+	Billing           *billing.Config             `yaml:"billing"`
+	Reconcile         *reconcile.Config           `yaml:"reconcile"`
 }
 
-func LoadConfig(envFilePath string) *Config {
-	err := loadDotEnv(envFilePath)
-	if err != nil {
-		log.Fatal(err)
+func (c *Config) Validate() error {
+	switch c.Env {
+	case logger.EnvLocal, logger.EnvProd:
+		return nil
 	}
-	serverConfig, err := http.LoadConfig()
+	return fmt.Errorf("env (APP_ENV) must be %v or %v, got %q", logger.EnvLocal, logger.EnvProd, c.Env)
+}
+
+// String returns config as YAML with secrets redacted, so it's safe to print
+func (c *Config) String() string {
+	out, err := yaml.Marshal(redact(reflect.ValueOf(c)))
 	if err != nil {
-		log.Fatal(err)
+		return err.Error()
 	}
-	postgresSQLConfig, err := postgres.LoadConfig()
-	if err != nil {
-		log.Fatal(err)
+	return string(out)
+}
+
+// Load reads config, envFilePath and YAML path (CONFIG_PATH) are optional.
+// Only the listed sections are validated, all of them if there are none.
+func Load(envFilePath string, sections ...string) (*Config, error) {
+	if err := loadDotEnv(envFilePath); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+	yamlPath, explicit := os.LookupEnv(ConfigPathEnv)
+	if !explicit || yamlPath == "" {
+		yamlPath, explicit = defaultConfigPath, false
+	}
+	if err := applyYAML(cfg, yamlPath, explicit); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := validate(cfg, sections); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
 	}
-	redisConfig, err := redis.LoadRedisConfig()
+	return cfg, nil
+}
+
+func LoadConfig(envFilePath string, sections ...string) *Config {
+	cfg, err := Load(envFilePath, sections...)
 	if err != nil {
 		log.Fatal(err)
 	}
-	cfg := &Config{
-		Server:            serverConfig,
-		PostgresSQLConfig: postgresSQLConfig,
-		RedisConfig:       redisConfig,
-	}
-	fmt.Printf("%+v\n", cfg.RedisConfig)
 	return cfg
 }
 
-func (c *Config) Run(logger *slog.Logger) {
+// Run starts the service and blocks until ctx is canceled (SIGINT, SIGTERM) or the server fails.
+// After that the service is stopped gracefully, see Shutdown. The error of the failed start or server
+// is returned, so the process exits with non-zero code.
+func (c *Config) Run(ctx context.Context, logger *slog.Logger) error {
+	// OpenTelemetry
+	shutdownTracing, err := tracing.Init(ctx, c.Tracing)
+	if err != nil {
+		return err
+	}
+
 	// PostgresSQL
-	err := postgres.InitPostgresDB(c.PostgresSQLConfig)
+	err = postgres.InitPostgresDB(c.PostgresSQLConfig)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	db, _ := postgres.GetDB()
-	repo := postgres.NewLogRepository(db)
+	// Migrations are applied by cmd/migrator, the service doesn't start with another schema
+	if err = db.CheckSchema(ctx); err != nil {
+		return err
+	}
+
+	// Card synonyms encryption
+	if err = c.InitCardCipher(ctx, db); err != nil {
+		return err
+	}
 
 	// Redis
 	err = redis.InitRedis(c.RedisConfig)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	rdb, _ := redis.GetCurrRedisDB()
+
+	// To init receipt defaults, descriptions and polling limits
+	metrics.Init(c.YooKassa, c.Checker)
+	logger.Debug("config loaded", slog.String("config", c.String()))
+	gateway := yookassa.NewClient(c.YooKassa)
+
+	deps := &http.Dependencies{
+		Logs:          postgres.NewLogRepository(db),
+		Audit:         postgres.NewAuditRepository(db),
+		Receipts:      postgres.NewReceiptRepository(db),
+		Methods:       postgres.NewPaymentMethodRepository(db),
+		Subscriptions: postgres.NewSubscriptionRepository(db),
+		Ledger:        postgres.NewLedgerRepository(db),
+		Commissions:   postgres.NewCommissionRepository(db),
+		Batches:       postgres.NewPayoutBatchRepository(db),
+		Destinations:  postgres.NewPayoutDestinationRepository(db),
+		Reports:       postgres.NewReportRepository(db),
+		Users:         postgres.NewUserRepository(db),
+		Cards:         db,
+		Database:      db,
+		Statuses:      rdb,
+		Gateway:       gateway,
+		Checker:       webhook.NewChecker(logger, rdb, gateway),
+		Background:    endpoints.NewBackground(),
+	}
+
+	// Final statuses found by polling are recorded in the ledger too
+	splitter := endpoints.NewSplitter(logger, deps.Ledger, deps.Commissions)
+	deps.Checker.SetFinalHook(splitter.OnFinalStatus)
+	// Checks interrupted by the previous shutdown
+	resumed, err := deps.Checker.ResumePendingChecks()
+	if err != nil {
+		logger.Error("failed to resume pending checks", slog.String("error", err.Error()))
+	} else {
+		logger.Info("pending checks resumed", slog.Int("count", resumed))
+	}
+
+	// Payout batches interrupted by the previous shutdown
+	payoutBatches := endpoints.NewPayoutBatchHandler(logger, deps.Logs, deps.Batches, deps.Audit, deps.Users,
+		deps.Destinations, deps.Cards, gateway, deps.Checker, deps.Background)
+	resumed, err = payoutBatches.ResumeQueuedBatches(ctx)
+	if err != nil {
+		logger.Error("failed to resume payout batches", slog.String("error", err.Error()))
+	} else {
+		logger.Info("payout batches resumed", slog.Int("count", resumed))
+	}
+
+	registerRuntimeMetrics(logger, db, rdb, deps.Checker)
+
+	srv := http.New(c.Server, logger, deps)
+
+	// Recurring charges, replicas share the lock in Redis
+	var scheduler *billing.Scheduler
+	if c.Billing.Enabled {
+		charger := endpoints.NewRecurringCharger(logger, deps.Logs, deps.Methods, deps.Users, splitter, gateway,
+			deps.Checker)
+		scheduler = billing.NewScheduler(logger, c.Billing, deps.Subscriptions, charger, rdb)
+		scheduler.Start(ctx)
+	}
+
+	// Daily reconciliation against YooKassa, the reconciled day is locked in Redis
+	var reconcileJob *reconcile.Job
+	if c.Reconcile.Enabled {
+		reconciler := reconcile.NewReconciler(logger, endpoints.NewYooKassaSource(gateway), deps.Logs, deps.Audit,
+			c.Reconcile.AutoFix)
+		reconciler.SetFinalHook(splitter.OnReconciledStatus)
+		reconcileJob = reconcile.NewJob(logger, c.Reconcile, reconciler, rdb)
+		reconcileJob.Start(ctx)
 	}
 
-	// To init storeId and secretKey from .env
-	metrics.Init()
-	srv := http.New(c.Server, logger, repo)
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("server started", slog.String("addr", srv.Addr()))
+		serverErr <- srv.Run()
+	}()
 
-	defer c.Disconnect(srv)
-	srv.Run()
+	go c.reloadLogLevelOnSIGHUP(ctx, logger)
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("shutdown signal received")
+	case runErr = <-serverErr:
+		if runErr != nil {
+			logger.Error("server failed", slog.String("error", runErr.Error()))
+		}
+	}
+	c.Shutdown(logger, srv, deps.Background, scheduler, reconcileJob, deps.Checker, shutdownTracing)
+	return runErr
 }
 
-func (c *Config) Disconnect(server *http.Server) {
-	err := postgres.Disconnect()
+// reloadLogLevelOnSIGHUP re-reads config on SIGHUP and applies its log level,
+// the level can also be changed by admin endpoint (it's overwritten by the next reload)
+func (c *Config) reloadLogLevelOnSIGHUP(ctx context.Context, log *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		cfg, err := Load("")
+		if err != nil {
+			log.Error("failed to reload config", slog.String("error", err.Error()))
+			continue
+		}
+		lvl, err := cfg.Logger.EffectiveLevel(c.Env)
+		if err != nil {
+			log.Error("failed to reload log level", slog.String("error", err.Error()))
+			continue
+		}
+		logger.SetLevel(lvl)
+		log.Info("log level reloaded", slog.String("level", lvl.String()))
+	}
+}
+
+// Shutdown stops the service in order: HTTP server (in-flight requests are drained), background work
+// of requests (payout resends and batches, unsent batch items stay queued), billing scheduler and reconciliation job (if they are run), status checkers (pending checks stay persisted in Redis),
+// Redis, Postgres, and flushes traces.
+func (c *Config) Shutdown(logger *slog.Logger, server *http.Server, background *endpoints.Background,
+	scheduler *billing.Scheduler, reconcileJob *reconcile.Job, checker *webhook.Checker,
+	shutdownTracing func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Server.ShutdownTimeout)
+	defer cancel()
+
+	logger.Info("stopping http server", slog.Duration("timeout", c.Server.ShutdownTimeout))
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("http server isn't stopped gracefully", slog.String("error", err.Error()))
+	} else {
+		logger.Info("http server stopped")
+	}
+
+	logger.Info("waiting for background work of requests")
+	if err := background.Shutdown(ctx); err != nil {
+		logger.Error("background work isn't finished in time", slog.String("error", err.Error()))
+	}
+
+	if scheduler != nil {
+		logger.Info("waiting for billing scheduler")
+		if err := scheduler.Wait(ctx); err != nil {
+			logger.Error("billing scheduler isn't stopped in time", slog.String("error", err.Error()))
+		}
+	}
+
+	if reconcileJob != nil {
+		logger.Info("waiting for reconciliation job")
+		if err := reconcileJob.Wait(ctx); err != nil {
+			logger.Error("reconciliation job isn't stopped in time", slog.String("error", err.Error()))
+		}
+	}
+
+	logger.Info("stopping status checkers", slog.Int64("active", checker.ActiveChecks()))
+	if err := checker.Shutdown(ctx); err != nil {
+		logger.Error("status checkers aren't stopped in time", slog.String("error", err.Error()))
+	} else {
+		logger.Info("status checkers stopped, pending checks are persisted")
+	}
+
+	logger.Info("disconnecting redis")
+	if err := redis.Disconnect(); err != nil {
+		logger.Error("failed to disconnect redis", slog.String("error", err.Error()))
+	}
+
+	logger.Info("disconnecting postgres")
+	if err := postgres.Disconnect(); err != nil {
+		logger.Error("failed to disconnect postgres", slog.String("error", err.Error()))
+	}
+
+	logger.Info("flushing traces")
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", slog.String("error", err.Error()))
+	}
+	logger.Info("shutdown completed")
+}
+
+// registerRuntimeMetrics exposes connection pools and status checker state
+func registerRuntimeMetrics(logger *slog.Logger, db *postgres.PostgresDB, rdb *redis.RedisDB,
+	checker *webhook.Checker) {
+	err := metrics.RegisterPoolStats("postgres", func() metrics.PoolStats {
+		s := db.Stats()
+		return metrics.PoolStats{
+			Open:         s.OpenConnections,
+			InUse:        s.InUse,
+			Idle:         s.Idle,
+			WaitCount:    s.WaitCount,
+			WaitDuration: s.WaitDuration,
+		}
+	})
 	if err != nil {
-		log.Println(err)
+		logger.Error("failed to register postgres metrics", slog.String("error", err.Error()))
 	}
-	err = redis.Disconnect()
+	err = metrics.RegisterPoolStats("redis", func() metrics.PoolStats {
+		s := rdb.PoolStats()
+		return metrics.PoolStats{
+			Open:     int(s.TotalConns),
+			InUse:    int(s.TotalConns - s.IdleConns),
+			Idle:     int(s.IdleConns),
+			Timeouts: int64(s.Timeouts),
+		}
+	})
+	if err != nil {
+		logger.Error("failed to register redis metrics", slog.String("error", err.Error()))
+	}
+	if err = metrics.RegisterCheckerStats(checker.CheckStats); err != nil {
+		logger.Error("failed to register checker metrics", slog.String("error", err.Error()))
+	}
+}
+
+// InitCardCipher loads data keys wrapped by master key and attaches keyring to the database
+func (c *Config) InitCardCipher(ctx context.Context, db *postgres.PostgresDB) error {
+	master, err := keyring.LoadMasterKey(c.KeyringConfig)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	err = server.Disconnect()
+	ring, err := keyring.New(ctx, postgres.NewKeyRepository(db), master)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+	db.SetCardCipher(ring)
+	return nil
 }
 
+// loadDotEnv loads variables into environment, default .env file is optional
 func loadDotEnv(filePath string) error {
 	if filePath == "" {
-		filePath = ".env"
+		err := godotenv.Load(defaultEnvPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
 	}
 	err := godotenv.Load(filePath)
 	return err
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Struct tags understood by the loader:
+//
+//	yaml:"name"        key in YAML file
+//	env:"NAME"         environment variable that overrides the value
+//	default:"value"    value used when neither YAML nor env set it
+//	required:"true"    value mustn't be empty after loading
+//	secret:"true"      value is redacted when config is printed
+const (
+	redacted = "******"
+)
+
+// Validator is implemented by config sections which need checks beyond required fields
+type Validator interface {
+	Validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// visit calls fn for every leaf field of struct pointed by v, allocating nil section pointers.
+// path is dot-separated chain of yaml keys.
+func visit(v reflect.Value, path string, fn func(f reflect.StructField, v reflect.Value, path string) error) error {
+	v = reflect.Indirect(v)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		fieldPath := joinPath(path, yamlName(field))
+
+		if isSection(field.Type) {
+			if value.Kind() == reflect.Pointer && value.IsNil() {
+				value.Set(reflect.New(field.Type.Elem()))
+			}
+			if err := visit(value, fieldPath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field, value, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSection(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{})
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// setFromString parses raw value according to the field type
+func setFromString(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %v", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func applyDefaults(cfg any) error {
+	return visit(reflect.ValueOf(cfg), "", func(f reflect.StructField, v reflect.Value, path string) error {
+		def, ok := f.Tag.Lookup("default")
+		if !ok || !v.IsZero() {
+			return nil
+		}
+		if err := setFromString(v, def); err != nil {
+			return fmt.Errorf("invalid default of %v: %w", path, err)
+		}
+		return nil
+	})
+}
+
+func applyYAML(cfg any, path string, explicit bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		return err
+	}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse %v: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg any) error {
+	return visit(reflect.ValueOf(cfg), "", func(f reflect.StructField, v reflect.Value, path string) error {
+		name := f.Tag.Get("env")
+		if name == "" {
+			return nil
+		}
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			return nil
+		}
+		if err := setFromString(v, raw); err != nil {
+			return fmt.Errorf("invalid value of %v (%v): %w", path, name, err)
+		}
+		return nil
+	})
+}
+
+// validate checks required fields and Validate methods of sections, all problems are reported at once
+func validate(cfg any, sections []string) error {
+	var errs []error
+	_ = visit(reflect.ValueOf(cfg), "", func(f reflect.StructField, v reflect.Value, path string) error {
+		if f.Tag.Get("required") != "true" || !v.IsZero() || !inSections(path, sections) {
+			return nil
+		}
+		if name := f.Tag.Get("env"); name != "" {
+			errs = append(errs, fmt.Errorf("%v (%v) is required", path, name))
+		} else {
+			errs = append(errs, fmt.Errorf("%v is required", path))
+		}
+		return nil
+	})
+	errs = append(errs, validateSections(reflect.ValueOf(cfg), "", sections)...)
+	return errors.Join(errs...)
+}
+
+func validateSections(v reflect.Value, path string, sections []string) []error {
+	var errs []error
+	if validator, ok := v.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			if path == "" {
+				errs = append(errs, err)
+			} else {
+				errs = append(errs, fmt.Errorf("%v: %w", path, err))
+			}
+		}
+	}
+	v = reflect.Indirect(v)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || !isSection(field.Type) {
+			continue
+		}
+		fieldPath := joinPath(path, yamlName(field))
+		if !inSections(fieldPath, sections) {
+			continue
+		}
+		value := v.Field(i)
+		if value.Kind() != reflect.Pointer {
+			value = value.Addr()
+		}
+		if value.IsNil() {
+			continue
+		}
+		errs = append(errs, validateSections(value, fieldPath, sections)...)
+	}
+	return errs
+}
+
+// inSections reports whether the path belongs to one of the top-level sections, all sections if there are none
+func inSections(path string, sections []string) bool {
+	if len(sections) == 0 {
+		return true
+	}
+	name, _, _ := strings.Cut(path, ".")
+	return slices.Contains(sections, name)
+}
+
+// redact returns config as nested map with secret values replaced
+func redact(v reflect.Value) map[string]any {
+	v = reflect.Indirect(v)
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		name := yamlName(field)
+		switch {
+		case isSection(field.Type):
+			if value.Kind() == reflect.Pointer && value.IsNil() {
+				out[name] = nil
+				continue
+			}
+			out[name] = redact(value)
+		case field.Tag.Get("secret") == "true" && !value.IsZero():
+			out[name] = redacted
+		case field.Type == durationType:
+			out[name] = time.Duration(value.Int()).String()
+		default:
+			out[name] = value.Interface()
+		}
+	}
+	return out
+}
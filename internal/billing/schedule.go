@@ -0,0 +1,38 @@
+package billing
+
+import "time"
+
+// MaxAnchorDay is the last anchor day, shorter months are charged on their last day
+const MaxAnchorDay = 31
+
+// FirstDueDate returns the first date from start (inclusive) with anchor day
+func FirstDueDate(start time.Time, anchorDay int) time.Time {
+	y, m, _ := start.Date()
+	due := anchorDate(y, m, anchorDay)
+	if due.Before(Date(start)) {
+		due = anchorDate(y, m+1, anchorDay)
+	}
+	return due
+}
+
+// NextDueDate returns the due date intervalMonths after the previous one
+func NextDueDate(prev time.Time, anchorDay, intervalMonths int) time.Time {
+	y, m, _ := prev.Date()
+	return anchorDate(y, m+time.Month(intervalMonths), anchorDay)
+}
+
+// Date truncates t to the date (UTC)
+func Date(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// anchorDate returns anchor day of the month, it's the last day of the month if the month is shorter
+func anchorDate(year int, month time.Month, anchorDay int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if anchorDay > lastDay {
+		anchorDay = lastDay
+	}
+	return first.AddDate(0, 0, anchorDay-1)
+}
@@ -0,0 +1,258 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/periodic"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// lockName is the lock of replicas, only one of them runs billing at a time
+const lockName = "billing"
+
+// ErrLockLost is returned by the tick which outlived its lock, other cycles aren't charged by it
+var ErrLockLost = errors.New("billing lock is lost")
+
+type Config struct {
+	Enabled bool `yaml:"enabled" env:"BILLING_ENABLED" default:"true"`
+	// TickInterval is how often due subscriptions and failed charges are checked
+	TickInterval time.Duration `yaml:"tickInterval" env:"BILLING_TICK_INTERVAL" default:"1m"`
+	// MaxRetries is the number of repeated charges of the cycle after the failed one
+	MaxRetries int `yaml:"maxRetries" env:"BILLING_MAX_RETRIES" default:"3"`
+	// RetryInterval is the delay before the repeated charge
+	RetryInterval time.Duration `yaml:"retryInterval" env:"BILLING_RETRY_INTERVAL" default:"24h"`
+	// LockTTL must be longer than a charge, the lock is extended before each charge
+	// and expires if the replica has died
+	LockTTL time.Duration `yaml:"lockTTL" env:"BILLING_LOCK_TTL" default:"5m"`
+}
+
+func (c *Config) Validate() error {
+	if c.TickInterval <= 0 || c.RetryInterval <= 0 || c.LockTTL <= 0 {
+		return errors.New("tickInterval, retryInterval and lockTTL must be positive")
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("maxRetries can't be negative")
+	}
+	return nil
+}
+
+// Charge is result of the charge of a billing cycle
+type Charge struct {
+	PaymentID string
+	Status    metrics.Status
+	// Reason is why the charge is canceled
+	Reason string
+}
+
+// Charger charges saved payment methods. Errors are transient failures (e.g. network),
+// the charge is repeated with the same idempotence key then. Rejected charges are canceled.
+type Charger interface {
+	Charge(ctx context.Context, sub *postgres.Subscription, cycle *postgres.BillingCycle,
+		idempotenceKey string) (*Charge, error)
+	// Status returns the current status of the payment
+	Status(ctx context.Context, paymentID string) (metrics.Status, error)
+}
+
+// Scheduler opens billing cycles of due subscriptions and charges them,
+// failed charges are retried (dunning) until the cycle fails and the subscription becomes past due.
+// It's started and waited for by the embedded runner.
+type Scheduler struct {
+	*periodic.Runner
+	log     *slog.Logger
+	cfg     *Config
+	subs    postgres.SubscriptionRepository
+	charger Charger
+	locker  periodic.Locker
+	// owner identifies the replica holding the lock
+	owner string
+}
+
+func NewScheduler(log *slog.Logger, cfg *Config, subs postgres.SubscriptionRepository, charger Charger,
+	locker periodic.Locker) *Scheduler {
+	s := &Scheduler{
+		log:     log.With(slog.String("fn", "billing.Scheduler")),
+		cfg:     cfg,
+		subs:    subs,
+		charger: charger,
+		locker:  locker,
+		owner:   uuid.New().String(),
+	}
+	s.Runner = periodic.NewRunner(s.log, cfg.TickInterval, s.Tick)
+	return s
+}
+
+// Tick opens cycles due by now and charges open cycles, it's skipped if another replica holds the lock
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) (err error) {
+	locked, err := s.locker.TryLock(ctx, lockName, s.owner, s.cfg.LockTTL)
+	if err != nil {
+		return fmt.Errorf("failed to lock billing: %w", err)
+	}
+	if !locked {
+		s.log.Debug("billing is run by another replica")
+		return nil
+	}
+	defer func() {
+		if unlockErr := s.locker.Unlock(ctx, lockName, s.owner); unlockErr != nil {
+			s.log.Warn("failed to unlock billing", slog.String("error", unlockErr.Error()))
+		}
+	}()
+
+	ctx, span := tracing.Start(ctx, "billing.Tick")
+	defer func() { tracing.End(span, err) }()
+
+	if err = s.openCycles(ctx, now); err != nil {
+		return err
+	}
+	return s.chargeCycles(ctx, now)
+}
+
+// openCycles opens all missed cycles of due subscriptions
+func (s *Scheduler) openCycles(ctx context.Context, now time.Time) error {
+	today := Date(now)
+	subs, err := s.subs.GetDueSubscriptions(ctx, today)
+	if err != nil {
+		return fmt.Errorf("failed to get due subscriptions: %w", err)
+	}
+	for _, sub := range subs {
+		for !sub.NextChargeAt.After(today) {
+			next := NextDueDate(sub.NextChargeAt, sub.AnchorDay, sub.IntervalMonths)
+			nextPtr := &next
+			if sub.EndDate != nil && next.After(*sub.EndDate) {
+				nextPtr = nil
+			}
+			if err = s.subs.OpenCycle(ctx, sub, nextPtr); err != nil {
+				return fmt.Errorf("failed to open cycle of subscription %v: %w", sub.ID, err)
+			}
+			s.log.Info("billing cycle opened",
+				slog.Int("subscription_id", sub.ID),
+				slog.Time("due_date", sub.NextChargeAt),
+			)
+			if nextPtr == nil {
+				s.log.Info("subscription completed", slog.Int("subscription_id", sub.ID))
+				break
+			}
+			sub.NextChargeAt = next
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) chargeCycles(ctx context.Context, now time.Time) error {
+	cycles, err := s.subs.GetOpenCycles(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to get open cycles: %w", err)
+	}
+	for _, cycle := range cycles {
+		// The lock may expire during a long tick (e.g. slow YooKassa), then another replica charges
+		// the same cycles, so it's extended before each charge
+		if err = s.extendLock(ctx); err != nil {
+			return err
+		}
+		log := s.log.With(
+			slog.Int("subscription_id", cycle.SubscriptionID),
+			slog.Int("cycle_id", cycle.ID),
+		)
+		if err = s.processCycle(ctx, log, cycle, now); err != nil {
+			// Other cycles are processed anyway, this one is processed on the next tick
+			log.Error("failed to process billing cycle", slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) extendLock(ctx context.Context) error {
+	extended, err := s.locker.Extend(ctx, lockName, s.owner, s.cfg.LockTTL)
+	if err != nil {
+		return fmt.Errorf("failed to extend billing lock: %w", err)
+	}
+	if !extended {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (s *Scheduler) processCycle(ctx context.Context, log *slog.Logger, cycle *postgres.BillingCycle,
+	now time.Time) (err error) {
+	sub, err := s.subs.GetSubscription(ctx, cycle.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	if sub == nil || sub.Status == postgres.SubscriptionCanceled {
+		cycle.Status = postgres.CycleFailed
+		cycle.Error = "subscription is canceled"
+		return s.subs.UpdateCycle(ctx, cycle)
+	}
+
+	ctx, span := tracing.Start(ctx, "billing.ProcessCycle",
+		attribute.Int("subscription_id", sub.ID),
+		attribute.Int("cycle_id", cycle.ID),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	charge := &Charge{PaymentID: cycle.PaymentID}
+	if cycle.Status == postgres.CycleCharging {
+		charge.Status, err = s.charger.Status(ctx, cycle.PaymentID)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Repeated charge of the same attempt (e.g. after a network error) has the same key
+		key := fmt.Sprintf("billing-cycle-%v-%v", cycle.ID, cycle.Attempt+1)
+		charge, err = s.charger.Charge(ctx, sub, cycle, key)
+		if err != nil {
+			return err
+		}
+		cycle.Attempt++
+		cycle.PaymentID = charge.PaymentID
+		log = log.With(slog.String("yookassa_id", charge.PaymentID), slog.Int("attempt", cycle.Attempt))
+	}
+
+	switch charge.Status {
+	case metrics.Succeeded:
+		cycle.Status = postgres.CycleSucceeded
+		cycle.Error = ""
+		log.Info("billing cycle charged")
+	case metrics.Canceled:
+		reason := charge.Reason
+		if reason == "" {
+			reason = "payment is canceled"
+		}
+		return s.failCharge(ctx, log, sub, cycle, reason, now)
+	default:
+		cycle.Status = postgres.CycleCharging
+		log.Debug("billing cycle is being charged", slog.String("status", string(charge.Status)))
+	}
+	return s.subs.UpdateCycle(ctx, cycle)
+}
+
+// failCharge schedules retry of the cycle, or fails it if retries are exhausted
+func (s *Scheduler) failCharge(ctx context.Context, log *slog.Logger, sub *postgres.Subscription,
+	cycle *postgres.BillingCycle, reason string, now time.Time) error {
+	cycle.Error = reason
+	if cycle.Attempt <= s.cfg.MaxRetries {
+		cycle.Status = postgres.CyclePending
+		cycle.NextAttemptAt = now.Add(s.cfg.RetryInterval)
+		log.Warn("billing charge failed, it will be retried",
+			slog.String("reason", reason),
+			slog.Time("next_attempt_at", cycle.NextAttemptAt),
+		)
+		return s.subs.UpdateCycle(ctx, cycle)
+	}
+
+	cycle.Status = postgres.CycleFailed
+	if err := s.subs.UpdateCycle(ctx, cycle); err != nil {
+		return err
+	}
+	log.Error("billing cycle failed, subscription is past due", slog.String("reason", reason))
+	_, err := s.subs.SetSubscriptionStatus(ctx, sub.ID, postgres.SubscriptionPastDue)
+	return err
+}
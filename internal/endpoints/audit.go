@@ -0,0 +1,151 @@
+package endpoints
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RoleAdmin is an operator of admin panel, the only authenticated caller
+const RoleAdmin = "admin"
+
+// Principal is the authenticated caller, it's put into the context by auth middleware
+type Principal struct {
+	ID   string
+	Role string
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns nil for unauthenticated requests (the main site, YooKassa notifications)
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// newAuditEvent fills request-related fields of an audit event. The actor is the authenticated
+// operator; the main site acts for the user the operation is made for, that user is defaultActor.
+func newAuditEvent(r *http.Request, eventType, subjectType, subjectID, defaultActor string) *postgres.AuditEvent {
+	actor := defaultActor
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		actor = principal.ID
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &postgres.AuditEvent{
+		EventType:   eventType,
+		ActorID:     actor,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		IP:          ip,
+		RequestID:   middleware.GetReqID(r.Context()),
+		Details:     map[string]string{},
+	}
+}
+
+// writeAudit stores audit event. Failure is logged but never hides the result of the operation
+// that was already made, so the event is stored even if ctx is canceled.
+func writeAudit(ctx context.Context, log *slog.Logger, repo postgres.AuditRepository, event *postgres.AuditEvent) {
+	if repo == nil {
+		log.Error("audit repository isn't initialized", slog.String("event", event.EventType))
+		return
+	}
+	if err := repo.InsertAuditEvent(context.WithoutCancel(ctx), event); err != nil {
+		log.Error("failed to write audit event",
+			slog.String("event", event.EventType),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// ____________________
+// Compliance queries
+// ____________________
+
+type AuditEventsResponse struct {
+	Events []*postgres.AuditEvent `json:"events"`
+}
+
+type AuditHandler struct {
+	log   *slog.Logger
+	audit postgres.AuditRepository
+}
+
+func NewAuditHandler(log *slog.Logger, audit postgres.AuditRepository) *AuditHandler {
+	return &AuditHandler{
+		log:   log,
+		audit: audit,
+	}
+}
+
+// GetEvents returns audit events filtered by query parameters:
+// event_type, actor_id, subject_type, subject_id, from, to (RFC3339), limit, offset
+func (h *AuditHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetEvents"
+
+	log := requestLogger(r, h.log, fn)
+	log.Debug("audit events endpoint called")
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		log.Error("failed to parse filter", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request, invalid filter"))
+		return
+	}
+
+	events, err := h.audit.GetAuditEvents(r.Context(), filter)
+	if err != nil {
+		log.Error("failed to get audit events", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	myJson.Write(w, http.StatusOK, AuditEventsResponse{Events: events})
+}
+
+func parseAuditFilter(r *http.Request) (*postgres.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := &postgres.AuditFilter{
+		EventType:   q.Get("event_type"),
+		ActorID:     q.Get("actor_id"),
+		SubjectType: q.Get("subject_type"),
+		SubjectID:   q.Get("subject_id"),
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
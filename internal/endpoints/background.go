@@ -0,0 +1,59 @@
+package endpoints
+
+import (
+	"context"
+	"sync"
+)
+
+// Background tracks work started by requests which outlives them (payout resends, payout batches),
+// so the service isn't stopped in the middle of it
+type Background struct {
+	wg       sync.WaitGroup
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func NewBackground() *Background {
+	return &Background{stopping: make(chan struct{})}
+}
+
+// Go runs fn in a goroutine which Shutdown waits for
+func (b *Background) Go(fn func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn()
+	}()
+}
+
+// Stopping is closed by Shutdown, the work should finish what it has started without waiting
+func (b *Background) Stopping() <-chan struct{} {
+	return b.stopping
+}
+
+// Stopped reports whether Shutdown is called
+func (b *Background) Stopped() bool {
+	select {
+	case <-b.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown signals the work to finish and waits for it
+func (b *Background) Shutdown(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.stopping) })
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
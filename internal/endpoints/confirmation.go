@@ -0,0 +1,89 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Confirmation types of YooKassa payments
+const (
+	// ConfirmationEmbedded is confirmed in the YooKassa widget by confirmation_token
+	ConfirmationEmbedded = "embedded"
+	// ConfirmationRedirect is confirmed on the YooKassa page by confirmation_url,
+	// then the user is returned to return_url
+	ConfirmationRedirect = "redirect"
+	// ConfirmationQR is confirmed by scanning confirmation_data (e.g. SBP)
+	ConfirmationQR = "qr"
+	// ConfirmationExternal is confirmed outside of the site (e.g. by SMS of the bank)
+	ConfirmationExternal = "external"
+)
+
+// Payment methods which can be preselected
+const (
+	PaymentMethodBankCard = "bank_card"
+	PaymentMethodSBP      = "sbp"
+	PaymentMethodYooMoney = "yoo_money"
+	PaymentMethodSberbank = "sberbank"
+)
+
+var (
+	ErrConfirmationType = errors.New("confirmation type must be redirect, embedded, qr or external")
+	ErrReturnURL        = errors.New("redirect confirmation requires absolute http(s) return_url")
+	ErrPaymentMethod    = errors.New("payment method must be bank_card, sbp, yoo_money or sberbank")
+)
+
+type Confirmation struct {
+	Type      string `json:"type"`
+	ReturnURL string `json:"return_url,omitempty"`
+}
+
+// PaymentMethodData restricts the payment to one payment method
+type PaymentMethodData struct {
+	Type string `json:"type"`
+}
+
+// ConfirmationResponse has only the data of its type: token (embedded),
+// url (redirect) or QR data (qr); external confirmation has no data
+type ConfirmationResponse struct {
+	Type              string `json:"type"`
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+	ConfirmationURL   string `json:"confirmation_url,omitempty"`
+	ConfirmationData  string `json:"confirmation_data,omitempty"`
+	ReturnURL         string `json:"return_url,omitempty"`
+}
+
+// newConfirmation checks the requested confirmation, it's embedded by default
+func newConfirmation(c *Confirmation, paymentMethod string) (Confirmation, error) {
+	if c == nil || c.Type == "" {
+		return Confirmation{Type: ConfirmationEmbedded}, nil
+	}
+	switch c.Type {
+	case ConfirmationRedirect:
+		u, err := url.Parse(c.ReturnURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Confirmation{}, ErrReturnURL
+		}
+		return Confirmation{Type: c.Type, ReturnURL: c.ReturnURL}, nil
+	case ConfirmationQR:
+		if paymentMethod == PaymentMethodBankCard {
+			return Confirmation{}, fmt.Errorf("qr confirmation isn't available for %v", paymentMethod)
+		}
+	case ConfirmationEmbedded, ConfirmationExternal:
+	default:
+		return Confirmation{}, ErrConfirmationType
+	}
+	return Confirmation{Type: c.Type}, nil
+}
+
+// newPaymentMethodData returns nil if any payment method is allowed
+func newPaymentMethodData(paymentMethod string) (*PaymentMethodData, error) {
+	switch paymentMethod {
+	case "":
+		return nil, nil
+	case PaymentMethodBankCard, PaymentMethodSBP, PaymentMethodYooMoney, PaymentMethodSberbank:
+		return &PaymentMethodData{Type: paymentMethod}, nil
+	default:
+		return nil, ErrPaymentMethod
+	}
+}
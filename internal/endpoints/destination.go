@@ -0,0 +1,244 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Payout destinations
+// ________________
+// ****************
+
+// Payout destination types, bank card is the card synonym from users_card
+const (
+	DestinationBankCard = "bank_card"
+	DestinationSBP      = "sbp"
+	DestinationYooMoney = "yoo_money"
+)
+
+var (
+	ErrPayoutDestination   = errors.New("destination must be bank_card, sbp or yoo_money")
+	ErrSBPPhone            = errors.New("phone must be 11 digits starting with 7")
+	ErrSBPBankID           = errors.New("bank_id must be 1 to 12 digits")
+	ErrYooMoneyAccount     = errors.New("account_number must be 11 to 33 digits")
+	ErrCardNotBound        = errors.New("the card is untethered for userID")
+	ErrDestinationNotBound = errors.New("payout destination is untethered for userID")
+)
+
+// PayoutDestinationData is payout_destination_data of YooKassa payout
+// https://yookassa.ru/developers/api#create_payout
+type PayoutDestinationData struct {
+	Type          string `json:"type"`
+	Phone         string `json:"phone,omitempty"`
+	BankID        string `json:"bank_id,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+}
+
+// Validate checks the destination before it's saved or sent to YooKassa
+func (d *PayoutDestinationData) Validate() error {
+	switch d.Type {
+	case DestinationSBP:
+		if len(d.Phone) != 11 || d.Phone[0] != '7' || !isNumeric(d.Phone) {
+			return ErrSBPPhone
+		}
+		if d.BankID == "" || len(d.BankID) > 12 || !isNumeric(d.BankID) {
+			return ErrSBPBankID
+		}
+	case DestinationYooMoney:
+		if len(d.AccountNumber) < 11 || len(d.AccountNumber) > 33 || !isNumeric(d.AccountNumber) {
+			return ErrYooMoneyAccount
+		}
+	default:
+		return ErrPayoutDestination
+	}
+	return nil
+}
+
+// account returns phone or wallet number and its mask
+func (d *PayoutDestinationData) account() (string, string, error) {
+	if d.Type == DestinationSBP {
+		mask, err := models.GenerateMask(d.Phone, 1, 4)
+		return d.Phone, mask, err
+	}
+	mask, err := models.GenerateMask(d.AccountNumber, 4, 4)
+	return d.AccountNumber, mask, err
+}
+
+func newPayoutDestinationData(destination *postgres.PayoutDestination) *PayoutDestinationData {
+	data := &PayoutDestinationData{Type: destination.Type}
+	if destination.Type == DestinationSBP {
+		data.Phone = destination.Account
+		data.BankID = destination.BankID
+	} else {
+		data.AccountNumber = destination.Account
+	}
+	return data
+}
+
+// isDestinationType returns true for known type, empty type is bank card
+func isDestinationType(destinationType string) bool {
+	switch destinationType {
+	case "", DestinationBankCard, DestinationSBP, DestinationYooMoney:
+		return true
+	}
+	return false
+}
+
+// payoutDestination is card synonym or other destination of the payout
+type payoutDestination struct {
+	cardSynonym string
+	data        *PayoutDestinationData
+	// mask is card mask or destination mask, it's written to audit
+	mask string
+}
+
+// findPayoutDestination returns the destination of the type bound by the user, ErrCardNotBound or
+// ErrDestinationNotBound if there is none. Stored destination is validated again before it's sent.
+func findPayoutDestination(ctx context.Context, cards postgres.CardStore,
+	destinations postgres.PayoutDestinationRepository, userID uuid.UUID, destinationType string) (
+	*payoutDestination, error) {
+	if destinationType == "" || destinationType == DestinationBankCard {
+		card, err := cards.GetRefillableCardByUserID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if card == nil || card.CardSynonym == "" {
+			return nil, ErrCardNotBound
+		}
+		return &payoutDestination{cardSynonym: card.CardSynonym, mask: card.CardMask}, nil
+	}
+
+	destination, err := destinations.GetPayoutDestination(ctx, userID.String(), destinationType)
+	if err != nil {
+		return nil, err
+	}
+	if destination == nil {
+		return nil, ErrDestinationNotBound
+	}
+	data := newPayoutDestinationData(destination)
+	if err = data.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDestinationNotBound, err)
+	}
+	return &payoutDestination{data: data, mask: destination.Mask}, nil
+}
+
+// SavePayoutDestination accepted structure from frontend
+type SavePayoutDestination struct {
+	UserID        string `json:"user_id"`
+	Type          string `json:"type"`
+	Phone         string `json:"phone,omitempty"`
+	BankID        string `json:"bank_id,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+}
+
+type PayoutDestinationsHandler struct {
+	log          *slog.Logger
+	users        postgres.UserRepository
+	destinations postgres.PayoutDestinationRepository
+	audit        postgres.AuditRepository
+}
+
+func NewPayoutDestinationsHandler(log *slog.Logger, users postgres.UserRepository,
+	destinations postgres.PayoutDestinationRepository, audit postgres.AuditRepository) *PayoutDestinationsHandler {
+	return &PayoutDestinationsHandler{
+		log:          log,
+		users:        users,
+		destinations: destinations,
+		audit:        audit,
+	}
+}
+
+// Save binds SBP phone or YooMoney wallet of the user, it replaces the previous one of the same type
+// (PUT /payout_destinations)
+func (h *PayoutDestinationsHandler) Save(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.PayoutDestinations.Save"
+
+	log := requestLogger(r, h.log, fn)
+
+	req := new(SavePayoutDestination)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	data := &PayoutDestinationData{
+		Type:          req.Type,
+		Phone:         req.Phone,
+		BankID:        req.BankID,
+		AccountNumber: req.AccountNumber,
+	}
+	if err := data.Validate(); err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", req.UserID))
+	if err := activeUser(r.Context(), h.users, req.UserID); err != nil {
+		writeUserError(w, log, err)
+		return
+	}
+
+	account, mask, err := data.account()
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	destination := &postgres.PayoutDestination{
+		UserID:  req.UserID,
+		Type:    data.Type,
+		Account: account,
+		BankID:  data.BankID,
+		Mask:    mask,
+	}
+	replaced, err := h.destinations.SavePayoutDestination(r.Context(), destination)
+	if err != nil {
+		log.Error("failed to save payout destination", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditPayoutDestinationSaved, postgres.AuditSubjectPayoutDestination,
+		req.UserID, req.UserID)
+	auditEvent.NewValue = mask
+	auditEvent.Details["type"] = destination.Type
+	auditEvent.Details["replaced"] = fmt.Sprint(replaced)
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("payout destination saved", slog.String("type", destination.Type))
+	myJson.Write(w, http.StatusOK, destination)
+}
+
+// GetDestinations returns masked payout destinations of the user (GET /payout_destinations?user_id=...)
+func (h *PayoutDestinationsHandler) GetDestinations(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.PayoutDestinations.GetDestinations"
+
+	log := requestLogger(r, h.log, fn)
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("user_id is empty"))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", userID))
+	destinations, err := h.destinations.GetPayoutDestinations(r.Context(), userID)
+	if err != nil {
+		log.Error("failed to get payout destinations", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, destinations)
+}
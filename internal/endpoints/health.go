@@ -0,0 +1,210 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	checkTimeout = 2 * time.Second
+	// YooKassa is checked rarely: it's external, and it's not critical for readiness
+	yooKassaCacheTTL = 30 * time.Second
+)
+
+// DependencyStatus is the result of one readiness check
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	// Details is check specific info, e.g. migration versions
+	Details map[string]any `json:"details,omitempty"`
+	// CheckedAt is set for cached checks
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                       `json:"status"`
+	Checks map[string]*DependencyStatus `json:"checks,omitempty"`
+}
+
+// Database is checked by readiness probe, it must be reachable and migrated to postgres.SchemaVersion
+// (or a newer compatible version, see postgres.MaxSchemaVersion)
+type Database interface {
+	PingContext(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// Pinger is a dependency checked by readiness probe
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type dependencyCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) (map[string]any, error)
+}
+
+type HealthHandler struct {
+	log      *slog.Logger
+	db       Database
+	statuses Pinger
+	gateway  yookassa.Gateway
+	checks   []dependencyCheck
+
+	yooKassaMu     sync.Mutex
+	yooKassaResult *DependencyStatus
+}
+
+func NewHealthHandler(log *slog.Logger, db Database, statuses Pinger, gateway yookassa.Gateway) *HealthHandler {
+	h := &HealthHandler{
+		log:      log,
+		db:       db,
+		statuses: statuses,
+		gateway:  gateway,
+	}
+	h.checks = []dependencyCheck{
+		{name: "postgres", critical: true, check: h.checkPostgres},
+		{name: "redis", critical: true, check: h.checkRedis},
+		{name: "migrations", critical: true, check: h.checkMigrations},
+	}
+	return h
+}
+
+// Healthz reports that the process is alive
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	myJson.Write(w, http.StatusOK, HealthResponse{Status: StatusUp})
+}
+
+// Readyz checks dependencies, it returns 503 if any critical dependency is down.
+// YooKassa isn't critical (pending payments are rechecked later), its result is cached.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Readyz"
+
+	log := requestLogger(r, h.log, fn)
+
+	resp := HealthResponse{
+		Status: StatusUp,
+		Checks: make(map[string]*DependencyStatus, len(h.checks)+1),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runCheck(r.Context(), c)
+			mu.Lock()
+			resp.Checks[c.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result := h.checkYooKassaCached(r.Context())
+		mu.Lock()
+		resp.Checks["yookassa"] = result
+		mu.Unlock()
+	}()
+	wg.Wait()
+
+	status := http.StatusOK
+	for name, result := range resp.Checks {
+		if result.Status == StatusDown && result.Critical {
+			log.Error("dependency is down", slog.String("dependency", name), slog.String("error", result.Error))
+			resp.Status = StatusDown
+			status = http.StatusServiceUnavailable
+		}
+	}
+	myJson.Write(w, status, resp)
+}
+
+func runCheck(ctx context.Context, c dependencyCheck) *DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := c.check(ctx)
+	result := &DependencyStatus{
+		Status:    StatusUp,
+		Critical:  c.critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (h *HealthHandler) checkYooKassaCached(ctx context.Context) *DependencyStatus {
+	h.yooKassaMu.Lock()
+	defer h.yooKassaMu.Unlock()
+
+	if h.yooKassaResult != nil && time.Since(*h.yooKassaResult.CheckedAt) < yooKassaCacheTTL {
+		return h.yooKassaResult
+	}
+	result := runCheck(ctx, dependencyCheck{name: "yookassa", critical: false, check: h.checkYooKassa})
+	checkedAt := time.Now()
+	result.CheckedAt = &checkedAt
+	h.yooKassaResult = result
+	return result
+}
+
+// ______________
+// Checks
+// ______________
+
+func (h *HealthHandler) checkPostgres(ctx context.Context) (map[string]any, error) {
+	return nil, h.db.PingContext(ctx)
+}
+
+func (h *HealthHandler) checkRedis(ctx context.Context) (map[string]any, error) {
+	return nil, h.statuses.Ping(ctx)
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) (map[string]any, error) {
+	version, dirty, err := h.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	details := map[string]any{
+		"applied":  version,
+		"expected": postgres.SchemaVersion,
+		"max":      postgres.MaxSchemaVersion,
+		"dirty":    dirty,
+	}
+	return details, postgres.CheckSchemaVersion(version, dirty)
+}
+
+// checkYooKassa requests store info, it checks both availability and credentials
+func (h *HealthHandler) checkYooKassa(ctx context.Context) (map[string]any, error) {
+	resp, err := h.gateway.Do(ctx, http.MethodGet, metrics.MeEndpoint, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	details := map[string]any{"status_code": resp.StatusCode}
+	if resp.StatusCode != http.StatusOK {
+		return details, fmt.Errorf("unexpected status %v", resp.StatusCode)
+	}
+	return details, nil
+}
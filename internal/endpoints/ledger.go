@@ -0,0 +1,176 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ****************
+// ________________
+// Ledger balances and commissions
+// ________________
+// ****************
+
+var (
+	ErrCommissionScope   = errors.New("commission rule applies to landlord or tier, not both")
+	ErrCommissionPercent = errors.New("percent must be between 0 and 100 with at most 2 decimals")
+	ErrCommissionAmount  = errors.New("fixed, min_fee and max_fee must be non-negative amounts")
+	ErrCommissionLimits  = errors.New("min_fee is greater than max_fee")
+)
+
+const (
+	defaultCurrency    = "RUB"
+	ledgerEntriesLimit = 50
+)
+
+// Balance of the ledger account with its last entries
+type Balance struct {
+	Account  string                  `json:"account"`
+	Balance  string                  `json:"balance"`
+	Currency string                  `json:"currency"`
+	Entries  []*postgres.LedgerEntry `json:"entries"`
+}
+
+type LedgerHandler struct {
+	log         *slog.Logger
+	ledger      postgres.LedgerRepository
+	commissions postgres.CommissionRepository
+	audit       postgres.AuditRepository
+}
+
+func NewLedgerHandler(log *slog.Logger, ledger postgres.LedgerRepository, commissions postgres.CommissionRepository,
+	audit postgres.AuditRepository) *LedgerHandler {
+	return &LedgerHandler{
+		log:         log,
+		ledger:      ledger,
+		commissions: commissions,
+		audit:       audit,
+	}
+}
+
+// GetLandlordBalance returns balance of the landlord (GET /landlords/{id}/balance?currency=RUB),
+// it can be withdrawn by payout with from_balance
+func (h *LedgerHandler) GetLandlordBalance(w http.ResponseWriter, r *http.Request) {
+	h.writeBalance(w, r, "endpoints.GetLandlordBalance", postgres.LandlordAccount(chi.URLParam(r, "id")))
+}
+
+// GetPlatformBalance returns balance of platform commissions (GET /admin/platform_balance?currency=RUB)
+func (h *LedgerHandler) GetPlatformBalance(w http.ResponseWriter, r *http.Request) {
+	h.writeBalance(w, r, "endpoints.GetPlatformBalance", postgres.PlatformAccount)
+}
+
+func (h *LedgerHandler) writeBalance(w http.ResponseWriter, r *http.Request, fn, account string) {
+	log := requestLogger(r, h.log, fn)
+
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	balance, err := h.ledger.GetBalance(r.Context(), account, currency)
+	if err != nil {
+		log.Error("failed to get balance", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	entries, err := h.ledger.GetEntries(r.Context(), account, ledgerEntriesLimit)
+	if err != nil {
+		log.Error("failed to get ledger entries", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, Balance{
+		Account:  account,
+		Balance:  balance,
+		Currency: currency,
+		Entries:  entries,
+	})
+}
+
+// GetCommissionRules returns all commission rules (GET /admin/commission_rules)
+func (h *LedgerHandler) GetCommissionRules(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetCommissionRules"
+
+	log := requestLogger(r, h.log, fn)
+
+	rules, err := h.commissions.GetCommissionRules(r.Context())
+	if err != nil {
+		log.Error("failed to get commission rules", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, rules)
+}
+
+// SaveCommissionRule creates or replaces rule of the landlord, tier or the default one
+// (PUT /admin/commission_rules)
+func (h *LedgerHandler) SaveCommissionRule(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.SaveCommissionRule"
+
+	log := requestLogger(r, h.log, fn)
+
+	rule := new(postgres.CommissionRule)
+	if err := myJson.Read(r, rule); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	if err := validateCommissionRule(rule); err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	if err := h.commissions.SaveCommissionRule(r.Context(), rule); err != nil {
+		log.Error("failed to save commission rule", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditAdminAction, postgres.AuditSubjectCommission,
+		strconv.Itoa(rule.ID), "admin")
+	auditEvent.Details["landlord_id"] = rule.LandlordID
+	auditEvent.Details["tier"] = rule.Tier
+	auditEvent.Details["percent"] = rule.Percent
+	auditEvent.Details["fixed"] = rule.Fixed
+	auditEvent.Details["min_fee"] = rule.MinFee
+	auditEvent.Details["max_fee"] = rule.MaxFee
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("commission rule saved", slog.Int("rule_id", rule.ID))
+	myJson.Write(w, http.StatusOK, rule)
+}
+
+func validateCommissionRule(rule *postgres.CommissionRule) error {
+	if rule.LandlordID != "" && rule.Tier != "" {
+		return ErrCommissionScope
+	}
+	if rule.Fixed == "" {
+		rule.Fixed = "0"
+	}
+	percent, err := parseMinorUnits(rule.Percent)
+	if err != nil || percent > 10000 {
+		return ErrCommissionPercent
+	}
+	values := []string{rule.Fixed, rule.MinFee, rule.MaxFee}
+	for i, v := range values {
+		if v == "" && i > 0 {
+			continue
+		}
+		if _, err = parseMinorUnits(v); err != nil {
+			return ErrCommissionAmount
+		}
+	}
+	if rule.MinFee != "" && rule.MaxFee != "" {
+		minFee, _ := parseMinorUnits(rule.MinFee)
+		maxFee, _ := parseMinorUnits(rule.MaxFee)
+		if minFee > maxFee {
+			return ErrCommissionLimits
+		}
+	}
+	return nil
+}
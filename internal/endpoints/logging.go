@@ -0,0 +1,20 @@
+package endpoints
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
+)
+
+// requestLogger returns logger of the request (request_id, route, remote_ip) with handler name
+func requestLogger(r *http.Request, fallback *slog.Logger, fn string) *slog.Logger {
+	return logger.FromContext(r.Context(), fallback).With(slog.String("fn", fn))
+}
+
+// annotate adds attributes both to the request logger, so they're in the access log
+// and in the logs of status checks, and to the handler logger
+func annotate(r *http.Request, log *slog.Logger, args ...any) *slog.Logger {
+	logger.AddAttrs(r.Context(), args...)
+	return log.With(args...)
+}
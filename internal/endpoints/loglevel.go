@@ -0,0 +1,63 @@
+package endpoints
+
+import (
+	"log/slog"
+	"net/http"
+
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+const logLevelSubject = "log_level"
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+type LogLevelHandler struct {
+	log   *slog.Logger
+	audit postgres.AuditRepository
+}
+
+func NewLogLevelHandler(log *slog.Logger, audit postgres.AuditRepository) *LogLevelHandler {
+	return &LogLevelHandler{
+		log:   log,
+		audit: audit,
+	}
+}
+
+// GetLevel returns current log level
+func (h *LogLevelHandler) GetLevel(w http.ResponseWriter, r *http.Request) {
+	myJson.Write(w, http.StatusOK, LogLevel{Level: logger.Level().String()})
+}
+
+// SetLevel changes log level until restart or SIGHUP (config is re-read then)
+func (h *LogLevelHandler) SetLevel(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.SetLevel"
+
+	log := requestLogger(r, h.log, fn)
+
+	req := new(LogLevel)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	lvl, err := logger.ParseLevel(req.Level)
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+
+	old := logger.Level()
+	logger.SetLevel(lvl)
+	log.Warn("log level changed", slog.String("old", old.String()), slog.String("new", lvl.String()))
+
+	auditEvent := newAuditEvent(r, postgres.AuditAdminAction, postgres.AuditSubjectConfig, logLevelSubject, "admin")
+	auditEvent.OldValue = old.String()
+	auditEvent.NewValue = lvl.String()
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	myJson.Write(w, http.StatusOK, LogLevel{Level: lvl.String()})
+}
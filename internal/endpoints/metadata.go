@@ -0,0 +1,73 @@
+package endpoints
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+)
+
+const descriptionMaxLen = 128
+
+// Metadata is sent with YooKassa objects and returned in notifications,
+// it links them back to our transaction, user and booking
+type Metadata struct {
+	TransactionID string `json:"transaction_id"`
+	UserID        string `json:"user_id,omitempty"`
+	BookingID     string `json:"booking_id,omitempty"`
+	OrderID       string `json:"order_id,omitempty"`
+	// SubscriptionID is set for recurring charges
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	// LandlordID and Tier (of the property) of payments define the commission split
+	LandlordID string `json:"landlord_id,omitempty"`
+	Tier       string `json:"tier,omitempty"`
+	// LedgerAccount is set for payouts from the balance, they are returned there if canceled
+	LedgerAccount string `json:"ledger_account,omitempty"`
+}
+
+func NewMetadata(transactionID uuid.UUID, userID, bookingID, orderID string) *Metadata {
+	return &Metadata{
+		TransactionID: transactionID.String(),
+		UserID:        userID,
+		BookingID:     bookingID,
+		OrderID:       orderID,
+	}
+}
+
+// metadataFromMap converts metadata of YooKassa object (notification or status check)
+func metadataFromMap(m map[string]string) *Metadata {
+	if len(m) == 0 {
+		return nil
+	}
+	return &Metadata{
+		TransactionID:  m["transaction_id"],
+		UserID:         m["user_id"],
+		BookingID:      m["booking_id"],
+		OrderID:        m["order_id"],
+		SubscriptionID: m["subscription_id"],
+		LandlordID:     m["landlord_id"],
+		Tier:           m["tier"],
+		LedgerAccount:  m["ledger_account"],
+	}
+}
+
+// descriptionData is passed to description templates
+type descriptionData struct {
+	*Metadata
+	Amount   string
+	Currency string
+}
+
+// renderDescription executes description template, the result is cut to YooKassa limit
+func renderDescription(tmpl string, metadata *Metadata, amount Amount) (string, error) {
+	t, err := template.New("description").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	b := new(strings.Builder)
+	err = t.Execute(b, descriptionData{Metadata: metadata, Amount: amount.Value, Currency: amount.Currency})
+	if err != nil {
+		return "", err
+	}
+	return truncateRunes(b.String(), descriptionMaxLen), nil
+}
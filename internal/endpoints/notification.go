@@ -0,0 +1,150 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Notification
+// ________________
+// ****************
+
+// Notification is YooKassa webhook request, e.g. event "payment.succeeded"
+type Notification struct {
+	Type   string             `json:"type"`
+	Event  string             `json:"event"`
+	Object NotificationObject `json:"object"`
+}
+
+type NotificationObject struct {
+	ID       string            `json:"id"`
+	Status   metrics.Status    `json:"status"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// resource returns YooKassa resource of the notified object
+func (n *Notification) resource() (string, bool) {
+	kind, _, _ := strings.Cut(n.Event, ".")
+	switch kind {
+	case "payment":
+		return metrics.PaymentsEndpoint, true
+	case "payout":
+		return metrics.PayoutsEndpoint, true
+	case "refund":
+		return metrics.RefundsEndpoint, true
+	default:
+		return "", false
+	}
+}
+
+type NotificationHandler struct {
+	log       *slog.Logger
+	logWriter postgres.LogRepository
+	methods   postgres.PaymentMethodRepository
+	audit     postgres.AuditRepository
+	splitter  *Splitter
+	checker   *webhook.Checker
+}
+
+func NewNotificationHandler(log *slog.Logger, logWriter postgres.LogRepository,
+	methods postgres.PaymentMethodRepository, audit postgres.AuditRepository, splitter *Splitter,
+	checker *webhook.Checker) *NotificationHandler {
+	return &NotificationHandler{
+		log:       log,
+		logWriter: logWriter,
+		methods:   methods,
+		audit:     audit,
+		splitter:  splitter,
+		checker:   checker,
+	}
+}
+
+// Notify handles YooKassa notification. Its body isn't trusted: the object is requested
+// from YooKassa and the transaction is found by metadata of the received object.
+func (h *NotificationHandler) Notify(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Notify"
+
+	log := requestLogger(r, h.log, fn)
+	log.Debug("notification endpoint called")
+
+	req := new(Notification)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	resource, ok := req.resource()
+	if !ok || req.Object.ID == "" {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("unknown event or empty object id"))
+		return
+	}
+	log = annotate(r, log, slog.String("event", req.Event), slog.String("yookassa_id", req.Object.ID))
+
+	object, err := h.checker.FetchStatus(r.Context(), resource, req.Object.ID)
+	if errors.Is(err, webhook.UnknownObject) {
+		// The notification isn't sent by YooKassa, it mustn't be repeated
+		log.Warn("notification of unknown object")
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse("unknown object"))
+		return
+	}
+	if err != nil {
+		log.Error("failed to verify notification", slog.String("error", err.Error()))
+		// YooKassa repeats notification if the answer isn't 200
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if object.Status != req.Object.Status {
+		log.Warn("notified status differs from the actual one",
+			slog.String("notified", string(req.Object.Status)),
+			slog.String("actual", string(object.Status)),
+		)
+	}
+
+	serverUUID, err := uuid.Parse(object.Metadata["transaction_id"])
+	if err != nil {
+		var found bool
+		if serverUUID, found = h.checker.FindTransaction(req.Object.ID); !found {
+			log.Warn("notification of unknown transaction")
+			myJson.Write(w, http.StatusNotFound, NewErrorResponse("unknown transaction"))
+			return
+		}
+	}
+	log = annotate(r, log, slog.String("transaction_id", serverUUID.String()))
+
+	err = h.checker.Resolve(serverUUID, object.Status)
+	if errors.Is(err, webhook.UnknownTransaction) {
+		log.Warn("notification of unknown transaction")
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse("unknown transaction"))
+		return
+	}
+	if err != nil {
+		log.Error("failed to resolve transaction", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	if _, err = h.logWriter.UpdateLogStatus(detached(r), req.Object.ID, string(object.Status)); err != nil {
+		log.Error("failed to update log status", slog.String("error", err.Error()))
+	}
+
+	if resource == metrics.PaymentsEndpoint && object.Status == metrics.Succeeded {
+		savePaymentMethod(r, log, h.methods, h.audit, object.Metadata["user_id"], req.Object.ID, object.PaymentMethod)
+	}
+	if object.Status.IsAlreadyProcessedStatus() {
+		h.splitter.OnFinalStatus(r.Context(), resource, req.Object.ID, object)
+	}
+
+	log.Info("notification processed", slog.String("status", string(object.Status)))
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,13 +1,11 @@
 package endpoints
 
 import (
-	"bytes"
-	"fmt"
-	"io"
+	"context"
+	"errors"
 	"time"
 	"unicode"
 
-	"encoding/json"
 	"log/slog"
 	"net/http"
 
@@ -15,9 +13,11 @@ import (
 	_ "github.com/imperatorofdwelling/Website-backend/internal/metrics"
 	"github.com/imperatorofdwelling/Website-backend/internal/models"
 	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
 	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
 
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
 
 	"github.com/google/uuid"
 )
@@ -59,19 +59,26 @@ func (c SaveCard) isFullData() bool {
 type SaveCardHandler struct {
 	log       *slog.Logger
 	logWriter postgres.LogRepository
+	audit     postgres.AuditRepository
+	users     postgres.UserRepository
+	cards     postgres.CardStore
 }
 
-func NewSaveCardHandler(log *slog.Logger, db postgres.LogRepository) *SaveCardHandler {
+func NewSaveCardHandler(log *slog.Logger, db postgres.LogRepository, audit postgres.AuditRepository,
+	users postgres.UserRepository, cards postgres.CardStore) *SaveCardHandler {
 	return &SaveCardHandler{
 		log:       log,
 		logWriter: db,
+		audit:     audit,
+		users:     users,
+		cards:     cards,
 	}
 }
 
 func (h SaveCardHandler) SaveCard(w http.ResponseWriter, r *http.Request) {
 	const fn = "endpoints.SaveCardHandler"
 
-	log := h.log.With(slog.String("fn", fn))
+	log := requestLogger(r, h.log, fn)
 	log.Debug("safe card endpoint called")
 
 	c := new(SaveCard)
@@ -85,6 +92,11 @@ func (h SaveCardHandler) SaveCard(w http.ResponseWriter, r *http.Request) {
 		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request, not full data"))
 		return
 	}
+	log = annotate(r, log, slog.String("user_id", c.UserId))
+	if err := activeUser(r.Context(), h.users, c.UserId); err != nil {
+		writeUserError(w, log, err)
+		return
+	}
 
 	usedUUID, _ := uuid.Parse(c.UserId)
 	insertedUsed := models.User{
@@ -94,14 +106,15 @@ func (h SaveCardHandler) SaveCard(w http.ResponseWriter, r *http.Request) {
 	cardMask, _ := models.GenerateCardMask(c.FirstSix, c.LastFour)
 	insertedCard := models.NewRefillableCard(&insertedUsed, c.Synonym, cardMask)
 
-	db, isContains := postgres.GetDB()
-	if !isContains {
-		log.Error("failed to get database")
+	// Previous card is needed only for audit (mask, never synonym)
+	oldCard, err := h.cards.GetRefillableCardByUserID(r.Context(), usedUUID)
+	if err != nil {
+		log.Error("failed to get previous refillable card", slog.String("error", err.Error()))
 		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse(
-			"Internal server error, database isn't initialized"))
+			"Internal server error!"))
 		return
 	}
-	isUpdated, err := db.InsertOrUpdateRefillableCard(insertedCard)
+	isUpdated, err := h.cards.InsertOrUpdateRefillableCard(r.Context(), insertedCard)
 	if err != nil {
 		log.Error("failed to insert or update refillable card", slog.String("error", err.Error()))
 		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse(
@@ -110,11 +123,18 @@ func (h SaveCardHandler) SaveCard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var messageToLog string
+	auditEvent := newAuditEvent(r, postgres.AuditCardBound, postgres.AuditSubjectCard, c.UserId, c.UserId)
+	auditEvent.NewValue = cardMask
 	if isUpdated {
-		messageToLog = fmt.Sprintf("Card info updated successfully, userID: %v", c.UserId)
+		messageToLog = "Card info updated successfully"
+		auditEvent.EventType = postgres.AuditCardReplaced
+		if oldCard != nil {
+			auditEvent.OldValue = oldCard.CardMask
+		}
 	} else {
-		messageToLog = fmt.Sprintf("Card info insert successfully, userID: %v", c.UserId)
+		messageToLog = "Card info insert successfully"
 	}
+	writeAudit(r.Context(), log, h.audit, auditEvent)
 
 	log.Info(messageToLog)
 
@@ -137,14 +157,17 @@ func (h SaveCardHandler) SaveCard(w http.ResponseWriter, r *http.Request) {
 // ________________
 // ****************
 
-const (
-	DefaultDescription = "From ImperatorOfDwelling for renting an apartment."
-)
-
 // PayoutRequestEndpoint endpoint parameters
 type PayoutRequestEndpoint struct {
 	ToUserId string `json:"user_id"`
 	Amount   Amount `json:"amount"`
+	// BookingID or OrderID references the paid out entity of the main site
+	BookingID string `json:"booking_id,omitempty"`
+	OrderID   string `json:"order_id,omitempty"`
+	// FromBalance takes the payout from the landlord balance (commission split of payments)
+	FromBalance bool `json:"from_balance,omitempty"`
+	// Destination is bank_card (by default), sbp or yoo_money bound by the user
+	Destination string `json:"destination,omitempty"`
 }
 
 func (p PayoutRequestEndpoint) isFullData() bool {
@@ -160,9 +183,11 @@ func (p PayoutRequestEndpoint) isFullData() bool {
 // PayloadRequestKassa provided json paraments of payout request
 // https://yookassa.ru/developers/payouts/making-payouts/bank-card/using-payout-widget/making-payouts-with-synonym
 type PayloadRequestKassa struct {
-	Amount      Amount `json:"amount"`
-	CardSynonym string `json:"card_synonym"`
-	Description string `json:"description"`
+	Amount                Amount                 `json:"amount"`
+	CardSynonym           string                 `json:"card_synonym,omitempty"`
+	PayoutDestinationData *PayoutDestinationData `json:"payout_destination_data,omitempty"`
+	Description           string                 `json:"description"`
+	Metadata              *Metadata              `json:"metadata"`
 }
 
 // YooKassaPayloadModel YooKassa payload model
@@ -182,31 +207,49 @@ type PayloadAnswer struct {
 	YouKassaModel *YooKassaPayloadModel `json:"you_kassa_payload_model"`
 }
 
-func NewPayloadAnswer(youKassaModel *YooKassaPayloadModel) *PayloadAnswer {
-	newUUID, _ := uuid.NewUUID()
+func NewPayloadAnswer(transactionID uuid.UUID, youKassaModel *YooKassaPayloadModel) *PayloadAnswer {
 	return &PayloadAnswer{
-		TransactionId: newUUID,
+		TransactionId: transactionID,
 		Status:        youKassaModel.Status,
 		YouKassaModel: youKassaModel,
 	}
 }
 
 type PayloadHandler struct {
-	log       *slog.Logger
-	logWriter postgres.LogRepository
+	log          *slog.Logger
+	logWriter    postgres.LogRepository
+	audit        postgres.AuditRepository
+	users        postgres.UserRepository
+	splitter     *Splitter
+	destinations postgres.PayoutDestinationRepository
+	cards        postgres.CardStore
+	gateway      yookassa.Gateway
+	checker      *webhook.Checker
+	background   *Background
 }
 
-func NewPayloadHandler(log *slog.Logger, logWriter postgres.LogRepository) *PayloadHandler {
+func NewPayloadHandler(log *slog.Logger, logWriter postgres.LogRepository, audit postgres.AuditRepository,
+	users postgres.UserRepository, splitter *Splitter, destinations postgres.PayoutDestinationRepository,
+	cards postgres.CardStore, gateway yookassa.Gateway, checker *webhook.Checker,
+	background *Background) *PayloadHandler {
 	return &PayloadHandler{
-		log:       log,
-		logWriter: logWriter,
+		log:          log,
+		logWriter:    logWriter,
+		audit:        audit,
+		users:        users,
+		splitter:     splitter,
+		destinations: destinations,
+		cards:        cards,
+		gateway:      gateway,
+		checker:      checker,
+		background:   background,
 	}
 }
 
 func (h *PayloadHandler) Payload(w http.ResponseWriter, r *http.Request) {
 	const fn = "endpoints.Payload"
 
-	log := h.log.With(slog.String("fn", fn))
+	log := requestLogger(r, h.log, fn)
 	log.Debug("payload endpoint called")
 
 	req := new(PayoutRequestEndpoint)
@@ -221,15 +264,11 @@ func (h *PayloadHandler) Payload(w http.ResponseWriter, r *http.Request) {
 		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("provided not full data"))
 		return
 	}
-
-	currDB, exists := postgres.GetDB()
-	if !exists {
-		log.Error("failed to get database")
-		myJson.Write(w, http.StatusInternalServerError,
-			NewErrorResponse("internal server error, database isn't initialized"),
-		)
+	if !isDestinationType(req.Destination) {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrPayoutDestination.Error()))
 		return
 	}
+	log = annotate(r, log, slog.String("user_id", req.ToUserId))
 
 	uuidUser, err := uuid.Parse(req.ToUserId)
 	if err != nil {
@@ -237,111 +276,189 @@ func (h *PayloadHandler) Payload(w http.ResponseWriter, r *http.Request) {
 		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
 		return
 	}
-	row, err := currDB.GetRefillableCardByUserID(uuidUser)
-	cardSynonym := row.CardSynonym
-	if err != nil {
-		log.Error("failed to get database refillable card", slog.String("error", err.Error()))
-		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse(
-			"internal server error, error getting mask card"),
-		)
+	if err = activeUser(r.Context(), h.users, req.ToUserId); err != nil {
+		writeUserError(w, log, err)
 		return
 	}
-	if cardSynonym == "" {
-		log.Info("failed to get database refillable card", slog.String("error", err.Error()))
-		myJson.Write(w, http.StatusLocked, NewErrorResponse("the card is untethered for userID"))
+	destination, err := findPayoutDestination(r.Context(), h.cards, h.destinations, uuidUser, req.Destination)
+	if errors.Is(err, ErrCardNotBound) || errors.Is(err, ErrDestinationNotBound) {
+		log.Info("failed to get payout destination", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusLocked, NewErrorResponse(err.Error()))
 		return
 	}
-
-	createReq := createPayloadBody(req, cardSynonym)
-
-	resp, err := sendPayloadRequest(createReq)
 	if err != nil {
-		log.Error(
-			"failed to send request to YooKassa API",
-			slog.String("error", err.Error()),
+		log.Error("failed to get payout destination", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse(
+			"internal server error, error getting payout destination"),
 		)
-		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
 		return
 	}
 
-	log.Debug("request to API sent")
-
-	defer resp.Body.Close()
-
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
+	transactionID := uuid.New()
+	log = annotate(r, log, slog.String("transaction_id", transactionID.String()))
+	createReq, err := createPayloadBody(req, destination, transactionID)
 	if err != nil {
-		log.Error(
-			"failed to read response",
-			slog.String("error", err.Error()),
-		)
+		log.Error("failed to build payout description", slog.String("error", err.Error()))
 		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
 		return
 	}
 
-	youkassaResp := new(YooKassaPayloadModel)
+	if req.FromBalance {
+		createReq.Metadata.LedgerAccount = postgres.LandlordAccount(req.ToUserId)
+		if err = h.splitter.DebitPayout(r.Context(), createReq.Metadata, req.Amount); err != nil {
+			if errors.Is(err, ErrInsufficientBalance) {
+				myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+				return
+			}
+			log.Error("failed to debit balance", slog.String("error", err.Error()))
+			myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+			return
+		}
+	}
 
-	// Create JSON Response
-	if err := json.Unmarshal(respBody, youkassaResp); err != nil {
-		log.Error(
-			"failed to make json from response",
-			slog.String("error", err.Error()),
-		)
-		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+	auditEvent := newAuditEvent(r, postgres.AuditPayoutCreate, postgres.AuditSubjectPayout, "", req.ToUserId)
+	auditEvent.NewValue = destination.mask
+
+	// The transaction ID is the idempotence key, so resent request can't create the second payout
+	youkassaResp, err := sendPayloadRequest(r.Context(), h.gateway, createReq, transactionID)
+	var apiErr *YooKassaError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError:
+		log.Info("payout rejected by YooKassa", slog.String("error", err.Error()))
+		h.reverse(detached(r), log, createReq.Metadata, req.Amount)
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(apiErr.Description))
+		return
+	case err != nil:
+		// The payout may be created, the balance stays debited until the result is known
+		log.Error("failed to create payout, resending it", slog.String("error", err.Error()))
+		ctx := detached(r)
+		h.background.Go(func() { h.settle(ctx, log, createReq, req.Amount, auditEvent) })
+		myJson.Write(w, http.StatusAccepted, &PayloadAnswer{TransactionId: transactionID, Status: metrics.Pending})
 		return
 	}
 
-	payloadResp := NewPayloadAnswer(youkassaResp)
-	checkerData := webhook.NewWebhookData(payloadResp.YouKassaModel.ID, payloadResp.TransactionId)
-	_ = webhook.StartCheck(checkerData, payloadResp.Status)
-
-	//Send response to Frontend
-	myJson.Write(w, http.StatusOK, payloadResp)
+	log.Debug("request to API sent")
 
-	if payloadResp.Status == metrics.Pending {
+	h.record(detached(r), annotate(r, log, slog.String("yookassa_id", youkassaResp.ID)),
+		createReq, req.Amount, youkassaResp, auditEvent)
 
-	}
+	//Send response to Frontend
+	myJson.Write(w, http.StatusOK, NewPayloadAnswer(transactionID, youkassaResp))
 
 	log.Info("response to frontend successfully sent")
 
 }
 
-func createPayloadBody(c *PayoutRequestEndpoint, cardSynonym string) *PayloadRequestKassa {
-	createReq := &PayloadRequestKassa{
-		Amount:      c.Amount,
-		CardSynonym: cardSynonym,
-		Description: DefaultDescription,
+// payoutResendDelays are delays between attempts to create payout whose result is unknown
+var payoutResendDelays = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// settle resends payout request with the same idempotence key until YooKassa answers. The balance
+// is returned if the payout is rejected, it stays debited if the result is still unknown, so the
+// payout is found by reconciliation and not paid twice. On shutdown the remaining attempts are made
+// without delays, so the result is recorded before the service stops.
+func (h *PayloadHandler) settle(ctx context.Context, log *slog.Logger, createReq *PayloadRequestKassa,
+	amount Amount, auditEvent *postgres.AuditEvent) {
+	transactionID, err := uuid.Parse(createReq.Metadata.TransactionID)
+	if err != nil {
+		log.Error("failed to parse transaction id", slog.String("error", err.Error()))
+		return
 	}
-	return createReq
+	for _, delay := range payoutResendDelays {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.background.Stopping():
+		case <-time.After(delay):
+		}
+		model, err := sendPayloadRequest(ctx, h.gateway, createReq, transactionID)
+		var apiErr *YooKassaError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError:
+			log.Info("payout rejected by YooKassa", slog.String("error", err.Error()))
+			h.reverse(ctx, log, createReq.Metadata, amount)
+			return
+		case err != nil:
+			log.Error("failed to resend payout", slog.String("error", err.Error()))
+			continue
+		}
+		h.record(ctx, log.With(slog.String("yookassa_id", model.ID)), createReq, amount, model, auditEvent)
+		return
+	}
+	log.Error("payout result is unknown, balance stays debited, created payout is reported by reconciliation")
 }
 
-func sendPayloadRequest(r *PayloadRequestKassa) (*http.Response, error) {
-	createReqJson, err := json.Marshal(r)
-	if err != nil {
-		return nil, err
+// record writes audit and log of the created payout and starts checking its status. Canceled
+// payout is returned to the balance, pending one is returned when it's canceled.
+func (h *PayloadHandler) record(ctx context.Context, log *slog.Logger, createReq *PayloadRequestKassa,
+	amount Amount, model *YooKassaPayloadModel, auditEvent *postgres.AuditEvent) {
+	if model.Status == metrics.Canceled {
+		h.reverse(ctx, log, createReq.Metadata, amount)
 	}
-	apiReq, err := http.NewRequest(
-		"POST",
-		metrics.PaymentsApi+metrics.PayoutsEndpoint,
-		bytes.NewBuffer(createReqJson),
-	)
+	transactionID, err := uuid.Parse(createReq.Metadata.TransactionID)
 	if err != nil {
-		return nil, err
+		log.Error("failed to parse transaction id", slog.String("error", err.Error()))
+		return
+	}
+
+	event := *auditEvent
+	event.SubjectID = model.ID
+	event.Details = map[string]string{
+		"user_id":        createReq.Metadata.UserID,
+		"amount":         amount.Value,
+		"currency":       amount.Currency,
+		"status":         string(model.Status),
+		"transaction_id": transactionID.String(),
+	}
+	writeAudit(ctx, log, h.audit, &event)
+
+	logToDb := postgres.NewLog(model.ID, amount.Value, string(model.Status), model.CreatedAt)
+	logToDb.Kind = postgres.LogKindPayout
+	logToDb.Currency = amount.Currency
+	logToDb.TraceID = tracing.TraceID(ctx)
+	if err = h.logWriter.InsertLog(ctx, logToDb); err != nil {
+		log.Error("failed to write log to db", slog.String("error", err.Error()))
 	}
-	idempotenceKey := uuid.New().String()
 
-	apiReq.SetBasicAuth(metrics.GetConfirmationData())
-	apiReq.Header.Set("Idempotence-Key", idempotenceKey)
-	apiReq.Header.Set("Content-Type", "application/json")
+	checkerData := webhook.NewWebhookData(metrics.PayoutsEndpoint, model.ID, transactionID, amount.Value)
+	_ = h.checker.StartCheck(ctx, checkerData, model.Status)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(apiReq)
+// reverse returns payout to the balance if it was taken from it
+func (h *PayloadHandler) reverse(ctx context.Context, log *slog.Logger, metadata *Metadata, amount Amount) {
+	if err := h.splitter.ReversePayout(ctx, metadata, amount); err != nil {
+		log.Error("failed to reverse payout", slog.String("error", err.Error()))
+	}
+}
 
+func createPayloadBody(c *PayoutRequestEndpoint, destination *payoutDestination,
+	transactionID uuid.UUID) (*PayloadRequestKassa, error) {
+	metadata := NewMetadata(transactionID, c.ToUserId, c.BookingID, c.OrderID)
+	description, err := renderDescription(metrics.GetDescriptionTemplates().Payout, metadata, c.Amount)
 	if err != nil {
 		return nil, err
 	}
+	createReq := &PayloadRequestKassa{
+		Amount:                c.Amount,
+		CardSynonym:           destination.cardSynonym,
+		PayoutDestinationData: destination.data,
+		Description:           description,
+		Metadata:              metadata,
+	}
+	return createReq, nil
+}
 
-	return resp, nil
+// sendPayloadRequest creates payout, error responses of YooKassa are returned as *YooKassaError
+func sendPayloadRequest(ctx context.Context, gateway yookassa.Gateway, r *PayloadRequestKassa,
+	transactionID uuid.UUID) (*YooKassaPayloadModel, error) {
+	resp, err := gateway.Do(ctx, http.MethodPost, metrics.PayoutsEndpoint, r, transactionID.String())
+	if err != nil {
+		return nil, err
+	}
+	model := new(YooKassaPayloadModel)
+	if err = readYooKassaResponse(resp, model); err != nil {
+		return nil, err
+	}
+	return model, nil
 }
 
 // ______________
@@ -1,7 +1,7 @@
 package endpoints
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
@@ -9,10 +9,13 @@ import (
 
 	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
 	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
 	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Request from frontend
@@ -20,6 +23,22 @@ import (
 type Create struct {
 	UserId string `json:"user_id,omitempty"`
 	Amount Amount `json:"amount,omitempty"`
+	// BookingID or OrderID references the paid entity of the main site
+	BookingID string `json:"booking_id,omitempty"`
+	OrderID   string `json:"order_id,omitempty"`
+	// Receipt is optional, it's required only if the store sends receipts through YooKassa
+	Receipt *BookingReceipt `json:"receipt,omitempty"`
+	// Confirmation is embedded (YooKassa widget) by default
+	Confirmation *Confirmation `json:"confirmation,omitempty"`
+	// PaymentMethod restricts the payment to bank_card, sbp, yoo_money or sberbank
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// SavePaymentMethod saves the method for later charges (e.g. monthly rent)
+	SavePaymentMethod bool `json:"save_payment_method,omitempty"`
+	// SavedPaymentMethodID charges method saved before (ID from GET /payment_methods) without the user
+	SavedPaymentMethodID string `json:"saved_payment_method_id,omitempty"`
+	// LandlordID gets the payment minus platform commission (by rules of the landlord or PropertyTier)
+	LandlordID   string `json:"landlord_id,omitempty"`
+	PropertyTier string `json:"property_tier,omitempty"`
 }
 
 func NewCreate(userId string, val string, currency string) *Create {
@@ -35,10 +54,16 @@ func NewCreate(userId string, val string, currency string) *Create {
 // request to YoooKassa API
 
 type CreatePaymentRequest struct {
-	Amount       Amount       `json:"amount"`
-	Confirmation Confirmation `json:"confirmation"`
-	Capture      bool         `json:"capture"`
-	Description  string       `json:"description"`
+	Amount Amount `json:"amount"`
+	// Confirmation is nil when saved payment method is charged
+	Confirmation      *Confirmation      `json:"confirmation,omitempty"`
+	PaymentMethodData *PaymentMethodData `json:"payment_method_data,omitempty"`
+	SavePaymentMethod bool               `json:"save_payment_method,omitempty"`
+	PaymentMethodID   string             `json:"payment_method_id,omitempty"`
+	Capture           bool               `json:"capture"`
+	Description       string             `json:"description"`
+	Metadata          *Metadata          `json:"metadata"`
+	Receipt           *Receipt           `json:"receipt,omitempty"`
 }
 
 // PaymentResponse response from youkassa
@@ -50,10 +75,21 @@ type PaymentResponse struct {
 	Confirmation ConfirmationResponse `json:"confirmation"`
 	CreatedAt    string               `json:"created_at"`
 	Description  string               `json:"description"`
-	Metadata     interface{}          `json:"metadata"`
+	Metadata     *Metadata            `json:"metadata"`
 	Recipient    Recipient            `json:"recipient"`
 	Refundable   bool                 `json:"refundable"`
 	Test         bool                 `json:"test"`
+	// ReceiptRegistration is status of the receipt sent with the payment
+	ReceiptRegistration string `json:"receipt_registration,omitempty"`
+	// PaymentMethod is known when the user has chosen it (or it's charged saved method)
+	PaymentMethod *webhook.PaymentMethod `json:"payment_method,omitempty"`
+	// CancellationDetails explains why the payment is canceled
+	CancellationDetails *CancellationDetails `json:"cancellation_details,omitempty"`
+}
+
+type CancellationDetails struct {
+	Party  string `json:"party"`
+	Reason string `json:"reason"`
 }
 
 // PaymentAnswer response (answer) to frontend
@@ -63,10 +99,9 @@ type PaymentAnswer struct {
 	YouKassaModel *PaymentResponse `json:"you_kassa_model"`
 }
 
-func NewPaymentAnswer(youKassaModel *PaymentResponse) *PaymentAnswer {
-	newUUID, _ := uuid.NewUUID()
+func NewPaymentAnswer(transactionID uuid.UUID, youKassaModel *PaymentResponse) *PaymentAnswer {
 	return &PaymentAnswer{
-		TransactionId: newUUID,
+		TransactionId: transactionID,
 		Status:        metrics.Pending,
 		YouKassaModel: youKassaModel,
 	}
@@ -79,20 +114,11 @@ type Amount struct {
 	Currency string `json:"currency"`
 }
 
-type Confirmation struct {
-	Type string `json:"type"`
-}
-
 type Recipient struct {
 	AccountID string `json:"account_id"`
 	GatewayID string `json:"gateway_id"`
 }
 
-type ConfirmationResponse struct {
-	Type              string `json:"type"`
-	ConfirmationToken string `json:"confirmation_token"`
-}
-
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -106,19 +132,35 @@ func NewErrorResponse(message string) *ErrorResponse {
 type PaymentHandler struct {
 	log       *slog.Logger
 	logWriter postgres.LogRepository
+	receipts  postgres.ReceiptRepository
+	methods   postgres.PaymentMethodRepository
+	audit     postgres.AuditRepository
+	users     postgres.UserRepository
+	splitter  *Splitter
+	gateway   yookassa.Gateway
+	checker   *webhook.Checker
 }
 
-func NewPaymentHandler(log *slog.Logger, db postgres.LogRepository) *PaymentHandler {
+func NewPaymentHandler(log *slog.Logger, db postgres.LogRepository, receipts postgres.ReceiptRepository,
+	methods postgres.PaymentMethodRepository, audit postgres.AuditRepository, users postgres.UserRepository,
+	splitter *Splitter, gateway yookassa.Gateway, checker *webhook.Checker) *PaymentHandler {
 	return &PaymentHandler{
 		log:       log,
 		logWriter: db,
+		receipts:  receipts,
+		methods:   methods,
+		audit:     audit,
+		users:     users,
+		splitter:  splitter,
+		gateway:   gateway,
+		checker:   checker,
 	}
 }
 
 func (h *PaymentHandler) Payment(w http.ResponseWriter, r *http.Request) {
 	const fn = "endpoints.Payment"
 
-	log := h.log.With(slog.String("fn", fn))
+	log := requestLogger(r, h.log, fn)
 	log.Debug("payment endpoint called")
 
 	req := new(Create)
@@ -134,10 +176,72 @@ func (h *PaymentHandler) Payment(w http.ResponseWriter, r *http.Request) {
 		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("userId or amount is empty"))
 		return
 	}
+	log = annotate(r, log, slog.String("user_id", req.UserId))
 
-	createReq := createPaymentBody(req)
+	confirmation, err := newConfirmation(req.Confirmation, req.PaymentMethod)
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	paymentMethodData, err := newPaymentMethodData(req.PaymentMethod)
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+
+	transactionID := uuid.New()
+	log = annotate(r, log, slog.String("transaction_id", transactionID.String()))
+	createReq, err := createPaymentBody(req, transactionID)
+	if err != nil {
+		log.Error("failed to build payment description", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	createReq.PaymentMethodData = paymentMethodData
+	createReq.SavePaymentMethod = req.SavePaymentMethod
+	if req.SavedPaymentMethodID != "" {
+		if req.Confirmation != nil || req.PaymentMethod != "" {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrSavedMethodConfirmation.Error()))
+			return
+		}
+		if req.SavePaymentMethod {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrSaveSavedMethod.Error()))
+			return
+		}
+		publicID, err := uuid.Parse(req.SavedPaymentMethodID)
+		if err != nil {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse("saved_payment_method_id is invalid"))
+			return
+		}
+		method, err := h.methods.GetPaymentMethodByPublicID(r.Context(), req.UserId, publicID)
+		if err != nil {
+			log.Error("failed to get payment method", slog.String("error", err.Error()))
+			myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+			return
+		}
+		if method == nil {
+			myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrPaymentMethodNotFound.Error()))
+			return
+		}
+		log = annotate(r, log, slog.String("payment_method", method.PublicID.String()))
+		createReq.PaymentMethodID = method.PaymentMethodID
+	} else {
+		createReq.Confirmation = &confirmation
+	}
+	if req.Receipt != nil {
+		receipt, err := req.Receipt.toReceipt(req.Amount, PaymentModeFullPrepayment)
+		if err != nil {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+			return
+		}
+		createReq.Receipt = receipt
+	}
+	if err = activeUser(r.Context(), h.users, req.UserId); err != nil {
+		writeUserError(w, log, err)
+		return
+	}
 
-	resp, err := sendRequest(createReq)
+	resp, err := sendRequest(r.Context(), h.gateway, createReq)
 	if err != nil {
 		log.Error(
 			"failed to send request to YooKassa API",
@@ -163,6 +267,7 @@ func (h *PaymentHandler) Payment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	log = annotate(r, log, slog.String("yookassa_id", responseFromYooKassa.ID))
 	log.Info("Response", slog.Any("response", responseFromYooKassa))
 	// If status is empty, it's mean that request is bad (for ex. invalid currency or negative value)
 	if responseFromYooKassa.Status == "" {
@@ -184,16 +289,22 @@ func (h *PaymentHandler) Payment(w http.ResponseWriter, r *http.Request) {
 
 	logToDb := postgres.NewLog(responseFromYooKassa.ID, req.Amount.Value, string(responseFromYooKassa.Status), createdAt)
 
-	paymentResp := NewPaymentAnswer(responseFromYooKassa)
-	checkerData := webhook.NewWebhookData(paymentResp.YouKassaModel.ID, paymentResp.TransactionId)
-	_ = webhook.StartCheck(checkerData, paymentResp.Status)
+	paymentResp := NewPaymentAnswer(transactionID, responseFromYooKassa)
+	checkerData := webhook.NewWebhookData(metrics.PaymentsEndpoint, paymentResp.YouKassaModel.ID,
+		paymentResp.TransactionId, req.Amount.Value)
+	_ = h.checker.StartCheck(r.Context(), checkerData, paymentResp.Status)
 
 	log.Info("response to frontend successfully sent")
 
 	logToDb = postgres.NewLog(responseFromYooKassa.ID, req.Amount.Value,
 		string(responseFromYooKassa.Status), createdAt)
+	logToDb.Currency = req.Amount.Currency
+	logToDb.TraceID = tracing.TraceID(r.Context())
 
-	err = h.logWriter.InsertLog(logToDb)
+	_, span := tracing.Start(r.Context(), "postgres.InsertLog",
+		attribute.String("yookassa_id", logToDb.TransactionID))
+	err = h.logWriter.InsertLog(detached(r), logToDb)
+	tracing.End(span, err)
 	if err != nil {
 		log.Error("failed to write log to db", slog.String("error", err.Error()))
 		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
@@ -201,48 +312,49 @@ func (h *PaymentHandler) Payment(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info("log to db successfully written")
 
-	// Send response to Frontend
-	myJson.Write(w, http.StatusOK, responseFromYooKassa)
-}
-
-func createPaymentBody(create *Create) *CreatePaymentRequest {
-	orderNum := uuid.New().String()
-	createReq := &CreatePaymentRequest{
-		Amount: create.Amount,
-		Confirmation: Confirmation{
-			Type: "embedded",
-		},
-		Capture:     true,
-		Description: "Заказ № " + orderNum,
+	if createReq.Receipt != nil {
+		record := newReceiptRecord(postgres.ReceiptTypePayment, responseFromYooKassa.ID, req.Receipt.BookingID,
+			responseFromYooKassa.ReceiptRegistration, createReq.Receipt)
+		if err = h.receipts.InsertReceipt(detached(r), record); err != nil {
+			// Registration status is still tracked by YooKassa, the receipt is stored on the next refresh
+			log.Error("failed to save receipt", slog.String("error", err.Error()))
+		}
 	}
-	return createReq
-}
 
-func sendRequest(createReq *CreatePaymentRequest) (*http.Response, error) {
-	createReqJson, err := json.Marshal(createReq)
-	if err != nil {
-		return nil, err
+	if responseFromYooKassa.Status == metrics.Succeeded {
+		// Payments confirmed later are split by notification or status check
+		if err = h.splitter.SplitPayment(detached(r), responseFromYooKassa.ID, req.Amount, createReq.Metadata); err != nil {
+			log.Error("failed to split payment", slog.String("error", err.Error()))
+		}
 	}
-	apiReq, err := http.NewRequest(
-		"POST",
-		metrics.PaymentsApi+metrics.PaymentsEndpoint,
-		bytes.NewBuffer(createReqJson),
-	)
-	if err != nil {
-		return nil, err
-	}
-	idempotenceKey := uuid.New().String()
 
-	apiReq.SetBasicAuth(metrics.GetConfirmationData())
-	apiReq.Header.Set("Idempotence-Key", idempotenceKey)
-	apiReq.Header.Set("Content-Type", "application/json")
+	if req.SavePaymentMethod {
+		// Usually the method is known only after confirmation, then it's saved by notification
+		savePaymentMethod(r, log, h.methods, h.audit, req.UserId, responseFromYooKassa.ID,
+			responseFromYooKassa.PaymentMethod)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(apiReq)
+	// Send response to Frontend
+	myJson.Write(w, http.StatusOK, responseFromYooKassa)
+}
 
+func createPaymentBody(create *Create, transactionID uuid.UUID) (*CreatePaymentRequest, error) {
+	metadata := NewMetadata(transactionID, create.UserId, create.BookingID, create.OrderID)
+	metadata.LandlordID = create.LandlordID
+	metadata.Tier = create.PropertyTier
+	description, err := renderDescription(metrics.GetDescriptionTemplates().Payment, metadata, create.Amount)
 	if err != nil {
 		return nil, err
 	}
+	createReq := &CreatePaymentRequest{
+		Amount:      create.Amount,
+		Capture:     true,
+		Description: description,
+		Metadata:    metadata,
+	}
+	return createReq, nil
+}
 
-	return resp, nil
+func sendRequest(ctx context.Context, gateway yookassa.Gateway, createReq *CreatePaymentRequest) (*http.Response, error) {
+	return sendYooKassaRequest(ctx, gateway, http.MethodPost, metrics.PaymentsEndpoint, createReq)
 }
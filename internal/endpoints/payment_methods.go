@@ -0,0 +1,128 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Saved payment methods
+// ________________
+// ****************
+
+var (
+	ErrSavedMethodConfirmation = errors.New("saved payment method is charged without confirmation and payment_method")
+	ErrSaveSavedMethod         = errors.New("payment method is already saved")
+	ErrPaymentMethodNotFound   = errors.New("payment method not found")
+)
+
+type PaymentMethodsHandler struct {
+	log     *slog.Logger
+	methods postgres.PaymentMethodRepository
+	audit   postgres.AuditRepository
+}
+
+func NewPaymentMethodsHandler(log *slog.Logger, methods postgres.PaymentMethodRepository,
+	audit postgres.AuditRepository) *PaymentMethodsHandler {
+	return &PaymentMethodsHandler{
+		log:     log,
+		methods: methods,
+		audit:   audit,
+	}
+}
+
+// GetMethods returns saved payment methods of the user (GET /payment_methods?user_id=...)
+func (h *PaymentMethodsHandler) GetMethods(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetMethods"
+
+	log := requestLogger(r, h.log, fn)
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("user_id is empty"))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", userID))
+
+	methods, err := h.methods.GetPaymentMethods(r.Context(), userID)
+	if err != nil {
+		log.Error("failed to get payment methods", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, methods)
+}
+
+// Revoke revokes saved payment method of the user (DELETE /payment_methods/{id}?user_id=...),
+// it can't be charged anymore
+func (h *PaymentMethodsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Revoke"
+
+	log := requestLogger(r, h.log, fn)
+
+	userID := r.URL.Query().Get("user_id")
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if userID == "" || err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("user_id or payment method id is invalid"))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", userID), slog.String("payment_method", id.String()))
+
+	revoked, err := h.methods.RevokePaymentMethod(r.Context(), userID, id)
+	if err != nil {
+		log.Error("failed to revoke payment method", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if !revoked {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrPaymentMethodNotFound.Error()))
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditPaymentMethodRevoked, postgres.AuditSubjectPaymentMethod,
+		id.String(), userID)
+	auditEvent.Details["user_id"] = userID
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("payment method revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// savePaymentMethod stores payment method saved by YooKassa with the payment
+func savePaymentMethod(r *http.Request, log *slog.Logger, methods postgres.PaymentMethodRepository,
+	audit postgres.AuditRepository, userID, paymentID string, pm *webhook.PaymentMethod) {
+	if pm == nil || !pm.Saved || userID == "" {
+		return
+	}
+	method := &postgres.PaymentMethod{
+		UserID:          userID,
+		PaymentMethodID: pm.ID,
+		PaymentID:       paymentID,
+		Type:            pm.Type,
+		Title:           pm.Title,
+	}
+	if err := methods.InsertPaymentMethod(detached(r), method); err != nil {
+		log.Error("failed to save payment method", slog.String("error", err.Error()))
+		return
+	}
+	if method.ID == 0 {
+		// It's already saved
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditPaymentMethodSaved, postgres.AuditSubjectPaymentMethod,
+		method.PublicID.String(), userID)
+	auditEvent.Details["payment_id"] = paymentID
+	auditEvent.Details["type"] = pm.Type
+	writeAudit(r.Context(), log, audit, auditEvent)
+	log.Info("payment method saved", slog.String("payment_method", method.PublicID.String()))
+}
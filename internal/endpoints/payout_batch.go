@@ -0,0 +1,536 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Batch payouts
+// ________________
+// ****************
+
+// batchResumeActor is the actor of payouts of batches resumed on start
+const batchResumeActor = "resume"
+
+var (
+	ErrBatchEmpty       = errors.New("batch has no items")
+	ErrBatchNotFound    = errors.New("payout batch not found")
+	ErrBatchFromBalance = errors.New("from_balance isn't supported by batch payouts")
+)
+
+// PayoutBatchRequest is the list of payouts, every item is paid out independently
+type PayoutBatchRequest struct {
+	Items []PayoutRequestEndpoint `json:"items"`
+}
+
+// BatchItemError is validation error of the item, Position is its index in the request
+type BatchItemError struct {
+	Position int    `json:"position"`
+	Error    string `json:"error"`
+}
+
+type PayoutBatchResponse struct {
+	BatchID uuid.UUID `json:"batch_id,omitempty"`
+	// SummaryURL is the result summary in CSV
+	SummaryURL string           `json:"summary_url,omitempty"`
+	Errors     []BatchItemError `json:"errors,omitempty"`
+}
+
+// PayoutBatchSummary is the batch with the number of items by status
+type PayoutBatchSummary struct {
+	BatchID   uuid.UUID                   `json:"batch_id"`
+	CreatedAt time.Time                   `json:"created_at"`
+	Total     int                         `json:"total"`
+	Statuses  map[string]int              `json:"statuses"`
+	Items     []*postgres.PayoutBatchItem `json:"items"`
+}
+
+type PayoutBatchHandler struct {
+	log          *slog.Logger
+	logWriter    postgres.LogRepository
+	batches      postgres.PayoutBatchRepository
+	audit        postgres.AuditRepository
+	users        postgres.UserRepository
+	destinations postgres.PayoutDestinationRepository
+	cards        postgres.CardStore
+	gateway      yookassa.Gateway
+	checker      *webhook.Checker
+	background   *Background
+}
+
+func NewPayoutBatchHandler(log *slog.Logger, logWriter postgres.LogRepository, batches postgres.PayoutBatchRepository,
+	audit postgres.AuditRepository, users postgres.UserRepository, destinations postgres.PayoutDestinationRepository,
+	cards postgres.CardStore, gateway yookassa.Gateway, checker *webhook.Checker,
+	background *Background) *PayoutBatchHandler {
+	return &PayoutBatchHandler{
+		log:          log,
+		logWriter:    logWriter,
+		batches:      batches,
+		audit:        audit,
+		users:        users,
+		destinations: destinations,
+		cards:        cards,
+		gateway:      gateway,
+		checker:      checker,
+		background:   background,
+	}
+}
+
+// Create validates all items, records the batch and creates its payouts in background
+// (POST /payouts/batch). Nothing is paid out if any item is invalid.
+func (h *PayoutBatchHandler) Create(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.PayoutBatch.Create"
+
+	log := requestLogger(r, h.log, fn)
+
+	req := new(PayoutBatchRequest)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	limits := metrics.GetPayoutBatchLimits()
+	if len(req.Items) == 0 {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrBatchEmpty.Error()))
+		return
+	}
+	if len(req.Items) > limits.MaxItems {
+		myJson.Write(w, http.StatusBadRequest,
+			NewErrorResponse(fmt.Sprintf("batch has more than %v items", limits.MaxItems)))
+		return
+	}
+
+	if itemErrors := validateBatchItems(req.Items); len(itemErrors) > 0 {
+		myJson.Write(w, http.StatusBadRequest, PayoutBatchResponse{Errors: itemErrors})
+		return
+	}
+	itemErrors, err := validateBatchDestinations(r.Context(), h.users, h.cards, h.destinations, req.Items)
+	if err != nil {
+		log.Error("failed to validate batch", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if len(itemErrors) > 0 {
+		myJson.Write(w, http.StatusBadRequest, PayoutBatchResponse{Errors: itemErrors})
+		return
+	}
+
+	batch := &postgres.PayoutBatch{ID: uuid.New()}
+	items := make([]*postgres.PayoutBatchItem, 0, len(req.Items))
+	for i, item := range req.Items {
+		destination := item.Destination
+		if destination == "" {
+			destination = DestinationBankCard
+		}
+		items = append(items, &postgres.PayoutBatchItem{
+			Position:      i,
+			UserID:        item.ToUserId,
+			Amount:        item.Amount.Value,
+			Currency:      item.Amount.Currency,
+			Destination:   destination,
+			BookingID:     item.BookingID,
+			OrderID:       item.OrderID,
+			TransactionID: uuid.New(),
+		})
+	}
+	if err = h.batches.InsertBatch(r.Context(), batch, items); err != nil {
+		log.Error("failed to insert payout batch", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	log = annotate(r, log, slog.String("batch_id", batch.ID.String()))
+	log.Info("payout batch created", slog.Int("items", len(items)))
+
+	h.start(r, log, batch.ID)
+	myJson.Write(w, http.StatusAccepted, PayoutBatchResponse{
+		BatchID:    batch.ID,
+		SummaryURL: summaryURL(batch.ID),
+	})
+}
+
+// Retry resends queued items and creates failed and canceled items again with new idempotence keys
+// (POST /payouts/batch/{id}/retry). Succeeded and pending items aren't retried.
+func (h *PayoutBatchHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.PayoutBatch.Retry"
+
+	log := requestLogger(r, h.log, fn)
+
+	batch, ok := h.batch(w, r, log)
+	if !ok {
+		return
+	}
+	log = annotate(r, log, slog.String("batch_id", batch.ID.String()))
+	requeued, err := h.batches.RequeueBatchItems(r.Context(), batch.ID)
+	if err != nil {
+		log.Error("failed to requeue payout batch items", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	log.Info("payout batch retried", slog.Int("requeued", requeued))
+
+	h.start(r, log, batch.ID)
+	myJson.Write(w, http.StatusAccepted, PayoutBatchResponse{
+		BatchID:    batch.ID,
+		SummaryURL: summaryURL(batch.ID),
+	})
+}
+
+// GetBatch returns the summary of the batch (GET /payouts/batch/{id}), it's CSV file with format=csv
+func (h *PayoutBatchHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.PayoutBatch.GetBatch"
+
+	log := requestLogger(r, h.log, fn)
+
+	batch, ok := h.batch(w, r, log)
+	if !ok {
+		return
+	}
+	items, err := h.batches.GetBatchItems(r.Context(), batch.ID)
+	if err != nil {
+		log.Error("failed to get payout batch items", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	h.refresh(r.Context(), log, items)
+
+	summary := &PayoutBatchSummary{
+		BatchID:   batch.ID,
+		CreatedAt: batch.CreatedAt,
+		Total:     len(items),
+		Statuses:  make(map[string]int),
+		Items:     items,
+	}
+	for _, item := range items {
+		summary.Statuses[item.Status]++
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeBatchCSV(w, log, summary)
+		return
+	}
+	myJson.Write(w, http.StatusOK, summary)
+}
+
+func (h *PayoutBatchHandler) batch(w http.ResponseWriter, r *http.Request, log *slog.Logger) (*postgres.PayoutBatch, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return nil, false
+	}
+	batch, err := h.batches.GetBatch(r.Context(), id)
+	if err != nil {
+		log.Error("failed to get payout batch", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return nil, false
+	}
+	if batch == nil {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrBatchNotFound.Error()))
+		return nil, false
+	}
+	return batch, true
+}
+
+// start creates queued payouts of the batch in background. Items which aren't sent before shutdown
+// stay queued, they're resumed by ResumeQueuedBatches on the next start.
+func (h *PayoutBatchHandler) start(r *http.Request, log *slog.Logger, batchID uuid.UUID) {
+	// Actor, IP and request ID of audit events are the ones of the request
+	auditEvent := newAuditEvent(r, postgres.AuditPayoutCreate, postgres.AuditSubjectPayout, "", "admin")
+	ctx := context.WithoutCancel(r.Context())
+	h.background.Go(func() { h.process(ctx, log, batchID, auditEvent) })
+}
+
+// ResumeQueuedBatches sends queued items of batches interrupted by the previous shutdown.
+// Items whose result was unknown are resent with the same idempotence keys, so they aren't paid twice.
+func (h *PayoutBatchHandler) ResumeQueuedBatches(ctx context.Context) (int, error) {
+	ids, err := h.batches.GetQueuedBatchIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, id := range ids {
+		batchID := id
+		log := h.log.With(slog.String("fn", "endpoints.PayoutBatch.ResumeQueuedBatches"),
+			slog.String("batch_id", batchID.String()))
+		auditEvent := &postgres.AuditEvent{
+			EventType:   postgres.AuditPayoutCreate,
+			ActorID:     batchResumeActor,
+			SubjectType: postgres.AuditSubjectPayout,
+			Details:     map[string]string{},
+		}
+		h.background.Go(func() { h.process(ctx, log, batchID, auditEvent) })
+	}
+	return len(ids), nil
+}
+
+func (h *PayoutBatchHandler) process(ctx context.Context, log *slog.Logger, batchID uuid.UUID,
+	auditEvent *postgres.AuditEvent) {
+	items, err := h.batches.GetBatchItems(ctx, batchID)
+	if err != nil {
+		log.Error("failed to get payout batch items", slog.String("error", err.Error()))
+		return
+	}
+
+	sem := make(chan struct{}, metrics.GetPayoutBatchLimits().Concurrency)
+	var wg sync.WaitGroup
+	for _, item := range items {
+		if item.Status != postgres.BatchItemQueued {
+			continue
+		}
+		sem <- struct{}{}
+		if h.background.Stopped() {
+			<-sem
+			log.Info("payout batch interrupted by shutdown, queued items are resumed on start")
+			break
+		}
+		wg.Add(1)
+		go func(item *postgres.PayoutBatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h.send(ctx, log, item, auditEvent)
+		}(item)
+	}
+	wg.Wait()
+	log.Info("payout batch processed")
+}
+
+// send creates payout of the item. Item stays queued if the result is unknown (e.g. network error
+// or 5xx response), so retry resends it with the same idempotence key. It fails if YooKassa rejects it.
+func (h *PayoutBatchHandler) send(ctx context.Context, log *slog.Logger, item *postgres.PayoutBatchItem,
+	auditEvent *postgres.AuditEvent) {
+	log = log.With(
+		slog.Int("position", item.Position),
+		slog.String("user_id", item.UserID),
+		slog.String("transaction_id", item.TransactionID.String()),
+	)
+
+	model, err := h.createPayout(ctx, item)
+	var apiErr *YooKassaError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError,
+		errors.Is(err, ErrCardNotBound), errors.Is(err, ErrDestinationNotBound),
+		errors.Is(err, ErrUserNotFound), errors.Is(err, ErrUserBlocked):
+		item.Status = postgres.BatchItemFailed
+		item.Error = err.Error()
+	case err != nil:
+		item.Error = err.Error()
+	default:
+		item.YooKassaID = model.ID
+		item.Status = batchItemStatus(model.Status)
+		item.Error = ""
+	}
+	if err != nil {
+		log.Error("failed to create payout of batch", slog.String("error", err.Error()))
+	}
+	if err = h.batches.UpdateBatchItem(ctx, item); err != nil {
+		log.Error("failed to update payout batch item", slog.String("error", err.Error()))
+	}
+	if model == nil || model.ID == "" {
+		return
+	}
+	log = log.With(slog.String("yookassa_id", model.ID))
+
+	event := *auditEvent
+	event.SubjectID = model.ID
+	event.Details = map[string]string{
+		"user_id":        item.UserID,
+		"amount":         item.Amount,
+		"currency":       item.Currency,
+		"status":         string(model.Status),
+		"transaction_id": item.TransactionID.String(),
+		"batch_id":       item.BatchID.String(),
+	}
+	writeAudit(ctx, log, h.audit, &event)
+
+	logToDb := postgres.NewLog(model.ID, item.Amount, string(model.Status), model.CreatedAt)
+	logToDb.Kind = postgres.LogKindPayout
+	logToDb.Currency = item.Currency
+	logToDb.TraceID = tracing.TraceID(ctx)
+	if err = h.logWriter.InsertLog(ctx, logToDb); err != nil {
+		log.Error("failed to write log to db", slog.String("error", err.Error()))
+	}
+
+	checkerData := webhook.NewWebhookData(metrics.PayoutsEndpoint, model.ID, item.TransactionID, item.Amount)
+	_ = h.checker.StartCheck(ctx, checkerData, model.Status)
+}
+
+// refresh updates pending items by statuses of their checks, status of the item whose check
+// is gone (e.g. it's not started because of the limit) is requested from YooKassa
+func (h *PayoutBatchHandler) refresh(ctx context.Context, log *slog.Logger, items []*postgres.PayoutBatchItem) {
+	for _, item := range items {
+		if item.Status != postgres.BatchItemPending {
+			continue
+		}
+		status, err := h.checker.Status(item.TransactionID)
+		if errors.Is(err, webhook.UnknownTransaction) {
+			var resp *webhook.CheckResponse
+			if resp, err = h.checker.FetchStatus(ctx, metrics.PayoutsEndpoint, item.YooKassaID); err == nil {
+				status = resp.Status
+			}
+		}
+		if err != nil {
+			log.Warn("failed to get status of payout batch item",
+				slog.String("yookassa_id", item.YooKassaID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		if newStatus := batchItemStatus(status); newStatus != item.Status {
+			item.Status = newStatus
+			if err = h.batches.UpdateBatchItem(ctx, item); err != nil {
+				log.Error("failed to update payout batch item", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// validateBatchItems returns errors of all items with invalid data
+func validateBatchItems(items []PayoutRequestEndpoint) []BatchItemError {
+	itemErrors := make([]BatchItemError, 0)
+	for i, item := range items {
+		var err error
+		switch {
+		case !item.isFullData():
+			err = errors.New("provided not full data")
+		case item.FromBalance:
+			err = ErrBatchFromBalance
+		case !isDestinationType(item.Destination):
+			err = ErrPayoutDestination
+		case !isPositiveAmount(item.Amount.Value):
+			err = fmt.Errorf("invalid amount %q", item.Amount.Value)
+		case uuid.Validate(item.ToUserId) != nil:
+			err = errors.New("invalid user_id")
+		}
+		if err != nil {
+			itemErrors = append(itemErrors, BatchItemError{Position: i, Error: err.Error()})
+		}
+	}
+	return itemErrors
+}
+
+// validateBatchDestinations returns errors of all items whose users are unknown, blocked
+// or have no bound destination
+func validateBatchDestinations(ctx context.Context, users postgres.UserRepository, cards postgres.CardStore,
+	destinations postgres.PayoutDestinationRepository, items []PayoutRequestEndpoint) ([]BatchItemError, error) {
+	itemErrors := make([]BatchItemError, 0)
+	for i, item := range items {
+		err := activeUser(ctx, users, item.ToUserId)
+		if err == nil {
+			_, err = findPayoutDestination(ctx, cards, destinations, uuid.MustParse(item.ToUserId), item.Destination)
+		}
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUserBlocked) ||
+			errors.Is(err, ErrCardNotBound) || errors.Is(err, ErrDestinationNotBound) {
+			itemErrors = append(itemErrors, BatchItemError{Position: i, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return itemErrors, nil
+}
+
+func isPositiveAmount(value string) bool {
+	v, err := parseMinorUnits(value)
+	return err == nil && v > 0
+}
+
+// createPayout sends payout of the item, the idempotence key is the same for all sends of the attempt.
+// The user is checked again, it could be blocked after the batch was created.
+func (h *PayoutBatchHandler) createPayout(ctx context.Context, item *postgres.PayoutBatchItem) (*YooKassaPayloadModel, error) {
+	userID, err := uuid.Parse(item.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err = activeUser(ctx, h.users, item.UserID); err != nil {
+		return nil, err
+	}
+	destination, err := findPayoutDestination(ctx, h.cards, h.destinations, userID, item.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &PayoutRequestEndpoint{
+		ToUserId:  item.UserID,
+		Amount:    Amount{Value: item.Amount, Currency: item.Currency},
+		BookingID: item.BookingID,
+		OrderID:   item.OrderID,
+	}
+	createReq, err := createPayloadBody(req, destination, item.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("payout-batch-%v-%v-%v", item.BatchID, item.Position, item.Attempt)
+	resp, err := h.gateway.Do(ctx, http.MethodPost, metrics.PayoutsEndpoint, createReq, key)
+	if err != nil {
+		return nil, err
+	}
+	model := new(YooKassaPayloadModel)
+	if err = readYooKassaResponse(resp, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func batchItemStatus(status metrics.Status) string {
+	switch status {
+	case metrics.Succeeded:
+		return postgres.BatchItemSucceeded
+	case metrics.Canceled:
+		return postgres.BatchItemCanceled
+	}
+	return postgres.BatchItemPending
+}
+
+func summaryURL(batchID uuid.UUID) string {
+	return "/payouts/batch/" + batchID.String() + "?format=csv"
+}
+
+func writeBatchCSV(w http.ResponseWriter, log *slog.Logger, summary *PayoutBatchSummary) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="payout-batch-%v.csv"`, summary.BatchID))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"position", "user_id", "amount", "currency", "destination", "booking_id", "order_id",
+		"attempt", "transaction_id", "yookassa_id", "status", "error"})
+	for _, item := range summary.Items {
+		_ = cw.Write([]string{
+			strconv.Itoa(item.Position),
+			item.UserID,
+			item.Amount,
+			item.Currency,
+			item.Destination,
+			item.BookingID,
+			item.OrderID,
+			strconv.Itoa(item.Attempt),
+			item.TransactionID.String(),
+			item.YooKassaID,
+			item.Status,
+			item.Error,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Error("failed to write payout batch summary", slog.String("error", err.Error()))
+	}
+}
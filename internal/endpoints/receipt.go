@@ -0,0 +1,404 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// ****************
+// ________________
+// Receipts (54-FZ)
+// ________________
+// ****************
+
+// Payment modes of receipt items
+const (
+	PaymentModeFullPrepayment = "full_prepayment"
+	PaymentModeFullPayment    = "full_payment"
+)
+
+// SettlementPrepayment is settlement of a separate receipt issued after prepayment
+const SettlementPrepayment = "prepayment"
+
+const receiptDescriptionMaxLen = 128
+
+var (
+	ErrNoReceiptContact = errors.New("receipt customer email or phone is required")
+	ErrNoReceiptItems   = errors.New("receipt items are empty")
+	ErrReceiptTotal     = errors.New("sum of receipt items doesn't match the amount")
+)
+
+type ReceiptCustomer struct {
+	FullName string `json:"full_name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	// Phone in international format, digits only: 79000000000
+	Phone string `json:"phone,omitempty"`
+}
+
+// BookingReceipt is receipt data of the booking, it's sent by the main site
+type BookingReceipt struct {
+	BookingID string          `json:"booking_id"`
+	Customer  ReceiptCustomer `json:"customer"`
+	Items     []BookingItem   `json:"items"`
+}
+
+type BookingItem struct {
+	Description string `json:"description"`
+	// Quantity is the number of units, e.g. nights
+	Quantity int `json:"quantity"`
+	// Price is the price of one unit
+	Price Amount `json:"price"`
+	// VatCode, PaymentSubject and PaymentMode are taken from config (and endpoint) if empty
+	VatCode        int    `json:"vat_code,omitempty"`
+	PaymentSubject string `json:"payment_subject,omitempty"`
+	PaymentMode    string `json:"payment_mode,omitempty"`
+}
+
+// Receipt is the receipt of YooKassa API, it's sent with payments and refunds
+type Receipt struct {
+	Customer      ReceiptCustomer `json:"customer"`
+	Items         []ReceiptItem   `json:"items"`
+	TaxSystemCode int             `json:"tax_system_code,omitempty"`
+}
+
+type ReceiptItem struct {
+	Description    string `json:"description"`
+	Quantity       string `json:"quantity"`
+	Amount         Amount `json:"amount"`
+	VatCode        int    `json:"vat_code"`
+	PaymentSubject string `json:"payment_subject"`
+	PaymentMode    string `json:"payment_mode"`
+}
+
+// toReceipt validates booking data and converts it to YooKassa receipt.
+// Items total must be equal to the amount of the payment (refund).
+func (b *BookingReceipt) toReceipt(amount Amount, paymentMode string) (*Receipt, error) {
+	c := b.Customer
+	if c.Email == "" && c.Phone == "" {
+		return nil, ErrNoReceiptContact
+	}
+	if c.Phone != "" && !isNumeric(strings.TrimPrefix(c.Phone, "+")) {
+		return nil, errors.New("receipt customer phone must contain only digits")
+	}
+	if len(b.Items) == 0 {
+		return nil, ErrNoReceiptItems
+	}
+
+	defaults := metrics.GetReceiptDefaults()
+	receipt := &Receipt{
+		Customer:      ReceiptCustomer{FullName: c.FullName, Email: c.Email, Phone: strings.TrimPrefix(c.Phone, "+")},
+		Items:         make([]ReceiptItem, 0, len(b.Items)),
+		TaxSystemCode: defaults.TaxSystemCode,
+	}
+	var total int64
+	for i, item := range b.Items {
+		if item.Description == "" || item.Quantity <= 0 {
+			return nil, fmt.Errorf("receipt item %v: description and positive quantity are required", i)
+		}
+		if item.Price.Currency != amount.Currency {
+			return nil, fmt.Errorf("receipt item %v: currency must be %v", i, amount.Currency)
+		}
+		price, err := parseMinorUnits(item.Price.Value)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("receipt item %v: invalid price %q", i, item.Price.Value)
+		}
+		total += price * int64(item.Quantity)
+
+		ri := ReceiptItem{
+			Description:    truncateRunes(item.Description, receiptDescriptionMaxLen),
+			Quantity:       strconv.Itoa(item.Quantity),
+			Amount:         item.Price,
+			VatCode:        item.VatCode,
+			PaymentSubject: item.PaymentSubject,
+			PaymentMode:    item.PaymentMode,
+		}
+		if ri.VatCode == 0 {
+			ri.VatCode = defaults.VatCode
+		}
+		if ri.PaymentSubject == "" {
+			ri.PaymentSubject = defaults.PaymentSubject
+		}
+		if ri.PaymentMode == "" {
+			ri.PaymentMode = paymentMode
+		}
+		receipt.Items = append(receipt.Items, ri)
+	}
+
+	expected, err := parseMinorUnits(amount.Value)
+	if err != nil || total != expected {
+		return nil, ErrReceiptTotal
+	}
+	return receipt, nil
+}
+
+// newReceiptRecord returns receipt as it's stored, registration status is tracked later
+func newReceiptRecord(receiptType, objectID, bookingID, status string, receipt *Receipt) *postgres.Receipt {
+	items, _ := json.Marshal(receipt.Items)
+	if status == "" {
+		status = postgres.ReceiptPending
+	}
+	return &postgres.Receipt{
+		Type:      receiptType,
+		ObjectID:  objectID,
+		BookingID: bookingID,
+		Status:    status,
+		Items:     items,
+	}
+}
+
+// ________________________
+// Separate receipts
+// ________________________
+
+// CreateReceipt is request to issue a separate receipt, e.g. the final settlement
+// receipt after the booking was prepaid
+type CreateReceipt struct {
+	// Type is payment or refund
+	Type      string         `json:"type"`
+	PaymentID string         `json:"payment_id,omitempty"`
+	RefundID  string         `json:"refund_id,omitempty"`
+	Amount    Amount         `json:"amount"`
+	Receipt   BookingReceipt `json:"receipt"`
+	// SettlementType is prepayment by default
+	SettlementType string `json:"settlement_type,omitempty"`
+}
+
+func (c *CreateReceipt) objectID() string {
+	if c.Type == postgres.ReceiptTypeRefund {
+		return c.RefundID
+	}
+	return c.PaymentID
+}
+
+func (c *CreateReceipt) validate() error {
+	switch c.Type {
+	case postgres.ReceiptTypePayment, postgres.ReceiptTypeRefund:
+	default:
+		return errors.New("type must be payment or refund")
+	}
+	if c.objectID() == "" {
+		return errors.New("payment_id or refund_id of the receipt type is required")
+	}
+	if c.Amount.Value == "" || c.Amount.Currency == "" {
+		return errors.New("amount is empty")
+	}
+	return nil
+}
+
+type Settlement struct {
+	Type   string `json:"type"`
+	Amount Amount `json:"amount"`
+}
+
+type ReceiptRequestKassa struct {
+	Type      string `json:"type"`
+	PaymentID string `json:"payment_id,omitempty"`
+	RefundID  string `json:"refund_id,omitempty"`
+	Receipt
+	Send        bool         `json:"send"`
+	Settlements []Settlement `json:"settlements"`
+}
+
+// ReceiptResponse is receipt of YooKassa API
+type ReceiptResponse struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	PaymentID string `json:"payment_id,omitempty"`
+	RefundID  string `json:"refund_id,omitempty"`
+	Status    string `json:"status"`
+}
+
+func (r *ReceiptResponse) objectID() string {
+	if r.Type == postgres.ReceiptTypeRefund {
+		return r.RefundID
+	}
+	return r.PaymentID
+}
+
+type ReceiptListResponse struct {
+	Items []*ReceiptResponse `json:"items"`
+}
+
+type ReceiptsAnswer struct {
+	Receipts []*postgres.Receipt `json:"receipts"`
+	// Stale is set when statuses couldn't be refreshed from YooKassa
+	Stale bool `json:"stale,omitempty"`
+}
+
+type ReceiptHandler struct {
+	log      *slog.Logger
+	receipts postgres.ReceiptRepository
+	gateway  yookassa.Gateway
+}
+
+func NewReceiptHandler(log *slog.Logger, receipts postgres.ReceiptRepository, gateway yookassa.Gateway) *ReceiptHandler {
+	return &ReceiptHandler{
+		log:      log,
+		receipts: receipts,
+		gateway:  gateway,
+	}
+}
+
+// Create issues a separate receipt through YooKassa receipts resource
+func (h *ReceiptHandler) Create(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.CreateReceipt"
+
+	log := requestLogger(r, h.log, fn)
+	log.Debug("create receipt endpoint called")
+
+	req := new(CreateReceipt)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	if err := req.validate(); err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	log = annotate(r, log, slog.String("yookassa_id", req.objectID()), slog.String("booking_id", req.Receipt.BookingID))
+
+	receipt, err := req.Receipt.toReceipt(req.Amount, PaymentModeFullPayment)
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	settlementType := req.SettlementType
+	if settlementType == "" {
+		settlementType = SettlementPrepayment
+	}
+	body := &ReceiptRequestKassa{
+		Type:        req.Type,
+		PaymentID:   req.PaymentID,
+		RefundID:    req.RefundID,
+		Receipt:     *receipt,
+		Send:        true,
+		Settlements: []Settlement{{Type: settlementType, Amount: req.Amount}},
+	}
+	if req.Type == postgres.ReceiptTypeRefund {
+		body.PaymentID = ""
+	} else {
+		body.RefundID = ""
+	}
+
+	resp, err := sendYooKassaRequest(r.Context(), h.gateway, http.MethodPost, metrics.ReceiptsEndpoint, body)
+	if err != nil {
+		log.Error("failed to send request to YooKassa API", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	created := new(ReceiptResponse)
+	if err = readYooKassaResponse(resp, created); err != nil {
+		log.Error("failed to create receipt", slog.String("error", err.Error()))
+		var apiErr *YooKassaError
+		if errors.As(err, &apiErr) {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(apiErr.Description))
+			return
+		}
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	record := newReceiptRecord(req.Type, req.objectID(), req.Receipt.BookingID, created.Status, receipt)
+	record.ReceiptID = created.ID
+	if err = h.receipts.InsertReceipt(detached(r), record); err != nil {
+		// The receipt is registered anyway, it will be stored on the next status refresh
+		log.Error("failed to save receipt", slog.String("error", err.Error()))
+	}
+	log.Info("receipt created", slog.String("receipt_id", created.ID), slog.String("status", created.Status))
+
+	myJson.Write(w, http.StatusOK, record)
+}
+
+// GetReceipts returns receipts of the payment (payment_id) or refund (refund_id),
+// registration statuses are refreshed from YooKassa
+func (h *ReceiptHandler) GetReceipts(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetReceipts"
+
+	log := requestLogger(r, h.log, fn)
+
+	query := url.Values{}
+	objectID := r.URL.Query().Get("payment_id")
+	if objectID != "" {
+		query.Set("payment_id", objectID)
+	} else if objectID = r.URL.Query().Get("refund_id"); objectID != "" {
+		query.Set("refund_id", objectID)
+	} else {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("payment_id or refund_id is required"))
+		return
+	}
+	log = annotate(r, log, slog.String("yookassa_id", objectID))
+
+	answer := ReceiptsAnswer{}
+	if err := h.refreshStatuses(r, query); err != nil {
+		log.Warn("failed to refresh receipt statuses", slog.String("error", err.Error()))
+		answer.Stale = true
+	}
+
+	receipts, err := h.receipts.GetReceiptsByObjectID(r.Context(), objectID)
+	if err != nil {
+		log.Error("failed to get receipts", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	answer.Receipts = receipts
+	myJson.Write(w, http.StatusOK, answer)
+}
+
+func (h *ReceiptHandler) refreshStatuses(r *http.Request, query url.Values) error {
+	resp, err := sendYooKassaRequest(r.Context(), h.gateway, http.MethodGet, metrics.ReceiptsEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	list := new(ReceiptListResponse)
+	if err = readYooKassaResponse(resp, list); err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		err = h.receipts.SaveReceiptStatus(r.Context(), &postgres.Receipt{
+			ReceiptID: item.ID,
+			Type:      item.Type,
+			ObjectID:  item.objectID(),
+			Status:    item.Status,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ______________
+// Utils
+// _______________
+
+// parseMinorUnits parses decimal amount "1000.50" into kopecks
+func parseMinorUnits(value string) (int64, error) {
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" || !isNumeric(whole) || len(frac) > 2 || !isNumeric(frac) {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	return strconv.ParseInt(whole+frac, 10, 64)
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
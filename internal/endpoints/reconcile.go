@@ -0,0 +1,65 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/reconcile"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+)
+
+// reconcilePageSize is the maximum limit of YooKassa lists
+const reconcilePageSize = 100
+
+// YooKassaSource lists payments, payouts and refunds of YooKassa for reconciliation
+// https://yookassa.ru/developers/using-api/lists
+type YooKassaSource struct {
+	gateway yookassa.Gateway
+}
+
+func NewYooKassaSource(gateway yookassa.Gateway) *YooKassaSource {
+	return &YooKassaSource{
+		gateway: gateway,
+	}
+}
+
+func (s *YooKassaSource) List(ctx context.Context, resource string, from, to time.Time,
+	cursor string) (*reconcile.Page, error) {
+	query := url.Values{}
+	query.Set("created_at.gte", from.UTC().Format(time.RFC3339Nano))
+	query.Set("created_at.lt", to.UTC().Format(time.RFC3339Nano))
+	query.Set("limit", strconv.Itoa(reconcilePageSize))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	resp, err := sendYooKassaRequest(ctx, s.gateway, http.MethodGet, resource+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	page := new(reconcile.Page)
+	if err = readYooKassaResponse(resp, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (s *YooKassaSource) Get(ctx context.Context, resource, id string) (*reconcile.Object, error) {
+	resp, err := sendYooKassaRequest(ctx, s.gateway, http.MethodGet, resource+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	object := new(reconcile.Object)
+	err = readYooKassaResponse(resp, object)
+	var apiErr *YooKassaError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, reconcile.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
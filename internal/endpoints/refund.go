@@ -0,0 +1,188 @@
+package endpoints
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
+
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Refund
+// ________________
+// ****************
+
+// CreateRefund is request from frontend (admin panel)
+type CreateRefund struct {
+	PaymentID   string `json:"payment_id"`
+	Amount      Amount `json:"amount"`
+	Description string `json:"description,omitempty"`
+	// UserId is the user the money is returned to, it's used for audit
+	UserId  string          `json:"user_id,omitempty"`
+	Receipt *BookingReceipt `json:"receipt,omitempty"`
+}
+
+func (c *CreateRefund) isFullData() bool {
+	return c.PaymentID != "" && c.Amount.Value != "" && c.Amount.Currency != ""
+}
+
+type RefundRequestKassa struct {
+	PaymentID   string   `json:"payment_id"`
+	Amount      Amount   `json:"amount"`
+	Description string   `json:"description,omitempty"`
+	Receipt     *Receipt `json:"receipt,omitempty"`
+}
+
+// RefundResponse YooKassa refund model
+type RefundResponse struct {
+	ID                  string         `json:"id"`
+	PaymentID           string         `json:"payment_id"`
+	Status              metrics.Status `json:"status"`
+	Amount              Amount         `json:"amount"`
+	Description         string         `json:"description,omitempty"`
+	ReceiptRegistration string         `json:"receipt_registration,omitempty"`
+	CreatedAt           time.Time      `json:"created_at"`
+}
+
+// RefundAnswer response (answer) to frontend
+type RefundAnswer struct {
+	TransactionId uuid.UUID       `json:"transaction_id"`
+	Status        metrics.Status  `json:"status"`
+	YouKassaModel *RefundResponse `json:"you_kassa_model"`
+}
+
+type RefundHandler struct {
+	log       *slog.Logger
+	logWriter postgres.LogRepository
+	receipts  postgres.ReceiptRepository
+	audit     postgres.AuditRepository
+	splitter  *Splitter
+	gateway   yookassa.Gateway
+	checker   *webhook.Checker
+}
+
+func NewRefundHandler(log *slog.Logger, logWriter postgres.LogRepository, receipts postgres.ReceiptRepository,
+	audit postgres.AuditRepository, splitter *Splitter, gateway yookassa.Gateway,
+	checker *webhook.Checker) *RefundHandler {
+	return &RefundHandler{
+		log:       log,
+		logWriter: logWriter,
+		receipts:  receipts,
+		audit:     audit,
+		splitter:  splitter,
+		gateway:   gateway,
+		checker:   checker,
+	}
+}
+
+func (h *RefundHandler) Refund(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Refund"
+
+	log := requestLogger(r, h.log, fn)
+	log.Debug("refund endpoint called")
+
+	req := new(CreateRefund)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	if !req.isFullData() {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("payment_id or amount is empty"))
+		return
+	}
+	log = annotate(r, log, slog.String("payment_id", req.PaymentID), slog.String("user_id", req.UserId))
+
+	createReq := &RefundRequestKassa{
+		PaymentID:   req.PaymentID,
+		Amount:      req.Amount,
+		Description: req.Description,
+	}
+	if req.Receipt != nil {
+		receipt, err := req.Receipt.toReceipt(req.Amount, PaymentModeFullPrepayment)
+		if err != nil {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+			return
+		}
+		createReq.Receipt = receipt
+	}
+
+	resp, err := sendYooKassaRequest(r.Context(), h.gateway, http.MethodPost, metrics.RefundsEndpoint, createReq)
+	if err != nil {
+		log.Error("failed to send request to YooKassa API", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	refund := new(RefundResponse)
+	if err = readYooKassaResponse(resp, refund); err != nil {
+		log.Error("failed to create refund", slog.String("error", err.Error()))
+		var apiErr *YooKassaError
+		if errors.As(err, &apiErr) {
+			myJson.Write(w, http.StatusBadRequest, NewErrorResponse(apiErr.Description))
+			return
+		}
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+
+	refundResp := &RefundAnswer{
+		TransactionId: uuid.New(),
+		Status:        refund.Status,
+		YouKassaModel: refund,
+	}
+	log = annotate(r, log,
+		slog.String("yookassa_id", refund.ID),
+		slog.String("transaction_id", refundResp.TransactionId.String()),
+	)
+
+	actor := req.UserId
+	if actor == "" {
+		actor = "admin"
+	}
+	auditEvent := newAuditEvent(r, postgres.AuditRefundCreate, postgres.AuditSubjectRefund, refund.ID, actor)
+	auditEvent.Details["payment_id"] = req.PaymentID
+	auditEvent.Details["amount"] = req.Amount.Value
+	auditEvent.Details["currency"] = req.Amount.Currency
+	auditEvent.Details["status"] = string(refund.Status)
+	auditEvent.Details["transaction_id"] = refundResp.TransactionId.String()
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	checkerData := webhook.NewWebhookData(metrics.RefundsEndpoint, refund.ID, refundResp.TransactionId, req.Amount.Value)
+	_ = h.checker.StartCheck(r.Context(), checkerData, refund.Status)
+
+	logToDb := postgres.NewLog(refund.ID, req.Amount.Value, string(refund.Status), refund.CreatedAt)
+	logToDb.Kind = postgres.LogKindRefund
+	logToDb.Currency = req.Amount.Currency
+	logToDb.TraceID = tracing.TraceID(r.Context())
+	if err = h.logWriter.InsertLog(detached(r), logToDb); err != nil {
+		log.Error("failed to write log to db", slog.String("error", err.Error()))
+	}
+
+	if createReq.Receipt != nil {
+		record := newReceiptRecord(postgres.ReceiptTypeRefund, refund.ID, req.Receipt.BookingID,
+			refund.ReceiptRegistration, createReq.Receipt)
+		if err = h.receipts.InsertReceipt(detached(r), record); err != nil {
+			log.Error("failed to save receipt", slog.String("error", err.Error()))
+		}
+	}
+
+	if refund.Status == metrics.Succeeded {
+		// Refunds confirmed later are reversed by notification or status check
+		if err = h.splitter.RefundPayment(detached(r), refund.ID, req.PaymentID, req.Amount); err != nil {
+			log.Error("failed to reverse split of refunded payment", slog.String("error", err.Error()))
+		}
+	}
+
+	log.Info("refund created", slog.String("status", string(refund.Status)))
+	myJson.Write(w, http.StatusOK, refundResp)
+}
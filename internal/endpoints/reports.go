@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/reports"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ****************
+// ________________
+// Financial reports
+// ________________
+// ****************
+
+type ReportHandler struct {
+	log     *slog.Logger
+	reports postgres.ReportRepository
+}
+
+func NewReportHandler(log *slog.Logger, reports postgres.ReportRepository) *ReportHandler {
+	return &ReportHandler{
+		log:     log,
+		reports: reports,
+	}
+}
+
+// Export streams the report as CSV or XLSX attachment
+// (GET /admin/reports/{name}?from=2024-05-01&to=2024-05-31&currency=RUB&period=month&format=xlsx).
+// Both days are included, currency is optional, period (day, week or month) groups turnover and commissions.
+func (h *ReportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Report.Export"
+
+	log := requestLogger(r, h.log, fn)
+
+	name := chi.URLParam(r, "name")
+	if !reports.IsReport(name) {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(reports.ErrUnknownReport.Error()))
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = reports.FormatCSV
+	}
+	filter, err := reports.ParseFilter(query.Get("from"), query.Get("to"), query.Get("currency"), query.Get("period"))
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	log = annotate(r, log, slog.String("report", name), slog.String("format", format))
+
+	out := &exportWriter{
+		w:           w,
+		contentType: reports.ContentType(format),
+		fileName:    reports.FileName(name, filter, format),
+	}
+	rw, err := reports.NewWriter(out, format, name)
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	// Large exports may take longer than the write timeout of the server
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	err = reports.Write(r.Context(), h.reports, name, filter, rw)
+	if err == nil {
+		err = rw.Close()
+	}
+	if err != nil {
+		log.Error("failed to export report", slog.String("error", err.Error()))
+		if !out.started {
+			myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		}
+		return
+	}
+	log.Info("report exported")
+}
+
+// exportWriter sends headers of the attachment with the first bytes of the report,
+// so that an error before anything is sent is still returned as JSON
+type exportWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	fileName    string
+	started     bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.w.Header().Set("Content-Type", e.contentType)
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, e.fileName))
+		e.w.WriteHeader(http.StatusOK)
+		e.started = true
+	}
+	return e.w.Write(p)
+}
@@ -0,0 +1,284 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/reconcile"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// ****************
+// ________________
+// Split of payments
+// ________________
+// ****************
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// Splitter divides succeeded payments between the landlord balance and the platform account,
+// and keeps the ledger of payouts from landlord balances
+type Splitter struct {
+	log         *slog.Logger
+	ledger      postgres.LedgerRepository
+	commissions postgres.CommissionRepository
+}
+
+func NewSplitter(log *slog.Logger, ledger postgres.LedgerRepository,
+	commissions postgres.CommissionRepository) *Splitter {
+	return &Splitter{
+		log:         log,
+		ledger:      ledger,
+		commissions: commissions,
+	}
+}
+
+// SplitPayment credits the landlord share and the platform fee of the succeeded payment,
+// payments without landlord aren't split. Repeated calls are no-op.
+func (s *Splitter) SplitPayment(ctx context.Context, paymentID string, amount Amount, metadata *Metadata) error {
+	if metadata == nil || metadata.LandlordID == "" {
+		return nil
+	}
+	total, err := parseMinorUnits(amount.Value)
+	if err != nil {
+		return err
+	}
+	rule, err := s.commissions.FindCommissionRule(ctx, metadata.LandlordID, metadata.Tier)
+	if err != nil {
+		return err
+	}
+	fee, err := computeFee(total, rule)
+	if err != nil {
+		return err
+	}
+
+	entries := []*postgres.LedgerEntry{
+		{
+			Account:   postgres.LandlordAccount(metadata.LandlordID),
+			Amount:    formatMinorUnits(total - fee),
+			Currency:  amount.Currency,
+			Kind:      postgres.LedgerLandlordShare,
+			Reference: paymentID,
+		},
+		{
+			Account:   postgres.PlatformAccount,
+			Amount:    formatMinorUnits(fee),
+			Currency:  amount.Currency,
+			Kind:      postgres.LedgerPlatformFee,
+			Reference: paymentID,
+		},
+	}
+	recorded, err := s.ledger.InsertEntries(ctx, entries)
+	if err != nil || !recorded {
+		return err
+	}
+	s.log.Info("payment split",
+		slog.String("yookassa_id", paymentID),
+		slog.String("landlord_id", metadata.LandlordID),
+		slog.String("landlord_share", entries[0].Amount),
+		slog.String("platform_fee", entries[1].Amount),
+	)
+	return nil
+}
+
+// DebitPayout takes the payout from the landlord balance before it's created,
+// ErrInsufficientBalance is returned if the balance is less than the amount
+func (s *Splitter) DebitPayout(ctx context.Context, metadata *Metadata, amount Amount) error {
+	value, err := parseMinorUnits(amount.Value)
+	if err != nil {
+		return err
+	}
+	ok, err := s.ledger.Debit(ctx, &postgres.LedgerEntry{
+		Account:   metadata.LedgerAccount,
+		Amount:    formatMinorUnits(-value),
+		Currency:  amount.Currency,
+		Kind:      postgres.LedgerPayout,
+		Reference: metadata.TransactionID,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// ReversePayout returns the canceled (or not created) payout to the balance it was taken from.
+// Repeated calls are no-op.
+func (s *Splitter) ReversePayout(ctx context.Context, metadata *Metadata, amount Amount) error {
+	if metadata == nil || metadata.LedgerAccount == "" {
+		return nil
+	}
+	recorded, err := s.ledger.InsertEntries(ctx, []*postgres.LedgerEntry{{
+		Account:   metadata.LedgerAccount,
+		Amount:    amount.Value,
+		Currency:  amount.Currency,
+		Kind:      postgres.LedgerPayoutReversal,
+		Reference: metadata.TransactionID,
+	}})
+	if err != nil || !recorded {
+		return err
+	}
+	s.log.Info("payout reversed",
+		slog.String("transaction_id", metadata.TransactionID),
+		slog.String("account", metadata.LedgerAccount),
+		slog.String("amount", amount.Value),
+	)
+	return nil
+}
+
+// RefundPayment takes the succeeded refund back from the landlord share and the platform fee
+// of the refunded payment in proportion to the split, so the refunded money can't be paid out.
+// The landlord balance becomes negative if the share is already paid out. Payments without split
+// are skipped, repeated calls are no-op.
+func (s *Splitter) RefundPayment(ctx context.Context, refundID, paymentID string, amount Amount) error {
+	if paymentID == "" {
+		return nil
+	}
+	entries, err := s.ledger.GetEntriesByReference(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	var share, fee *postgres.LedgerEntry
+	for _, e := range entries {
+		switch e.Kind {
+		case postgres.LedgerLandlordShare:
+			share = e
+		case postgres.LedgerPlatformFee:
+			fee = e
+		}
+	}
+	if share == nil || fee == nil {
+		return nil
+	}
+	shareValue, err := parseMinorUnits(share.Amount)
+	if err != nil {
+		return err
+	}
+	feeValue, err := parseMinorUnits(fee.Amount)
+	if err != nil {
+		return err
+	}
+	refunded, err := parseMinorUnits(amount.Value)
+	if err != nil {
+		return err
+	}
+	total := shareValue + feeValue
+	if total <= 0 {
+		return nil
+	}
+	refunded = min(refunded, total)
+	// The fee is rounded, the rest of the refund is taken from the landlord
+	feeRefund := (feeValue*refunded + total/2) / total
+
+	reversal := []*postgres.LedgerEntry{
+		{
+			Account:   share.Account,
+			Amount:    formatMinorUnits(feeRefund - refunded),
+			Currency:  share.Currency,
+			Kind:      postgres.LedgerLandlordShare,
+			Reference: refundID,
+		},
+		{
+			Account:   fee.Account,
+			Amount:    formatMinorUnits(-feeRefund),
+			Currency:  fee.Currency,
+			Kind:      postgres.LedgerPlatformFee,
+			Reference: refundID,
+		},
+	}
+	recorded, err := s.ledger.InsertEntries(ctx, reversal)
+	if err != nil || !recorded {
+		return err
+	}
+	s.log.Info("payment refund reversed in ledger",
+		slog.String("yookassa_id", refundID),
+		slog.String("payment_id", paymentID),
+		slog.String("landlord_share", reversal[0].Amount),
+		slog.String("platform_fee", reversal[1].Amount),
+	)
+	return nil
+}
+
+// OnFinalStatus splits succeeded payments, reverses split of succeeded refunds and canceled payouts,
+// it's called by status checks (webhook.SetFinalHook) and by notifications
+func (s *Splitter) OnFinalStatus(ctx context.Context, resource, yooKassaID string, resp *webhook.CheckResponse) {
+	if resp.Amount == nil {
+		return
+	}
+	amount := Amount{Value: resp.Amount.Value, Currency: resp.Amount.Currency}
+	metadata := metadataFromMap(resp.Metadata)
+	var err error
+	switch {
+	case resource == metrics.PaymentsEndpoint && resp.Status == metrics.Succeeded:
+		err = s.SplitPayment(ctx, yooKassaID, amount, metadata)
+	case resource == metrics.PayoutsEndpoint && resp.Status == metrics.Canceled:
+		err = s.ReversePayout(ctx, metadata, amount)
+	case resource == metrics.RefundsEndpoint && resp.Status == metrics.Succeeded:
+		err = s.RefundPayment(ctx, yooKassaID, resp.PaymentID, amount)
+	}
+	if err != nil {
+		s.log.Error("failed to update ledger",
+			slog.String("resource", resource),
+			slog.String("yookassa_id", yooKassaID),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// OnReconciledStatus is OnFinalStatus of the status corrected by reconciliation (reconcile.SetFinalHook)
+func (s *Splitter) OnReconciledStatus(ctx context.Context, resource, yooKassaID string, object *reconcile.Object) {
+	s.OnFinalStatus(ctx, resource, yooKassaID, &webhook.CheckResponse{
+		Status:    object.Status,
+		Metadata:  object.Metadata,
+		Amount:    &webhook.Amount{Value: object.Amount.Value, Currency: object.Amount.Currency},
+		PaymentID: object.PaymentID,
+	})
+}
+
+// computeFee returns platform fee (minor units) of the amount, the fee is at most the amount
+func computeFee(amount int64, rule *postgres.CommissionRule) (int64, error) {
+	if rule == nil {
+		return 0, nil
+	}
+	// Percent has 2 decimals, so it's in 1/10000 of the amount
+	percent, err := parseMinorUnits(rule.Percent)
+	if err != nil {
+		return 0, fmt.Errorf("commission rule %v: %w", rule.ID, err)
+	}
+	fixed, err := parseMinorUnits(rule.Fixed)
+	if err != nil {
+		return 0, fmt.Errorf("commission rule %v: %w", rule.ID, err)
+	}
+	fee := (amount*percent+5000)/10000 + fixed
+	if rule.MinFee != "" {
+		minFee, err := parseMinorUnits(rule.MinFee)
+		if err != nil {
+			return 0, fmt.Errorf("commission rule %v: %w", rule.ID, err)
+		}
+		fee = max(fee, minFee)
+	}
+	if rule.MaxFee != "" {
+		maxFee, err := parseMinorUnits(rule.MaxFee)
+		if err != nil {
+			return 0, fmt.Errorf("commission rule %v: %w", rule.ID, err)
+		}
+		fee = min(fee, maxFee)
+	}
+	return min(fee, amount), nil
+}
+
+// formatMinorUnits is reverse of parseMinorUnits
+func formatMinorUnits(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%v%v.%02d", sign, strconv.FormatInt(v/100, 10), v%100)
+}
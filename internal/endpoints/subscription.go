@@ -0,0 +1,386 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/billing"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Subscription
+// ________________
+// ****************
+
+const dateLayout = "2006-01-02"
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// CreateSubscription is request from the main site, dates are in 2006-01-02 format
+type CreateSubscription struct {
+	TenantID   string `json:"tenant_id"`
+	LandlordID string `json:"landlord_id"`
+	// PaymentMethodID is ID of saved payment method of the tenant (GET /payment_methods)
+	PaymentMethodID string `json:"payment_method_id"`
+	BookingID       string `json:"booking_id,omitempty"`
+	Amount          Amount `json:"amount"`
+	// IntervalMonths is 1 (monthly) by default
+	IntervalMonths int `json:"interval_months,omitempty"`
+	// AnchorDay is the day of month of charges, it's the day of StartDate by default
+	AnchorDay int    `json:"anchor_day,omitempty"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date,omitempty"`
+}
+
+// toSubscription validates the request
+func (c *CreateSubscription) toSubscription() (*postgres.Subscription, error) {
+	if c.TenantID == "" || c.LandlordID == "" || c.PaymentMethodID == "" {
+		return nil, errors.New("tenant_id, landlord_id or payment_method_id is empty")
+	}
+	methodID, err := uuid.Parse(c.PaymentMethodID)
+	if err != nil {
+		return nil, errors.New("payment_method_id is invalid")
+	}
+	if minor, err := parseMinorUnits(c.Amount.Value); err != nil || minor <= 0 || c.Amount.Currency == "" {
+		return nil, errors.New("amount is invalid")
+	}
+	start, err := time.Parse(dateLayout, c.StartDate)
+	if err != nil {
+		return nil, errors.New("start_date must be in YYYY-MM-DD format")
+	}
+	sub := &postgres.Subscription{
+		TenantID:              c.TenantID,
+		LandlordID:            c.LandlordID,
+		PaymentMethodPublicID: methodID,
+		BookingID:             c.BookingID,
+		Amount:                c.Amount.Value,
+		Currency:              c.Amount.Currency,
+		IntervalMonths:        c.IntervalMonths,
+		AnchorDay:             c.AnchorDay,
+		StartDate:             start,
+		Status:                postgres.SubscriptionActive,
+	}
+	if sub.IntervalMonths == 0 {
+		sub.IntervalMonths = 1
+	}
+	if sub.IntervalMonths < 0 || sub.IntervalMonths > 12 {
+		return nil, errors.New("interval_months must be between 1 and 12")
+	}
+	if sub.AnchorDay == 0 {
+		sub.AnchorDay = start.Day()
+	}
+	if sub.AnchorDay < 0 || sub.AnchorDay > billing.MaxAnchorDay {
+		return nil, errors.New("anchor_day must be between 1 and 31")
+	}
+	sub.NextChargeAt = billing.FirstDueDate(start, sub.AnchorDay)
+	if c.EndDate != "" {
+		end, err := time.Parse(dateLayout, c.EndDate)
+		if err != nil {
+			return nil, errors.New("end_date must be in YYYY-MM-DD format")
+		}
+		if end.Before(sub.NextChargeAt) {
+			return nil, errors.New("end_date is before the first charge")
+		}
+		sub.EndDate = &end
+	}
+	return sub, nil
+}
+
+type SubscriptionHandler struct {
+	log     *slog.Logger
+	subs    postgres.SubscriptionRepository
+	methods postgres.PaymentMethodRepository
+	users   postgres.UserRepository
+	audit   postgres.AuditRepository
+}
+
+func NewSubscriptionHandler(log *slog.Logger, subs postgres.SubscriptionRepository,
+	methods postgres.PaymentMethodRepository, users postgres.UserRepository,
+	audit postgres.AuditRepository) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		log:     log,
+		subs:    subs,
+		methods: methods,
+		users:   users,
+		audit:   audit,
+	}
+}
+
+// Create creates subscription, its cycles are charged by billing scheduler
+func (h *SubscriptionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.CreateSubscription"
+
+	log := requestLogger(r, h.log, fn)
+
+	req := new(CreateSubscription)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	sub, err := req.toSubscription()
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(err.Error()))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", sub.TenantID))
+	if err = activeUser(r.Context(), h.users, sub.TenantID); err != nil {
+		writeUserError(w, log, err)
+		return
+	}
+
+	method, err := h.methods.GetPaymentMethodByPublicID(r.Context(), sub.TenantID, sub.PaymentMethodPublicID)
+	if err != nil {
+		log.Error("failed to get payment method", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if method == nil {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrPaymentMethodNotFound.Error()))
+		return
+	}
+	sub.PaymentMethodID = method.ID
+
+	if err = h.subs.InsertSubscription(r.Context(), sub); err != nil {
+		log.Error("failed to create subscription", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	log = annotate(r, log, slog.Int("subscription_id", sub.ID))
+
+	auditEvent := newAuditEvent(r, postgres.AuditSubscriptionCreate, postgres.AuditSubjectSubscription,
+		strconv.Itoa(sub.ID), sub.TenantID)
+	auditEvent.Details["landlord_id"] = sub.LandlordID
+	auditEvent.Details["amount"] = sub.Amount
+	auditEvent.Details["currency"] = sub.Currency
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("subscription created", slog.Time("next_charge_at", sub.NextChargeAt))
+	myJson.Write(w, http.StatusOK, sub)
+}
+
+// GetSubscriptions returns subscriptions of the tenant (GET /subscriptions?tenant_id=...)
+func (h *SubscriptionHandler) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetSubscriptions"
+
+	log := requestLogger(r, h.log, fn)
+
+	tenantID := r.URL.Query().Get("tenant_id")
+	if tenantID == "" {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("tenant_id is empty"))
+		return
+	}
+	subs, err := h.subs.GetSubscriptionsByTenant(r.Context(), tenantID)
+	if err != nil {
+		log.Error("failed to get subscriptions", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, subs)
+}
+
+// GetCycles returns billing cycles of the subscription (GET /subscriptions/{id}/cycles)
+func (h *SubscriptionHandler) GetCycles(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.GetCycles"
+
+	log := requestLogger(r, h.log, fn)
+
+	sub, ok := h.subscription(w, r, log)
+	if !ok {
+		return
+	}
+	cycles, err := h.subs.GetCycles(r.Context(), sub.ID)
+	if err != nil {
+		log.Error("failed to get billing cycles", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	myJson.Write(w, http.StatusOK, cycles)
+}
+
+// Cancel stops charges of the subscription (POST /subscriptions/{id}/cancel)
+func (h *SubscriptionHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.CancelSubscription"
+
+	log := requestLogger(r, h.log, fn)
+
+	sub, ok := h.subscription(w, r, log)
+	if !ok {
+		return
+	}
+	canceled, err := h.subs.SetSubscriptionStatus(r.Context(), sub.ID, postgres.SubscriptionCanceled)
+	if err != nil {
+		log.Error("failed to cancel subscription", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if !canceled {
+		myJson.Write(w, http.StatusConflict, NewErrorResponse("subscription is already "+sub.Status))
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditSubscriptionCancel, postgres.AuditSubjectSubscription,
+		strconv.Itoa(sub.ID), sub.TenantID)
+	auditEvent.OldValue = sub.Status
+	auditEvent.NewValue = postgres.SubscriptionCanceled
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("subscription canceled")
+	sub.Status = postgres.SubscriptionCanceled
+	myJson.Write(w, http.StatusOK, sub)
+}
+
+// subscription finds subscription by URL parameter, the error response is written if it isn't found
+func (h *SubscriptionHandler) subscription(w http.ResponseWriter, r *http.Request,
+	log *slog.Logger) (*postgres.Subscription, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("invalid subscription id"))
+		return nil, false
+	}
+	log = annotate(r, log, slog.Int("subscription_id", id))
+	sub, err := h.subs.GetSubscription(r.Context(), id)
+	if err != nil {
+		log.Error("failed to get subscription", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return nil, false
+	}
+	if sub == nil {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrSubscriptionNotFound.Error()))
+		return nil, false
+	}
+	return sub, true
+}
+
+// RecurringCharger charges billing cycles with saved payment methods through the payment creation path
+type RecurringCharger struct {
+	log       *slog.Logger
+	logWriter postgres.LogRepository
+	methods   postgres.PaymentMethodRepository
+	users     postgres.UserRepository
+	splitter  *Splitter
+	gateway   yookassa.Gateway
+	checker   *webhook.Checker
+}
+
+func NewRecurringCharger(log *slog.Logger, logWriter postgres.LogRepository,
+	methods postgres.PaymentMethodRepository, users postgres.UserRepository, splitter *Splitter,
+	gateway yookassa.Gateway, checker *webhook.Checker) *RecurringCharger {
+	return &RecurringCharger{
+		log:       log,
+		logWriter: logWriter,
+		methods:   methods,
+		users:     users,
+		splitter:  splitter,
+		gateway:   gateway,
+		checker:   checker,
+	}
+}
+
+func (c *RecurringCharger) Charge(ctx context.Context, sub *postgres.Subscription, cycle *postgres.BillingCycle,
+	idempotenceKey string) (*billing.Charge, error) {
+	// The charge of blocked tenant is canceled, it's retried like rejected ones
+	err := activeUser(ctx, c.users, sub.TenantID)
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUserBlocked) {
+		return &billing.Charge{Status: metrics.Canceled, Reason: err.Error()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	method, err := c.methods.GetPaymentMethod(ctx, sub.TenantID, sub.PaymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+	if method == nil {
+		return &billing.Charge{Status: metrics.Canceled, Reason: ErrPaymentMethodNotFound.Error()}, nil
+	}
+
+	amount := Amount{Value: sub.Amount, Currency: sub.Currency}
+	metadata := NewMetadata(uuid.New(), sub.TenantID, sub.BookingID, "")
+	metadata.SubscriptionID = strconv.Itoa(sub.ID)
+	metadata.LandlordID = sub.LandlordID
+	description, err := renderDescription(metrics.GetDescriptionTemplates().Payment, metadata, amount)
+	if err != nil {
+		return nil, err
+	}
+	createReq := &CreatePaymentRequest{
+		Amount:          amount,
+		PaymentMethodID: method.PaymentMethodID,
+		Capture:         true,
+		Description:     description,
+		Metadata:        metadata,
+	}
+
+	resp, err := c.gateway.Do(ctx, http.MethodPost, metrics.PaymentsEndpoint, createReq, idempotenceKey)
+	if err != nil {
+		return nil, err
+	}
+	payment := new(PaymentResponse)
+	if err = readYooKassaResponse(resp, payment); err != nil {
+		var apiErr *YooKassaError
+		if errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError {
+			return &billing.Charge{Status: metrics.Canceled, Reason: apiErr.Description}, nil
+		}
+		return nil, err
+	}
+
+	charge := &billing.Charge{PaymentID: payment.ID, Status: payment.Status}
+	if details := payment.CancellationDetails; details != nil {
+		charge.Reason = details.Party + ": " + details.Reason
+	}
+
+	// Repeated request returns the payment of the first one, it's linked to its transaction
+	transactionID, err := uuid.Parse(metadata.TransactionID)
+	if payment.Metadata != nil {
+		transactionID, err = uuid.Parse(payment.Metadata.TransactionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log := c.log.With(
+		slog.String("fn", "endpoints.RecurringCharger"),
+		slog.Int("subscription_id", sub.ID),
+		slog.String("yookassa_id", payment.ID),
+		slog.String("transaction_id", transactionID.String()),
+	)
+	checkerData := webhook.NewWebhookData(metrics.PaymentsEndpoint, payment.ID, transactionID, sub.Amount)
+	_ = c.checker.StartCheck(ctx, checkerData, payment.Status)
+
+	createdAt, err := time.Parse(time.RFC3339, payment.CreatedAt)
+	if err != nil {
+		createdAt = time.Now()
+	}
+	logToDb := postgres.NewLog(payment.ID, sub.Amount, string(payment.Status), createdAt)
+	logToDb.Currency = sub.Currency
+	if err = c.logWriter.InsertLog(ctx, logToDb); err != nil {
+		log.Error("failed to write log to db", slog.String("error", err.Error()))
+	}
+	if payment.Status == metrics.Succeeded {
+		if err = c.splitter.SplitPayment(ctx, payment.ID, payment.Amount, payment.Metadata); err != nil {
+			log.Error("failed to split payment", slog.String("error", err.Error()))
+		}
+	}
+	log.Info("recurring payment created", slog.String("status", string(payment.Status)))
+	return charge, nil
+}
+
+func (c *RecurringCharger) Status(ctx context.Context, paymentID string) (metrics.Status, error) {
+	resp, err := c.checker.FetchStatus(ctx, metrics.PaymentsEndpoint, paymentID)
+	if err != nil {
+		return "", err
+	}
+	return resp.Status, nil
+}
@@ -0,0 +1,231 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ****************
+// ________________
+// Users
+// ________________
+// ****************
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserBlocked  = errors.New("user is blocked")
+	ErrUserRole     = errors.New("role must be tenant or landlord")
+	ErrExternalID   = errors.New("external_id must be 1 to 64 characters")
+)
+
+// RegisterUser accepted structure from the main site, role is tenant by default
+type RegisterUser struct {
+	ExternalID string `json:"external_id"`
+	Role       string `json:"role,omitempty"`
+}
+
+// activeUser returns ErrUserNotFound or ErrUserBlocked if the user can't bind cards, pay and get payouts
+func activeUser(ctx context.Context, users postgres.UserRepository, userID string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	user, err := users.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.IsBlocked() {
+		return ErrUserBlocked
+	}
+	return nil
+}
+
+// writeUserError writes the response for the error of activeUser
+func writeUserError(w http.ResponseWriter, log *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(err.Error()))
+	case errors.Is(err, ErrUserBlocked):
+		log.Info("request of blocked user rejected")
+		myJson.Write(w, http.StatusForbidden, NewErrorResponse(err.Error()))
+	default:
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+	}
+}
+
+type UsersHandler struct {
+	log   *slog.Logger
+	users postgres.UserRepository
+	audit postgres.AuditRepository
+}
+
+func NewUsersHandler(log *slog.Logger, users postgres.UserRepository, audit postgres.AuditRepository) *UsersHandler {
+	return &UsersHandler{
+		log:   log,
+		users: users,
+		audit: audit,
+	}
+}
+
+// Register registers the user of the main site (POST /users). It's idempotent: the user registered
+// before with the same external_id is returned with 200, the new one with 201.
+func (h *UsersHandler) Register(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Users.Register"
+
+	log := requestLogger(r, h.log, fn)
+
+	req := new(RegisterUser)
+	if err := myJson.Read(r, req); err != nil {
+		log.Error("failed to read request", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("bad request"))
+		return
+	}
+	if req.ExternalID == "" || len(req.ExternalID) > 64 {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrExternalID.Error()))
+		return
+	}
+	user := models.NewUser()
+	user.ExternalID = req.ExternalID
+	if req.Role != "" {
+		user.Role = req.Role
+	}
+	if !models.IsRole(user.Role) {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse(ErrUserRole.Error()))
+		return
+	}
+
+	inserted, err := h.users.RegisterUser(r.Context(), user)
+	if err != nil {
+		log.Error("failed to register user", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	log = annotate(r, log, slog.String("user_id", user.Id.String()))
+	if !inserted {
+		log.Info("user is already registered")
+		myJson.Write(w, http.StatusOK, user)
+		return
+	}
+
+	auditEvent := newAuditEvent(r, postgres.AuditUserRegistered, postgres.AuditSubjectUser,
+		user.Id.String(), user.Id.String())
+	auditEvent.NewValue = user.Status
+	auditEvent.Details["external_id"] = user.ExternalID
+	auditEvent.Details["role"] = user.Role
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("user registered", slog.String("role", user.Role))
+	myJson.Write(w, http.StatusCreated, user)
+}
+
+// GetUser returns the user (GET /users/{id})
+func (h *UsersHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Users.GetUser"
+
+	log := requestLogger(r, h.log, fn)
+
+	user, ok := h.user(w, r, log)
+	if !ok {
+		return
+	}
+	myJson.Write(w, http.StatusOK, user)
+}
+
+// FindUser returns the user by ID on the main site (GET /users?external_id=...)
+func (h *UsersHandler) FindUser(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Users.FindUser"
+
+	log := requestLogger(r, h.log, fn)
+
+	externalID := r.URL.Query().Get("external_id")
+	if externalID == "" {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("external_id is empty"))
+		return
+	}
+	user, err := h.users.GetUserByExternalID(r.Context(), externalID)
+	if err != nil {
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if user == nil {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrUserNotFound.Error()))
+		return
+	}
+	myJson.Write(w, http.StatusOK, user)
+}
+
+// Block forbids the user to bind cards, pay and get payouts (POST /users/{id}/block)
+func (h *UsersHandler) Block(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Users.Block"
+
+	h.setStatus(w, r, requestLogger(r, h.log, fn), models.UserStatusBlocked, postgres.AuditUserBlocked)
+}
+
+// Unblock allows blocked user to bind cards, pay and get payouts again (POST /users/{id}/unblock)
+func (h *UsersHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	const fn = "endpoints.Users.Unblock"
+
+	h.setStatus(w, r, requestLogger(r, h.log, fn), models.UserStatusActive, postgres.AuditUserUnblocked)
+}
+
+func (h *UsersHandler) setStatus(w http.ResponseWriter, r *http.Request, log *slog.Logger,
+	status, eventType string) {
+	user, ok := h.user(w, r, log)
+	if !ok {
+		return
+	}
+	changed, err := h.users.SetUserStatus(r.Context(), user.Id, status)
+	if err != nil {
+		log.Error("failed to set user status", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return
+	}
+	if !changed {
+		myJson.Write(w, http.StatusConflict, NewErrorResponse("user is already "+user.Status))
+		return
+	}
+
+	auditEvent := newAuditEvent(r, eventType, postgres.AuditSubjectUser, user.Id.String(), "admin")
+	auditEvent.OldValue = user.Status
+	auditEvent.NewValue = status
+	writeAudit(r.Context(), log, h.audit, auditEvent)
+
+	log.Info("user status changed", slog.String("status", status))
+	user.Status = status
+	myJson.Write(w, http.StatusOK, user)
+}
+
+// user finds user by URL parameter, the error response is written if it isn't found
+func (h *UsersHandler) user(w http.ResponseWriter, r *http.Request, log *slog.Logger) (*models.User, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		myJson.Write(w, http.StatusBadRequest, NewErrorResponse("invalid user id"))
+		return nil, false
+	}
+	log = annotate(r, log, slog.String("user_id", id.String()))
+	user, err := h.users.GetUser(r.Context(), id)
+	if err != nil {
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		myJson.Write(w, http.StatusInternalServerError, NewErrorResponse("server error"))
+		return nil, false
+	}
+	if user == nil {
+		myJson.Write(w, http.StatusNotFound, NewErrorResponse(ErrUserNotFound.Error()))
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,51 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+
+	"github.com/google/uuid"
+)
+
+// YooKassaError is error response of YooKassa API
+type YooKassaError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Parameter   string `json:"parameter,omitempty"`
+}
+
+func (e *YooKassaError) Error() string {
+	return fmt.Sprintf("yookassa: %v %v: %v", e.StatusCode, e.Code, e.Description)
+}
+
+// sendYooKassaRequest sends request through the gateway, resource is relative to API root
+// (e.g. "refunds" or "receipts?payment_id=..."). POST requests get new idempotence key.
+func sendYooKassaRequest(ctx context.Context, gateway yookassa.Gateway, method, resource string,
+	body any) (*http.Response, error) {
+	return gateway.Do(ctx, method, resource, body, uuid.New().String())
+}
+
+// detached keeps values of the request context but isn't canceled with it. It's used to record
+// what is already done in YooKassa even if the client has gone.
+func detached(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
+
+// readYooKassaResponse decodes response into v, error responses are returned as *YooKassaError
+func readYooKassaResponse(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &YooKassaError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil {
+			apiErr.Description = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"net/url"
+	"text/template"
 )
 
 // ___________________
@@ -18,6 +20,10 @@ const (
 const (
 	PaymentsEndpoint = "payments"
 	PayoutsEndpoint  = "payouts"
+	RefundsEndpoint  = "refunds"
+	ReceiptsEndpoint = "receipts"
+	// MeEndpoint returns store settings, it's used to check credentials
+	MeEndpoint = "me"
 )
 
 var (
@@ -45,27 +51,163 @@ func (status Status) IsAlreadyProcessedStatus() bool {
 // YouKassa confirmation
 // _______________________
 
-type YouKassaConfirmation struct {
-	StoreID        string
-	StoreSecretKey string
+type YooKassaConfig struct {
+	BaseURL   string `yaml:"baseUrl" env:"YOOKASSA_API_URL" default:"https://api.yookassa.ru/v3/"`
+	StoreID   string `yaml:"storeId" env:"STORE_ID" required:"true"`
+	SecretKey string `yaml:"secretKey" env:"SECRET_KEY" required:"true" secret:"true"`
+	// Receipt (54-FZ) defaults, used when booking items don't set them
+	Receipt ReceiptConfig `yaml:"receipt"`
+	// Descriptions are text/template templates of descriptions sent to YooKassa
+	Descriptions DescriptionConfig `yaml:"descriptions"`
+	// PayoutBatch limits batch payouts (POST /payouts/batch)
+	PayoutBatch PayoutBatchConfig `yaml:"payoutBatch"`
+}
+
+type PayoutBatchConfig struct {
+	// Concurrency is the number of payouts of the batch created simultaneously
+	Concurrency int `yaml:"concurrency" env:"PAYOUT_BATCH_CONCURRENCY" default:"4"`
+	MaxItems    int `yaml:"maxItems" env:"PAYOUT_BATCH_MAX_ITEMS" default:"500"`
+}
+
+// Default description templates, they're used when templates aren't configured
+const (
+	DefaultPaymentDescription = "Бронирование № {{or .BookingID .OrderID .TransactionID}}"
+	DefaultPayoutDescription  = "From ImperatorOfDwelling for renting an apartment.{{with .BookingID}} Booking № {{.}}{{end}}"
+)
+
+// DescriptionConfig templates get fields TransactionID, UserID, BookingID, OrderID, Amount, Currency.
+// Empty templates are replaced by DefaultPaymentDescription and DefaultPayoutDescription.
+type DescriptionConfig struct {
+	Payment string `yaml:"payment" env:"PAYMENT_DESCRIPTION"`
+	Payout  string `yaml:"payout" env:"PAYOUT_DESCRIPTION"`
+}
+
+type ReceiptConfig struct {
+	// VatCode is YooKassa VAT code: 1 is "without VAT"
+	VatCode int `yaml:"vatCode" env:"RECEIPT_VAT_CODE" default:"1"`
+	// TaxSystemCode is required only by stores with several tax systems, 0 is not sent
+	TaxSystemCode  int    `yaml:"taxSystemCode" env:"RECEIPT_TAX_SYSTEM_CODE"`
+	PaymentSubject string `yaml:"paymentSubject" env:"RECEIPT_PAYMENT_SUBJECT" default:"service"`
+}
+
+func (c *YooKassaConfig) Validate() error {
+	if c.Receipt.VatCode <= 0 {
+		return errors.New("receipt.vatCode must be positive")
+	}
+	if c.Receipt.TaxSystemCode < 0 {
+		return errors.New("receipt.taxSystemCode can't be negative")
+	}
+	if c.PayoutBatch.Concurrency <= 0 || c.PayoutBatch.MaxItems <= 0 {
+		return errors.New("payoutBatch.concurrency and payoutBatch.maxItems must be positive")
+	}
+	if _, err := template.New("payment").Parse(c.Descriptions.Payment); err != nil {
+		return fmt.Errorf("descriptions.payment: %w", err)
+	}
+	if _, err := template.New("payout").Parse(c.Descriptions.Payout); err != nil {
+		return fmt.Errorf("descriptions.payout: %w", err)
+	}
+	if c.BaseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("baseUrl must be absolute URL")
+	}
+	return nil
+}
+
+// Init sets receipt defaults, description templates and polling limits.
+// Store credentials are kept by the YooKassa client (yookassa.NewClient).
+func Init(yooKassa *YooKassaConfig, checker *CheckerConfig) {
+	if yooKassa != nil {
+		receiptInstance = yooKassa.Receipt
+		payoutBatchInstance = yooKassa.PayoutBatch
+		descriptionsInstance = defaultDescriptions
+		if yooKassa.Descriptions.Payment != "" {
+			descriptionsInstance.Payment = yooKassa.Descriptions.Payment
+		}
+		if yooKassa.Descriptions.Payout != "" {
+			descriptionsInstance.Payout = yooKassa.Descriptions.Payout
+		}
+	}
+	if checker != nil {
+		checkerInstance = *checker
+	}
 }
 
 var (
-	confirmationInstance YouKassaConfirmation
+	receiptInstance = ReceiptConfig{
+		VatCode:        1,
+		PaymentSubject: "service",
+	}
 )
 
-func Init() {
-	confirmationInstance = YouKassaConfirmation{
-		StoreID:        os.Getenv("STORE_ID"),
-		StoreSecretKey: os.Getenv("SECRET_KEY"),
+var (
+	defaultDescriptions = DescriptionConfig{
+		Payment: DefaultPaymentDescription,
+		Payout:  DefaultPayoutDescription,
 	}
+	descriptionsInstance = defaultDescriptions
+)
+
+// GetDescriptionTemplates returns templates of payment and payout descriptions
+func GetDescriptionTemplates() DescriptionConfig {
+	return descriptionsInstance
+}
+
+// GetReceiptDefaults returns defaults of receipt items
+func GetReceiptDefaults() ReceiptConfig {
+	return receiptInstance
 }
 
-func GetConfirmationData() (storeID string, storeSecretKey string) {
-	return confirmationInstance.StoreID, confirmationInstance.StoreSecretKey
+var (
+	payoutBatchInstance = PayoutBatchConfig{
+		Concurrency: 4,
+		MaxItems:    500,
+	}
+)
+
+// GetPayoutBatchLimits returns concurrency and size limits of batch payouts
+func GetPayoutBatchLimits() PayoutBatchConfig {
+	return payoutBatchInstance
 }
 
+// _______________________
+// Status checking limits
+// _______________________
+
 // An indication of how many minutes I have to check the status
 const (
 	CheckMaxMinutes = 24 * 60
 )
+
+type CheckerConfig struct {
+	// MaxMinutes is how long status of a transaction is polled
+	MaxMinutes int `yaml:"maxMinutes" env:"CHECK_MAX_MINUTES" default:"1440"`
+	// MaxActiveChecks limits the number of simultaneously polled transactions, 0 is unlimited
+	MaxActiveChecks int `yaml:"maxActiveChecks" env:"CHECK_MAX_ACTIVE"`
+}
+
+func (c *CheckerConfig) Validate() error {
+	if c.MaxMinutes <= 0 {
+		return errors.New("maxMinutes must be positive")
+	}
+	if c.MaxActiveChecks < 0 {
+		return errors.New("maxActiveChecks can't be negative")
+	}
+	return nil
+}
+
+var (
+	checkerInstance = CheckerConfig{
+		MaxMinutes: CheckMaxMinutes,
+	}
+)
+
+func GetCheckMaxMinutes() int {
+	return checkerInstance.MaxMinutes
+}
+
+func GetCheckMaxActive() int {
+	return checkerInstance.MaxActiveChecks
+}
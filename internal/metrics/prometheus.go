@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ___________________
+// Prometheus
+// ___________________
+
+const namespace = "iod_payment"
+
+// Registry holds all service metrics, it's exposed by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	yooKassaRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "yookassa_requests_total",
+		Help:      "Requests to YooKassa API by resource, method and status code.",
+	}, []string{"resource", "method", "code"})
+
+	yooKassaErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "yookassa_errors_total",
+		Help:      "Failed requests to YooKassa API by resource and status code (\"transport\" if there is no response).",
+	}, []string{"resource", "code"})
+
+	yooKassaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "yookassa_request_duration_seconds",
+		Help:      "YooKassa API latency by resource and method.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"resource", "method"})
+
+	transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Payments and payouts by final status and amount bucket.",
+	}, []string{"kind", "status", "amount_bucket"})
+
+	checkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "status_check_duration_seconds",
+		Help:      "Time from the start of status polling to its end, by result.",
+		Buckets:   []float64{60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+	}, []string{"kind", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		yooKassaRequests,
+		yooKassaErrors,
+		yooKassaDuration,
+		transactions,
+		checkDuration,
+	)
+}
+
+// Handler exposes metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTPRequest is called by router middleware, route is chi pattern (not the raw path)
+func ObserveHTTPRequest(route, method string, code int, duration time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// ObserveTransaction counts a transaction which reached its final status
+func ObserveTransaction(kind string, status Status, amount string) {
+	transactions.WithLabelValues(kind, string(status), AmountBucket(amount)).Inc()
+}
+
+// ObserveCheckFinished is called when status polling ends,
+// result is final status or reason of the end (e.g. "expired")
+func ObserveCheckFinished(kind, result string, duration time.Duration) {
+	checkDuration.WithLabelValues(kind, result).Observe(duration.Seconds())
+}
+
+// Amount buckets, upper bounds in currency units
+var amountBuckets = []struct {
+	upper float64
+	label string
+}{
+	{1_000, "lt_1k"},
+	{10_000, "1k_10k"},
+	{50_000, "10k_50k"},
+	{100_000, "50k_100k"},
+}
+
+// AmountBucket returns label of the amount range, amount is decimal string as in YooKassa API
+func AmountBucket(amount string) string {
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return "unknown"
+	}
+	for _, b := range amountBuckets {
+		if value < b.upper {
+			return b.label
+		}
+	}
+	return "gte_100k"
+}
+
+// _______________________
+// YooKassa API client
+// _______________________
+
+const yooKassaTimeout = 30 * time.Second
+
+type yooKassaTransport struct {
+	base http.RoundTripper
+}
+
+func (t *yooKassaTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resource := yooKassaResource(r.URL.Path)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	yooKassaDuration.WithLabelValues(resource, r.Method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		yooKassaErrors.WithLabelValues(resource, "transport").Inc()
+		return nil, err
+	}
+	code := strconv.Itoa(resp.StatusCode)
+	yooKassaRequests.WithLabelValues(resource, r.Method, code).Inc()
+	if resp.StatusCode >= http.StatusBadRequest {
+		yooKassaErrors.WithLabelValues(resource, code).Inc()
+	}
+	return resp, nil
+}
+
+// yooKassaResource returns API resource of the path: /v3/payments/<id> -> payments
+func yooKassaResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 2 && strings.HasPrefix(parts[0], "v") {
+		return parts[1]
+	}
+	if len(parts) >= 1 && parts[0] != "" {
+		return parts[0]
+	}
+	return "unknown"
+}
+
+// NewYooKassaClient returns HTTP client for YooKassa API with instrumented transport:
+// every request is measured and traced, trace context is propagated with the request
+func NewYooKassaClient() *http.Client {
+	return &http.Client{
+		Transport: otelhttp.NewTransport(
+			&yooKassaTransport{base: http.DefaultTransport},
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return "yookassa " + r.Method + " " + yooKassaResource(r.URL.Path)
+			}),
+		),
+		Timeout: yooKassaTimeout,
+	}
+}
+
+// _______________________
+// Runtime stats
+// _______________________
+
+// PoolStats is common view of Postgres and Redis connection pools
+type PoolStats struct {
+	Open         int
+	InUse        int
+	Idle         int
+	WaitCount    int64
+	WaitDuration time.Duration
+	Timeouts     int64
+}
+
+type poolCollector struct {
+	stats        func() PoolStats
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	timeouts     *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.timeouts
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(s.Open))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+}
+
+// RegisterPoolStats exposes stats of the connection pool, pool is "postgres" or "redis"
+func RegisterPoolStats(pool string, stats func() PoolStats) error {
+	labels := prometheus.Labels{"pool": pool}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", name), help, nil, labels)
+	}
+	return Registry.Register(&poolCollector{
+		stats:        stats,
+		open:         desc("open_connections", "Established connections."),
+		inUse:        desc("in_use_connections", "Connections currently in use."),
+		idle:         desc("idle_connections", "Idle connections."),
+		waitCount:    desc("wait_total", "Times a connection was waited for."),
+		waitDuration: desc("wait_seconds_total", "Total time spent waiting for a connection."),
+		timeouts:     desc("timeouts_total", "Times waiting for a connection timed out."),
+	})
+}
+
+// RegisterCheckerStats exposes the number of running status checks and the age of the oldest one
+func RegisterCheckerStats(stats func() (active int, oldest time.Duration)) error {
+	err := Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "status_checks_active",
+		Help:      "Transactions whose status is being polled.",
+	}, func() float64 {
+		active, _ := stats()
+		return float64(active)
+	}))
+	if err != nil {
+		return err
+	}
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "status_checks_oldest_age_seconds",
+		Help:      "Age of the oldest active status check.",
+	}, func() float64 {
+		_, oldest := stats()
+		return oldest.Seconds()
+	}))
+}
@@ -42,3 +42,13 @@ func GenerateCardMask(firstSix string, lastFour string) (string, error) {
 	missedNumbers := strings.Repeat("*", CardSize-6-4)
 	return firstSix + missedNumbers + lastFour, nil
 }
+
+// GenerateMask hides digits of the account (phone, wallet number) like card mask does,
+// only first and last digits are kept
+func GenerateMask(account string, first, last int) (string, error) {
+	if first < 0 || last < 0 || len(account) <= first+last {
+		return "", errors.New("account is too short to be masked")
+	}
+	missedNumbers := strings.Repeat("*", len(account)-first-last)
+	return account[:first] + missedNumbers + account[len(account)-last:], nil
+}
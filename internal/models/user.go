@@ -7,9 +7,26 @@ import (
 	"github.com/google/uuid"
 )
 
+// User roles
+const (
+	UserRoleTenant   = "tenant"
+	UserRoleLandlord = "landlord"
+)
+
+// User statuses, blocked user can't bind cards, pay and get payouts
+const (
+	UserStatusActive  = "active"
+	UserStatusBlocked = "blocked"
+)
+
 type User struct {
-	Id      uuid.UUID `json:"id"`
-	Balance float64   `json:"balance"`
+	Id uuid.UUID `json:"id"`
+	// ExternalID is ID of the user on the main site
+	ExternalID string    `json:"external_id,omitempty"`
+	Role       string    `json:"role,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Balance    float64   `json:"balance,omitempty"`
 }
 
 type BalanceChange struct {
@@ -25,10 +42,21 @@ type BalanceChange struct {
 func NewUser() *User {
 	return &User{
 		Id:      uuid.New(),
+		Role:    UserRoleTenant,
+		Status:  UserStatusActive,
 		Balance: 0,
 	}
 }
 
+// IsRole returns true for known role
+func IsRole(role string) bool {
+	return role == UserRoleTenant || role == UserRoleLandlord
+}
+
+func (u *User) IsBlocked() bool {
+	return u.Status == UserStatusBlocked
+}
+
 func (u *User) String() string {
 	return fmt.Sprintf(
 		`Id: %s
@@ -0,0 +1,79 @@
+// Package periodic runs ticks of background jobs (billing, reconciliation) shared by replicas
+package periodic
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Locker is a lock shared by replicas (Redis)
+type Locker interface {
+	TryLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// Extend resets ttl of the lock, it returns false if the lock isn't held by the owner
+	Extend(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, name, owner string) error
+}
+
+// TickFunc is one run of the job, the error is logged and the job runs again on the next tick
+type TickFunc func(ctx context.Context, now time.Time) error
+
+// Runner calls the tick right after Start and then every interval
+type Runner struct {
+	log      *slog.Logger
+	interval time.Duration
+	tick     TickFunc
+	wg       sync.WaitGroup
+}
+
+func NewRunner(log *slog.Logger, interval time.Duration, tick TickFunc) *Runner {
+	return &Runner{
+		log:      log,
+		interval: interval,
+		tick:     tick,
+	}
+}
+
+// Start ticks in background until ctx is canceled, the started tick is finished anyway (see Wait)
+func (r *Runner) Start(ctx context.Context) {
+	// Added before the goroutine starts, so Wait called right after Start waits for it
+	r.wg.Add(1)
+	go r.loop(ctx)
+}
+
+func (r *Runner) loop(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.tick(context.WithoutCancel(ctx), time.Now()); err != nil {
+			r.log.Error("tick failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// select picks any ready case, the stopped runner doesn't tick again
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// Wait waits for the running tick after ctx of Start is canceled
+func (r *Runner) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
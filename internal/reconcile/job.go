@@ -0,0 +1,148 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/periodic"
+
+	"github.com/google/uuid"
+)
+
+// lockPrefix is the lock of the reconciled day, only one replica reconciles it
+const lockPrefix = "reconcile:"
+
+type Config struct {
+	Enabled bool `yaml:"enabled" env:"RECONCILE_ENABLED" default:"false"`
+	// TickInterval is how often the job checks whether the previous days are reconciled
+	TickInterval time.Duration `yaml:"tickInterval" env:"RECONCILE_TICK_INTERVAL" default:"1h"`
+	// Delay after midnight (UTC) before the previous day is reconciled, so that its objects become final
+	Delay time.Duration `yaml:"delay" env:"RECONCILE_DELAY" default:"3h"`
+	// AutoFix corrects safe status mismatches, otherwise they are only reported
+	AutoFix   bool   `yaml:"autoFix" env:"RECONCILE_AUTO_FIX" default:"true"`
+	ReportDir string `yaml:"reportDir" env:"RECONCILE_REPORT_DIR" default:"reports"`
+	// LockTTL must be longer than reconciliation of a day, the lock of the day expires after it
+	// if the replica has died, so the day is reconciled again
+	LockTTL time.Duration `yaml:"lockTTL" env:"RECONCILE_LOCK_TTL" default:"1h"`
+	// BackfillDays is how many days before now are reconciled if they're missed (e.g. the service was down),
+	// 1 is only the previous day
+	BackfillDays int `yaml:"backfillDays" env:"RECONCILE_BACKFILL_DAYS" default:"7"`
+}
+
+func (c *Config) Validate() error {
+	if c.TickInterval <= 0 || c.LockTTL <= 0 {
+		return errors.New("tickInterval and lockTTL must be positive")
+	}
+	if c.Delay < 0 || c.Delay >= 24*time.Hour {
+		return errors.New("delay must be in [0, 24h)")
+	}
+	if c.BackfillDays < 1 {
+		return errors.New("backfillDays must be at least 1")
+	}
+	if c.ReportDir == "" {
+		return errors.New("reportDir is required")
+	}
+	return nil
+}
+
+// Job reconciles the previous UTC day and the missed days before it and saves the reports to ReportDir.
+// It's started and waited for by the embedded runner.
+type Job struct {
+	*periodic.Runner
+	log        *slog.Logger
+	cfg        *Config
+	reconciler *Reconciler
+	locker     periodic.Locker
+	// owner identifies the replica holding the lock
+	owner string
+}
+
+func NewJob(log *slog.Logger, cfg *Config, reconciler *Reconciler, locker periodic.Locker) *Job {
+	j := &Job{
+		log:        log.With(slog.String("fn", "reconcile.Job")),
+		cfg:        cfg,
+		reconciler: reconciler,
+		locker:     locker,
+		owner:      uuid.New().String(),
+	}
+	j.Runner = periodic.NewRunner(j.log, cfg.TickInterval, j.Tick)
+	return j
+}
+
+// Tick reconciles the days before now (minus Delay) which aren't reconciled yet, oldest first.
+// The lock of the day is held while it's reconciled and then it marks the day as reconciled until the day
+// is out of BackfillDays. The lock is released on failure and expires if the replica has died,
+// so the day is reconciled again on the next tick.
+func (j *Job) Tick(ctx context.Context, now time.Time) error {
+	last := now.Add(-j.cfg.Delay)
+	var errs []error
+	for back := j.cfg.BackfillDays - 1; back >= 0; back-- {
+		from, to := Day(last.AddDate(0, 0, -back))
+		if err := j.reconcileDay(ctx, from, to); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (j *Job) reconcileDay(ctx context.Context, from, to time.Time) error {
+	name := from.Format(time.DateOnly)
+	locked, err := j.locker.TryLock(ctx, lockPrefix+name, j.owner, j.cfg.LockTTL)
+	if err != nil {
+		return fmt.Errorf("failed to lock reconciliation of %v: %w", name, err)
+	}
+	if !locked {
+		j.log.Debug("day is reconciled already or by another replica", slog.String("day", name))
+		return nil
+	}
+
+	if err = j.run(ctx, from, to, name); err != nil {
+		if unlockErr := j.locker.Unlock(ctx, lockPrefix+name, j.owner); unlockErr != nil {
+			j.log.Warn("failed to unlock reconciliation", slog.String("error", unlockErr.Error()))
+		}
+		return fmt.Errorf("failed to reconcile %v: %w", name, err)
+	}
+	// The lock is kept until the day is out of backfill, otherwise the day is reconciled again by the next tick
+	reconciledTTL := time.Duration(j.cfg.BackfillDays+1) * 24 * time.Hour
+	extended, err := j.locker.Extend(ctx, lockPrefix+name, j.owner, reconciledTTL)
+	if err != nil {
+		j.log.Warn("failed to mark day as reconciled", slog.String("day", name), slog.String("error", err.Error()))
+	} else if !extended {
+		j.log.Warn("lock of the day expired during reconciliation, it will be reconciled again",
+			slog.String("day", name))
+	}
+	return nil
+}
+
+func (j *Job) run(ctx context.Context, from, to time.Time, name string) error {
+	report, err := j.reconciler.Run(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	paths, err := SaveReport(j.cfg.ReportDir, "reconcile-"+name, report)
+	if err != nil {
+		return fmt.Errorf("failed to save reconciliation report: %w", err)
+	}
+	j.log.Info("reconciliation report saved",
+		slog.String("day", name),
+		slog.Any("files", paths),
+		slog.Int("discrepancies", len(report.Discrepancies)),
+	)
+	if report.Unaudited > 0 {
+		j.log.Error("reconciliation fixes aren't audited, see unaudited discrepancies of the report",
+			slog.String("day", name),
+			slog.Int("unaudited", report.Unaudited),
+		)
+	}
+	return nil
+}
+
+// Day returns bounds [from, to) of the UTC day before t
+func Day(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	to := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return to.AddDate(0, 0, -1), to
+}
@@ -0,0 +1,366 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// auditActor is the actor of audited status corrections
+const auditActor = "reconcile"
+
+// Discrepancy types
+const (
+	MissingLocally  = "missing_locally"
+	MissingRemotely = "missing_remotely"
+	AmountMismatch  = "amount_mismatch"
+	StatusMismatch  = "status_mismatch"
+)
+
+// ErrNotFound is returned by Source if YooKassa has no such object
+var ErrNotFound = errors.New("object is not found")
+
+// Resource is YooKassa resource and kind of its local log
+type Resource struct {
+	Name string
+	Kind string
+}
+
+// Resources are reconciled in this order
+var Resources = []Resource{
+	{Name: "payments", Kind: postgres.LogKindPayment},
+	{Name: "payouts", Kind: postgres.LogKindPayout},
+	{Name: "refunds", Kind: postgres.LogKindRefund},
+}
+
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// Object is payment, payout or refund of YooKassa
+type Object struct {
+	ID        string            `json:"id"`
+	Status    metrics.Status    `json:"status"`
+	Amount    Amount            `json:"amount"`
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// PaymentID is the refunded payment, it's returned only for refunds
+	PaymentID string `json:"payment_id,omitempty"`
+}
+
+// FinalHook gets the object whose local status is corrected to the final one, so the status
+// has the same effects (e.g. ledger entries) as if it was received by the status check
+type FinalHook func(ctx context.Context, resource, yooKassaID string, object *Object)
+
+// Page is a page of YooKassa list, NextCursor is empty on the last page
+type Page struct {
+	Items      []*Object `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Source lists YooKassa objects
+type Source interface {
+	// List returns the page after cursor of the objects created in [from, to)
+	List(ctx context.Context, resource string, from, to time.Time, cursor string) (*Page, error)
+	// Get returns the object, ErrNotFound if there is no such object
+	Get(ctx context.Context, resource, id string) (*Object, error)
+}
+
+type Discrepancy struct {
+	Resource     string `json:"resource"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	LocalAmount  string `json:"local_amount,omitempty"`
+	RemoteAmount string `json:"remote_amount,omitempty"`
+	LocalStatus  string `json:"local_status,omitempty"`
+	RemoteStatus string `json:"remote_status,omitempty"`
+	// Fixed is true if the local status is corrected to the remote one
+	Fixed bool `json:"fixed"`
+	// Unaudited is true if the status is corrected, but its audit event isn't written
+	Unaudited bool `json:"unaudited,omitempty"`
+}
+
+type Report struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Checked is the number of remote objects by resource
+	Checked       map[string]int `json:"checked"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+	Fixed         int            `json:"fixed"`
+	// Unaudited is the number of fixes without audit events, they must be audited by hand
+	Unaudited int `json:"unaudited"`
+}
+
+// Reconciler compares YooKassa objects with local logs by ID, amount and status
+type Reconciler struct {
+	log    *slog.Logger
+	source Source
+	logs   postgres.LogRepository
+	audit  postgres.AuditRepository
+	// fix enables correction of safe status mismatches, otherwise they are only reported
+	fix bool
+	// finalHook is called when the status is corrected to the final one
+	finalHook FinalHook
+}
+
+func NewReconciler(log *slog.Logger, source Source, logs postgres.LogRepository, audit postgres.AuditRepository,
+	fix bool) *Reconciler {
+	return &Reconciler{
+		log:    log.With(slog.String("fn", "reconcile.Reconciler")),
+		source: source,
+		logs:   logs,
+		audit:  audit,
+		fix:    fix,
+	}
+}
+
+// SetFinalHook sets hook of corrected final statuses, it must be called before Run
+func (r *Reconciler) SetFinalHook(hook FinalHook) {
+	r.finalHook = hook
+}
+
+// Run reconciles objects created in [from, to)
+func (r *Reconciler) Run(ctx context.Context, from, to time.Time) (report *Report, err error) {
+	ctx, span := tracing.Start(ctx, "reconcile.Run",
+		attribute.String("from", from.Format(time.RFC3339)),
+		attribute.String("to", to.Format(time.RFC3339)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	report = &Report{
+		From:          from,
+		To:            to,
+		Checked:       make(map[string]int),
+		Discrepancies: make([]*Discrepancy, 0),
+	}
+	for _, resource := range Resources {
+		if err = r.reconcile(ctx, resource, from, to, report); err != nil {
+			return nil, fmt.Errorf("failed to reconcile %v: %w", resource.Name, err)
+		}
+	}
+	report.GeneratedAt = time.Now().UTC()
+	r.log.Info("reconciliation finished",
+		slog.Time("from", from),
+		slog.Time("to", to),
+		slog.Int("discrepancies", len(report.Discrepancies)),
+		slog.Int("fixed", report.Fixed),
+		slog.Int("unaudited", report.Unaudited),
+	)
+	return report, nil
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, resource Resource, from, to time.Time, report *Report) error {
+	remote, err := r.list(ctx, resource.Name, from, to)
+	if err != nil {
+		return err
+	}
+	report.Checked[resource.Name] = len(remote)
+	remoteIDs := make(map[string]bool, len(remote))
+	for _, object := range remote {
+		remoteIDs[object.ID] = true
+	}
+
+	logs, err := r.logs.GetLogs(ctx, resource.Kind, from, to)
+	if err != nil {
+		return err
+	}
+	local := make(map[string]*postgres.Log, len(logs))
+	for _, l := range logs {
+		local[l.TransactionID] = l
+	}
+
+	for _, object := range remote {
+		l, ok := local[object.ID]
+		if !ok {
+			// The object may be logged a bit earlier or later than YooKassa has created it
+			if l, err = r.logs.GetLog(ctx, object.ID); err != nil {
+				return err
+			}
+		}
+		if l == nil {
+			report.add(&Discrepancy{
+				Resource:     resource.Name,
+				ID:           object.ID,
+				Type:         MissingLocally,
+				RemoteAmount: object.Amount.Value,
+				RemoteStatus: string(object.Status),
+			})
+			continue
+		}
+		if err = r.compare(ctx, resource, l, object, report); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range logs {
+		if remoteIDs[l.TransactionID] {
+			continue
+		}
+		object, err := r.source.Get(ctx, resource.Name, l.TransactionID)
+		if errors.Is(err, ErrNotFound) {
+			report.add(&Discrepancy{
+				Resource:    resource.Name,
+				ID:          l.TransactionID,
+				Type:        MissingRemotely,
+				LocalAmount: l.Amount,
+				LocalStatus: l.Status,
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = r.compare(ctx, resource, l, object, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns objects of the resource from all pages
+func (r *Reconciler) list(ctx context.Context, resource string, from, to time.Time) ([]*Object, error) {
+	objects := make([]*Object, 0)
+	cursor := ""
+	for {
+		page, err := r.source.List(ctx, resource, from, to, cursor)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Items...)
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			return objects, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// compare reports amount and status mismatches, a safe status mismatch is fixed
+func (r *Reconciler) compare(ctx context.Context, resource Resource, l *postgres.Log, object *Object,
+	report *Report) error {
+	if !SameAmount(l.Amount, object.Amount.Value) {
+		report.add(&Discrepancy{
+			Resource:     resource.Name,
+			ID:           object.ID,
+			Type:         AmountMismatch,
+			LocalAmount:  l.Amount,
+			RemoteAmount: object.Amount.Value,
+		})
+	}
+	if l.Status == string(object.Status) {
+		return nil
+	}
+
+	discrepancy := &Discrepancy{
+		Resource:     resource.Name,
+		ID:           object.ID,
+		Type:         StatusMismatch,
+		LocalStatus:  l.Status,
+		RemoteStatus: string(object.Status),
+	}
+	if r.fix && IsSafeFix(metrics.Status(l.Status), object.Status) {
+		audited, err := r.fixStatus(ctx, resource, l, object)
+		if err != nil {
+			return err
+		}
+		discrepancy.Fixed = true
+		discrepancy.Unaudited = !audited
+	}
+	report.add(discrepancy)
+	return nil
+}
+
+// fixStatus corrects the local status, false is returned if the correction isn't audited
+func (r *Reconciler) fixStatus(ctx context.Context, resource Resource, l *postgres.Log, object *Object) (bool, error) {
+	oldStatus := l.Status
+	log := r.log.With(
+		slog.String("resource", resource.Name),
+		slog.String("yookassa_id", object.ID),
+		slog.String("old_status", oldStatus),
+		slog.String("new_status", string(object.Status)),
+	)
+	if _, err := r.logs.UpdateLogStatus(ctx, object.ID, string(object.Status)); err != nil {
+		return false, err
+	}
+	log.Info("transaction status is corrected by reconciliation")
+	if r.finalHook != nil && object.Status.IsAlreadyProcessedStatus() {
+		r.finalHook(ctx, resource.Name, object.ID, object)
+	}
+
+	event := &postgres.AuditEvent{
+		EventType:   postgres.AuditReconcileFix,
+		ActorID:     auditActor,
+		SubjectType: subjectType(resource.Kind),
+		SubjectID:   object.ID,
+		OldValue:    oldStatus,
+		NewValue:    string(object.Status),
+		Details: map[string]string{
+			"resource": resource.Name,
+			"trace_id": tracing.TraceID(ctx),
+		},
+	}
+	if err := r.audit.InsertAuditEvent(ctx, event); err != nil {
+		// The status is corrected already, the fix is reported as unaudited
+		log.Error("failed to write audit event of the fix", slog.String("error", err.Error()))
+		return false, nil
+	}
+	return true, nil
+}
+
+func (report *Report) add(discrepancy *Discrepancy) {
+	report.Discrepancies = append(report.Discrepancies, discrepancy)
+	if discrepancy.Fixed {
+		report.Fixed++
+	}
+	if discrepancy.Unaudited {
+		report.Unaudited++
+	}
+}
+
+// IsSafeFix returns true if the local status may be replaced by the remote one.
+// Final local statuses are never changed, money may have moved already.
+func IsSafeFix(local, remote metrics.Status) bool {
+	if local.IsAlreadyProcessedStatus() {
+		return false
+	}
+	switch remote {
+	case metrics.Succeeded, metrics.Canceled:
+		return true
+	case metrics.WaitingForCapture:
+		return local == metrics.Pending
+	}
+	return false
+}
+
+// SameAmount compares decimal amounts, "100" and "100.00" are the same
+func SameAmount(a, b string) bool {
+	x, ok := new(big.Rat).SetString(a)
+	if !ok {
+		return false
+	}
+	y, ok := new(big.Rat).SetString(b)
+	if !ok {
+		return false
+	}
+	return x.Cmp(y) == 0
+}
+
+func subjectType(kind string) string {
+	switch kind {
+	case postgres.LogKindPayout:
+		return postgres.AuditSubjectPayout
+	case postgres.LogKindRefund:
+		return postgres.AuditSubjectRefund
+	}
+	return postgres.AuditSubjectPayment
+}
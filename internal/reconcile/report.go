@@ -0,0 +1,68 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// WriteCSV writes discrepancies of the report, one per row
+func WriteCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"resource", "id", "type", "local_amount", "remote_amount", "local_status",
+		"remote_status", "fixed", "unaudited"})
+	for _, d := range report.Discrepancies {
+		_ = cw.Write([]string{
+			d.Resource,
+			d.ID,
+			d.Type,
+			d.LocalAmount,
+			d.RemoteAmount,
+			d.LocalStatus,
+			d.RemoteStatus,
+			strconv.FormatBool(d.Fixed),
+			strconv.FormatBool(d.Unaudited),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SaveReport writes <name>.json and <name>.csv to dir, paths of the files are returned
+func SaveReport(dir, name string, report *Report) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	writers := []struct {
+		ext   string
+		write func(io.Writer, *Report) error
+	}{
+		{ext: ".json", write: WriteJSON},
+		{ext: ".csv", write: WriteCSV},
+	}
+	paths := make([]string, 0, len(writers))
+	for _, writer := range writers {
+		path := filepath.Join(dir, name+writer.ext)
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.write(f, report)
+		if err = errors.Join(err, f.Close()); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,272 @@
+package reports
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/xlsx"
+)
+
+// Report names
+const (
+	Turnover        = "turnover"
+	LandlordPayouts = "landlord_payouts"
+	Refunds         = "refunds"
+	Commissions     = "commissions"
+	Escrow          = "escrow"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// pageSize is the number of rows of paged reports selected at once
+const pageSize = 1000
+
+var (
+	ErrUnknownReport = errors.New("report must be turnover, landlord_payouts, refunds, commissions or escrow")
+	ErrFormat        = errors.New("format must be csv or xlsx")
+	ErrPeriod        = errors.New("period must be day, week or month")
+	ErrCurrency      = errors.New("currency must be 3 uppercase letters")
+	ErrDateRange     = errors.New("from and to must be dates (YYYY-MM-DD), to can't be before from")
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// IsReport returns true for known report name
+func IsReport(name string) bool {
+	switch name {
+	case Turnover, LandlordPayouts, Refunds, Commissions, Escrow:
+		return true
+	}
+	return false
+}
+
+// ParseFilter parses UTC days, both from and to are included. Empty period is a day.
+func ParseFilter(from, to, currency, period string) (*postgres.ReportFilter, error) {
+	fromDay, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return nil, ErrDateRange
+	}
+	toDay, err := time.Parse(time.DateOnly, to)
+	if err != nil || toDay.Before(fromDay) {
+		return nil, ErrDateRange
+	}
+	if currency != "" && !currencyCode.MatchString(currency) {
+		return nil, ErrCurrency
+	}
+	switch period {
+	case "":
+		period = postgres.ReportPeriodDay
+	case postgres.ReportPeriodDay, postgres.ReportPeriodWeek, postgres.ReportPeriodMonth:
+	default:
+		return nil, ErrPeriod
+	}
+	return &postgres.ReportFilter{
+		From:     fromDay,
+		To:       toDay.AddDate(0, 0, 1),
+		Currency: currency,
+		Period:   period,
+	}, nil
+}
+
+// FileName returns name of the exported report, e.g. turnover-2024-05-01-2024-05-31.csv
+func FileName(name string, filter *postgres.ReportFilter, format string) string {
+	return fmt.Sprintf("%v-%v-%v.%v", name, filter.From.Format(time.DateOnly),
+		filter.To.AddDate(0, 0, -1).Format(time.DateOnly), format)
+}
+
+// Writer writes rows of the exported report
+type Writer interface {
+	Write(record []string) error
+	// Close finishes the export, the underlying writer isn't closed
+	Close() error
+}
+
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w csvWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+// NewWriter returns CSV or XLSX writer, the sheet of XLSX is named after the report
+func NewWriter(w io.Writer, format, name string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return csvWriter{csv.NewWriter(w)}, nil
+	case FormatXLSX:
+		return xlsx.NewWriter(w, name)
+	}
+	return nil, ErrFormat
+}
+
+// ContentType returns the content type of the format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return xlsx.ContentType
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Write writes the header and rows of the report, paged reports are selected and written page by page
+func Write(ctx context.Context, repo postgres.ReportRepository, name string,
+	filter *postgres.ReportFilter, w Writer) error {
+	switch name {
+	case Turnover:
+		return writeTurnover(ctx, repo, filter, w)
+	case LandlordPayouts:
+		return writeLandlordPayouts(ctx, repo, filter, w)
+	case Refunds:
+		return writeRefunds(ctx, repo, filter, w)
+	case Commissions:
+		return writeCommissions(ctx, repo, filter, w)
+	case Escrow:
+		return writeEscrow(ctx, repo, filter, w)
+	}
+	return ErrUnknownReport
+}
+
+func writeTurnover(ctx context.Context, repo postgres.ReportRepository,
+	filter *postgres.ReportFilter, w Writer) error {
+	rows, err := repo.GetTurnover(ctx, filter)
+	if err != nil {
+		return err
+	}
+	err = w.Write([]string{"period", "currency", "payments", "payments_amount", "refunds", "refunds_amount",
+		"net_amount"})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = w.Write([]string{
+			row.Period.Format(time.DateOnly),
+			row.Currency,
+			strconv.Itoa(row.Payments),
+			row.PaymentsAmount,
+			strconv.Itoa(row.Refunds),
+			row.RefundsAmount,
+			row.Net,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCommissions(ctx context.Context, repo postgres.ReportRepository,
+	filter *postgres.ReportFilter, w Writer) error {
+	rows, err := repo.GetCommissions(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if err = w.Write([]string{"period", "currency", "payments", "commission"}); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = w.Write([]string{
+			row.Period.Format(time.DateOnly),
+			row.Currency,
+			strconv.Itoa(row.Payments),
+			row.Amount,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLandlordPayouts(ctx context.Context, repo postgres.ReportRepository,
+	filter *postgres.ReportFilter, w Writer) error {
+	if err := w.Write([]string{"landlord_id", "currency", "payouts", "amount"}); err != nil {
+		return err
+	}
+	var cursor postgres.ReportCursor
+	for {
+		rows, err := repo.GetLandlordPayouts(ctx, filter, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = w.Write([]string{row.LandlordID, row.Currency, strconv.Itoa(row.Payouts), row.Amount}); err != nil {
+				return err
+			}
+			cursor = postgres.ReportCursor{Key: row.LandlordID, Currency: row.Currency}
+		}
+		if len(rows) < pageSize {
+			return nil
+		}
+	}
+}
+
+func writeRefunds(ctx context.Context, repo postgres.ReportRepository,
+	filter *postgres.ReportFilter, w Writer) error {
+	if err := w.Write([]string{"time", "yookassa_id", "amount", "currency", "status"}); err != nil {
+		return err
+	}
+	var cursor postgres.ReportCursor
+	for {
+		rows, err := repo.GetRefunds(ctx, filter, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			err = w.Write([]string{
+				row.Time.UTC().Format(time.RFC3339),
+				row.TransactionID,
+				row.Amount,
+				row.Currency,
+				row.Status,
+			})
+			if err != nil {
+				return err
+			}
+			cursor = postgres.ReportCursor{ID: row.ID}
+		}
+		if len(rows) < pageSize {
+			return nil
+		}
+	}
+}
+
+func writeEscrow(ctx context.Context, repo postgres.ReportRepository,
+	filter *postgres.ReportFilter, w Writer) error {
+	if err := w.Write([]string{"landlord_id", "currency", "balance"}); err != nil {
+		return err
+	}
+	var cursor postgres.ReportCursor
+	for {
+		rows, err := repo.GetEscrow(ctx, filter, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = w.Write([]string{row.LandlordID, row.Currency, row.Balance}); err != nil {
+				return err
+			}
+			cursor = postgres.ReportCursor{Key: row.LandlordID, Currency: row.Currency}
+		}
+		if len(rows) < pageSize {
+			return nil
+		}
+	}
+}
+
+// Names returns report names for usage messages
+func Names() string {
+	return strings.Join([]string{Turnover, LandlordPayouts, Refunds, Commissions, Escrow}, ", ")
+}
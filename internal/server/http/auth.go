@@ -0,0 +1,79 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+)
+
+// AuthConfig has bearer tokens of admin panel. Admin routes reject all requests if there are no tokens.
+type AuthConfig struct {
+	// AdminTokens are "operator:token" pairs, the operator is the actor of audit events
+	AdminTokens []string `yaml:"adminTokens" env:"AUTH_ADMIN_TOKENS" secret:"true"`
+}
+
+func (c *AuthConfig) Validate() error {
+	tokens := make(map[string]bool, len(c.AdminTokens))
+	for _, pair := range c.AdminTokens {
+		operator, token, ok := strings.Cut(pair, ":")
+		if !ok || operator == "" || token == "" {
+			return errors.New("adminTokens must be operator:token pairs")
+		}
+		if tokens[token] {
+			return errors.New("tokens must be unique")
+		}
+		tokens[token] = true
+	}
+	return nil
+}
+
+// authenticator finds principal by bearer token
+type authenticator struct {
+	tokens     [][]byte
+	principals []*endpoints.Principal
+}
+
+func newAuthenticator(cfg AuthConfig) *authenticator {
+	a := new(authenticator)
+	for _, pair := range cfg.AdminTokens {
+		operator, token, ok := strings.Cut(pair, ":")
+		if !ok || operator == "" || token == "" {
+			continue
+		}
+		a.tokens = append(a.tokens, []byte(token))
+		a.principals = append(a.principals, &endpoints.Principal{ID: operator, Role: endpoints.RoleAdmin})
+	}
+	return a
+}
+
+// principal compares the token with all known ones in constant time
+func (a *authenticator) principal(r *http.Request) *endpoints.Principal {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil
+	}
+	var found *endpoints.Principal
+	for i, known := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), known) == 1 {
+			found = a.principals[i]
+		}
+	}
+	return found
+}
+
+// Authenticate lets requests of admin panel through and puts the principal into the context
+func (a *authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := a.principal(r)
+		if principal == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			myJson.Write(w, http.StatusUnauthorized, endpoints.NewErrorResponse("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(endpoints.WithPrincipal(r.Context(), principal)))
+	})
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestLogger puts request-scoped logger (request_id, route, remote_ip) into the context
+// and writes structured access log line when the request is finished.
+// Handlers get the logger with logger.FromContext and add user_id, transaction_id, etc.
+func RequestLogger(log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			reqLog := log.With(
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.String("route", matchRoute(r)),
+				slog.String("remote_ip", remoteIP(r)),
+			)
+			ctx := logger.NewContext(r.Context(), reqLog)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			// Logger is taken again: handler could add attributes
+			logger.FromContext(ctx, reqLog).LogAttrs(ctx, level, "request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		})
+	}
+}
+
+// matchRoute returns chi pattern of the request. Middlewares run before routing,
+// so the pattern is resolved with the router in advance.
+func matchRoute(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return "unknown"
+	}
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, r.URL.Path) {
+		return "unknown"
+	}
+	return tctx.RoutePattern()
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	myJson "github.com/imperatorofdwelling/Website-backend/pkg/json"
+)
+
+type RateLimitConfig struct {
+	// RequestsPerSecond allowed for one client IP, 0 (default) disables limiting.
+	// The main site is the only client, so all its requests share one bucket.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond" env:"RATE_LIMIT_RPS" default:"0"`
+	Burst             int     `yaml:"burst" env:"RATE_LIMIT_BURST" default:"40"`
+}
+
+func (c *RateLimitConfig) Validate() error {
+	if c.RequestsPerSecond < 0 {
+		return errors.New("requestsPerSecond can't be negative")
+	}
+	if c.RequestsPerSecond > 0 && c.Burst < 1 {
+		return errors.New("burst must be at least 1")
+	}
+	return nil
+}
+
+const (
+	// Buckets of clients which were silent longer are forgotten
+	bucketTTL = time.Minute * 10
+)
+
+// unlimitedPaths are probes and YooKassa notifications, rejecting them fails the pod or loses the notification
+var unlimitedPaths = map[string]bool{
+	"/healthz":          true,
+	"/readyz":           true,
+	"/webhook/yookassa": true,
+}
+
+// bucket is a token bucket of one client
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func (l *limiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > bucketTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > bucketTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimit limits requests per client IP with token bucket, unlimitedPaths aren't limited
+func RateLimit(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.RequestsPerSecond <= 0 {
+			return next
+		}
+		l := &limiter{
+			rate:      cfg.RequestsPerSecond,
+			burst:     float64(cfg.Burst),
+			buckets:   make(map[string]*bucket),
+			lastSweep: time.Now(),
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if unlimitedPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			if !l.allow(ip, time.Now()) {
+				w.Header().Set("Retry-After", "1")
+				myJson.Write(w, http.StatusTooManyRequests, endpoints.NewErrorResponse("too many requests"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
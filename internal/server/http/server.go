@@ -1,92 +1,299 @@
 package http
 
 import (
-	"log"
+	"context"
+	"errors"
 	"time"
 
 	"log/slog"
 	"net/http"
 
 	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/redis"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ServerConfig struct {
-	Addr         string        `yaml:"addr"`
-	ReadTimeout  time.Duration `yaml:"readTimeout"`
-	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	Addr         string        `yaml:"addr" env:"SERVER_ADDR" default:"0.0.0.0:8080"`
+	ReadTimeout  time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT" default:"10s"`
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT" default:"10s"`
 	/*
 		Idle timeout is a period of time during which
 		the server or connection waits for any action from the client.
 	*/
-	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	IdleTimeout time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT" default:"5s"`
+	// ShutdownTimeout is how long in-flight requests are drained on shutdown
+	ShutdownTimeout time.Duration   `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT" default:"15s"`
+	RateLimit       RateLimitConfig `yaml:"rateLimit"`
+	Auth            AuthConfig      `yaml:"auth"`
 }
 
-func LoadConfig() (*ServerConfig, error) {
-	//TODO load vars from .env
-	return &ServerConfig{
-		Addr:         "0.0.0.0:8080",
-		ReadTimeout:  time.Second * 10,
-		WriteTimeout: time.Second * 10,
-		IdleTimeout:  time.Second * 5,
-	}, nil
+func (c *ServerConfig) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	return nil
 }
 
 type Server struct {
 	srv *http.Server
 }
 
-func New(cfg *ServerConfig, log *slog.Logger, repo postgres.LogRepository) *Server {
+// Dependencies are repositories and services of the handlers. They are built by the caller,
+// so the router can be run against in-memory fakes (pkg/repository/memory, yookassatest.Fake).
+type Dependencies struct {
+	Logs          postgres.LogRepository
+	Audit         postgres.AuditRepository
+	Receipts      postgres.ReceiptRepository
+	Methods       postgres.PaymentMethodRepository
+	Subscriptions postgres.SubscriptionRepository
+	Ledger        postgres.LedgerRepository
+	Commissions   postgres.CommissionRepository
+	Batches       postgres.PayoutBatchRepository
+	Destinations  postgres.PayoutDestinationRepository
+	Reports       postgres.ReportRepository
+	Users         postgres.UserRepository
+	Cards         postgres.CardStore
+	// Database and Statuses are checked by readiness probe
+	Database endpoints.Database
+	Statuses redis.StatusStore
+	Gateway  yookassa.Gateway
+	Checker  *webhook.Checker
+	// Background is drained on shutdown
+	Background *endpoints.Background
+}
+
+func New(cfg *ServerConfig, log *slog.Logger, deps *Dependencies) *Server {
+	router := NewRouter(log, cfg.Auth, deps)
 	srv := &http.Server{
 		Addr:         cfg.Addr,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
-		Handler:      NewRouter(log, repo),
+		Handler:      RateLimit(cfg.RateLimit)(router),
 	}
 	return &Server{
 		srv: srv,
 	}
 }
 
-// NewRouter Creating chi router
-func NewRouter(log *slog.Logger, repo postgres.LogRepository) http.Handler {
+// NewRouter Creating chi router. Admin routes are authenticated by bearer tokens of auth config,
+// the other ones are public.
+func NewRouter(log *slog.Logger, auth AuthConfig, deps *Dependencies) http.Handler {
 	r := chi.NewRouter()
 	// There we need to write endpoints and middlewares
 
 	// Middlewares
 	r.Use(middleware.RequestID)
-	r.Use(middleware.DefaultLogger)
+	r.Use(Tracing)
+	r.Use(RequestLogger(log))
 	r.Use(middleware.Recoverer)
+	r.Use(Metrics)
 
 	// We need db instance to work with it
-	payment := endpoints.NewPaymentHandler(log, repo)
-	saveCard := endpoints.NewSaveCardHandler(log, repo)
-	payload := endpoints.NewPayloadHandler(log, repo)
+	splitter := endpoints.NewSplitter(log, deps.Ledger, deps.Commissions)
+	payment := endpoints.NewPaymentHandler(log, deps.Logs, deps.Receipts, deps.Methods, deps.Audit, deps.Users,
+		splitter, deps.Gateway, deps.Checker)
+	refund := endpoints.NewRefundHandler(log, deps.Logs, deps.Receipts, deps.Audit, splitter, deps.Gateway,
+		deps.Checker)
+	receipt := endpoints.NewReceiptHandler(log, deps.Receipts, deps.Gateway)
+	saveCard := endpoints.NewSaveCardHandler(log, deps.Logs, deps.Audit, deps.Users, deps.Cards)
+	payload := endpoints.NewPayloadHandler(log, deps.Logs, deps.Audit, deps.Users, splitter, deps.Destinations,
+		deps.Cards, deps.Gateway, deps.Checker, deps.Background)
+	payoutBatch := endpoints.NewPayoutBatchHandler(log, deps.Logs, deps.Batches, deps.Audit, deps.Users,
+		deps.Destinations, deps.Cards, deps.Gateway, deps.Checker, deps.Background)
+	users := endpoints.NewUsersHandler(log, deps.Users, deps.Audit)
+	payoutDestinations := endpoints.NewPayoutDestinationsHandler(log, deps.Users, deps.Destinations, deps.Audit)
+	auditEvents := endpoints.NewAuditHandler(log, deps.Audit)
+	health := endpoints.NewHealthHandler(log, deps.Database, deps.Statuses, deps.Gateway)
+	logLevel := endpoints.NewLogLevelHandler(log, deps.Audit)
+	notification := endpoints.NewNotificationHandler(log, deps.Logs, deps.Methods, deps.Audit, splitter,
+		deps.Checker)
+	paymentMethods := endpoints.NewPaymentMethodsHandler(log, deps.Methods, deps.Audit)
+	subscription := endpoints.NewSubscriptionHandler(log, deps.Subscriptions, deps.Methods, deps.Users, deps.Audit)
+	ledgerBalances := endpoints.NewLedgerHandler(log, deps.Ledger, deps.Commissions, deps.Audit)
+	financialReports := endpoints.NewReportHandler(log, deps.Reports)
+	r.Handle(
+		"/metrics",
+		metrics.Handler())
+	r.Get(
+		"/healthz",
+		health.Healthz)
+	r.Get(
+		"/readyz",
+		health.Readyz)
+	r.Post(
+		"/webhook/yookassa",
+		notification.Notify)
 	r.Post(
 		"/payment/create",
 		payment.Payment)
+	r.Get(
+		"/payment_methods",
+		paymentMethods.GetMethods)
+	r.Delete(
+		"/payment_methods/{id}",
+		paymentMethods.Revoke)
+	r.Post(
+		"/subscriptions",
+		subscription.Create)
+	r.Get(
+		"/subscriptions",
+		subscription.GetSubscriptions)
+	r.Get(
+		"/subscriptions/{id}/cycles",
+		subscription.GetCycles)
+	r.Post(
+		"/subscriptions/{id}/cancel",
+		subscription.Cancel)
+	r.Get(
+		"/landlords/{id}/balance",
+		ledgerBalances.GetLandlordBalance)
+	r.Post(
+		"/refund/create",
+		refund.Refund)
+	r.Post(
+		"/receipt/create",
+		receipt.Create)
+	r.Get(
+		"/receipts",
+		receipt.GetReceipts)
+	r.Post(
+		"/users",
+		users.Register)
+	r.Get(
+		"/users",
+		users.FindUser)
+	r.Get(
+		"/users/{id}",
+		users.GetUser)
 	r.Post(
 		"/save_card",
 		saveCard.SaveCard)
 	r.Post(
 		"/payload/create",
 		payload.Payload)
+	r.Get(
+		"/payout_destinations",
+		payoutDestinations.GetDestinations)
+	r.Put(
+		"/payout_destinations",
+		payoutDestinations.Save)
+
+	authenticator := newAuthenticator(auth)
+	r.Group(func(r chi.Router) {
+		r.Use(authenticator.Authenticate)
+
+		r.Post(
+			"/users/{id}/block",
+			users.Block)
+		r.Post(
+			"/users/{id}/unblock",
+			users.Unblock)
+		r.Post(
+			"/payouts/batch",
+			payoutBatch.Create)
+		r.Get(
+			"/payouts/batch/{id}",
+			payoutBatch.GetBatch)
+		r.Post(
+			"/payouts/batch/{id}/retry",
+			payoutBatch.Retry)
+		r.Get(
+			"/admin/audit_events",
+			auditEvents.GetEvents)
+		r.Get(
+			"/admin/platform_balance",
+			ledgerBalances.GetPlatformBalance)
+		r.Get(
+			"/admin/commission_rules",
+			ledgerBalances.GetCommissionRules)
+		r.Put(
+			"/admin/commission_rules",
+			ledgerBalances.SaveCommissionRule)
+		r.Get(
+			"/admin/reports/{name}",
+			financialReports.Export)
+		r.Get(
+			"/admin/log_level",
+			logLevel.GetLevel)
+		r.Put(
+			"/admin/log_level",
+			logLevel.SetLevel)
+	})
 
 	return r
 }
 
-func (s *Server) Run() {
-	// Logger print need
-	log.Println("Server started...")
-	if err := s.srv.ListenAndServe(); err != nil {
-		log.Fatal(err)
+// Run blocks until the server is stopped. It returns nil after Shutdown.
+func (s *Server) Run() error {
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests.
+// If the context expires first, remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		return errors.Join(err, s.srv.Close())
 	}
+	return nil
+}
+
+// Tracing starts server span for every request, trace context of the caller (traceparent header)
+// is continued. The span is renamed by route pattern once the request is routed.
+func Tracing(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("request_id", middleware.GetReqID(r.Context())))
+		next.ServeHTTP(w, r)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+	})
+	return otelhttp.NewHandler(named, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// Metrics measures requests by route pattern, so IDs in paths don't blow up label cardinality
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unknown"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveHTTPRequest(route, r.Method, status, time.Since(start))
+	})
 }
 
-func (s *Server) Disconnect() error {
-	return s.srv.Close()
+func (s *Server) Addr() string {
+	return s.srv.Addr
 }
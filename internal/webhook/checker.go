@@ -5,13 +5,20 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/redis"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // _______________
@@ -21,66 +28,384 @@ import (
 // as webhook
 
 type WebhookData struct {
-	YooKassaTransactionID string                       `json:"yooKassaTransactionID"`
-	YouKassaConfirmation  metrics.YouKassaConfirmation `json:"youKassaConfirmation"`
-	ServerUUID            uuid.UUID                    `json:"serverUUID"`
+	// Resource is YooKassa API resource of the transaction: payments or payouts
+	Resource              string    `json:"resource"`
+	YooKassaTransactionID string    `json:"yooKassaTransactionID"`
+	Amount                string    `json:"amount"`
+	ServerUUID            uuid.UUID `json:"serverUUID"`
+	// StartedAt is used to continue polling schedule after restart
+	StartedAt time.Time `json:"startedAt"`
+	// TraceParent (W3C) of the request which created the transaction, polling spans are linked to it
+	TraceParent string `json:"traceParent"`
+	// RequestID of the request which created the transaction, it's in every log line of the check
+	RequestID string `json:"requestID"`
+
+	log *slog.Logger
 }
 
-func NewWebhookData(yooKassaID string, serverUUID uuid.UUID) *WebhookData {
+func NewWebhookData(resource string, yooKassaID string, serverUUID uuid.UUID, amount string) *WebhookData {
 	return &WebhookData{
+		Resource:              resource,
 		YooKassaTransactionID: yooKassaID,
+		Amount:                amount,
 		ServerUUID:            serverUUID,
+		StartedAt:             time.Now(),
 	}
 }
 
+// runningCheck is a running updater, status received by notification is sent to it
+type runningCheck struct {
+	whData   *WebhookData
+	resolved chan metrics.Status
+}
+
 var (
 	NotNeedToCheck     = errors.New("not need to check")
-	CannotStartToCheck = errors.New("can't start checking, redis is empty")
 	EmptyResponse      = errors.New("empty response")
+	TooManyChecks      = errors.New("can't start checking, too many active checks")
+	CheckerStopped     = errors.New("can't start checking, checker is stopped")
+	UnknownTransaction = errors.New("unknown transaction")
+	UnknownObject      = errors.New("object doesn't exist in YooKassa")
 )
 
+// FinalHook gets the verified object of the transaction with final status. Status received
+// by notification isn't passed to it, the notification handler processes the object itself.
+type FinalHook func(ctx context.Context, resource, yooKassaID string, resp *CheckResponse)
+
+// Checker polls statuses of transactions in YooKassa until they are final.
+// Statuses and pending checks are kept in the status store.
+type Checker struct {
+	log     *slog.Logger
+	store   redis.StatusStore
+	gateway yookassa.Gateway
+	// finalHook is called when polled transaction reaches final status
+	finalHook FinalHook
+
+	// activeChecks is the number of running updaters
+	activeChecks atomic.Int64
+	// running are running updaters by server transaction ID
+	running   map[uuid.UUID]*runningCheck
+	runningMu sync.Mutex
+
+	// All updaters are children of ctx, it's canceled on shutdown
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func NewChecker(log *slog.Logger, store redis.StatusStore, gateway yookassa.Gateway) *Checker {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Checker{
+		log:     log,
+		store:   store,
+		gateway: gateway,
+		running: make(map[uuid.UUID]*runningCheck),
+		ctx:     ctx,
+		stop:    stop,
+	}
+}
+
+// SetFinalHook sets hook of final statuses, it must be called before checks are started
+func (c *Checker) SetFinalHook(hook FinalHook) {
+	c.finalHook = hook
+}
+
 // StartCheck starts periodically checking the status of transaction
-func StartCheck(whData *WebhookData, startStatus metrics.Status) error {
+func (c *Checker) StartCheck(ctx context.Context, whData *WebhookData, startStatus metrics.Status) error {
 	if startStatus.IsAlreadyProcessedStatus() {
+		metrics.ObserveTransaction(whData.resource(), startStatus, whData.Amount)
 		return NotNeedToCheck
 	}
-	currDB, contains := redis.GetCurrRedisDB()
-	if !contains {
-		return CannotStartToCheck
+	if c.ctx.Err() != nil {
+		return CheckerStopped
+	}
+	// The slot is taken before the check is started, so concurrent starts don't exceed the limit
+	maxActive := int64(metrics.GetCheckMaxActive())
+	if active := c.activeChecks.Add(1); maxActive > 0 && active > maxActive {
+		c.activeChecks.Add(-1)
+		return TooManyChecks
 	}
-	err := currDB.CommitTransaction(whData.ServerUUID, startStatus)
+	whData.TraceParent = tracing.Inject(ctx)
+	whData.RequestID = middleware.GetReqID(ctx)
+
+	_, span := tracing.Start(ctx, "redis.CommitTransaction", whData.attributes()...)
+	err := c.store.CommitTransaction(whData.ServerUUID, startStatus)
+	if err == nil {
+		err = c.savePendingCheck(whData)
+	}
+	tracing.End(span, err)
 	if err != nil {
+		c.activeChecks.Add(-1)
+		whData.logger(c.log).Error("failed to start status check", slog.String("error", err.Error()))
+		return err
+	}
+	c.runUpdater(whData)
+	whData.logger(c.log).Info("status check started", slog.String("status", string(startStatus)))
+
+	return nil
+}
+
+// ResumePendingChecks restarts checks which were interrupted by shutdown or crash.
+// It returns the number of resumed checks.
+func (c *Checker) ResumePendingChecks() (int, error) {
+	pending, err := c.store.GetPendingChecks()
+	if err != nil {
+		return 0, err
+	}
+	resumed := 0
+	for id, raw := range pending {
+		whData := new(WebhookData)
+		if err = json.Unmarshal([]byte(raw), whData); err != nil {
+			// Broken record can't be resumed, don't keep it forever
+			c.log.Error("failed to resume status check",
+				slog.String("transaction_id", id),
+				slog.String("error", err.Error()),
+			)
+			if serverUUID, parseErr := uuid.Parse(id); parseErr == nil {
+				_ = c.store.DelPendingCheck(serverUUID)
+			}
+			continue
+		}
+		// Resumed checks aren't limited, they were started before the shutdown
+		c.activeChecks.Add(1)
+		c.runUpdater(whData)
+		whData.logger(c.log).Info("status check resumed", slog.Duration("elapsed", time.Since(whData.StartedAt)))
+		resumed++
+	}
+	return resumed, nil
+}
+
+// Shutdown stops all updaters and waits for them. Pending checks stay in the store
+// and are resumed by ResumePendingChecks on the next start.
+func (c *Checker) Shutdown(ctx context.Context) error {
+	c.stop()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ActiveChecks returns the number of running updaters
+func (c *Checker) ActiveChecks() int64 {
+	return c.activeChecks.Load()
+}
+
+// CheckStats returns the number of running updaters and the age of the oldest one
+func (c *Checker) CheckStats() (int, time.Duration) {
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+
+	var oldest time.Duration
+	for _, rc := range c.running {
+		if age := time.Since(rc.whData.StartedAt); age > oldest {
+			oldest = age
+		}
+	}
+	return len(c.running), oldest
+}
+
+// Resolve applies status received by notification (webhook). It's saved to the store and
+// the running check of the transaction, if any, is finished without waiting for the next poll.
+func (c *Checker) Resolve(serverUUID uuid.UUID, status metrics.Status) error {
+	if err := c.store.UpdateStatus(serverUUID, status); err != nil {
+		if errors.Is(err, redis.TransactionNotFoundError) {
+			return UnknownTransaction
+		}
 		return err
 	}
-	go updater(whData)
+	if !status.IsAlreadyProcessedStatus() {
+		return nil
+	}
 
+	c.runningMu.Lock()
+	rc, ok := c.running[serverUUID]
+	c.runningMu.Unlock()
+	if !ok {
+		// Check isn't running here (e.g. it's run by another replica), it mustn't be resumed
+		return c.store.DelPendingCheck(serverUUID)
+	}
+	select {
+	case rc.resolved <- status:
+	default:
+		// Status is already sent
+	}
 	return nil
 }
 
-func updater(whData *WebhookData) {
-	ch := make(chan struct{}, 1)
-	ctx, cancel := context.WithCancel(context.Background())
-	go signaller(ch, ctx)
-	for range ch {
-		newStatus, _ := sendCheckRequstToYouKassa(whData)
-		err := updateRedis(whData, newStatus)
+// FindTransaction returns server transaction ID of the checked YooKassa object,
+// it's used when notification has no metadata
+func (c *Checker) FindTransaction(yooKassaID string) (uuid.UUID, bool) {
+	c.runningMu.Lock()
+	for serverUUID, rc := range c.running {
+		if rc.whData.YooKassaTransactionID == yooKassaID {
+			c.runningMu.Unlock()
+			return serverUUID, true
+		}
+	}
+	c.runningMu.Unlock()
+
+	pending, err := c.store.GetPendingChecks()
+	if err != nil {
+		return uuid.Nil, false
+	}
+	for _, raw := range pending {
+		whData := new(WebhookData)
+		if err = json.Unmarshal([]byte(raw), whData); err == nil && whData.YooKassaTransactionID == yooKassaID {
+			return whData.ServerUUID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+// Status returns the last status of the checked transaction saved by the updater or by notification.
+// Final status is returned once, the transaction is forgotten then.
+func (c *Checker) Status(serverUUID uuid.UUID) (metrics.Status, error) {
+	status, err := c.store.GetTransactionStatus(serverUUID, "")
+	if errors.Is(err, redis.TransactionNotFoundError) {
+		return "", UnknownTransaction
+	}
+	return status, err
+}
+
+func (whData *WebhookData) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("transaction_id", whData.ServerUUID.String()),
+		attribute.String("yookassa_id", whData.YooKassaTransactionID),
+		attribute.String("resource", whData.resource()),
+	}
+}
+
+// logger returns logger with IDs of the transaction
+func (whData *WebhookData) logger(base *slog.Logger) *slog.Logger {
+	if whData.log == nil {
+		whData.log = base.With(
+			slog.String("fn", "webhook.updater"),
+			slog.String("request_id", whData.RequestID),
+			slog.String("transaction_id", whData.ServerUUID.String()),
+			slog.String("yookassa_id", whData.YooKassaTransactionID),
+			slog.String("resource", whData.resource()),
+		)
+	}
+	return whData.log
+}
+
+// resource returns the resource, checks saved before resource was stored are payouts
+func (whData *WebhookData) resource() string {
+	if whData.Resource == "" {
+		return metrics.PayoutsEndpoint
+	}
+	return whData.Resource
+}
+
+func (c *Checker) savePendingCheck(whData *WebhookData) error {
+	data, err := json.Marshal(whData)
+	if err != nil {
+		return err
+	}
+	return c.store.SavePendingCheck(whData.ServerUUID, data)
+}
+
+// runUpdater starts the updater, the slot of active checks is taken by the caller
+func (c *Checker) runUpdater(whData *WebhookData) {
+	// Logger is built before the updater goroutine starts, it's not synchronized
+	whData.logger(c.log)
+	c.wg.Add(1)
+	rc := &runningCheck{
+		whData:   whData,
+		resolved: make(chan metrics.Status, 1),
+	}
+	c.runningMu.Lock()
+	c.running[whData.ServerUUID] = rc
+	c.runningMu.Unlock()
+	go c.updater(c.ctx, rc)
+}
+
+func (c *Checker) updater(parent context.Context, rc *runningCheck) {
+	whData := rc.whData
+	defer c.wg.Done()
+	defer c.activeChecks.Add(-1)
+	defer func() {
+		c.runningMu.Lock()
+		delete(c.running, whData.ServerUUID)
+		c.runningMu.Unlock()
+	}()
+
+	ch := make(chan struct{})
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	go signaller(ch, ctx, time.Since(whData.StartedAt))
+	log := whData.logger(c.log)
+	for {
+		var newStatus *CheckResponse
+		var err error
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if parent.Err() == nil {
+					// Polling schedule is exhausted, the status won't be checked anymore
+					log.Warn("status check expired", slog.Duration("elapsed", time.Since(whData.StartedAt)))
+					c.dropPendingCheck(whData)
+					metrics.ObserveCheckFinished(whData.resource(), "expired", time.Since(whData.StartedAt))
+				}
+				return
+			}
+			newStatus, err = c.check(ctx, whData)
+			if err != nil {
+				log.Warn("failed to check status", slog.String("error", err.Error()))
+			} else {
+				log.Debug("status checked", slog.String("status", string(newStatus.Status)))
+			}
+		case status := <-rc.resolved:
+			// Redis is already updated by Resolve
+			newStatus = &CheckResponse{Status: status}
+			log.Debug("status received by notification", slog.String("status", string(status)))
+		}
 		if isFinalUpdate(newStatus, err) {
-			break
+			log.Info("transaction reached final status", slog.String("status", string(newStatus.Status)))
+			c.dropPendingCheck(whData)
+			metrics.ObserveTransaction(whData.resource(), newStatus.Status, whData.Amount)
+			metrics.ObserveCheckFinished(whData.resource(), string(newStatus.Status), time.Since(whData.StartedAt))
+			if c.finalHook != nil && newStatus.Amount != nil {
+				c.finalHook(ctx, whData.resource(), whData.YooKassaTransactionID, newStatus)
+			}
+			return
 		}
 	}
-	cancel()
 }
 
-func updateRedis(whData *WebhookData, r *CheckResponse) error {
-	currRedis, contains := redis.GetCurrRedisDB()
-	if !contains {
-		return CannotStartToCheck
+// check polls the status once, its span is linked to the request which created the transaction
+func (c *Checker) check(ctx context.Context, whData *WebhookData) (*CheckResponse, error) {
+	ctx, span := tracing.StartLinked(ctx, "webhook.check", whData.TraceParent, whData.attributes()...)
+	newStatus, err := c.sendCheckRequstToYouKassa(ctx, whData)
+	if err == nil && newStatus == nil {
+		err = EmptyResponse
+	}
+	if err == nil {
+		span.SetAttributes(attribute.String("status", string(newStatus.Status)))
+		err = c.updateStore(whData, newStatus)
 	}
+	tracing.End(span, err)
+	return newStatus, err
+}
+
+func (c *Checker) dropPendingCheck(whData *WebhookData) {
+	_ = c.store.DelPendingCheck(whData.ServerUUID)
+}
+
+func (c *Checker) updateStore(whData *WebhookData, r *CheckResponse) error {
 	if r == nil {
 		return EmptyResponse
 	}
 
-	return currRedis.CommitTransaction(whData.ServerUUID, r.Status)
+	return c.store.UpdateStatus(whData.ServerUUID, r.Status)
 }
 
 func isFinalUpdate(r *CheckResponse, err error) bool {
@@ -90,33 +415,56 @@ func isFinalUpdate(r *CheckResponse, err error) bool {
 	return r.Status.IsAlreadyProcessedStatus()
 }
 
-// Code for signaling that a request should be made
+// Code for signaling that a request should be made.
+// elapsed is time passed since the check was started: steps of the schedule
+// which are already in the past are replaced by one immediate signal.
 
-func signaller(ch chan<- struct{}, ctx context.Context) {
-	select {
-	case <-ctx.Done():
-		return
-	default:
-		fibArr := getFibArr()
+func signaller(ch chan<- struct{}, ctx context.Context, elapsed time.Duration) {
+	defer close(ch)
+
+	fibArr := getFibArr()
 
-		for _, timing := range fibArr {
-			sleepTiming := time.Duration(timing) * time.Minute
-			sleep(sleepTiming, ctx)
-			ch <- struct{}{}
+	var scheduled time.Duration
+	skipped := false
+	for _, timing := range fibArr {
+		scheduled += time.Duration(timing) * time.Minute
+		wait := scheduled - elapsed
+		if wait <= 0 {
+			skipped = true
+			continue
+		}
+		if skipped {
+			// Resumed after missed checks: check right away
+			wait, skipped = 0, false
+		}
+		if !sleep(wait, ctx) {
+			return
+		}
+		select {
+		case ch <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if skipped {
+		// The whole schedule was missed, check the last time
+		select {
+		case ch <- struct{}{}:
+		case <-ctx.Done():
 		}
-		close(ch)
 	}
-
 }
 
-func sleep(d time.Duration, ctx context.Context) {
+// sleep returns false if context is done before timer fires
+func sleep(d time.Duration, ctx context.Context) bool {
 	timer := time.NewTimer(d)
+	defer timer.Stop()
 
 	select {
 	case <-ctx.Done():
-		return
+		return false
 	case <-timer.C:
-		return
+		return true
 	}
 }
 
@@ -124,7 +472,7 @@ func getFibArr() []int {
 	var fibArr = []int{1, 1}
 
 	var fibSum int
-	maxFibSum := metrics.CheckMaxMinutes
+	maxFibSum := metrics.GetCheckMaxMinutes()
 
 	index := 2
 
@@ -143,28 +491,47 @@ func getFibArr() []int {
 // _____________________
 
 type CheckResponse struct {
-	Status metrics.Status `json:"status"`
+	Status   metrics.Status    `json:"status"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Amount   *Amount           `json:"amount,omitempty"`
+	// PaymentMethod is returned only for payments
+	PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+	// PaymentID is the refunded payment, it's returned only for refunds
+	PaymentID string `json:"payment_id,omitempty"`
 }
 
-func sendCheckRequstToYouKassa(whData *WebhookData) (*CheckResponse, error) {
-	url := metrics.PaymentsApi + metrics.PayoutsEndpoint + "/" + whData.YooKassaTransactionID
-	apiReq, err := http.NewRequest(
-		"GET",
-		url,
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// PaymentMethod is payment method of YooKassa payment, it can be charged again if it's saved
+type PaymentMethod struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Saved bool   `json:"saved"`
+	Title string `json:"title,omitempty"`
+}
 
-	apiReq.SetBasicAuth(metrics.GetConfirmationData())
-	apiReq.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(apiReq)
+// FetchStatus requests the current status of YooKassa object, resource is payments, payouts or refunds
+func (c *Checker) FetchStatus(ctx context.Context, resource, yooKassaID string) (*CheckResponse, error) {
+	status, err := c.sendCheckRequstToYouKassa(ctx, &WebhookData{Resource: resource, YooKassaTransactionID: yooKassaID})
+	if err == nil && (status == nil || status.Status == "") {
+		// Error responses of YooKassa have no status
+		err = EmptyResponse
+	}
+	return status, err
+}
 
+func (c *Checker) sendCheckRequstToYouKassa(ctx context.Context, whData *WebhookData) (*CheckResponse, error) {
+	resp, err := c.gateway.Do(ctx, http.MethodGet, whData.resource()+"/"+whData.YooKassaTransactionID, nil, "")
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, UnknownObject
+	}
 
 	// Read Response
 	respBody, err := io.ReadAll(resp.Body)
@@ -0,0 +1,64 @@
+package yookassa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+)
+
+// Gateway sends requests to YooKassa API, resource is relative to API root
+// (e.g. "refunds" or "receipts?payment_id=..."). POST requests are sent with the idempotence key,
+// YooKassa returns the result of the first request for repeated ones.
+type Gateway interface {
+	Do(ctx context.Context, method, resource string, body any, idempotenceKey string) (*http.Response, error)
+}
+
+// Client is Gateway of YooKassa API authenticated by store credentials
+type Client struct {
+	baseURL   string
+	storeID   string
+	secretKey string
+	client    *http.Client
+}
+
+func NewClient(cfg *metrics.YooKassaConfig) *Client {
+	baseURL := metrics.PaymentsApi
+	if cfg.BaseURL != "" {
+		baseURL = strings.TrimSuffix(cfg.BaseURL, "/") + "/"
+	}
+	return &Client{
+		baseURL:   baseURL,
+		storeID:   cfg.StoreID,
+		secretKey: cfg.SecretKey,
+		client:    metrics.NewYooKassaClient(),
+	}
+}
+
+func (c *Client) Do(ctx context.Context, method, resource string, body any,
+	idempotenceKey string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewBuffer(data)
+	}
+	apiReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+resource, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	apiReq.SetBasicAuth(c.storeID, c.secretKey)
+	if method == http.MethodPost {
+		apiReq.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+
+	return c.client.Do(apiReq)
+}
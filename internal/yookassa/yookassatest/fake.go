@@ -0,0 +1,337 @@
+// Package yookassatest provides in-memory YooKassa gateway for tests
+package yookassatest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+
+	"github.com/google/uuid"
+)
+
+// FakeCurrency is the only currency accepted by the fake store
+const FakeCurrency = "RUB"
+
+// Descriptions of YooKassa errors returned by Fake
+const (
+	ErrDescriptionCurrency = "Incorrect currency of payment. The value of the amount.currency parameter doesn't " +
+		"correspond with the settings of your store. Specify another currency value in the request or contact " +
+		"the YooMoney manager to change the settings"
+	ErrDescriptionAmount   = "Error in the payment amount. Specify the amount in correct format. For example, 100.00"
+	ErrDescriptionNotFound = "Object doesn't exist or access denied"
+	ErrDescriptionFault    = "Fault injected by the test"
+)
+
+// amountValue is positive amount with up to 2 decimals, e.g. 100 or 100.50
+var amountValue = regexp.MustCompile(`^[0-9]{1,15}(\.[0-9]{1,2})?$`)
+
+// Fake is in-memory yookassa.Gateway. Created payments, payouts, refunds and receipts are kept and returned
+// by GET requests, lists are filtered by query parameters but aren't paged. Amounts and currency
+// are validated like YooKassa does, so error handling is tested without the sandbox store.
+type Fake struct {
+	mu sync.Mutex
+	// objects by resource and ID, order keeps IDs in creation order
+	objects map[string]map[string]map[string]any
+	order   map[string][]string
+	// responses of POST requests by idempotence key
+	responses map[string]*fakeResponse
+	// keys of all POST requests by resource, in order of requests
+	keys   map[string][]string
+	faults []*fault
+}
+
+// fault fails matching POST requests of the resource, times is decremented by every failed request
+type fault struct {
+	resource string
+	status   int
+	times    int
+	match    func(req map[string]any) bool
+}
+
+type fakeResponse struct {
+	status int
+	body   []byte
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		objects:   make(map[string]map[string]map[string]any),
+		order:     make(map[string][]string),
+		responses: make(map[string]*fakeResponse),
+		keys:      make(map[string][]string),
+	}
+}
+
+// Fail makes the next times POST requests of the resource fail with the status, match selects
+// the requests by their body (nil matches all). Like in YooKassa, 5xx responses aren't kept
+// by idempotence key, so the request resent with the same key creates the object.
+func (f *Fake) Fail(resource string, status, times int, match func(req map[string]any) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults = append(f.faults, &fault{resource: resource, status: status, times: times, match: match})
+}
+
+// IdempotenceKeys returns keys of all POST requests of the resource in order of requests,
+// resent requests are included
+func (f *Fake) IdempotenceKeys(resource string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.keys[resource]...)
+}
+
+func (f *Fake) Do(ctx context.Context, method, resource string, body any,
+	idempotenceKey string) (*http.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, rawQuery, _ := strings.Cut(resource, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case method == http.MethodPost:
+		f.keys[path] = append(f.keys[path], idempotenceKey)
+		if resp, ok := f.responses[idempotenceKey]; ok && idempotenceKey != "" {
+			return newResponse(resp), nil
+		}
+		req := make(map[string]any)
+		if err = convert(body, &req); err != nil {
+			return nil, err
+		}
+		resp, err := f.fail(path, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			resp, err = f.create(path, req)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if idempotenceKey != "" && resp.status < http.StatusInternalServerError {
+			f.responses[idempotenceKey] = resp
+		}
+		return newResponse(resp), nil
+	case method == http.MethodGet && path == metrics.MeEndpoint:
+		return respond(http.StatusOK, map[string]any{"account_id": "fake", "test": true, "status": "enabled"})
+	case method == http.MethodGet && strings.Contains(path, "/"):
+		kind, id, _ := strings.Cut(path, "/")
+		object, ok := f.objects[kind][id]
+		if !ok {
+			return respond(http.StatusNotFound, apiError("not_found", ErrDescriptionNotFound, ""))
+		}
+		return respond(http.StatusOK, object)
+	case method == http.MethodGet:
+		return respond(http.StatusOK, map[string]any{"type": "list", "items": f.list(path, query)})
+	}
+	return respond(http.StatusNotFound, apiError("not_found", ErrDescriptionNotFound, ""))
+}
+
+// SetStatus changes status of the created object, e.g. payment is confirmed by the user.
+// It returns false if there is no such object.
+func (f *Fake) SetStatus(resource, id string, status metrics.Status) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.objects[resource][id]
+	if ok {
+		object["status"] = status
+		if resource == metrics.PaymentsEndpoint {
+			object["paid"] = status == metrics.Succeeded || status == metrics.WaitingForCapture
+		}
+	}
+	return ok
+}
+
+// create validates the request and stores the new object, its fields are the ones of the request
+func (f *Fake) create(resource string, req map[string]any) (*fakeResponse, error) {
+	status := metrics.Pending
+	switch resource {
+	case metrics.PaymentsEndpoint, metrics.PayoutsEndpoint, metrics.RefundsEndpoint:
+		if apiErr := validateAmount(req["amount"]); apiErr != nil {
+			return newFakeResponse(http.StatusBadRequest, apiErr)
+		}
+		if resource == metrics.RefundsEndpoint {
+			status = metrics.Succeeded
+		}
+	case metrics.ReceiptsEndpoint:
+	default:
+		return newFakeResponse(http.StatusNotFound, apiError("not_found", ErrDescriptionNotFound, ""))
+	}
+
+	object := make(map[string]any, len(req)+4)
+	for k, v := range req {
+		object[k] = v
+	}
+	object["id"] = uuid.New().String()
+	object["status"] = status
+	object["created_at"] = time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	object["test"] = true
+	if resource == metrics.PaymentsEndpoint {
+		object["paid"] = false
+		object["refundable"] = false
+		object["recipient"] = map[string]any{"account_id": "fake", "gateway_id": "fake"}
+		if confirmation, ok := req["confirmation"].(map[string]any); ok {
+			object["confirmation"] = fakeConfirmation(confirmation)
+		}
+	}
+	if _, ok := req["receipt"]; ok {
+		object["receipt_registration"] = metrics.Pending
+		delete(object, "receipt")
+	}
+
+	if f.objects[resource] == nil {
+		f.objects[resource] = make(map[string]map[string]any)
+	}
+	f.objects[resource][object["id"].(string)] = object
+	f.order[resource] = append(f.order[resource], object["id"].(string))
+	return newFakeResponse(http.StatusOK, object)
+}
+
+// fail returns the response of the first matching fault, it's nil if the request doesn't fail
+func (f *Fake) fail(resource string, req map[string]any) (*fakeResponse, error) {
+	for _, fault := range f.faults {
+		if fault.resource != resource || fault.times == 0 || (fault.match != nil && !fault.match(req)) {
+			continue
+		}
+		fault.times--
+		code := "invalid_request"
+		if fault.status >= http.StatusInternalServerError {
+			code = "internal_server_error"
+		}
+		return newFakeResponse(fault.status, apiError(code, ErrDescriptionFault, ""))
+	}
+	return nil, nil
+}
+
+// list returns objects of the resource matching the query: created_at.gte and created_at.lt
+// limit creation time, other parameters (e.g. payment_id) must be equal to fields of the object
+func (f *Fake) list(resource string, query url.Values) []map[string]any {
+	items := make([]map[string]any, 0)
+	for _, id := range f.order[resource] {
+		object := f.objects[resource][id]
+		if matches(object, query) {
+			items = append(items, object)
+		}
+	}
+	return items
+}
+
+func matches(object map[string]any, query url.Values) bool {
+	createdAt, _ := time.Parse(time.RFC3339, fmt.Sprint(object["created_at"]))
+	for key := range query {
+		value := query.Get(key)
+		switch key {
+		case "limit", "cursor":
+		case "created_at.gte":
+			if from, err := time.Parse(time.RFC3339, value); err == nil && createdAt.Before(from) {
+				return false
+			}
+		case "created_at.lt":
+			if to, err := time.Parse(time.RFC3339, value); err == nil && !createdAt.Before(to) {
+				return false
+			}
+		default:
+			if fmt.Sprint(object[key]) != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validateAmount(v any) map[string]any {
+	amount, _ := v.(map[string]any)
+	value, _ := amount["value"].(string)
+	if !amountValue.MatchString(value) || strings.Trim(value, "0.") == "" {
+		return apiError("invalid_request", ErrDescriptionAmount, "amount.value")
+	}
+	if currency, _ := amount["currency"].(string); currency != FakeCurrency {
+		return apiError("invalid_request", ErrDescriptionCurrency, "amount.currency")
+	}
+	return nil
+}
+
+// fakeConfirmation returns data of the requested confirmation type
+func fakeConfirmation(req map[string]any) map[string]any {
+	confirmation := map[string]any{"type": req["type"]}
+	switch req["type"] {
+	case "embedded":
+		confirmation["confirmation_token"] = "ct-" + uuid.New().String()
+	case "redirect":
+		confirmation["confirmation_url"] = "https://yoomoney.ru/checkout/payments/v2/contract?orderId=" +
+			uuid.New().String()
+		confirmation["return_url"] = req["return_url"]
+	case "qr":
+		confirmation["confirmation_data"] = "https://qr.nspk.ru/" + uuid.New().String()
+	}
+	return confirmation
+}
+
+func apiError(code, description, parameter string) map[string]any {
+	apiErr := map[string]any{
+		"type":        "error",
+		"id":          uuid.New().String(),
+		"code":        code,
+		"description": description,
+	}
+	if parameter != "" {
+		apiErr["parameter"] = parameter
+	}
+	return apiErr
+}
+
+// convert copies v into out through JSON, so structs of requests become maps
+func convert(v, out any) error {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func newFakeResponse(status int, v any) (*fakeResponse, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeResponse{status: status, body: data}, nil
+}
+
+func respond(status int, v any) (*http.Response, error) {
+	resp, err := newFakeResponse(status, v)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(resp), nil
+}
+
+func newResponse(resp *fakeResponse) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", resp.status, http.StatusText(resp.status)),
+		StatusCode:    resp.status,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(resp.body)),
+		ContentLength: int64(len(resp.body)),
+	}
+}
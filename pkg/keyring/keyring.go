@@ -0,0 +1,317 @@
+// Package keyring implements envelope encryption of sensitive values (card synonyms).
+//
+// Values are encrypted with AES-256-GCM data keys. Data keys are stored in the
+// database wrapped (encrypted) by a master key which never leaves the config or
+// a local keyfile. Every ciphertext is stored together with the ID of its data key,
+// so data keys can be rotated and old rows re-encrypted.
+package keyring
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	KeySize = 32
+)
+
+var (
+	ErrNoMasterKey      = errors.New("master key isn't configured")
+	ErrInvalidMasterKey = errors.New("master key must be 32 bytes (base64 encoded)")
+	ErrUnknownDataKey   = errors.New("unknown data key")
+	ErrNoActiveKey      = errors.New("there is no active data key")
+	ErrInvalidCipher    = errors.New("invalid ciphertext")
+)
+
+type Config struct {
+	MasterKeyID string `yaml:"masterKeyId" env:"CARD_MASTER_KEY_ID" default:"default"`
+	// MasterKey is base64 encoded 32 bytes key, it has priority over MasterKeyFile
+	MasterKey     string `yaml:"masterKey" env:"CARD_MASTER_KEY" secret:"true"`
+	MasterKeyFile string `yaml:"masterKeyFile" env:"CARD_MASTER_KEY_FILE"`
+}
+
+func (c *Config) Validate() error {
+	if c.MasterKey == "" && c.MasterKeyFile == "" {
+		return errors.New("masterKey (CARD_MASTER_KEY) or masterKeyFile (CARD_MASTER_KEY_FILE) is required")
+	}
+	return nil
+}
+
+// MasterKey wraps and unwraps data keys
+type MasterKey struct {
+	ID  string
+	key []byte
+}
+
+// LoadMasterKey reads base64 encoded key from config value or, if it's empty, from keyfile
+func LoadMasterKey(cfg *Config) (*MasterKey, error) {
+	if cfg == nil {
+		return nil, ErrNoMasterKey
+	}
+	encoded := cfg.MasterKey
+	if encoded == "" && cfg.MasterKeyFile != "" {
+		data, err := os.ReadFile(cfg.MasterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, ErrNoMasterKey
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidMasterKey
+	}
+	return &MasterKey{
+		ID:  cfg.MasterKeyID,
+		key: key,
+	}, nil
+}
+
+// GenerateKey returns new random base64 encoded key, suitable for master keyfile
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// DataKey is a data key as it's persisted: encrypted by master key
+type DataKey struct {
+	ID          string
+	MasterKeyID string
+	WrappedKey  []byte
+	IsActive    bool
+	CreatedAt   time.Time
+}
+
+// KeyStore persists wrapped data keys
+type KeyStore interface {
+	GetDataKeys(ctx context.Context) ([]*DataKey, error)
+	// InsertActiveDataKey stores the key and makes it the only active one
+	InsertActiveDataKey(ctx context.Context, key *DataKey) error
+}
+
+type Keyring struct {
+	mu       sync.RWMutex
+	store    KeyStore
+	masters  map[string]*MasterKey
+	current  *MasterKey
+	keys     map[string]cipher.AEAD
+	activeID string
+}
+
+// New loads data keys from the store. The first master key wraps new data keys,
+// the others are used only to unwrap keys created before master key rotation.
+// If there is no active data key, it's generated.
+func New(ctx context.Context, store KeyStore, masters ...*MasterKey) (*Keyring, error) {
+	if len(masters) == 0 || masters[0] == nil {
+		return nil, ErrNoMasterKey
+	}
+	k := &Keyring{
+		store:   store,
+		masters: make(map[string]*MasterKey, len(masters)),
+		current: masters[0],
+		keys:    make(map[string]cipher.AEAD),
+	}
+	for _, m := range masters {
+		if m != nil {
+			k.masters[m.ID] = m
+		}
+	}
+
+	dataKeys, err := store.GetDataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = k.load(dataKeys); err != nil {
+		return nil, err
+	}
+
+	if k.activeID == "" {
+		if _, err = k.Rotate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Rotate generates new data key, wrapped by the current master key, and makes it active
+func (k *Keyring) Rotate(ctx context.Context) (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", err
+	}
+	dk := &DataKey{
+		ID:          uuid.New().String(),
+		MasterKeyID: k.current.ID,
+		IsActive:    true,
+	}
+	wrapped, err := seal(k.current.key, raw, []byte(dk.ID))
+	if err != nil {
+		return "", err
+	}
+	dk.WrappedKey = wrapped
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return "", err
+	}
+	if err = k.store.InsertActiveDataKey(ctx, dk); err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[dk.ID] = aead
+	k.activeID = dk.ID
+	return dk.ID, nil
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeID
+}
+
+// Encrypt returns base64 ciphertext and ID of the data key it's encrypted with
+func (k *Keyring) Encrypt(plaintext string) (string, string, error) {
+	k.mu.RLock()
+	keyID := k.activeID
+	aead, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", "", ErrNoActiveKey
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(keyID))
+	return base64.StdEncoding.EncodeToString(sealed), keyID, nil
+}
+
+// Decrypt returns plaintext of the ciphertext. Unknown data key is loaded from the store,
+// it could be created by Rotate of another replica after this keyring was loaded.
+func (k *Keyring) Decrypt(ctx context.Context, ciphertext, keyID string) (string, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		var err error
+		if aead, err = k.reload(ctx, keyID); err != nil {
+			return "", err
+		}
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidCipher
+	}
+	nonce, data := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, data, []byte(keyID))
+	if err != nil {
+		return "", ErrInvalidCipher
+	}
+	return string(plaintext), nil
+}
+
+// reload loads data keys from the store and returns the key. The lock is held during loading,
+// so concurrent calls with the same unknown key query the store once.
+func (k *Keyring) reload(ctx context.Context, keyID string) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if aead, ok := k.keys[keyID]; ok {
+		return aead, nil
+	}
+	dataKeys, err := k.store.GetDataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = k.load(dataKeys); err != nil {
+		return nil, err
+	}
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownDataKey
+	}
+	return aead, nil
+}
+
+// load unwraps data keys which aren't loaded yet and takes the active one,
+// the caller holds the lock (or owns the keyring)
+func (k *Keyring) load(dataKeys []*DataKey) error {
+	for _, dk := range dataKeys {
+		master, ok := k.masters[dk.MasterKeyID]
+		if !ok {
+			// Key of another master, rows encrypted with it can't be read
+			continue
+		}
+		if _, ok = k.keys[dk.ID]; !ok {
+			raw, err := unwrap(master, dk)
+			if err != nil {
+				return fmt.Errorf("failed to unwrap data key %v: %w", dk.ID, err)
+			}
+			aead, err := newAEAD(raw)
+			if err != nil {
+				return err
+			}
+			k.keys[dk.ID] = aead
+		}
+		if dk.IsActive && dk.MasterKeyID == k.current.ID {
+			k.activeID = dk.ID
+		}
+	}
+	return nil
+}
+
+// ______________
+// Utils
+// ______________
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func unwrap(master *MasterKey, dk *DataKey) ([]byte, error) {
+	aead, err := newAEAD(master.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(dk.WrappedKey) < aead.NonceSize() {
+		return nil, ErrInvalidCipher
+	}
+	nonce, data := dk.WrappedKey[:aead.NonceSize()], dk.WrappedKey[aead.NonceSize():]
+	return aead.Open(nil, nonce, data, []byte(dk.ID))
+}
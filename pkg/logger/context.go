@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+type ctxKey struct{}
+
+// scope is the logger of one request (or background job). It's shared by pointer,
+// so attributes added deep in a handler (user_id, transaction_id) are also seen
+// by the access log written after the handler returns.
+type scope struct {
+	mu  sync.RWMutex
+	log *slog.Logger
+}
+
+// NewContext returns context carrying request-scoped logger
+func NewContext(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &scope{log: log})
+}
+
+// FromContext returns request-scoped logger, fallback is used if ctx has none
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	s, ok := ctx.Value(ctxKey{}).(*scope)
+	if !ok {
+		return fallback
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log
+}
+
+// AddAttrs adds attributes to request-scoped logger, it does nothing if ctx has no logger
+func AddAttrs(ctx context.Context, args ...any) {
+	s, ok := ctx.Value(ctxKey{}).(*scope)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = s.log.With(args...)
+}
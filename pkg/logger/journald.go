@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// priorityHandler writes JSON lines prefixed by syslog priority, the prefix is understood
+// by journald and by syslog daemons reading stderr of the service (sd-daemon(3)).
+type priorityHandler struct {
+	inner slog.Handler
+	w     *priorityWriter
+}
+
+type priorityWriter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	priority int
+}
+
+func newPriorityHandler(out io.Writer, opts *slog.HandlerOptions) *priorityHandler {
+	w := &priorityWriter{out: out}
+	jsonOpts := *opts
+	// Time is added by journald
+	jsonOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return &priorityHandler{
+		inner: slog.NewJSONHandler(w, &jsonOpts),
+		w:     w,
+	}
+}
+
+func (h *priorityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	// The record is written by inner handler with one Write call, priority is passed to it
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.priority = syslogPriority(r.Level)
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithAttrs(attrs), w: h.w}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithGroup(name), w: h.w}
+}
+
+// Write is called with w.mu locked by Handle
+func (w *priorityWriter) Write(p []byte) (int, error) {
+	line := make([]byte, 0, len(p)+3)
+	line = append(line, '<')
+	line = strconv.AppendInt(line, int64(w.priority), 10)
+	line = append(line, '>')
+	line = append(line, p...)
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// syslogPriority maps slog level to syslog severity: err(3), warning(4), info(6), debug(7)
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -2,8 +2,10 @@ package logger
 
 import (
 	"errors"
-	"log"
+	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"log/slog"
 
@@ -15,32 +17,136 @@ const (
 	EnvProd  = "prod"
 )
 
+const (
+	// OutputStdout writes JSON lines to stdout
+	OutputStdout = "stdout"
+	// OutputFile writes JSON lines to a file rotated by size and age
+	OutputFile = "file"
+	// OutputJournald writes JSON lines to stderr prefixed by syslog priority (<3>, <6>, ...),
+	// without time: journald and syslog daemons add it themselves
+	OutputJournald = "journald"
+)
+
 var unknownEnv = errors.New("unknown environment (should be local or prod)")
 
-func New(env string) *slog.Logger {
-	var logger *slog.Logger
+// level is shared by all loggers, so it can be changed at runtime (SetLevel)
+var level = new(slog.LevelVar)
+
+type Config struct {
+	// Level is debug, info, warn or error. Empty means debug for local env and info for prod
+	Level  string     `yaml:"level" env:"LOG_LEVEL"`
+	Output string     `yaml:"output" env:"LOG_OUTPUT" default:"stdout"`
+	File   FileConfig `yaml:"file"`
+}
+
+// FileConfig is used by file output, the file is rotated when either limit is reached
+type FileConfig struct {
+	Path        string        `yaml:"path" env:"LOG_FILE_PATH"`
+	MaxSizeMB   int           `yaml:"maxSizeMb" env:"LOG_FILE_MAX_SIZE_MB" default:"100"`
+	RotateEvery time.Duration `yaml:"rotateEvery" env:"LOG_FILE_ROTATE_EVERY" default:"24h"`
+	// MaxBackups is the number of rotated files to keep, 0 keeps all of them
+	MaxBackups int `yaml:"maxBackups" env:"LOG_FILE_MAX_BACKUPS" default:"7"`
+}
+
+func (c *Config) Validate() error {
+	if c.Level != "" {
+		if _, err := ParseLevel(c.Level); err != nil {
+			return err
+		}
+	}
+	switch c.Output {
+	case OutputStdout, OutputJournald:
+	case OutputFile:
+		if c.File.Path == "" {
+			return errors.New("file.path (LOG_FILE_PATH) is required for file output")
+		}
+		if c.File.MaxSizeMB <= 0 || c.File.RotateEvery <= 0 {
+			return errors.New("file.maxSizeMb and file.rotateEvery must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown output %q (should be stdout, file or journald)", c.Output)
+	}
+	return nil
+}
+
+// EffectiveLevel returns configured level or the default level of env
+func (c *Config) EffectiveLevel(env string) (slog.Level, error) {
+	if c.Level != "" {
+		return ParseLevel(c.Level)
+	}
+	if env == EnvLocal {
+		return slog.LevelDebug, nil
+	}
+	return slog.LevelInfo, nil
+}
+
+// New creates logger of the environment: colored output for local, JSON for prod.
+// Sensitive values are redacted in both. The returned closer must be closed on shutdown.
+func New(env string, cfg *Config) (*slog.Logger, io.Closer, error) {
+	if cfg == nil {
+		cfg = &Config{Output: OutputStdout}
+	}
+	lvl, err := cfg.EffectiveLevel(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	level.Set(lvl)
+
+	var handler slog.Handler
+	var closer io.Closer = nopCloser{}
 	switch env {
 	case EnvLocal:
-		logger = setupPrettySlog()
+		handler = setupPrettyHandler()
 	case EnvProd:
-		// TODO change writer(example: file)
-		logger = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
+		opts := &slog.HandlerOptions{Level: level}
+		switch cfg.Output {
+		case OutputFile:
+			file, err := OpenRotatingFile(cfg.File.Path, int64(cfg.File.MaxSizeMB)<<20, cfg.File.RotateEvery, cfg.File.MaxBackups)
+			if err != nil {
+				return nil, nil, err
+			}
+			handler, closer = slog.NewJSONHandler(file, opts), file
+		case OutputJournald:
+			handler = newPriorityHandler(os.Stderr, opts)
+		default:
+			handler = slog.NewJSONHandler(os.Stdout, opts)
+		}
 	default:
-		log.Fatal(unknownEnv)
+		return nil, nil, unknownEnv
+	}
+	return slog.New(NewRedactHandler(handler)), closer, nil
+}
+
+// ParseLevel accepts level names as slog prints them, case-insensitive
+func ParseLevel(name string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (should be debug, info, warn or error)", name)
 	}
-	return logger
+	return lvl, nil
 }
 
-func setupPrettySlog() *slog.Logger {
+// SetLevel changes level of all loggers created by New
+func SetLevel(lvl slog.Level) {
+	level.Set(lvl)
+}
+
+// Level returns current level of loggers created by New
+func Level() slog.Level {
+	return level.Level()
+}
+
+func setupPrettyHandler() slog.Handler {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
-			Level: slog.LevelDebug,
+			Level: level,
 		},
+		Multiline: true,
 	}
 
-	handler := opts.NewPrettyHandler(os.Stdout)
-
-	return slog.New(handler)
+	return opts.NewPrettyHandler(os.Stdout)
 }
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
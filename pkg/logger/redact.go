@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute keys (lowercase, without "_" and "-") whose values are never logged
+var sensitiveKeys = []string{
+	"synonym",
+	"secret",
+	"password",
+	"passwd",
+	"token",
+	"authorization",
+	"masterkey",
+	"cardnumber",
+}
+
+// panPattern finds candidates for card numbers: 13-19 digits, optionally separated by spaces or dashes
+var panPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// RedactHandler masks sensitive values before they reach the wrapped handler:
+// values of sensitive keys (card synonyms, secret keys, passwords, tokens) are replaced,
+// full card numbers in any string are masked as 411111******1111.
+// Structs and maps passed with slog.Any are checked by their JSON representation.
+type RedactHandler struct {
+	inner slog.Handler
+}
+
+func NewRedactHandler(inner slog.Handler) *RedactHandler {
+	return &RedactHandler{inner: inner}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, maskPANs(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, clean)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &RedactHandler{inner: h.inner.WithAttrs(clean)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{inner: h.inner.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, maskPANs(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		clean := make([]any, len(group))
+		for i, ga := range group {
+			clean[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindAny:
+		return slog.Any(a.Key, redactAny(v.Any()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func redactAny(value any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return maskPANs(v.Error())
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var generic any
+	if err = json.Unmarshal(data, &generic); err != nil {
+		return value
+	}
+	return redactJSON(generic)
+}
+
+func redactJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if isSensitiveKey(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactJSON(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+		return v
+	case string:
+		return maskPANs(v)
+	}
+	return value
+}
+
+func isSensitiveKey(key string) bool {
+	normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	if normalized == "pan" {
+		return true
+	}
+	for _, s := range sensitiveKeys {
+		if strings.Contains(normalized, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskPANs keeps first six and last four digits of card numbers (as card masks do)
+func maskPANs(s string) string {
+	if !strings.ContainsAny(s, "0123456789") {
+		return s
+	}
+	return panPattern.ReplaceAllStringFunc(s, func(candidate string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(candidate)
+		if !luhnValid(digits) {
+			return candidate
+		}
+		return digits[:6] + strings.Repeat("*", len(digits)-10) + digits[len(digits)-4:]
+	})
+}
+
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the name of rotated file, it sorts chronologically
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log file which is rotated when it exceeds maxSize bytes
+// or when it's older than rotateEvery. Rotated files are named <path>.<time>.
+type RotatingFile struct {
+	mu          sync.Mutex
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxBackups  int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func OpenRotatingFile(path string, maxSize int64, rotateEvery time.Duration, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f := &RotatingFile{
+		path:        path,
+		maxSize:     maxSize,
+		rotateEvery: rotateEvery,
+		maxBackups:  maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.rotateEvery > 0 && time.Since(f.openedAt) >= f.rotateEvery
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// Age of the existing file is unknown after restart, modification time is the best guess
+	f.openedAt = time.Now()
+	if f.size > 0 {
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	backup := fmt.Sprintf("%s.%s", f.path, time.Now().Format(backupTimeFormat))
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.openedAt = time.Now()
+	return f.removeOldBackups()
+}
+
+func (f *RotatingFile) removeOldBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-f.maxBackups] {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,99 +1,231 @@
 package slogpretty
 
 import (
+	"bytes"
 	"context"
-	"io"
-	stdLog "log"
-
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
 
+const timeFormat = "[15:04:05.000]"
+
 type PrettyHandlerOptions struct {
+	// SlogOpts.Level, AddSource and ReplaceAttr are supported
 	SlogOpts *slog.HandlerOptions
+	// Multiline prints attributes as indented JSON
+	Multiline bool
+	// NoColor disables colors, by default they are disabled only if output isn't a terminal
+	NoColor bool
 }
 
+// PrettyHandler prints records as "[time] LEVEL: message source {attrs}",
+// attributes (including ones added by With and WithGroup) are printed as JSON.
+// It's safe for concurrent use, handlers derived by With share the output lock.
 type PrettyHandler struct {
 	opts PrettyHandlerOptions
-	slog.Handler
-	l     *stdLog.Logger
-	attrs []slog.Attr
+	mu   *sync.Mutex
+	out  io.Writer
+
+	// attrs are attributes added by WithAttrs, nested by groups
+	attrs map[string]any
+	// groups is the path of the groups opened by WithGroup
+	groups []string
 }
 
 func (opts PrettyHandlerOptions) NewPrettyHandler(
 	out io.Writer,
 ) *PrettyHandler {
-	h := &PrettyHandler{
-		Handler: slog.NewJSONHandler(out, opts.SlogOpts),
-		l:       stdLog.New(out, "", 0),
+	if opts.SlogOpts == nil {
+		opts.SlogOpts = &slog.HandlerOptions{}
+	}
+	return &PrettyHandler{
+		opts:  opts,
+		mu:    &sync.Mutex{},
+		out:   out,
+		attrs: map[string]any{},
 	}
-
-	return h
 }
 
-func (h *PrettyHandler) Handle(_ context.Context, r slog.Record) error {
-	level := r.Level.String() + ":"
-
-	switch r.Level {
-	case slog.LevelDebug:
-		level = color.MagentaString(level)
-	case slog.LevelInfo:
-		level = color.BlueString(level)
-	case slog.LevelWarn:
-		level = color.YellowString(level)
-	case slog.LevelError:
-		level = color.RedString(level)
+func (h *PrettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.SlogOpts.Level != nil {
+		minLevel = h.opts.SlogOpts.Level.Level()
 	}
+	return level >= minLevel
+}
 
-	fields := make(map[string]interface{}, r.NumAttrs())
-
+func (h *PrettyHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := cloneMap(h.attrs)
+	target := openGroups(fields, h.groups)
 	r.Attrs(func(a slog.Attr) bool {
-		fields[a.Key] = a.Value.Any()
-
+		h.addAttr(target, h.groups, a)
 		return true
 	})
 
-	for _, a := range h.attrs {
-		fields[a.Key] = a.Value.Any()
+	buf := new(bytes.Buffer)
+	if !r.Time.IsZero() {
+		buf.WriteString(r.Time.Format(timeFormat))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(h.colorize(levelColor(r.Level), r.Level.String()+":"))
+	buf.WriteByte(' ')
+	buf.WriteString(h.colorize(color.FgCyan, r.Message))
+
+	if h.opts.SlogOpts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		buf.WriteByte(' ')
+		buf.WriteString(h.colorize(color.FgHiBlack, fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)))
 	}
-
-	var b []byte
-	var err error
 
 	if len(fields) > 0 {
-		b, err = json.MarshalIndent(fields, "", "  ")
+		var b []byte
+		var err error
+		if h.opts.Multiline {
+			b, err = json.MarshalIndent(fields, "", "  ")
+		} else {
+			b, err = json.Marshal(fields)
+		}
 		if err != nil {
 			return err
 		}
+		buf.WriteByte(' ')
+		buf.WriteString(h.colorize(color.FgWhite, string(b)))
 	}
+	buf.WriteByte('\n')
 
-	timeStr := r.Time.Format("[15:05:05.000]")
-	msg := color.CyanString(r.Message)
-
-	h.l.Println(
-		timeStr,
-		level,
-		msg,
-		color.WhiteString(string(b)),
-	)
-
-	return nil
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
 }
 
 func (h *PrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &PrettyHandler{
-		Handler: h.Handler,
-		l:       h.l,
-		attrs:   attrs,
+	if len(attrs) == 0 {
+		return h
 	}
+	h2 := h.clone()
+	target := openGroups(h2.attrs, h2.groups)
+	for _, a := range attrs {
+		h2.addAttr(target, h2.groups, a)
+	}
+	return h2
 }
 
 func (h *PrettyHandler) WithGroup(name string) slog.Handler {
-	// TODO: implement
+	if name == "" {
+		return h
+	}
+	h2 := h.clone()
+	h2.groups = append(h2.groups, name)
+	return h2
+}
+
+func (h *PrettyHandler) clone() *PrettyHandler {
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
 	return &PrettyHandler{
-		Handler: h.Handler.WithGroup(name),
-		l:       h.l,
+		opts:   h.opts,
+		mu:     h.mu,
+		out:    h.out,
+		attrs:  cloneMap(h.attrs),
+		groups: groups,
+	}
+}
+
+// addAttr puts attribute into target map, groups is the path of target (for ReplaceAttr)
+func (h *PrettyHandler) addAttr(target map[string]any, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup && h.opts.SlogOpts.ReplaceAttr != nil {
+		a = h.opts.SlogOpts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		target[a.Key] = attrValue(a.Value)
+		return
+	}
+
+	group := a.Value.Group()
+	if len(group) == 0 {
+		return
+	}
+	// Group with empty key is inlined
+	nested, nestedGroups := target, groups
+	if a.Key != "" {
+		nested = openGroups(target, []string{a.Key})
+		nestedGroups = append(groups[:len(groups):len(groups)], a.Key)
+	}
+	for _, ga := range group {
+		h.addAttr(nested, nestedGroups, ga)
+	}
+}
+
+func (h *PrettyHandler) colorize(attr color.Attribute, s string) string {
+	c := color.New(attr)
+	if h.opts.NoColor {
+		c.DisableColor()
+	}
+	return c.Sprint(s)
+}
+
+func levelColor(level slog.Level) color.Attribute {
+	switch {
+	case level >= slog.LevelError:
+		return color.FgRed
+	case level >= slog.LevelWarn:
+		return color.FgYellow
+	case level >= slog.LevelInfo:
+		return color.FgBlue
+	default:
+		return color.FgMagenta
+	}
+}
+
+func attrValue(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+// openGroups returns map of the nested group, missing groups are created
+func openGroups(m map[string]any, groups []string) map[string]any {
+	for _, g := range groups {
+		nested, ok := m[g].(map[string]any)
+		if !ok {
+			nested = map[string]any{}
+			m[g] = nested
+		}
+		m = nested
+	}
+	return m
+}
+
+// cloneMap copies nested group maps, values are shared
+func cloneMap(m map[string]any) map[string]any {
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		if nested, ok := v.(map[string]any); ok {
+			v = cloneMap(nested)
+		}
+		c[k] = v
 	}
+	return c
 }
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// AuditRepository is in-memory postgres.AuditRepository, events are returned newest first
+type AuditRepository struct {
+	mu     sync.Mutex
+	events []*postgres.AuditEvent
+}
+
+func NewAuditRepository() *AuditRepository {
+	return &AuditRepository{}
+}
+
+func (r *AuditRepository) InsertAuditEvent(_ context.Context, event *postgres.AuditEvent) error {
+	if event == nil || event.EventType == "" || event.SubjectType == "" || event.SubjectID == "" {
+		return errors.New("try to insert not full audit event")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.ID = int64(len(r.events) + 1)
+	event.CreatedAt = time.Now()
+	stored := *event
+	r.events = append(r.events, &stored)
+	return nil
+}
+
+func (r *AuditRepository) GetAuditEvents(_ context.Context, filter *postgres.AuditFilter) (
+	[]*postgres.AuditEvent, error) {
+	if filter == nil {
+		filter = &postgres.AuditFilter{}
+	}
+	limit, offset := filter.Page()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]*postgres.AuditEvent, 0)
+	for i := len(r.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := r.events[i]
+		if !matchesAudit(filter, event) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		found := *event
+		events = append(events, &found)
+	}
+	return events, nil
+}
+
+func matchesAudit(f *postgres.AuditFilter, event *postgres.AuditEvent) bool {
+	switch {
+	case f.EventType != "" && f.EventType != event.EventType,
+		f.ActorID != "" && f.ActorID != event.ActorID,
+		f.SubjectType != "" && f.SubjectType != event.SubjectType,
+		f.SubjectID != "" && f.SubjectID != event.SubjectID,
+		!f.From.IsZero() && event.CreatedAt.Before(f.From),
+		!f.To.IsZero() && !event.CreatedAt.Before(f.To):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// CardStore is in-memory postgres.CardStore, synonyms aren't encrypted
+type CardStore struct {
+	mu     sync.Mutex
+	cards  map[uuid.UUID]*postgres.RefillableCardDBRow
+	lastID int
+}
+
+func NewCardStore() *CardStore {
+	return &CardStore{
+		cards: make(map[uuid.UUID]*postgres.RefillableCardDBRow),
+	}
+}
+
+func (s *CardStore) GetRefillableCardByUserID(_ context.Context, userId uuid.UUID) (
+	*postgres.RefillableCardDBRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.cards[userId]
+	if !ok {
+		return nil, nil
+	}
+	row := *card
+	return &row, nil
+}
+
+func (s *CardStore) InsertOrUpdateRefillableCard(_ context.Context, card *models.RefillableCard) (
+	isUpdated bool, err error) {
+	if !card.IsFullData() {
+		return false, errors.New("try to insert not full card data")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	row, isUpdated := s.cards[card.Owner.Id]
+	if !isUpdated {
+		s.lastID++
+		row = &postgres.RefillableCardDBRow{Id: s.lastID, UserId: card.Owner.Id.String(), CreatedAt: now}
+		s.cards[card.Owner.Id] = row
+	}
+	row.CardSynonym = card.Synonym
+	row.CardMask = card.CardMask
+	row.UpdatedAt = now
+	return isUpdated, nil
+}
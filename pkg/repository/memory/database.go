@@ -0,0 +1,20 @@
+// Package memory has in-memory stores for tests and local runs without Postgres and Redis.
+// They keep data only while the process runs and are safe for concurrent use.
+package memory
+
+import (
+	"context"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// Database stands for Postgres in readiness checks, its schema is always up to date
+type Database struct{}
+
+func (Database) PingContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (Database) GetSchemaVersion(context.Context) (uint, bool, error) {
+	return postgres.SchemaVersion, false, nil
+}
@@ -0,0 +1,237 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// LedgerStore is in-memory postgres.LedgerRepository
+type LedgerStore struct {
+	mu      sync.Mutex
+	entries []*postgres.LedgerEntry
+}
+
+func NewLedgerStore() *LedgerStore {
+	return &LedgerStore{}
+}
+
+func (s *LedgerStore) InsertEntries(_ context.Context, entries []*postgres.LedgerEntry) (bool, error) {
+	for _, e := range entries {
+		if e.Account == "" || e.Amount == "" || e.Currency == "" || e.Kind == "" || e.Reference == "" {
+			return false, errors.New("try to insert not full ledger entry")
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range entries {
+		if s.recorded(e) {
+			return false, nil
+		}
+	}
+	for _, e := range entries {
+		s.insert(e)
+	}
+	return true, nil
+}
+
+func (s *LedgerStore) Debit(_ context.Context, entry *postgres.LedgerEntry) (bool, error) {
+	if entry.Account == "" || entry.Amount == "" || entry.Currency == "" || entry.Kind == "" || entry.Reference == "" {
+		return false, errors.New("try to insert not full ledger entry")
+	}
+	amount, err := parseCents(entry.Amount)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, err := s.balance(entry.Account, entry.Currency)
+	if err != nil || balance+amount < 0 {
+		return false, err
+	}
+	if s.recorded(entry) {
+		return false, errors.New("duplicate ledger entry")
+	}
+	s.insert(entry)
+	return true, nil
+}
+
+func (s *LedgerStore) GetBalance(_ context.Context, account, currency string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, err := s.balance(account, currency)
+	if err != nil {
+		return "", err
+	}
+	return formatCents(balance), nil
+}
+
+func (s *LedgerStore) GetEntries(_ context.Context, account string, limit int) ([]*postgres.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*postgres.LedgerEntry, 0)
+	for i := len(s.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		if s.entries[i].Account == account {
+			e := *s.entries[i]
+			entries = append(entries, &e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *LedgerStore) GetEntriesByReference(_ context.Context, reference string) ([]*postgres.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*postgres.LedgerEntry, 0)
+	for _, e := range s.entries {
+		if e.Reference == reference {
+			found := *e
+			entries = append(entries, &found)
+		}
+	}
+	return entries, nil
+}
+
+func (s *LedgerStore) recorded(entry *postgres.LedgerEntry) bool {
+	for _, e := range s.entries {
+		if e.Reference == entry.Reference && e.Account == entry.Account && e.Kind == entry.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LedgerStore) insert(entry *postgres.LedgerEntry) {
+	entry.ID = int64(len(s.entries) + 1)
+	entry.CreatedAt = time.Now()
+	stored := *entry
+	s.entries = append(s.entries, &stored)
+}
+
+func (s *LedgerStore) balance(account, currency string) (int64, error) {
+	var balance int64
+	for _, e := range s.entries {
+		if e.Account != account || e.Currency != currency {
+			continue
+		}
+		amount, err := parseCents(e.Amount)
+		if err != nil {
+			return 0, err
+		}
+		balance += amount
+	}
+	return balance, nil
+}
+
+// parseCents parses amount with up to 2 decimals like numeric(12,2) of the ledger
+func parseCents(value string) (int64, error) {
+	sign := int64(1)
+	if strings.HasPrefix(value, "-") {
+		sign, value = -1, value[1:]
+	}
+	whole, fraction, _ := strings.Cut(value, ".")
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	v, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return sign * v, nil
+}
+
+func formatCents(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%v%v.%02d", sign, v/100, v%100)
+}
+
+// CommissionStore is in-memory postgres.CommissionRepository
+type CommissionStore struct {
+	mu    sync.Mutex
+	rules []*postgres.CommissionRule
+}
+
+func NewCommissionStore() *CommissionStore {
+	return &CommissionStore{}
+}
+
+func (s *CommissionStore) GetCommissionRules(context.Context) ([]*postgres.CommissionRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]*postgres.CommissionRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		found := *rule
+		rules = append(rules, &found)
+	}
+	return rules, nil
+}
+
+func (s *CommissionStore) FindCommissionRule(_ context.Context, landlordID, tier string) (
+	*postgres.CommissionRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The landlord rule is preferred to the tier one, the tier one to the default one
+	var found *postgres.CommissionRule
+	rank := 0
+	for _, rule := range s.rules {
+		r := 0
+		switch {
+		case rule.LandlordID != "" && rule.LandlordID == landlordID:
+			r = 3
+		case rule.LandlordID == "" && rule.Tier != "" && rule.Tier == tier:
+			r = 2
+		case rule.LandlordID == "" && rule.Tier == "":
+			r = 1
+		}
+		if r > rank {
+			found, rank = rule, r
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	rule := *found
+	return &rule, nil
+}
+
+func (s *CommissionStore) SaveCommissionRule(_ context.Context, rule *postgres.CommissionRule) error {
+	if rule == nil || rule.Percent == "" || rule.Fixed == "" {
+		return errors.New("try to save not full commission rule")
+	}
+	if rule.LandlordID != "" && rule.Tier != "" {
+		return errors.New("commission rule applies to landlord or tier, not both")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule.UpdatedAt = time.Now()
+	for i, saved := range s.rules {
+		if saved.LandlordID == rule.LandlordID && saved.Tier == rule.Tier {
+			rule.ID = saved.ID
+			stored := *rule
+			s.rules[i] = &stored
+			return nil
+		}
+	}
+	rule.ID = len(s.rules) + 1
+	stored := *rule
+	s.rules = append(s.rules, &stored)
+	return nil
+}
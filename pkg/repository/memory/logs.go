@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// LogRepository is in-memory postgres.LogRepository
+type LogRepository struct {
+	mu   sync.Mutex
+	logs []*postgres.Log
+}
+
+func NewLogRepository() *LogRepository {
+	return &LogRepository{}
+}
+
+func (r *LogRepository) InsertLog(_ context.Context, log *postgres.Log) error {
+	if log.Kind == "" {
+		log.Kind = postgres.LogKindPayment
+	}
+	if log.Currency == "" {
+		log.Currency = postgres.DefaultLogCurrency
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.ID = len(r.logs) + 1
+	stored := *log
+	r.logs = append(r.logs, &stored)
+	return nil
+}
+
+func (r *LogRepository) UpdateLogStatus(_ context.Context, transactionID string, status string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updated := false
+	for _, log := range r.logs {
+		if log.TransactionID == transactionID {
+			log.Status = status
+			updated = true
+		}
+	}
+	return updated, nil
+}
+
+func (r *LogRepository) GetLogs(_ context.Context, kind string, from, to time.Time) ([]*postgres.Log, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logs := make([]*postgres.Log, 0)
+	for _, log := range r.logs {
+		if log.Kind == kind && !log.Time.Before(from) && log.Time.Before(to) {
+			found := *log
+			logs = append(logs, &found)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Time.Before(logs[j].Time)
+	})
+	return logs, nil
+}
+
+func (r *LogRepository) GetLog(_ context.Context, transactionID string) (*postgres.Log, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, log := range r.logs {
+		if log.TransactionID == transactionID {
+			found := *log
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
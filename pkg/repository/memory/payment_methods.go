@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// PaymentMethodStore is in-memory postgres.PaymentMethodRepository
+type PaymentMethodStore struct {
+	mu      sync.Mutex
+	methods []*postgres.PaymentMethod
+}
+
+func NewPaymentMethodStore() *PaymentMethodStore {
+	return &PaymentMethodStore{}
+}
+
+func (s *PaymentMethodStore) InsertPaymentMethod(_ context.Context, method *postgres.PaymentMethod) error {
+	if method == nil || method.UserID == "" || method.PaymentMethodID == "" || method.PaymentID == "" ||
+		method.Type == "" {
+		return errors.New("try to insert not full payment method")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saved := range s.methods {
+		if saved.PaymentID == method.PaymentID {
+			// Notification is repeated, the method is already saved
+			return nil
+		}
+	}
+	method.ID = len(s.methods) + 1
+	method.PublicID = uuid.New()
+	method.CreatedAt = time.Now()
+	stored := *method
+	s.methods = append(s.methods, &stored)
+	return nil
+}
+
+func (s *PaymentMethodStore) GetPaymentMethods(_ context.Context, userID string) ([]*postgres.PaymentMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	methods := make([]*postgres.PaymentMethod, 0)
+	for _, method := range s.methods {
+		if method.UserID == userID && method.RevokedAt == nil {
+			found := *method
+			methods = append(methods, &found)
+		}
+	}
+	return methods, nil
+}
+
+func (s *PaymentMethodStore) GetPaymentMethod(_ context.Context, userID string, id int) (
+	*postgres.PaymentMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method := s.method(userID, func(m *postgres.PaymentMethod) bool { return m.ID == id })
+	if method == nil {
+		return nil, nil
+	}
+	found := *method
+	return &found, nil
+}
+
+func (s *PaymentMethodStore) GetPaymentMethodByPublicID(_ context.Context, userID string, publicID uuid.UUID) (
+	*postgres.PaymentMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method := s.method(userID, func(m *postgres.PaymentMethod) bool { return m.PublicID == publicID })
+	if method == nil {
+		return nil, nil
+	}
+	found := *method
+	return &found, nil
+}
+
+func (s *PaymentMethodStore) RevokePaymentMethod(_ context.Context, userID string, publicID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method := s.method(userID, func(m *postgres.PaymentMethod) bool { return m.PublicID == publicID })
+	if method == nil {
+		return false, nil
+	}
+	now := time.Now()
+	method.RevokedAt = &now
+	return true, nil
+}
+
+// method returns not revoked method of the user
+func (s *PaymentMethodStore) method(userID string, match func(*postgres.PaymentMethod) bool) *postgres.PaymentMethod {
+	for _, method := range s.methods {
+		if method.UserID == userID && method.RevokedAt == nil && match(method) {
+			return method
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// PayoutBatchStore is in-memory postgres.PayoutBatchRepository
+type PayoutBatchStore struct {
+	mu      sync.Mutex
+	batches map[uuid.UUID]*postgres.PayoutBatch
+	items   []*postgres.PayoutBatchItem
+}
+
+func NewPayoutBatchStore() *PayoutBatchStore {
+	return &PayoutBatchStore{
+		batches: make(map[uuid.UUID]*postgres.PayoutBatch),
+	}
+}
+
+func (s *PayoutBatchStore) InsertBatch(_ context.Context, batch *postgres.PayoutBatch,
+	items []*postgres.PayoutBatchItem) error {
+	if batch == nil || batch.ID == uuid.Nil || len(items) == 0 {
+		return errors.New("try to insert not full payout batch")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.batches[batch.ID]; ok {
+		return errors.New("duplicate payout batch")
+	}
+	batch.CreatedAt = time.Now()
+	stored := *batch
+	s.batches[batch.ID] = &stored
+	for _, item := range items {
+		item.ID = len(s.items) + 1
+		item.BatchID = batch.ID
+		item.Attempt = 1
+		item.Status = postgres.BatchItemQueued
+		item.UpdatedAt = batch.CreatedAt
+		storedItem := *item
+		s.items = append(s.items, &storedItem)
+	}
+	return nil
+}
+
+func (s *PayoutBatchStore) GetBatch(_ context.Context, id uuid.UUID) (*postgres.PayoutBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *batch
+	return &found, nil
+}
+
+func (s *PayoutBatchStore) GetBatchItems(_ context.Context, batchID uuid.UUID) ([]*postgres.PayoutBatchItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]*postgres.PayoutBatchItem, 0)
+	for _, item := range s.items {
+		if item.BatchID == batchID {
+			found := *item
+			items = append(items, &found)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Position < items[j].Position
+	})
+	return items, nil
+}
+
+func (s *PayoutBatchStore) UpdateBatchItem(_ context.Context, item *postgres.PayoutBatchItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item.ID <= 0 || item.ID > len(s.items) {
+		return errors.New("no such payout batch item")
+	}
+	stored := s.items[item.ID-1]
+	stored.YooKassaID = item.YooKassaID
+	stored.Status = item.Status
+	stored.Error = item.Error
+	stored.UpdatedAt = time.Now()
+	item.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (s *PayoutBatchStore) RequeueBatchItems(_ context.Context, batchID uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requeued := 0
+	for _, item := range s.items {
+		if item.BatchID != batchID ||
+			(item.Status != postgres.BatchItemFailed && item.Status != postgres.BatchItemCanceled) {
+			continue
+		}
+		item.Attempt++
+		item.TransactionID = uuid.New()
+		item.YooKassaID = ""
+		item.Status = postgres.BatchItemQueued
+		item.Error = ""
+		item.UpdatedAt = time.Now()
+		requeued++
+	}
+	return requeued, nil
+}
+
+func (s *PayoutBatchStore) GetQueuedBatchIDs(_ context.Context) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := make(map[uuid.UUID]bool)
+	for _, item := range s.items {
+		if item.Status == postgres.BatchItemQueued {
+			queued[item.BatchID] = true
+		}
+	}
+	ids := make([]uuid.UUID, 0, len(queued))
+	for id := range queued {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.batches[ids[i]].CreatedAt.Before(s.batches[ids[j]].CreatedAt)
+	})
+	return ids, nil
+}
+
+// PayoutDestinationStore is in-memory postgres.PayoutDestinationRepository
+type PayoutDestinationStore struct {
+	mu           sync.Mutex
+	destinations []*postgres.PayoutDestination
+}
+
+func NewPayoutDestinationStore() *PayoutDestinationStore {
+	return &PayoutDestinationStore{}
+}
+
+func (s *PayoutDestinationStore) SavePayoutDestination(_ context.Context, destination *postgres.PayoutDestination) (
+	bool, error) {
+	if destination == nil || destination.UserID == "" || destination.Type == "" || destination.Account == "" ||
+		destination.Mask == "" {
+		return false, errors.New("try to save not full payout destination")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, saved := range s.destinations {
+		if saved.UserID == destination.UserID && saved.Type == destination.Type {
+			saved.Account = destination.Account
+			saved.BankID = destination.BankID
+			saved.Mask = destination.Mask
+			saved.UpdatedAt = now
+			destination.ID = saved.ID
+			destination.CreatedAt = saved.CreatedAt
+			destination.UpdatedAt = now
+			return true, nil
+		}
+	}
+	destination.ID = len(s.destinations) + 1
+	destination.CreatedAt = now
+	destination.UpdatedAt = now
+	stored := *destination
+	s.destinations = append(s.destinations, &stored)
+	return false, nil
+}
+
+func (s *PayoutDestinationStore) GetPayoutDestinations(_ context.Context, userID string) (
+	[]*postgres.PayoutDestination, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	destinations := make([]*postgres.PayoutDestination, 0)
+	for _, destination := range s.destinations {
+		if destination.UserID == userID {
+			found := *destination
+			destinations = append(destinations, &found)
+		}
+	}
+	sort.SliceStable(destinations, func(i, j int) bool {
+		return destinations[i].Type < destinations[j].Type
+	})
+	return destinations, nil
+}
+
+func (s *PayoutDestinationStore) GetPayoutDestination(_ context.Context, userID, destinationType string) (
+	*postgres.PayoutDestination, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, destination := range s.destinations {
+		if destination.UserID == userID && destination.Type == destinationType {
+			found := *destination
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// ReceiptStore is in-memory postgres.ReceiptRepository
+type ReceiptStore struct {
+	mu       sync.Mutex
+	receipts []*postgres.Receipt
+}
+
+func NewReceiptStore() *ReceiptStore {
+	return &ReceiptStore{}
+}
+
+func (s *ReceiptStore) InsertReceipt(_ context.Context, receipt *postgres.Receipt) error {
+	if receipt == nil || receipt.Type == "" || receipt.ObjectID == "" || receipt.Status == "" {
+		return errors.New("try to insert not full receipt")
+	}
+	if len(receipt.Items) == 0 {
+		receipt.Items = json.RawMessage("[]")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insert(receipt)
+	return nil
+}
+
+func (s *ReceiptStore) SaveReceiptStatus(_ context.Context, receipt *postgres.Receipt) error {
+	if receipt == nil || receipt.ReceiptID == "" || receipt.ObjectID == "" {
+		return errors.New("try to save status of not full receipt")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *postgres.Receipt
+	for _, saved := range s.receipts {
+		if saved.ReceiptID == receipt.ReceiptID {
+			found = saved
+			break
+		}
+	}
+	if found == nil {
+		for _, saved := range s.receipts {
+			if saved.ObjectID == receipt.ObjectID && saved.Type == receipt.Type && saved.ReceiptID == "" {
+				found = saved
+				break
+			}
+		}
+	}
+	if found == nil {
+		// The receipt was issued outside of the service (e.g. in YooKassa dashboard)
+		s.insert(&postgres.Receipt{
+			ReceiptID: receipt.ReceiptID,
+			Type:      receipt.Type,
+			ObjectID:  receipt.ObjectID,
+			Status:    receipt.Status,
+			Items:     json.RawMessage("[]"),
+		})
+		receipt.ID = len(s.receipts)
+		return nil
+	}
+	found.ReceiptID = receipt.ReceiptID
+	found.Status = receipt.Status
+	found.UpdatedAt = time.Now()
+	receipt.ID = found.ID
+	return nil
+}
+
+func (s *ReceiptStore) GetReceiptsByObjectID(_ context.Context, objectID string) ([]*postgres.Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipts := make([]*postgres.Receipt, 0)
+	for _, receipt := range s.receipts {
+		if receipt.ObjectID == objectID {
+			found := *receipt
+			receipts = append(receipts, &found)
+		}
+	}
+	return receipts, nil
+}
+
+func (s *ReceiptStore) insert(receipt *postgres.Receipt) {
+	receipt.ID = len(s.receipts) + 1
+	receipt.CreatedAt = time.Now()
+	receipt.UpdatedAt = receipt.CreatedAt
+	stored := *receipt
+	s.receipts = append(s.receipts, &stored)
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/redis"
+
+	"github.com/google/uuid"
+)
+
+// StatusStore is in-memory redis.StatusStore. Statuses don't expire,
+// final status is forgotten once it's read like in Redis.
+type StatusStore struct {
+	mu       sync.Mutex
+	statuses map[uuid.UUID]metrics.Status
+	pending  map[string]string
+}
+
+func NewStatusStore() *StatusStore {
+	return &StatusStore{
+		statuses: make(map[uuid.UUID]metrics.Status),
+		pending:  make(map[string]string),
+	}
+}
+
+func (s *StatusStore) CommitTransaction(serverTransactionID uuid.UUID, status metrics.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.statuses[serverTransactionID]; ok {
+		return redis.TransactionAlreadyExistsError
+	}
+	s.statuses[serverTransactionID] = status
+	return nil
+}
+
+func (s *StatusStore) UpdateStatus(serverTransactionID uuid.UUID, status metrics.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.statuses[serverTransactionID]; !ok {
+		return redis.TransactionNotFoundError
+	}
+	s.statuses[serverTransactionID] = status
+	return nil
+}
+
+func (s *StatusStore) GetTransactionStatus(serverTransactionID uuid.UUID, _ metrics.Status) (metrics.Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[serverTransactionID]
+	if !ok {
+		return "", redis.TransactionNotFoundError
+	}
+	if status.IsAlreadyProcessedStatus() {
+		delete(s.statuses, serverTransactionID)
+	}
+	return status, nil
+}
+
+func (s *StatusStore) SavePendingCheck(serverTransactionID uuid.UUID, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[serverTransactionID.String()] = string(data)
+	return nil
+}
+
+func (s *StatusStore) DelPendingCheck(serverTransactionID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, serverTransactionID.String())
+	return nil
+}
+
+func (s *StatusStore) GetPendingChecks() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make(map[string]string, len(s.pending))
+	for id, data := range s.pending {
+		pending[id] = data
+	}
+	return pending, nil
+}
+
+func (s *StatusStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,188 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+)
+
+// SubscriptionStore is in-memory postgres.SubscriptionRepository
+type SubscriptionStore struct {
+	mu     sync.Mutex
+	subs   []*postgres.Subscription
+	cycles []*postgres.BillingCycle
+}
+
+func NewSubscriptionStore() *SubscriptionStore {
+	return &SubscriptionStore{}
+}
+
+func (s *SubscriptionStore) InsertSubscription(_ context.Context, sub *postgres.Subscription) error {
+	if sub == nil || sub.TenantID == "" || sub.LandlordID == "" || sub.PaymentMethodID == 0 ||
+		sub.Amount == "" || sub.Currency == "" || sub.IntervalMonths <= 0 || sub.AnchorDay <= 0 {
+		return errors.New("try to insert not full subscription")
+	}
+	if sub.Status == "" {
+		sub.Status = postgres.SubscriptionActive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub.ID = len(s.subs) + 1
+	sub.CreatedAt = time.Now()
+	sub.UpdatedAt = sub.CreatedAt
+	stored := *sub
+	s.subs = append(s.subs, &stored)
+	return nil
+}
+
+func (s *SubscriptionStore) GetSubscription(_ context.Context, id int) (*postgres.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > len(s.subs) {
+		return nil, nil
+	}
+	found := *s.subs[id-1]
+	return &found, nil
+}
+
+func (s *SubscriptionStore) GetSubscriptionsByTenant(_ context.Context, tenantID string) (
+	[]*postgres.Subscription, error) {
+	return s.selectSubscriptions(func(sub *postgres.Subscription) bool {
+		return sub.TenantID == tenantID
+	}), nil
+}
+
+func (s *SubscriptionStore) SetSubscriptionStatus(_ context.Context, id int, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > len(s.subs) {
+		return false, nil
+	}
+	sub := s.subs[id-1]
+	if sub.Status != postgres.SubscriptionActive && sub.Status != postgres.SubscriptionPastDue {
+		return false, nil
+	}
+	sub.Status = status
+	sub.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *SubscriptionStore) GetDueSubscriptions(_ context.Context, date time.Time) ([]*postgres.Subscription, error) {
+	subs := s.selectSubscriptions(func(sub *postgres.Subscription) bool {
+		return sub.Status == postgres.SubscriptionActive && !sub.NextChargeAt.After(date)
+	})
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[i].NextChargeAt.Before(subs[j].NextChargeAt)
+	})
+	return subs, nil
+}
+
+func (s *SubscriptionStore) OpenCycle(_ context.Context, sub *postgres.Subscription, next *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub.ID <= 0 || sub.ID > len(s.subs) {
+		return errors.New("no such subscription")
+	}
+	opened := false
+	for _, cycle := range s.cycles {
+		if cycle.SubscriptionID == sub.ID && cycle.DueDate.Equal(sub.NextChargeAt) {
+			opened = true
+			break
+		}
+	}
+	now := time.Now()
+	if !opened {
+		s.cycles = append(s.cycles, &postgres.BillingCycle{
+			ID:             len(s.cycles) + 1,
+			SubscriptionID: sub.ID,
+			DueDate:        sub.NextChargeAt,
+			Status:         postgres.CyclePending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+	}
+	stored := s.subs[sub.ID-1]
+	if next == nil {
+		stored.Status = postgres.SubscriptionCompleted
+	} else {
+		stored.NextChargeAt = *next
+	}
+	stored.UpdatedAt = now
+	return nil
+}
+
+func (s *SubscriptionStore) GetOpenCycles(_ context.Context, now time.Time) ([]*postgres.BillingCycle, error) {
+	cycles := s.selectCycles(func(cycle *postgres.BillingCycle) bool {
+		return (cycle.Status == postgres.CyclePending && !cycle.NextAttemptAt.After(now)) ||
+			cycle.Status == postgres.CycleCharging
+	})
+	sort.SliceStable(cycles, func(i, j int) bool {
+		return cycles[i].NextAttemptAt.Before(cycles[j].NextAttemptAt)
+	})
+	return cycles, nil
+}
+
+func (s *SubscriptionStore) UpdateCycle(_ context.Context, cycle *postgres.BillingCycle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cycle.ID <= 0 || cycle.ID > len(s.cycles) {
+		return errors.New("no such billing cycle")
+	}
+	stored := s.cycles[cycle.ID-1]
+	stored.Status = cycle.Status
+	stored.Attempt = cycle.Attempt
+	stored.PaymentID = cycle.PaymentID
+	stored.NextAttemptAt = cycle.NextAttemptAt
+	stored.Error = cycle.Error
+	stored.UpdatedAt = time.Now()
+	cycle.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (s *SubscriptionStore) GetCycles(_ context.Context, subscriptionID int) ([]*postgres.BillingCycle, error) {
+	cycles := s.selectCycles(func(cycle *postgres.BillingCycle) bool {
+		return cycle.SubscriptionID == subscriptionID
+	})
+	sort.SliceStable(cycles, func(i, j int) bool {
+		return cycles[i].DueDate.Before(cycles[j].DueDate)
+	})
+	return cycles, nil
+}
+
+func (s *SubscriptionStore) selectSubscriptions(match func(*postgres.Subscription) bool) []*postgres.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]*postgres.Subscription, 0)
+	for _, sub := range s.subs {
+		if match(sub) {
+			found := *sub
+			subs = append(subs, &found)
+		}
+	}
+	return subs
+}
+
+func (s *SubscriptionStore) selectCycles(match func(*postgres.BillingCycle) bool) []*postgres.BillingCycle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cycles := make([]*postgres.BillingCycle, 0)
+	for _, cycle := range s.cycles {
+		if match(cycle) {
+			found := *cycle
+			cycles = append(cycles, &found)
+		}
+	}
+	return cycles
+}
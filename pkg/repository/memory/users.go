@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// UserStore is in-memory postgres.UserRepository
+type UserStore struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]*models.User
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{
+		users: make(map[uuid.UUID]*models.User),
+	}
+}
+
+func (s *UserStore) RegisterUser(_ context.Context, user *models.User) (bool, error) {
+	if user == nil || user.ExternalID == "" || user.Role == "" || user.Status == "" {
+		return false, errors.New("try to register not full user")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, registered := range s.users {
+		if registered.ExternalID == user.ExternalID {
+			*user = *registered
+			return false, nil
+		}
+	}
+	user.CreatedAt = time.Now()
+	row := *user
+	s.users[user.Id] = &row
+	return true, nil
+}
+
+func (s *UserStore) GetUser(_ context.Context, id uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	row := *user
+	return &row, nil
+}
+
+func (s *UserStore) GetUserByExternalID(_ context.Context, externalID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.ExternalID == externalID {
+			row := *user
+			return &row, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *UserStore) SetUserStatus(_ context.Context, id uuid.UUID, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.Status == status {
+		return false, nil
+	}
+	user.Status = status
+	return true, nil
+}
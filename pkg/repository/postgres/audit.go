@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit event types
+const (
+	AuditCardBound    = "card.bound"
+	AuditCardReplaced = "card.replaced"
+	AuditPayoutCreate = "payout.created"
+	AuditRefundCreate = "refund.created"
+	AuditAdminAction  = "admin.action"
+
+	AuditPaymentMethodSaved   = "payment_method.saved"
+	AuditPaymentMethodRevoked = "payment_method.revoked"
+	AuditSubscriptionCreate   = "subscription.created"
+	AuditSubscriptionCancel   = "subscription.canceled"
+
+	AuditPayoutDestinationSaved = "payout_destination.saved"
+
+	AuditUserRegistered = "user.registered"
+	AuditUserBlocked    = "user.blocked"
+	AuditUserUnblocked  = "user.unblocked"
+	// AuditReconcileFix is a status of the transaction corrected by reconciliation with YooKassa
+	AuditReconcileFix = "reconcile.status_fixed"
+)
+
+// Audited subjects
+const (
+	AuditSubjectCard   = "card"
+	AuditSubjectPayout = "payout"
+	AuditSubjectRefund = "refund"
+	AuditSubjectUser   = "user"
+	AuditSubjectConfig = "config"
+
+	AuditSubjectPaymentMethod = "payment_method"
+	AuditSubjectSubscription  = "subscription"
+	AuditSubjectCommission    = "commission_rule"
+
+	AuditSubjectPayoutDestination = "payout_destination"
+	AuditSubjectPayment           = "payment"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// AuditEvent is an immutable record of a sensitive operation.
+// OldValue and NewValue must never contain secrets (card synonyms, keys),
+// only masked representations of them.
+type AuditEvent struct {
+	ID          int64             `json:"id"`
+	EventType   string            `json:"event_type"`
+	ActorID     string            `json:"actor_id"`
+	SubjectType string            `json:"subject_type"`
+	SubjectID   string            `json:"subject_id"`
+	OldValue    string            `json:"old_value,omitempty"`
+	NewValue    string            `json:"new_value,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	IP          string            `json:"ip"`
+	RequestID   string            `json:"request_id"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// AuditFilter selects audit events, empty fields are ignored
+type AuditFilter struct {
+	EventType   string
+	ActorID     string
+	SubjectType string
+	SubjectID   string
+	From        time.Time
+	To          time.Time
+	Limit       int
+	Offset      int
+}
+
+type AuditRepository interface {
+	InsertAuditEvent(ctx context.Context, event *AuditEvent) error
+	GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]*AuditEvent, error)
+}
+
+type AuditRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewAuditRepository(db *PostgresDB) AuditRepository {
+	return &AuditRepositoryImpl{
+		db: db,
+	}
+}
+
+func (a *AuditRepositoryImpl) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if a.db == nil || a.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if event == nil || event.EventType == "" || event.SubjectType == "" || event.SubjectID == "" {
+		return errors.New("try to insert not full audit event")
+	}
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `INSERT INTO public.audit_events
+		(event_type, actor_id, subject_type, subject_id, old_value, new_value, details, ip, request_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9)
+		RETURNING id, created_at`
+	return a.db.db.QueryRowContext(ctx, query,
+		event.EventType,
+		event.ActorID,
+		event.SubjectType,
+		event.SubjectID,
+		event.OldValue,
+		event.NewValue,
+		details,
+		event.IP,
+		event.RequestID,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+// Page returns limit and offset of the filter: limit is 100 by default and 1000 at most
+func (f *AuditFilter) Page() (limit, offset int) {
+	limit = f.Limit
+	if limit <= 0 {
+		limit = auditDefaultLimit
+	}
+	if limit > auditMaxLimit {
+		limit = auditMaxLimit
+	}
+	offset = f.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func (f *AuditFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.EventType != "" {
+		add("event_type = $%d", f.EventType)
+	}
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.SubjectType != "" {
+		add("subject_type = $%d", f.SubjectType)
+	}
+	if f.SubjectID != "" {
+		add("subject_id = $%d", f.SubjectID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (a *AuditRepositoryImpl) GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]*AuditEvent, error) {
+	if a.db == nil || a.db.db == nil {
+		return nil, errors.New("try to select audit events by using empty db")
+	}
+	if filter == nil {
+		filter = &AuditFilter{}
+	}
+	limit, offset := filter.Page()
+
+	where, args := filter.where()
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT
+			id,
+			event_type,
+			actor_id,
+			subject_type,
+			subject_id,
+			COALESCE(old_value, ''),
+			COALESCE(new_value, ''),
+			details,
+			ip,
+			request_id,
+			created_at
+		FROM public.audit_events
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args))
+
+	rows, err := a.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*AuditEvent, 0)
+	for rows.Next() {
+		event := new(AuditEvent)
+		var details []byte
+		err = rows.Scan(
+			&event.ID,
+			&event.EventType,
+			&event.ActorID,
+			&event.SubjectType,
+			&event.SubjectID,
+			&event.OldValue,
+			&event.NewValue,
+			&details,
+			&event.IP,
+			&event.RequestID,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(details, &event.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -1,20 +1,36 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"strconv"
+	"time"
 
 	"github.com/imperatorofdwelling/Website-backend/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
+// CardStore keeps refillable cards of users, one card per user
+type CardStore interface {
+	// GetRefillableCardByUserID returns nil if the user has no card
+	GetRefillableCardByUserID(ctx context.Context, userId uuid.UUID) (*RefillableCardDBRow, error)
+	// InsertOrUpdateRefillableCard returns true if the card of the user is replaced
+	InsertOrUpdateRefillableCard(ctx context.Context, card *models.RefillableCard) (isUpdated bool, err error)
+}
+
 type RefillableCardDBRow struct {
-	Id          int    `json:"id"`
-	UserId      string `json:"user_id"`
+	Id     int    `json:"id"`
+	UserId string `json:"user_id"`
+	// CardSynonym is decrypted synonym, it's never stored in clear text
 	CardSynonym string `json:"card_synonym"`
 	CardMask    string `json:"card_mask"`
+	// KeyID is ID of data key the synonym is encrypted with, empty for legacy plaintext rows
+	KeyID     string    `json:"key_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 func (c *RefillableCardDBRow) RefillableCardDBRowToCardRecord() (*models.RefillableCard, error) {
@@ -36,27 +52,45 @@ func (c *RefillableCardDBRow) RefillableCardDBRowToCardRecord() (*models.Refilla
 	}, err
 }
 
-func getInsertQueryOfRefillableCard(card *models.RefillableCard) string {
-	ownerID := card.Owner.Id
-	var query string
-	query = fmt.Sprintf(`
-		INSERT INTO users_card (
-		    user_id,
-			card_synonym,
-		    card_mask
-		) VALUES (
-			'%v',
-		    '%v',
-		    '%v'
-		)`,
-		ownerID.String(),
-		card.Synonym,
-		card.CardMask,
-	)
-	return query
+// encryptSynonym returns ciphertext of card synonym and ID of data key
+func (db *PostgresDB) encryptSynonym(synonym string) (string, string, error) {
+	if db.cardCipher == nil {
+		return "", "", errNoCardCipher
+	}
+	return db.cardCipher.Encrypt(synonym)
 }
 
-func (db *PostgresDB) InsertNewRefillableCard(card *models.RefillableCard) (*RefillableCardDBRow, error) {
+// decryptRow replaces ciphertext of the row with synonym
+func (db *PostgresDB) decryptRow(ctx context.Context, row *RefillableCardDBRow) error {
+	if row.KeyID == "" {
+		// legacy plaintext row, it will be encrypted by cmd/rekey
+		return nil
+	}
+	if db.cardCipher == nil {
+		return errNoCardCipher
+	}
+	synonym, err := db.cardCipher.Decrypt(ctx, row.CardSynonym, row.KeyID)
+	if err != nil {
+		return err
+	}
+	row.CardSynonym = synonym
+	return nil
+}
+
+const selectRefillableCardQuery = `
+	SELECT
+		id,
+		user_id,
+		card_synonym,
+		card_mask,
+		COALESCE(key_id, ''),
+		created_at,
+		updated_at
+	FROM users_card
+`
+
+func (db *PostgresDB) InsertNewRefillableCard(ctx context.Context, card *models.RefillableCard) (
+	*RefillableCardDBRow, error) {
 	if db == nil || db.db == nil {
 		return nil, errors.New("nil DB")
 	}
@@ -64,168 +98,178 @@ func (db *PostgresDB) InsertNewRefillableCard(card *models.RefillableCard) (*Ref
 		return nil, errors.New("try to insert not full card data")
 	}
 
-	query := getInsertQueryOfRefillableCard(card)
-	db.Lock()
-	defer db.Unlock()
-	_, err := db.db.Exec(query)
+	encryptedSynonym, keyID, err := db.encryptSynonym(card.Synonym)
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO users_card (user_id, card_synonym, card_mask, key_id)
+		VALUES ($1, $2, $3, $4)`
+	_, err = db.db.ExecContext(ctx, query, card.Owner.Id, encryptedSynonym, card.CardMask, keyID)
 	if err != nil {
 		return nil, err
 	}
-	return db.GetRefillableCardByUserID(card.Owner.Id)
+	return db.GetRefillableCardByUserID(ctx, card.Owner.Id)
 }
 
-func (db *PostgresDB) InsertOrUpdateRefillableCard(card *models.RefillableCard) (isUpdated bool, err error) {
+// InsertOrUpdateRefillableCard replaces the card of the user in one statement, users_card.user_id is unique
+func (db *PostgresDB) InsertOrUpdateRefillableCard(ctx context.Context, card *models.RefillableCard) (
+	isUpdated bool, err error) {
 	if db == nil || db.db == nil {
 		return false, errors.New("nil DB")
 	}
 	if isFullDataOfNewCard := card.IsFullData(); !isFullDataOfNewCard {
 		return false, errors.New("try to insert not full card data")
 	}
-
-	result, err := db.GetRefillableCardByUser(card.Owner)
+	encryptedSynonym, keyID, err := db.encryptSynonym(card.Synonym)
 	if err != nil {
 		return false, err
 	}
-	if result == nil {
-		_, err = db.InsertNewRefillableCard(card)
-		return false, err
-	}
 
-	_, err = db.UpdateRefillableCardInfo(card)
-	return true, err
+	// xmax is set for the updated row, it's zero for the inserted one
+	query := `INSERT INTO users_card (user_id, card_synonym, card_mask, key_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET card_synonym = EXCLUDED.card_synonym,
+			card_mask = EXCLUDED.card_mask,
+			key_id = EXCLUDED.key_id,
+			updated_at = now()
+		RETURNING xmax <> 0`
+	err = db.db.QueryRowContext(ctx, query, card.Owner.Id, encryptedSynonym, card.CardMask, keyID).Scan(&isUpdated)
+	return isUpdated, err
 }
 
-func (db *PostgresDB) GetRefillableCardByUser(user *models.User) (*RefillableCardDBRow, error) {
+func (db *PostgresDB) GetRefillableCardByUser(ctx context.Context, user *models.User) (*RefillableCardDBRow, error) {
 	if user == nil {
 		return nil, errors.New("try to search refillable card for empty user")
 	}
 	userID := user.Id
-	return db.GetRefillableCardByUserID(userID)
+	return db.GetRefillableCardByUserID(ctx, userID)
 }
 
-func getSelectQueryByUserID(userID uuid.UUID) string {
-	stringUserID := userID.String()
-	var query string
-	query = fmt.Sprintf(`
-		SELECT
-			id,
-			user_id,
-			card_synonym,
-			card_mask
-		FROM users_card
-		WHERE user_id = '%v'
-	`, stringUserID)
-	return query
+func (db *PostgresDB) GetRefillableCardByUserID(ctx context.Context, userId uuid.UUID) (*RefillableCardDBRow, error) {
+	if db == nil || db.db == nil {
+		return nil, errors.New("try to select card by using empty db")
+	}
+	return db.selectRefillableCard(ctx, selectRefillableCardQuery+`WHERE user_id = $1`, userId)
 }
 
-func (db *PostgresDB) GetRefillableCardByUserID(userId uuid.UUID) (*RefillableCardDBRow, error) {
+func (db *PostgresDB) getRefillableCardByRowID(ctx context.Context, rowId int64) (*RefillableCardDBRow, error) {
 	if db == nil || db.db == nil {
 		return nil, errors.New("try to select card by using empty db")
 	}
-	executedQuery := getSelectQueryByUserID(userId)
-	// Lock, defer unlock
-	if db.TryLock() {
-		defer db.Unlock()
-	}
-	rows, err := db.db.Query(executedQuery)
+	return db.selectRefillableCard(ctx, selectRefillableCardQuery+`WHERE id = $1`, rowId)
+}
+
+// selectRefillableCard returns the first card of the query, nil if there is none
+func (db *PostgresDB) selectRefillableCard(ctx context.Context, query string, args ...any) (
+	*RefillableCardDBRow, error) {
+	rows, err := db.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// check is empty data
-	copyRow := rows
-	if !copyRow.Next() {
-		return nil, nil
+	if !rows.Next() {
+		return nil, rows.Err()
 	}
 	row := RefillableCardDBRow{}
 	err = rows.Scan(&row.Id,
 		&row.UserId,
 		&row.CardSynonym,
-		&row.CardMask)
+		&row.CardMask,
+		&row.KeyID,
+		&row.CreatedAt,
+		&row.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	if err = db.decryptRow(ctx, &row); err != nil {
+		return nil, err
+	}
 	return &row, err
 }
 
-func getSelectQueryByRowID(rowID int64) string {
-	stringRowID := strconv.FormatInt(rowID, 10)
-	var query string
-	query = fmt.Sprintf(`
-		SELECT
-			id,
-			user_id,
-			card_synonym,
-			card_mask
-		FROM users_card
-		WHERE id = '%v'
-	`, stringRowID)
-	return query
-}
-
-func (db *PostgresDB) getRefillableCardByRowID(rowId int64) (*RefillableCardDBRow, error) {
+func (db *PostgresDB) UpdateRefillableCardInfo(ctx context.Context, card *models.RefillableCard) (
+	*RefillableCardDBRow, error) {
 	if db == nil || db.db == nil {
-		return nil, errors.New("try to select card by using empty db")
+		return nil, errors.New("try to update with nil database")
 	}
-	executedQuery := getSelectQueryByRowID(rowId)
-	// Lock, defer unlock
-	if db.TryLock() {
-		defer db.Unlock()
+	if isFullDataOfCard := card.IsFullData(); !isFullDataOfCard {
+		return nil, errors.New("try to update card with not full data")
 	}
-	rows, err := db.db.Query(executedQuery)
+	encryptedSynonym, keyID, err := db.encryptSynonym(card.Synonym)
 	if err != nil {
 		return nil, err
 	}
 
-	row := RefillableCardDBRow{}
-	err = rows.Scan(&row.Id,
-		&row.UserId,
-		&row.CardSynonym,
-		&row.CardMask)
+	query := `UPDATE users_card
+		SET card_synonym = $1, card_mask = $2, key_id = $3, updated_at = now()
+		WHERE user_id = $4
+		RETURNING id`
+	var rowID int64
+	err = db.db.QueryRowContext(ctx, query, encryptedSynonym, card.CardMask, keyID, card.Owner.Id).Scan(&rowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("invalid usage of update. Use Insert.")
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &row, err
-}
-
-func getUpdateQuery(card *models.RefillableCard) string {
-	var query string
-	query = fmt.Sprintf(`
-		UPDATE users_card
-		SET
-			card_synonym = '%v',
-			card_mask = '%v'
-		WHERE user_id = '%v'
-	`, card.Synonym, card.CardMask, card.Owner.Id.String())
-	return query
+	return db.getRefillableCardByRowID(ctx, rowID)
 }
 
-func (db *PostgresDB) UpdateRefillableCardInfo(card *models.RefillableCard) (
-	*RefillableCardDBRow, error) {
+// ReEncryptRefillableCards re-encrypts every synonym which isn't encrypted with the active data key
+// (including legacy plaintext rows) in one transaction. It returns the number of updated rows.
+func (db *PostgresDB) ReEncryptRefillableCards(ctx context.Context) (int, error) {
 	if db == nil || db.db == nil {
-		return nil, errors.New("try to update with nil database")
+		return 0, errors.New("try to re-encrypt cards with nil database")
 	}
-	if isFullDataOfCard := card.IsFullData(); !isFullDataOfCard {
-		return nil, errors.New("try to update card with not full data")
-	}
-	// Check is containing data
-	// If not, return err
-	result, err := db.GetRefillableCardByUser(card.Owner)
-	if result == nil || err != nil {
-		return nil, errors.New("invalid usage of update. Use Insert.")
+	if db.cardCipher == nil {
+		return 0, errNoCardCipher
 	}
+	activeKeyID := db.cardCipher.ActiveKeyID()
 
-	// If result is containing,
-	//when we update the values of the card, is will be stable.
-	// Ok, got it
-	rowID := result.Id
-	executedQuery := getUpdateQuery(card)
-	if db.TryLock() {
-		defer db.Unlock()
-	}
-	_, err = db.db.Exec(executedQuery)
+	var outdated []RefillableCardDBRow
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, card_synonym, COALESCE(key_id, '')
+			FROM users_card
+			WHERE key_id IS DISTINCT FROM $1
+			FOR UPDATE`, activeKeyID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			row := RefillableCardDBRow{}
+			if err = rows.Scan(&row.Id, &row.CardSynonym, &row.KeyID); err != nil {
+				rows.Close()
+				return err
+			}
+			outdated = append(outdated, row)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range outdated {
+			row := &outdated[i]
+			if err = db.decryptRow(ctx, row); err != nil {
+				return fmt.Errorf("failed to decrypt card %v: %w", row.Id, err)
+			}
+			encryptedSynonym, keyID, err := db.encryptSynonym(row.CardSynonym)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE users_card SET card_synonym = $1, key_id = $2 WHERE id = $3`,
+				encryptedSynonym, keyID, row.Id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	return db.getRefillableCardByRowID(int64(rowID))
+	return len(outdated), nil
 }
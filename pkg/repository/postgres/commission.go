@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CommissionRule is fee of the platform: Percent of the payment plus Fixed, limited by MinFee and MaxFee.
+// It applies to the landlord, or to the property tier, or it's the default rule if both are empty.
+type CommissionRule struct {
+	ID         int       `json:"id"`
+	LandlordID string    `json:"landlord_id,omitempty"`
+	Tier       string    `json:"tier,omitempty"`
+	Percent    string    `json:"percent"`
+	Fixed      string    `json:"fixed"`
+	MinFee     string    `json:"min_fee,omitempty"`
+	MaxFee     string    `json:"max_fee,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CommissionRepository interface {
+	GetCommissionRules(ctx context.Context) ([]*CommissionRule, error)
+	// FindCommissionRule returns the rule of the landlord, or of the tier, or the default one,
+	// nil if there is no rule
+	FindCommissionRule(ctx context.Context, landlordID, tier string) (*CommissionRule, error)
+	// SaveCommissionRule inserts the rule or replaces the rule of the same landlord or tier
+	SaveCommissionRule(ctx context.Context, rule *CommissionRule) error
+}
+
+type CommissionRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewCommissionRepository(db *PostgresDB) CommissionRepository {
+	return &CommissionRepositoryImpl{
+		db: db,
+	}
+}
+
+func (c *CommissionRepositoryImpl) GetCommissionRules(ctx context.Context) ([]*CommissionRule, error) {
+	return c.selectRules(ctx, `ORDER BY id`)
+}
+
+func (c *CommissionRepositoryImpl) FindCommissionRule(ctx context.Context, landlordID, tier string) (
+	*CommissionRule, error) {
+	rules, err := c.selectRules(ctx, `
+		WHERE landlord_id = $1
+			OR (landlord_id IS NULL AND tier = $2)
+			OR (landlord_id IS NULL AND tier IS NULL)
+		ORDER BY landlord_id IS NULL, tier IS NULL
+		LIMIT 1`, landlordID, tier)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return rules[0], nil
+}
+
+func (c *CommissionRepositoryImpl) SaveCommissionRule(ctx context.Context, rule *CommissionRule) error {
+	if c.db == nil || c.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if rule == nil || rule.Percent == "" || rule.Fixed == "" {
+		return errors.New("try to save not full commission rule")
+	}
+	if rule.LandlordID != "" && rule.Tier != "" {
+		return errors.New("commission rule applies to landlord or tier, not both")
+	}
+
+	query := `INSERT INTO public.commission_rules (landlord_id, tier, percent, fixed, min_fee, max_fee)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, NULLIF($5, '')::numeric, NULLIF($6, '')::numeric)
+		ON CONFLICT (COALESCE(landlord_id, ''), COALESCE(tier, '')) DO UPDATE
+		SET percent = EXCLUDED.percent,
+			fixed = EXCLUDED.fixed,
+			min_fee = EXCLUDED.min_fee,
+			max_fee = EXCLUDED.max_fee,
+			updated_at = now()
+		RETURNING id, updated_at`
+	return c.db.db.QueryRowContext(ctx, query,
+		rule.LandlordID,
+		rule.Tier,
+		rule.Percent,
+		rule.Fixed,
+		rule.MinFee,
+		rule.MaxFee,
+	).Scan(&rule.ID, &rule.UpdatedAt)
+}
+
+func (c *CommissionRepositoryImpl) selectRules(ctx context.Context, where string, args ...any) (
+	[]*CommissionRule, error) {
+	if c.db == nil || c.db.db == nil {
+		return nil, errors.New("try to select commission rules by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			COALESCE(landlord_id, ''),
+			COALESCE(tier, ''),
+			percent,
+			fixed,
+			min_fee,
+			max_fee,
+			updated_at
+		FROM public.commission_rules
+	` + where
+	rows, err := c.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*CommissionRule, 0)
+	for rows.Next() {
+		rule := new(CommissionRule)
+		var minFee, maxFee sql.NullString
+		err = rows.Scan(
+			&rule.ID,
+			&rule.LandlordID,
+			&rule.Tier,
+			&rule.Percent,
+			&rule.Fixed,
+			&minFee,
+			&maxFee,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rule.MinFee = minFee.String
+		rule.MaxFee = maxFee.String
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
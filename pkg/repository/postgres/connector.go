@@ -1,12 +1,12 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"os"
-	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -16,36 +16,54 @@ const (
 	usersTable = "users"
 )
 
+// SchemaVersion is the migration version the code expects to work with
+const SchemaVersion = 16
+
+// MaxSchemaVersion is the newest applied migration the code still works with. Migrations must be
+// backward compatible with the previous release (tables and nullable columns are added, removed ones
+// are dropped a release later), so replicas of the previous release stay ready while the next release
+// is migrated and rolled out. A release must not add more than MaxSchemaVersion-SchemaVersion migrations.
+const MaxSchemaVersion = SchemaVersion + 3
+
 type PostgresSQLConfig struct {
-	DBHost     string
-	DBPort     string
-	DBUsername string
-	DBPassword string
-	DBName     string
-	DBSSLMode  string
+	DBHost     string `yaml:"host" env:"POSTGRES_HOST" required:"true"`
+	DBPort     string `yaml:"port" env:"POSTGRES_PORT" default:"5432"`
+	DBUsername string `yaml:"user" env:"POSTGRES_USER" required:"true"`
+	DBPassword string `yaml:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	DBName     string `yaml:"name" env:"POSTGRES_DB" required:"true"`
+	DBSSLMode  string `yaml:"sslMode" env:"POSTGRES_DB_SSL" default:"disable"`
+	// MaxOpenConns limits connections of the pool, queries wait for a free one
+	MaxOpenConns int `yaml:"maxOpenConns" env:"POSTGRES_MAX_OPEN_CONNS" default:"20"`
+	// MaxIdleConns is kept open between queries, it can't exceed MaxOpenConns
+	MaxIdleConns    int           `yaml:"maxIdleConns" env:"POSTGRES_MAX_IDLE_CONNS" default:"10"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" env:"POSTGRES_CONN_MAX_LIFETIME" default:"30m"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime" env:"POSTGRES_CONN_MAX_IDLE_TIME" default:"5m"`
+	// ConnectTimeout bounds dialing and the first ping
+	ConnectTimeout time.Duration `yaml:"connectTimeout" env:"POSTGRES_CONNECT_TIMEOUT" default:"5s"`
+	// StatementTimeout aborts queries running longer on the server side, 0 disables it
+	StatementTimeout time.Duration `yaml:"statementTimeout" env:"POSTGRES_STATEMENT_TIMEOUT" default:"30s"`
 }
 
-func LoadConfig() (*PostgresSQLConfig, error) {
-	host := os.Getenv("POSTGRES_HOST")
-	port := os.Getenv("POSTGRES_PORT")
-	user := os.Getenv("POSTGRES_USER")
-	pass := os.Getenv("POSTGRES_PASSWORD")
-	dbName := os.Getenv("POSTGRES_DB")
-	sslMode := os.Getenv("POSTGRES_DB_SSL")
-
-	return &PostgresSQLConfig{
-		DBHost:     host,
-		DBPort:     port,
-		DBUsername: user,
-		DBPassword: pass,
-		DBName:     dbName,
-		DBSSLMode:  sslMode,
-	}, nil
+func (c *PostgresSQLConfig) Validate() error {
+	if c.MaxOpenConns <= 0 || c.MaxIdleConns < 0 {
+		return errors.New("maxOpenConns must be positive and maxIdleConns can't be negative")
+	}
+	if c.MaxIdleConns > c.MaxOpenConns {
+		return fmt.Errorf("maxIdleConns (%d) can't exceed maxOpenConns (%d)", c.MaxIdleConns, c.MaxOpenConns)
+	}
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 || c.StatementTimeout < 0 {
+		return errors.New("connMaxLifetime, connMaxIdleTime and statementTimeout can't be negative")
+	}
+	if c.ConnectTimeout < time.Second {
+		return errors.New("connectTimeout must be at least 1s")
+	}
+	return nil
 }
 
+// PostgresDB is safe for concurrent use, queries run on connections of the pool
 type PostgresDB struct {
-	sync.Mutex
-	db *sqlx.DB
+	db         *sqlx.DB
+	cardCipher CardCipher
 }
 
 const (
@@ -68,32 +86,55 @@ func InitPostgresDB(cfg *PostgresSQLConfig) error {
 	if currDB != nil {
 		return errors.New("the database is already initialized")
 	}
+	db, err := Connect(cfg)
+	if err != nil {
+		return err
+	}
+	currDB = db
+	return nil
+}
+
+// Connect opens connection pool of the database, it isn't shared through GetDB
+func Connect(cfg *PostgresSQLConfig) (*PostgresDB, error) {
 	if cfg == nil {
-		return errors.New("config is empty")
+		return nil, errors.New("config is empty")
 	}
 
 	var connectionString string
 	connectionString = fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d statement_timeout=%d",
 		cfg.DBHost,
 		cfg.DBPort,
 		cfg.DBUsername,
 		cfg.DBPassword,
 		cfg.DBName,
-		cfg.DBSSLMode)
+		cfg.DBSSLMode,
+		int(cfg.ConnectTimeout.Seconds()),
+		cfg.StatementTimeout.Milliseconds())
 	sqlxDB, err := sqlx.Open("postgres", connectionString)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if err = sqlxDB.Ping(); err != nil {
-		return err
+	sqlxDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlxDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlxDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlxDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	if err = sqlxDB.PingContext(ctx); err != nil {
+		_ = sqlxDB.Close()
+		return nil, err
 	}
+	return &PostgresDB{db: sqlxDB}, nil
+}
 
-	currDB = &PostgresDB{
-		db: sqlxDB,
+// Close closes connections of the pool
+func (db *PostgresDB) Close() error {
+	if db == nil || db.db == nil {
+		return errors.New("nil DB")
 	}
-	return nil
+	return db.db.Close()
 }
 
 func GetDB() (*PostgresDB, bool) {
@@ -103,13 +144,48 @@ func GetDB() (*PostgresDB, bool) {
 	return currDB, true
 }
 
+func (db *PostgresDB) PingContext(ctx context.Context) error {
+	if db == nil || db.db == nil {
+		return errors.New("nil DB")
+	}
+	return db.db.PingContext(ctx)
+}
+
+// Stats returns connection pool statistics
+func (db *PostgresDB) Stats() sql.DBStats {
+	return db.db.Stats()
+}
+
+// GetSchemaVersion returns the applied migration version from golang-migrate table
+func (db *PostgresDB) GetSchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	if db == nil || db.db == nil {
+		return 0, false, errors.New("nil DB")
+	}
+	err = db.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
 func Disconnect() error {
 	db, isContains := GetDB()
 	if !isContains || db.db == nil {
 		return errors.New("the database is already initialized")
 	}
-	db.Lock()
-	defer db.Unlock()
-	err := db.db.Close()
-	return err
+	return db.Close()
+}
+
+// inTx runs fn in a transaction, it's committed if fn succeeds and rolled back otherwise
+func (db *PostgresDB) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
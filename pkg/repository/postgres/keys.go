@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CardCipher encrypts card synonyms before they reach the database
+type CardCipher interface {
+	Encrypt(plaintext string) (ciphertext string, keyID string, err error)
+	Decrypt(ctx context.Context, ciphertext string, keyID string) (string, error)
+	ActiveKeyID() string
+}
+
+var errNoCardCipher = errors.New("card cipher isn't configured")
+
+// SetCardCipher must be called before any card is read or written
+func (db *PostgresDB) SetCardCipher(c CardCipher) {
+	db.cardCipher = c
+}
+
+// KeyRepositoryImpl stores wrapped data keys of keyring
+type KeyRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewKeyRepository(db *PostgresDB) keyring.KeyStore {
+	return &KeyRepositoryImpl{
+		db: db,
+	}
+}
+
+func (k *KeyRepositoryImpl) GetDataKeys(ctx context.Context) ([]*keyring.DataKey, error) {
+	if k.db == nil || k.db.db == nil {
+		return nil, errors.New("try to select data keys by using empty db")
+	}
+	query := `SELECT id, master_key_id, wrapped_key, is_active, created_at FROM public.encryption_keys`
+	rows, err := k.db.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*keyring.DataKey, 0)
+	for rows.Next() {
+		key := new(keyring.DataKey)
+		if err = rows.Scan(&key.ID, &key.MasterKeyID, &key.WrappedKey, &key.IsActive, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (k *KeyRepositoryImpl) InsertActiveDataKey(ctx context.Context, key *keyring.DataKey) error {
+	if k.db == nil || k.db.db == nil {
+		return errors.New("nil DB")
+	}
+	return k.db.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE public.encryption_keys SET is_active = false, retired_at = now() WHERE is_active`)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO public.encryption_keys (id, master_key_id, wrapped_key, is_active)
+			VALUES ($1, $2, $3, true)
+			RETURNING created_at`,
+			key.ID, key.MasterKeyID, key.WrappedKey,
+		).Scan(&key.CreatedAt)
+	})
+}
@@ -0,0 +1,191 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PlatformAccount is the ledger account of platform commissions
+const PlatformAccount = "platform"
+
+// Kinds of ledger entries
+const (
+	LedgerPlatformFee   = "platform_fee"
+	LedgerLandlordShare = "landlord_share"
+	LedgerPayout        = "payout"
+	// LedgerPayoutReversal returns money of the canceled payout
+	LedgerPayoutReversal = "payout_reversal"
+)
+
+// LandlordAccount returns the ledger account of the landlord
+func LandlordAccount(landlordID string) string {
+	return "landlord:" + landlordID
+}
+
+// LedgerEntry is a movement of money of the account, Amount is negative for debits
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	Account   string    `json:"account"`
+	Amount    string    `json:"amount"`
+	Currency  string    `json:"currency"`
+	Kind      string    `json:"kind"`
+	Reference string    `json:"reference"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LedgerRepository interface {
+	// InsertEntries records legs of one operation in one transaction. Repeated operation
+	// (an entry with the same reference, account and kind exists) isn't recorded, false is returned then.
+	InsertEntries(ctx context.Context, entries []*LedgerEntry) (bool, error)
+	// Debit records negative entry if the account balance is sufficient, false is returned otherwise
+	Debit(ctx context.Context, entry *LedgerEntry) (bool, error)
+	GetBalance(ctx context.Context, account, currency string) (string, error)
+	// GetEntries returns the last entries of the account
+	GetEntries(ctx context.Context, account string, limit int) ([]*LedgerEntry, error)
+	// GetEntriesByReference returns entries of the YooKassa object, e.g. split of the payment
+	GetEntriesByReference(ctx context.Context, reference string) ([]*LedgerEntry, error)
+}
+
+type LedgerRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewLedgerRepository(db *PostgresDB) LedgerRepository {
+	return &LedgerRepositoryImpl{
+		db: db,
+	}
+}
+
+const insertLedgerEntryQuery = `INSERT INTO public.ledger_entries (account, amount, currency, kind, reference)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (reference, account, kind) DO NOTHING
+	RETURNING id, created_at`
+
+func (l *LedgerRepositoryImpl) InsertEntries(ctx context.Context, entries []*LedgerEntry) (bool, error) {
+	if l.db == nil || l.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	for _, e := range entries {
+		if e.Account == "" || e.Amount == "" || e.Currency == "" || e.Kind == "" || e.Reference == "" {
+			return false, errors.New("try to insert not full ledger entry")
+		}
+	}
+
+	tx, err := l.db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		rows, err := tx.QueryContext(ctx, insertLedgerEntryQuery, e.Account, e.Amount, e.Currency, e.Kind, e.Reference)
+		if err != nil {
+			return false, err
+		}
+		inserted := rows.Next()
+		if inserted {
+			err = rows.Scan(&e.ID, &e.CreatedAt)
+		}
+		rows.Close()
+		if err != nil {
+			return false, err
+		}
+		if !inserted {
+			// The operation is already recorded, its other legs are too
+			return false, nil
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (l *LedgerRepositoryImpl) Debit(ctx context.Context, entry *LedgerEntry) (bool, error) {
+	if l.db == nil || l.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	if entry.Account == "" || entry.Amount == "" || entry.Currency == "" || entry.Kind == "" || entry.Reference == "" {
+		return false, errors.New("try to insert not full ledger entry")
+	}
+
+	tx, err := l.db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Debits of the account are serialized, so the balance can't become negative
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, entry.Account); err != nil {
+		return false, err
+	}
+	var sufficient bool
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) + $1::numeric >= 0
+		FROM public.ledger_entries
+		WHERE account = $2 AND currency = $3`,
+		entry.Amount, entry.Account, entry.Currency,
+	).Scan(&sufficient)
+	if err != nil || !sufficient {
+		return false, err
+	}
+	err = tx.QueryRowContext(ctx, insertLedgerEntryQuery,
+		entry.Account, entry.Amount, entry.Currency, entry.Kind, entry.Reference,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (l *LedgerRepositoryImpl) GetBalance(ctx context.Context, account, currency string) (string, error) {
+	if l.db == nil || l.db.db == nil {
+		return "", errors.New("try to select balance by using empty db")
+	}
+
+	var balance string
+	err := l.db.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0)::numeric(12,2)::text
+		FROM public.ledger_entries
+		WHERE account = $1 AND currency = $2`,
+		account, currency,
+	).Scan(&balance)
+	return balance, err
+}
+
+func (l *LedgerRepositoryImpl) GetEntries(ctx context.Context, account string, limit int) ([]*LedgerEntry, error) {
+	return l.selectEntries(ctx, `WHERE account = $1 ORDER BY id DESC LIMIT $2`, account, limit)
+}
+
+func (l *LedgerRepositoryImpl) GetEntriesByReference(ctx context.Context, reference string) ([]*LedgerEntry, error) {
+	return l.selectEntries(ctx, `WHERE reference = $1 ORDER BY id`, reference)
+}
+
+func (l *LedgerRepositoryImpl) selectEntries(ctx context.Context, where string, args ...any) ([]*LedgerEntry, error) {
+	if l.db == nil || l.db.db == nil {
+		return nil, errors.New("try to select ledger entries by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			account,
+			amount,
+			currency,
+			kind,
+			reference,
+			created_at
+		FROM public.ledger_entries
+	` + where
+	rows, err := l.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*LedgerEntry, 0)
+	for rows.Next() {
+		e := new(LedgerEntry)
+		if err = rows.Scan(&e.ID, &e.Account, &e.Amount, &e.Currency, &e.Kind, &e.Reference, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -1,6 +1,20 @@
 package postgres
 
-import "time"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultLogCurrency is the currency of transactions logged without one
+const DefaultLogCurrency = "RUB"
+
+// Kinds of logged transactions
+const (
+	LogKindPayment = "payment"
+	LogKindRefund  = "refund"
+	LogKindPayout  = "payout"
+)
 
 type Log struct {
 	ID            int       `json:"id"`
@@ -8,6 +22,12 @@ type Log struct {
 	Amount        string    `json:"amount"`
 	Status        string    `json:"status"`
 	Time          time.Time `json:"time"`
+	// TraceID links the transaction to the trace of the request which created it
+	TraceID string `json:"trace_id"`
+	// Kind is payment (by default) or refund
+	Kind string `json:"kind"`
+	// Currency is RUB by default
+	Currency string `json:"currency"`
 }
 
 func NewLog(id string, amount string, status string, time time.Time) *Log {
@@ -16,11 +36,20 @@ func NewLog(id string, amount string, status string, time time.Time) *Log {
 		Amount:        amount,
 		Status:        status,
 		Time:          time,
+		Kind:          LogKindPayment,
+		Currency:      DefaultLogCurrency,
 	}
 }
 
 type LogRepository interface {
-	InsertLog(log *Log) error
+	InsertLog(ctx context.Context, log *Log) error
+	// UpdateLogStatus sets status of the logged transaction by its YooKassa ID,
+	// false is returned if there is no such transaction
+	UpdateLogStatus(ctx context.Context, transactionID string, status string) (bool, error)
+	// GetLogs returns transactions of the kind created in [from, to)
+	GetLogs(ctx context.Context, kind string, from, to time.Time) ([]*Log, error)
+	// GetLog returns the transaction by its YooKassa ID, nil if there is no such transaction
+	GetLog(ctx context.Context, transactionID string) (*Log, error)
 }
 
 type LogRepositoryImpl struct {
@@ -33,13 +62,86 @@ func NewLogRepository(db *PostgresDB) LogRepository {
 	}
 }
 
-func (l *LogRepositoryImpl) InsertLog(log *Log) error {
-	query := `INSERT INTO public.logs (transaction_id, amount, status, time) VALUES ($1, $2, $3, $4) RETURNING id`
-	l.db.Lock()
-	defer l.db.Unlock()
-	err := l.db.db.QueryRow(query, log.TransactionID, log.Amount, log.Status, log.Time).Scan(&log.ID)
+func (l *LogRepositoryImpl) InsertLog(ctx context.Context, log *Log) error {
+	if l.db == nil || l.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if log.Kind == "" {
+		log.Kind = LogKindPayment
+	}
+	if log.Currency == "" {
+		log.Currency = DefaultLogCurrency
+	}
+	query := `INSERT INTO public.logs (transaction_id, amount, status, time, trace_id, kind, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := l.db.db.QueryRowContext(ctx, query, log.TransactionID, log.Amount, log.Status, log.Time, log.TraceID, log.Kind,
+		log.Currency).Scan(&log.ID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (l *LogRepositoryImpl) UpdateLogStatus(ctx context.Context, transactionID string, status string) (bool, error) {
+	if l.db == nil || l.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	query := `UPDATE public.logs SET status = $1 WHERE transaction_id = $2`
+	res, err := l.db.db.ExecContext(ctx, query, status, transactionID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (l *LogRepositoryImpl) GetLogs(ctx context.Context, kind string, from, to time.Time) ([]*Log, error) {
+	return l.selectLogs(ctx, `WHERE kind = $1 AND time >= $2 AND time < $3 ORDER BY time, id`, kind, from, to)
+}
+
+func (l *LogRepositoryImpl) GetLog(ctx context.Context, transactionID string) (*Log, error) {
+	logs, err := l.selectLogs(ctx, `WHERE transaction_id = $1`, transactionID)
+	if err != nil || len(logs) == 0 {
+		return nil, err
+	}
+	return logs[0], nil
+}
+
+func (l *LogRepositoryImpl) selectLogs(ctx context.Context, where string, args ...any) ([]*Log, error) {
+	if l.db == nil || l.db.db == nil {
+		return nil, errors.New("try to select logs by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			transaction_id,
+			amount::text,
+			status,
+			time,
+			trace_id,
+			kind,
+			currency
+		FROM public.logs
+	` + where
+	rows, err := l.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := make([]*Log, 0)
+	for rows.Next() {
+		log := new(Log)
+		err = rows.Scan(&log.ID, &log.TransactionID, &log.Amount, &log.Status, &log.Time, &log.TraceID, &log.Kind,
+			&log.Currency)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"net/url"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationsFS has the migrations in the binary, so they don't depend on the working directory
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// MigrationsSource returns source driver of the embedded migrations
+func MigrationsSource() (source.Driver, error) {
+	return iofs.New(migrationsFS, "migrations")
+}
+
+// NewMigrate connects golang-migrate to the database with the embedded migrations
+func NewMigrate(cfg *PostgresSQLConfig) (*migrate.Migrate, error) {
+	src, err := MigrationsSource()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithSourceInstance("iofs", src, cfg.URL())
+}
+
+// URL returns connection URL of the database in the format of golang-migrate
+func (c *PostgresSQLConfig) URL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.DBUsername, c.DBPassword),
+		Host:     c.DBHost + ":" + c.DBPort,
+		Path:     c.DBName,
+		RawQuery: url.Values{"sslmode": {c.DBSSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// CheckSchemaVersion returns error if the applied migration is dirty or it isn't
+// in [SchemaVersion, MaxSchemaVersion]
+func CheckSchemaVersion(version uint, dirty bool) error {
+	if dirty {
+		return fmt.Errorf("migration %v is dirty", version)
+	}
+	if version < SchemaVersion || version > MaxSchemaVersion {
+		return fmt.Errorf("schema version %v, expected %v-%v", version, SchemaVersion, MaxSchemaVersion)
+	}
+	return nil
+}
+
+// CheckSchema refuses to work with the database which isn't migrated to SchemaVersion
+// or is migrated beyond MaxSchemaVersion
+func (db *PostgresDB) CheckSchema(ctx context.Context) error {
+	version, dirty, err := db.GetSchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return CheckSchemaVersion(version, dirty)
+}
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON public.audit_events;
+DROP TRIGGER IF EXISTS audit_events_no_update ON public.audit_events;
+DROP FUNCTION IF EXISTS public.audit_events_immutable();
+DROP TABLE IF EXISTS public.audit_events;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS public.audit_events
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_type varchar(64) NOT NULL,
+    actor_id varchar(255) NOT NULL,
+    subject_type varchar(64) NOT NULL,
+    subject_id varchar(255) NOT NULL,
+    old_value varchar(255),
+    new_value varchar(255),
+    details jsonb NOT NULL DEFAULT '{}'::jsonb,
+    ip varchar(64) NOT NULL DEFAULT '',
+    request_id varchar(255) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_subject_idx
+    ON public.audit_events (subject_type, subject_id, created_at);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx
+    ON public.audit_events (created_at);
+
+-- Audit events are append-only: any attempt to change or remove a row fails.
+CREATE OR REPLACE FUNCTION public.audit_events_immutable()
+    RETURNS trigger
+    LANGUAGE plpgsql
+AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$;
+
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON public.audit_events
+    FOR EACH ROW EXECUTE FUNCTION public.audit_events_immutable();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON public.audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION public.audit_events_immutable();
+
+ALTER TABLE IF EXISTS public.audit_events
+    OWNER to postgres;
@@ -0,0 +1,6 @@
+-- Encrypted synonyms stay encrypted: decrypt them before rolling back,
+-- otherwise they are unusable without encryption_keys.
+ALTER TABLE public.users_card
+    DROP COLUMN IF EXISTS key_id;
+
+DROP TABLE IF EXISTS public.encryption_keys;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS public.encryption_keys
+(
+    id varchar(64) PRIMARY KEY,
+    master_key_id varchar(64) NOT NULL,
+    wrapped_key bytea NOT NULL,
+    is_active boolean NOT NULL DEFAULT false,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    retired_at timestamptz
+);
+
+-- Only one data key encrypts new values
+CREATE UNIQUE INDEX IF NOT EXISTS encryption_keys_single_active_idx
+    ON public.encryption_keys (is_active) WHERE is_active;
+
+ALTER TABLE IF EXISTS public.encryption_keys
+    OWNER to postgres;
+
+-- Ciphertext (base64 of nonce and sealed synonym) doesn't fit into varchar(30).
+-- Rows with NULL key_id are legacy plaintext, they are encrypted by cmd/rekey.
+ALTER TABLE public.users_card
+    ALTER COLUMN card_synonym TYPE text;
+
+ALTER TABLE public.users_card
+    ADD COLUMN IF NOT EXISTS key_id varchar(64) REFERENCES public.encryption_keys (id);
@@ -0,0 +1,2 @@
+ALTER TABLE public.logs
+    DROP COLUMN IF EXISTS trace_id;
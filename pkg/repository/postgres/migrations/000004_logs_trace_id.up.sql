@@ -0,0 +1,2 @@
+ALTER TABLE public.logs
+    ADD COLUMN IF NOT EXISTS trace_id varchar(32) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+ALTER TABLE public.logs
+    DROP COLUMN IF EXISTS kind;
+
+DROP TABLE IF EXISTS public.receipts;
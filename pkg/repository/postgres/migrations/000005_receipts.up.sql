@@ -0,0 +1,27 @@
+-- Fiscal receipts (54-FZ) of payments and refunds.
+-- receipt_id is set when YooKassa registers the receipt.
+CREATE TABLE IF NOT EXISTS public.receipts
+(
+    id SERIAL PRIMARY KEY,
+    receipt_id varchar(64),
+    type varchar(16) NOT NULL,
+    object_id varchar(64) NOT NULL,
+    booking_id varchar(64) NOT NULL DEFAULT '',
+    status varchar(16) NOT NULL,
+    items jsonb NOT NULL DEFAULT '[]'::jsonb,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS receipts_receipt_id_idx
+    ON public.receipts (receipt_id);
+
+CREATE INDEX IF NOT EXISTS receipts_object_id_idx
+    ON public.receipts (object_id);
+
+ALTER TABLE IF EXISTS public.receipts
+    OWNER to postgres;
+
+-- Refunds are logged together with payments and payouts
+ALTER TABLE public.logs
+    ADD COLUMN IF NOT EXISTS kind varchar(16) NOT NULL DEFAULT 'payment';
@@ -0,0 +1,22 @@
+-- Payment methods saved by YooKassa for recurring charges (e.g. monthly rent).
+-- payment_method_id is encrypted like users_card.card_synonym,
+-- payment_id is the payment the method was saved with.
+CREATE TABLE IF NOT EXISTS public.payment_methods
+(
+    id SERIAL PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    payment_method_id text NOT NULL,
+    key_id varchar(64) NOT NULL,
+    payment_id varchar(64) NOT NULL UNIQUE,
+    type varchar(32) NOT NULL,
+    title varchar(255) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS payment_methods_user_id_idx
+    ON public.payment_methods (user_id)
+    WHERE revoked_at IS NULL;
+
+ALTER TABLE IF EXISTS public.payment_methods
+    OWNER to postgres;
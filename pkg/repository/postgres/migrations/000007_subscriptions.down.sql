@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.billing_cycles;
+
+DROP TABLE IF EXISTS public.subscriptions;
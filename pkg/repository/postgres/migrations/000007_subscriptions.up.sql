@@ -0,0 +1,54 @@
+-- Recurring charges (e.g. monthly rent) of a saved payment method.
+-- next_charge_at is the due date of the next billing cycle.
+CREATE TABLE IF NOT EXISTS public.subscriptions
+(
+    id SERIAL PRIMARY KEY,
+    tenant_id varchar(64) NOT NULL,
+    landlord_id varchar(64) NOT NULL,
+    payment_method_id integer NOT NULL REFERENCES public.payment_methods (id),
+    booking_id varchar(64) NOT NULL DEFAULT '',
+    amount numeric(10,2) NOT NULL,
+    currency varchar(3) NOT NULL,
+    interval_months smallint NOT NULL DEFAULT 1,
+    anchor_day smallint NOT NULL,
+    start_date date NOT NULL,
+    end_date date,
+    next_charge_at date NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'active',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_tenant_id_idx
+    ON public.subscriptions (tenant_id);
+
+CREATE INDEX IF NOT EXISTS subscriptions_next_charge_at_idx
+    ON public.subscriptions (next_charge_at)
+    WHERE status = 'active';
+
+ALTER TABLE IF EXISTS public.subscriptions
+    OWNER to postgres;
+
+-- Billing cycle is one due charge of a subscription, failed charges are retried
+-- (attempt is the number of made charges) until the cycle succeeds or fails.
+CREATE TABLE IF NOT EXISTS public.billing_cycles
+(
+    id SERIAL PRIMARY KEY,
+    subscription_id integer NOT NULL REFERENCES public.subscriptions (id),
+    due_date date NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempt smallint NOT NULL DEFAULT 0,
+    payment_id varchar(64) NOT NULL DEFAULT '',
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    error text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT billing_cycles_subscription_due_date_key UNIQUE (subscription_id, due_date)
+);
+
+CREATE INDEX IF NOT EXISTS billing_cycles_open_idx
+    ON public.billing_cycles (next_attempt_at)
+    WHERE status IN ('pending', 'charging');
+
+ALTER TABLE IF EXISTS public.billing_cycles
+    OWNER to postgres;
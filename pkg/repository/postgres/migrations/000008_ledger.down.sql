@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.ledger_entries;
+
+DROP TABLE IF EXISTS public.commission_rules;
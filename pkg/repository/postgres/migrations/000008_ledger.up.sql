@@ -0,0 +1,41 @@
+-- Commission of the platform: percent of the payment plus fixed part, limited by min_fee and max_fee.
+-- A rule applies to the landlord, or to the property tier, or to everything if both are NULL.
+CREATE TABLE IF NOT EXISTS public.commission_rules
+(
+    id SERIAL PRIMARY KEY,
+    landlord_id varchar(64),
+    tier varchar(32),
+    percent numeric(5,2) NOT NULL DEFAULT 0,
+    fixed numeric(10,2) NOT NULL DEFAULT 0,
+    min_fee numeric(10,2),
+    max_fee numeric(10,2),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT commission_rules_scope_check CHECK (landlord_id IS NULL OR tier IS NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS commission_rules_scope_idx
+    ON public.commission_rules (COALESCE(landlord_id, ''), COALESCE(tier, ''));
+
+ALTER TABLE IF EXISTS public.commission_rules
+    OWNER to postgres;
+
+-- Double-entry-like ledger of internal accounts: "platform" and "landlord:<id>".
+-- Credits are positive, debits (payouts) are negative, balance is the sum of entries.
+-- reference is YooKassa object (payment or payout) of the entry.
+CREATE TABLE IF NOT EXISTS public.ledger_entries
+(
+    id BIGSERIAL PRIMARY KEY,
+    account varchar(80) NOT NULL,
+    amount numeric(12,2) NOT NULL,
+    currency varchar(3) NOT NULL,
+    kind varchar(32) NOT NULL,
+    reference varchar(64) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT ledger_entries_reference_key UNIQUE (reference, account, kind)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx
+    ON public.ledger_entries (account, currency);
+
+ALTER TABLE IF EXISTS public.ledger_entries
+    OWNER to postgres;
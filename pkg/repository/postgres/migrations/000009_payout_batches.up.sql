@@ -0,0 +1,33 @@
+-- Batch of payouts created by one request, items are paid out independently.
+CREATE TABLE IF NOT EXISTS public.payout_batches
+(
+    id uuid PRIMARY KEY,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+ALTER TABLE IF EXISTS public.payout_batches
+    OWNER to postgres;
+
+-- Payout of the batch. attempt is part of the idempotence key, it's increased only when
+-- the failed or canceled item is retried, so queued items are resent with the same key.
+CREATE TABLE IF NOT EXISTS public.payout_batch_items
+(
+    id SERIAL PRIMARY KEY,
+    batch_id uuid NOT NULL REFERENCES public.payout_batches (id) ON DELETE CASCADE,
+    position integer NOT NULL,
+    user_id varchar(64) NOT NULL,
+    amount numeric(10,2) NOT NULL,
+    currency varchar(3) NOT NULL,
+    booking_id varchar(64) NOT NULL DEFAULT '',
+    order_id varchar(64) NOT NULL DEFAULT '',
+    attempt smallint NOT NULL DEFAULT 1,
+    transaction_id uuid NOT NULL,
+    yookassa_id varchar(64) NOT NULL DEFAULT '',
+    status varchar(16) NOT NULL DEFAULT 'queued',
+    error text NOT NULL DEFAULT '',
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT payout_batch_items_batch_position_key UNIQUE (batch_id, position)
+);
+
+ALTER TABLE IF EXISTS public.payout_batch_items
+    OWNER to postgres;
@@ -0,0 +1,4 @@
+ALTER TABLE IF EXISTS public.payout_batch_items
+    DROP COLUMN IF EXISTS destination;
+
+DROP TABLE IF EXISTS public.payout_destinations;
@@ -0,0 +1,22 @@
+-- Payout destinations of the user other than bank card (users_card): SBP and YooMoney wallets.
+-- account (phone or wallet number) is encrypted like users_card.card_synonym,
+-- mask keeps only its first and last digits. The user has one destination of every type.
+CREATE TABLE IF NOT EXISTS public.payout_destinations
+(
+    id SERIAL PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    type varchar(16) NOT NULL,
+    account text NOT NULL,
+    key_id varchar(64) NOT NULL,
+    bank_id varchar(12) NOT NULL DEFAULT '',
+    mask varchar(64) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT payout_destinations_user_type_key UNIQUE (user_id, type)
+);
+
+ALTER TABLE IF EXISTS public.payout_destinations
+    OWNER to postgres;
+
+ALTER TABLE IF EXISTS public.payout_batch_items
+    ADD COLUMN IF NOT EXISTS destination varchar(16) NOT NULL DEFAULT 'bank_card';
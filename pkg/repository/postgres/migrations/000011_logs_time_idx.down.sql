@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.logs_kind_time_idx;
@@ -0,0 +1,3 @@
+-- Transactions of the day are selected by reconciliation
+CREATE INDEX IF NOT EXISTS logs_kind_time_idx
+    ON public.logs (kind, time);
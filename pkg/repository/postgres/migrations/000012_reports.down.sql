@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.ledger_entries_kind_created_at_idx;
+
+ALTER TABLE public.logs
+    DROP COLUMN IF EXISTS currency;
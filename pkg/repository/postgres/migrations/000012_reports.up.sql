@@ -0,0 +1,6 @@
+-- Financial reports are filtered by currency
+ALTER TABLE public.logs
+    ADD COLUMN IF NOT EXISTS currency varchar(3) NOT NULL DEFAULT 'RUB';
+
+CREATE INDEX IF NOT EXISTS ledger_entries_kind_created_at_idx
+    ON public.ledger_entries (kind, created_at);
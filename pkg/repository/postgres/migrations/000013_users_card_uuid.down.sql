@@ -0,0 +1,8 @@
+-- user_id stays uuid, bigint can't hold it.
+-- users_card_legacy is kept, its rows are not moved back.
+ALTER TABLE public.users_card
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at,
+    ALTER COLUMN card_mask DROP NOT NULL;
+
+DROP INDEX IF EXISTS public.users_card_user_id_key;
@@ -0,0 +1,30 @@
+-- user_id is UUID of the user, bigint column couldn't store it, so no card was saved into it.
+-- Columns already changed to text with UUID strings are converted too.
+-- Other values can't be converted, such rows are moved to users_card_legacy.
+CREATE TABLE IF NOT EXISTS public.users_card_legacy AS
+    SELECT * FROM public.users_card WITH NO DATA;
+
+WITH moved AS (
+    DELETE FROM public.users_card
+        WHERE user_id::text !~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+        RETURNING *
+)
+INSERT INTO public.users_card_legacy SELECT * FROM moved;
+
+ALTER TABLE public.users_card
+    ALTER COLUMN user_id TYPE uuid USING user_id::text::uuid;
+
+-- The user has one card, the latest one is kept
+DELETE FROM public.users_card AS older
+    USING public.users_card AS newer
+    WHERE older.user_id = newer.user_id AND older.id < newer.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_card_user_id_key
+    ON public.users_card (user_id);
+
+UPDATE public.users_card SET card_mask = '' WHERE card_mask IS NULL;
+
+ALTER TABLE public.users_card
+    ALTER COLUMN card_mask SET NOT NULL,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,16 @@
+-- Users of the service. id is user_id of cards, payments and payouts,
+-- external_id is ID of the user on the main site. Blocked users can't bind cards, pay and get payouts.
+CREATE TABLE IF NOT EXISTS public.users
+(
+    id uuid PRIMARY KEY,
+    external_id varchar(64) NOT NULL,
+    role varchar(16) NOT NULL DEFAULT 'tenant',
+    status varchar(16) NOT NULL DEFAULT 'active',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT users_external_id_key UNIQUE (external_id),
+    CONSTRAINT users_role_check CHECK (role IN ('tenant', 'landlord')),
+    CONSTRAINT users_status_check CHECK (status IN ('active', 'blocked'))
+);
+
+ALTER TABLE IF EXISTS public.users
+    OWNER to postgres;
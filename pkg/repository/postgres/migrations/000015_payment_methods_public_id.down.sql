@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.payment_methods_public_id_key;
+
+ALTER TABLE public.payment_methods
+    DROP COLUMN IF EXISTS public_id;
@@ -0,0 +1,7 @@
+-- Clients reference saved payment methods by random public_id, the serial id could be guessed
+-- and charged with any user_id. The serial id is internal (subscriptions.payment_method_id).
+ALTER TABLE public.payment_methods
+    ADD COLUMN IF NOT EXISTS public_id uuid NOT NULL DEFAULT gen_random_uuid();
+
+CREATE UNIQUE INDEX IF NOT EXISTS payment_methods_public_id_key
+    ON public.payment_methods (public_id);
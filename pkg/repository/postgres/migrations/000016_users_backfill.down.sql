@@ -0,0 +1,2 @@
+-- Backfilled users can't be told from registered ones, they are kept
+SELECT 1;
@@ -0,0 +1,22 @@
+-- Users referenced before the users table existed are registered, otherwise their cards,
+-- payment methods, subscriptions and payouts are rejected as of unknown users. user_id was
+-- the ID of the main site then, so it's external_id too. Users referenced as landlords are
+-- landlords, other ones are tenants. IDs which aren't UUID can't be users and are skipped.
+WITH referenced (id, role) AS (
+    SELECT user_id::text, 'tenant' FROM public.users_card
+    UNION ALL
+    SELECT user_id, 'tenant' FROM public.payment_methods
+    UNION ALL
+    SELECT tenant_id, 'tenant' FROM public.subscriptions
+    UNION ALL
+    SELECT landlord_id, 'landlord' FROM public.subscriptions
+    UNION ALL
+    SELECT user_id, 'landlord' FROM public.payout_destinations
+)
+INSERT INTO public.users (id, external_id, role)
+SELECT id::uuid, lower(id),
+       CASE WHEN bool_or(role = 'landlord') THEN 'landlord' ELSE 'tenant' END
+FROM referenced
+WHERE id ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+GROUP BY id::uuid, lower(id)
+ON CONFLICT DO NOTHING;
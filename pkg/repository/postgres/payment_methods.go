@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentMethod is a payment method saved by YooKassa for charges without the user.
+// PaymentMethodID is decrypted YooKassa ID, it's never returned to clients. Clients reference
+// the method by PublicID, ID is internal.
+type PaymentMethod struct {
+	ID              int        `json:"-"`
+	PublicID        uuid.UUID  `json:"id"`
+	UserID          string     `json:"user_id"`
+	PaymentMethodID string     `json:"-"`
+	PaymentID       string     `json:"payment_id"`
+	Type            string     `json:"type"`
+	Title           string     `json:"title"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+}
+
+type PaymentMethodRepository interface {
+	// InsertPaymentMethod saves the method, it's a no-op if the method of the payment is already saved
+	InsertPaymentMethod(ctx context.Context, method *PaymentMethod) error
+	// GetPaymentMethods returns not revoked methods of the user
+	GetPaymentMethods(ctx context.Context, userID string) ([]*PaymentMethod, error)
+	// GetPaymentMethod returns not revoked method of the user, nil if there is no such method
+	GetPaymentMethod(ctx context.Context, userID string, id int) (*PaymentMethod, error)
+	// GetPaymentMethodByPublicID is GetPaymentMethod by ID returned to clients
+	GetPaymentMethodByPublicID(ctx context.Context, userID string, publicID uuid.UUID) (*PaymentMethod, error)
+	// RevokePaymentMethod returns false if there is no such not revoked method
+	RevokePaymentMethod(ctx context.Context, userID string, publicID uuid.UUID) (bool, error)
+}
+
+type PaymentMethodRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewPaymentMethodRepository(db *PostgresDB) PaymentMethodRepository {
+	return &PaymentMethodRepositoryImpl{
+		db: db,
+	}
+}
+
+func (r *PaymentMethodRepositoryImpl) InsertPaymentMethod(ctx context.Context, method *PaymentMethod) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if method == nil || method.UserID == "" || method.PaymentMethodID == "" || method.PaymentID == "" ||
+		method.Type == "" {
+		return errors.New("try to insert not full payment method")
+	}
+	encryptedID, keyID, err := r.db.encryptSynonym(method.PaymentMethodID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO public.payment_methods (user_id, payment_method_id, key_id, payment_id, type, title)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (payment_id) DO NOTHING
+		RETURNING id, public_id, created_at`
+	err = r.db.db.QueryRowContext(ctx, query,
+		method.UserID,
+		encryptedID,
+		keyID,
+		method.PaymentID,
+		method.Type,
+		method.Title,
+	).Scan(&method.ID, &method.PublicID, &method.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Notification is repeated, the method is already saved
+		return nil
+	}
+	return err
+}
+
+func (r *PaymentMethodRepositoryImpl) GetPaymentMethods(ctx context.Context, userID string) ([]*PaymentMethod, error) {
+	return r.selectPaymentMethods(ctx, `WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id`, userID)
+}
+
+func (r *PaymentMethodRepositoryImpl) GetPaymentMethod(ctx context.Context, userID string, id int) (
+	*PaymentMethod, error) {
+	methods, err := r.selectPaymentMethods(ctx, `WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL`, userID, id)
+	if err != nil || len(methods) == 0 {
+		return nil, err
+	}
+	return methods[0], nil
+}
+
+func (r *PaymentMethodRepositoryImpl) GetPaymentMethodByPublicID(ctx context.Context, userID string,
+	publicID uuid.UUID) (*PaymentMethod, error) {
+	methods, err := r.selectPaymentMethods(ctx, `WHERE user_id = $1 AND public_id = $2 AND revoked_at IS NULL`,
+		userID, publicID)
+	if err != nil || len(methods) == 0 {
+		return nil, err
+	}
+	return methods[0], nil
+}
+
+func (r *PaymentMethodRepositoryImpl) RevokePaymentMethod(ctx context.Context, userID string,
+	publicID uuid.UUID) (bool, error) {
+	if r.db == nil || r.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+
+	query := `UPDATE public.payment_methods SET revoked_at = now()
+		WHERE user_id = $1 AND public_id = $2 AND revoked_at IS NULL`
+	res, err := r.db.db.ExecContext(ctx, query, userID, publicID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *PaymentMethodRepositoryImpl) selectPaymentMethods(ctx context.Context, where string, args ...any) (
+	[]*PaymentMethod, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select payment methods by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			public_id,
+			user_id,
+			payment_method_id,
+			key_id,
+			payment_id,
+			type,
+			title,
+			created_at,
+			revoked_at
+		FROM public.payment_methods
+	` + where
+	rows, err := r.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := make([]*PaymentMethod, 0)
+	for rows.Next() {
+		method := new(PaymentMethod)
+		var keyID string
+		err = rows.Scan(
+			&method.ID,
+			&method.PublicID,
+			&method.UserID,
+			&method.PaymentMethodID,
+			&keyID,
+			&method.PaymentID,
+			&method.Type,
+			&method.Title,
+			&method.CreatedAt,
+			&method.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if r.db.cardCipher == nil {
+			return nil, errNoCardCipher
+		}
+		if method.PaymentMethodID, err = r.db.cardCipher.Decrypt(ctx, method.PaymentMethodID, keyID); err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	return methods, rows.Err()
+}
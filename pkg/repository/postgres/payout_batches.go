@@ -0,0 +1,255 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payout batch item statuses
+const (
+	// BatchItemQueued isn't sent yet, or its result is unknown (it's resent with the same key)
+	BatchItemQueued    = "queued"
+	BatchItemPending   = "pending"
+	BatchItemSucceeded = "succeeded"
+	BatchItemCanceled  = "canceled"
+	// BatchItemFailed is rejected by YooKassa, the payout isn't created
+	BatchItemFailed = "failed"
+)
+
+type PayoutBatch struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PayoutBatchItem struct {
+	ID            int       `json:"-"`
+	BatchID       uuid.UUID `json:"-"`
+	Position      int       `json:"position"`
+	UserID        string    `json:"user_id"`
+	Amount        string    `json:"amount"`
+	Currency      string    `json:"currency"`
+	Destination   string    `json:"destination"`
+	BookingID     string    `json:"booking_id,omitempty"`
+	OrderID       string    `json:"order_id,omitempty"`
+	Attempt       int       `json:"attempt"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	YooKassaID    string    `json:"yookassa_id,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type PayoutBatchRepository interface {
+	// InsertBatch records the batch with its items in one transaction
+	InsertBatch(ctx context.Context, batch *PayoutBatch, items []*PayoutBatchItem) error
+	// GetBatch returns nil if there is no such batch
+	GetBatch(ctx context.Context, id uuid.UUID) (*PayoutBatch, error)
+	GetBatchItems(ctx context.Context, batchID uuid.UUID) ([]*PayoutBatchItem, error)
+	// UpdateBatchItem saves YooKassa ID, status and error of the item
+	UpdateBatchItem(ctx context.Context, item *PayoutBatchItem) error
+	// RequeueBatchItems moves failed and canceled items of the batch to the next attempt
+	// with new transaction ID, items which succeeded or are in progress aren't changed
+	RequeueBatchItems(ctx context.Context, batchID uuid.UUID) (int, error)
+	// GetQueuedBatchIDs returns batches which have queued items, oldest first
+	GetQueuedBatchIDs(ctx context.Context) ([]uuid.UUID, error)
+}
+
+type PayoutBatchRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewPayoutBatchRepository(db *PostgresDB) PayoutBatchRepository {
+	return &PayoutBatchRepositoryImpl{
+		db: db,
+	}
+}
+
+func (p *PayoutBatchRepositoryImpl) InsertBatch(ctx context.Context,
+	batch *PayoutBatch, items []*PayoutBatchItem) error {
+	if p.db == nil || p.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if batch == nil || batch.ID == uuid.Nil || len(items) == 0 {
+		return errors.New("try to insert not full payout batch")
+	}
+
+	tx, err := p.db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO public.payout_batches (id) VALUES ($1) RETURNING created_at`, batch.ID).
+		Scan(&batch.CreatedAt)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO public.payout_batch_items (batch_id, position, user_id, amount, currency,
+			booking_id, order_id, transaction_id, destination)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, attempt, status, updated_at`
+	for _, item := range items {
+		item.BatchID = batch.ID
+		err = tx.QueryRowContext(ctx, query,
+			item.BatchID,
+			item.Position,
+			item.UserID,
+			item.Amount,
+			item.Currency,
+			item.BookingID,
+			item.OrderID,
+			item.TransactionID,
+			item.Destination,
+		).Scan(&item.ID, &item.Attempt, &item.Status, &item.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *PayoutBatchRepositoryImpl) GetBatch(ctx context.Context, id uuid.UUID) (*PayoutBatch, error) {
+	if p.db == nil || p.db.db == nil {
+		return nil, errors.New("try to select payout batch by using empty db")
+	}
+
+	rows, err := p.db.db.QueryContext(ctx, `SELECT id, created_at FROM public.payout_batches WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	batch := new(PayoutBatch)
+	if err = rows.Scan(&batch.ID, &batch.CreatedAt); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (p *PayoutBatchRepositoryImpl) GetBatchItems(ctx context.Context, batchID uuid.UUID) ([]*PayoutBatchItem, error) {
+	if p.db == nil || p.db.db == nil {
+		return nil, errors.New("try to select payout batch items by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			batch_id,
+			position,
+			user_id,
+			amount,
+			currency,
+			destination,
+			booking_id,
+			order_id,
+			attempt,
+			transaction_id,
+			yookassa_id,
+			status,
+			error,
+			updated_at
+		FROM public.payout_batch_items
+		WHERE batch_id = $1
+		ORDER BY position
+	`
+	rows, err := p.db.db.QueryContext(ctx, query, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*PayoutBatchItem, 0)
+	for rows.Next() {
+		item := new(PayoutBatchItem)
+		err = rows.Scan(
+			&item.ID,
+			&item.BatchID,
+			&item.Position,
+			&item.UserID,
+			&item.Amount,
+			&item.Currency,
+			&item.Destination,
+			&item.BookingID,
+			&item.OrderID,
+			&item.Attempt,
+			&item.TransactionID,
+			&item.YooKassaID,
+			&item.Status,
+			&item.Error,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (p *PayoutBatchRepositoryImpl) UpdateBatchItem(ctx context.Context, item *PayoutBatchItem) error {
+	if p.db == nil || p.db.db == nil {
+		return errors.New("nil DB")
+	}
+
+	query := `UPDATE public.payout_batch_items
+		SET yookassa_id = $1, status = $2, error = $3, updated_at = now()
+		WHERE id = $4
+		RETURNING updated_at`
+	return p.db.db.QueryRowContext(ctx, query, item.YooKassaID, item.Status, item.Error, item.ID).Scan(&item.UpdatedAt)
+}
+
+func (p *PayoutBatchRepositoryImpl) RequeueBatchItems(ctx context.Context, batchID uuid.UUID) (int, error) {
+	if p.db == nil || p.db.db == nil {
+		return 0, errors.New("nil DB")
+	}
+
+	query := `UPDATE public.payout_batch_items
+		SET attempt = attempt + 1,
+			transaction_id = gen_random_uuid(),
+			yookassa_id = '',
+			status = 'queued',
+			error = '',
+			updated_at = now()
+		WHERE batch_id = $1 AND status IN ('failed', 'canceled')`
+	res, err := p.db.db.ExecContext(ctx, query, batchID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	return int(affected), err
+}
+
+func (p *PayoutBatchRepositoryImpl) GetQueuedBatchIDs(ctx context.Context) ([]uuid.UUID, error) {
+	if p.db == nil || p.db.db == nil {
+		return nil, errors.New("nil DB")
+	}
+
+	query := `SELECT b.id
+		FROM public.payout_batches b
+		WHERE EXISTS (
+			SELECT 1 FROM public.payout_batch_items i WHERE i.batch_id = b.id AND i.status = $1
+		)
+		ORDER BY b.created_at
+	`
+	rows, err := p.db.db.QueryContext(ctx, query, BatchItemQueued)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PayoutDestination is SBP phone or YooMoney wallet of the user for payouts.
+// Account is decrypted phone or wallet number, it's never returned to clients.
+type PayoutDestination struct {
+	ID        int       `json:"id"`
+	UserID    string    `json:"user_id"`
+	Type      string    `json:"type"`
+	Account   string    `json:"-"`
+	BankID    string    `json:"bank_id,omitempty"`
+	Mask      string    `json:"mask"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PayoutDestinationRepository interface {
+	// SavePayoutDestination inserts the destination or replaces the destination of the same type,
+	// true is returned if it's replaced
+	SavePayoutDestination(ctx context.Context, destination *PayoutDestination) (bool, error)
+	GetPayoutDestinations(ctx context.Context, userID string) ([]*PayoutDestination, error)
+	// GetPayoutDestination returns nil if the user has no destination of the type
+	GetPayoutDestination(ctx context.Context, userID, destinationType string) (*PayoutDestination, error)
+}
+
+type PayoutDestinationRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewPayoutDestinationRepository(db *PostgresDB) PayoutDestinationRepository {
+	return &PayoutDestinationRepositoryImpl{
+		db: db,
+	}
+}
+
+func (p *PayoutDestinationRepositoryImpl) SavePayoutDestination(ctx context.Context, destination *PayoutDestination) (
+	bool, error) {
+	if p.db == nil || p.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	if destination == nil || destination.UserID == "" || destination.Type == "" || destination.Account == "" ||
+		destination.Mask == "" {
+		return false, errors.New("try to save not full payout destination")
+	}
+	encryptedAccount, keyID, err := p.db.encryptSynonym(destination.Account)
+	if err != nil {
+		return false, err
+	}
+
+	// xmax is set for the updated row, it's zero for the inserted one
+	query := `INSERT INTO public.payout_destinations (user_id, type, account, key_id, bank_id, mask)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, type) DO UPDATE
+		SET account = EXCLUDED.account,
+			key_id = EXCLUDED.key_id,
+			bank_id = EXCLUDED.bank_id,
+			mask = EXCLUDED.mask,
+			updated_at = now()
+		RETURNING id, created_at, updated_at, xmax <> 0`
+	var replaced bool
+	err = p.db.db.QueryRowContext(ctx, query,
+		destination.UserID,
+		destination.Type,
+		encryptedAccount,
+		keyID,
+		destination.BankID,
+		destination.Mask,
+	).Scan(&destination.ID, &destination.CreatedAt, &destination.UpdatedAt, &replaced)
+	return replaced, err
+}
+
+func (p *PayoutDestinationRepositoryImpl) GetPayoutDestinations(ctx context.Context, userID string) (
+	[]*PayoutDestination, error) {
+	return p.selectDestinations(ctx, `WHERE user_id = $1 ORDER BY type`, userID)
+}
+
+func (p *PayoutDestinationRepositoryImpl) GetPayoutDestination(ctx context.Context, userID, destinationType string) (
+	*PayoutDestination, error) {
+	destinations, err := p.selectDestinations(ctx, `WHERE user_id = $1 AND type = $2`, userID, destinationType)
+	if err != nil || len(destinations) == 0 {
+		return nil, err
+	}
+	return destinations[0], nil
+}
+
+func (p *PayoutDestinationRepositoryImpl) selectDestinations(ctx context.Context, where string, args ...any) (
+	[]*PayoutDestination, error) {
+	if p.db == nil || p.db.db == nil {
+		return nil, errors.New("try to select payout destinations by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			type,
+			account,
+			key_id,
+			bank_id,
+			mask,
+			created_at,
+			updated_at
+		FROM public.payout_destinations
+	` + where
+	rows, err := p.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	destinations := make([]*PayoutDestination, 0)
+	for rows.Next() {
+		destination := new(PayoutDestination)
+		var keyID string
+		err = rows.Scan(
+			&destination.ID,
+			&destination.UserID,
+			&destination.Type,
+			&destination.Account,
+			&keyID,
+			&destination.BankID,
+			&destination.Mask,
+			&destination.CreatedAt,
+			&destination.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if p.db.cardCipher == nil {
+			return nil, errNoCardCipher
+		}
+		if destination.Account, err = p.db.cardCipher.Decrypt(ctx, destination.Account, keyID); err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations, rows.Err()
+}
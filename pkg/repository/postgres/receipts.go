@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Receipt types, as in YooKassa API
+const (
+	ReceiptTypePayment = "payment"
+	ReceiptTypeRefund  = "refund"
+)
+
+// Receipt registration statuses, as in YooKassa API
+const (
+	ReceiptPending   = "pending"
+	ReceiptSucceeded = "succeeded"
+	ReceiptCanceled  = "canceled"
+)
+
+// Receipt is a fiscal receipt of a payment or refund (ObjectID is its YooKassa ID).
+// Items are stored as they were sent to YooKassa, customer contacts aren't stored.
+type Receipt struct {
+	ID        int             `json:"id"`
+	ReceiptID string          `json:"receipt_id,omitempty"`
+	Type      string          `json:"type"`
+	ObjectID  string          `json:"object_id"`
+	BookingID string          `json:"booking_id,omitempty"`
+	Status    string          `json:"status"`
+	Items     json.RawMessage `json:"items"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type ReceiptRepository interface {
+	InsertReceipt(ctx context.Context, receipt *Receipt) error
+	// SaveReceiptStatus stores status reported by YooKassa: the receipt is found by its ID,
+	// or it's the first not yet registered receipt of the object, or it's inserted
+	SaveReceiptStatus(ctx context.Context, receipt *Receipt) error
+	GetReceiptsByObjectID(ctx context.Context, objectID string) ([]*Receipt, error)
+}
+
+type ReceiptRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewReceiptRepository(db *PostgresDB) ReceiptRepository {
+	return &ReceiptRepositoryImpl{
+		db: db,
+	}
+}
+
+func (r *ReceiptRepositoryImpl) InsertReceipt(ctx context.Context, receipt *Receipt) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if receipt == nil || receipt.Type == "" || receipt.ObjectID == "" || receipt.Status == "" {
+		return errors.New("try to insert not full receipt")
+	}
+	items := receipt.Items
+	if len(items) == 0 {
+		items = json.RawMessage("[]")
+	}
+
+	query := `INSERT INTO public.receipts (receipt_id, type, object_id, booking_id, status, items)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`
+	return r.db.db.QueryRowContext(ctx, query,
+		receipt.ReceiptID,
+		receipt.Type,
+		receipt.ObjectID,
+		receipt.BookingID,
+		receipt.Status,
+		[]byte(items),
+	).Scan(&receipt.ID, &receipt.CreatedAt, &receipt.UpdatedAt)
+}
+
+func (r *ReceiptRepositoryImpl) SaveReceiptStatus(ctx context.Context, receipt *Receipt) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if receipt == nil || receipt.ReceiptID == "" || receipt.ObjectID == "" {
+		return errors.New("try to save status of not full receipt")
+	}
+
+	tx, err := r.db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `UPDATE public.receipts SET status = $1, updated_at = now()
+		WHERE receipt_id = $2
+		RETURNING id`,
+		receipt.Status, receipt.ReceiptID,
+	).Scan(&receipt.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `UPDATE public.receipts SET receipt_id = $1, status = $2, updated_at = now()
+			WHERE id = (
+				SELECT id FROM public.receipts
+				WHERE object_id = $3 AND type = $4 AND receipt_id IS NULL
+				ORDER BY id
+				LIMIT 1
+			)
+			RETURNING id`,
+			receipt.ReceiptID, receipt.Status, receipt.ObjectID, receipt.Type,
+		).Scan(&receipt.ID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		// The receipt was issued outside of the service (e.g. in YooKassa dashboard)
+		err = tx.QueryRowContext(ctx, `INSERT INTO public.receipts (receipt_id, type, object_id, status)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`,
+			receipt.ReceiptID, receipt.Type, receipt.ObjectID, receipt.Status,
+		).Scan(&receipt.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *ReceiptRepositoryImpl) GetReceiptsByObjectID(ctx context.Context, objectID string) ([]*Receipt, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select receipts by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			COALESCE(receipt_id, ''),
+			type,
+			object_id,
+			booking_id,
+			status,
+			items,
+			created_at,
+			updated_at
+		FROM public.receipts
+		WHERE object_id = $1
+		ORDER BY id
+	`
+	rows, err := r.db.db.QueryContext(ctx, query, objectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := make([]*Receipt, 0)
+	for rows.Next() {
+		receipt := new(Receipt)
+		var items []byte
+		err = rows.Scan(
+			&receipt.ID,
+			&receipt.ReceiptID,
+			&receipt.Type,
+			&receipt.ObjectID,
+			&receipt.BookingID,
+			&receipt.Status,
+			&items,
+			&receipt.CreatedAt,
+			&receipt.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		receipt.Items = items
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Periods of grouped reports, they are date_trunc fields
+const (
+	ReportPeriodDay   = "day"
+	ReportPeriodWeek  = "week"
+	ReportPeriodMonth = "month"
+)
+
+// ReportFilter selects transactions created in [From, To), empty Currency selects all currencies
+type ReportFilter struct {
+	From     time.Time
+	To       time.Time
+	Currency string
+	// Period groups turnover and commissions
+	Period string
+}
+
+// ReportCursor is the last row of the previous page of paged reports, zero cursor is the first page
+type ReportCursor struct {
+	Key      string
+	Currency string
+	ID       int
+}
+
+// TurnoverRow is succeeded payments and refunds of the period
+type TurnoverRow struct {
+	Period         time.Time
+	Currency       string
+	Payments       int
+	PaymentsAmount string
+	Refunds        int
+	RefundsAmount  string
+	// Net is payments minus refunds
+	Net string
+}
+
+// LandlordPayoutsRow is payouts of the landlord from the balance, canceled payouts aren't counted
+type LandlordPayoutsRow struct {
+	LandlordID string
+	Currency   string
+	Payouts    int
+	Amount     string
+}
+
+type RefundRow struct {
+	ID            int
+	Time          time.Time
+	TransactionID string
+	Amount        string
+	Currency      string
+	Status        string
+}
+
+// CommissionsRow is platform fees of the period
+type CommissionsRow struct {
+	Period   time.Time
+	Currency string
+	Payments int
+	Amount   string
+}
+
+// EscrowRow is money held for the landlord, it isn't paid out yet
+type EscrowRow struct {
+	LandlordID string
+	Currency   string
+	Balance    string
+}
+
+// ReportRepository selects financial reports from logs and the ledger. Grouped reports are small,
+// the others are selected by pages after the cursor, so that exports don't load everything into memory.
+type ReportRepository interface {
+	GetTurnover(ctx context.Context, filter *ReportFilter) ([]*TurnoverRow, error)
+	GetCommissions(ctx context.Context, filter *ReportFilter) ([]*CommissionsRow, error)
+	GetLandlordPayouts(ctx context.Context, filter *ReportFilter, after ReportCursor, limit int) (
+		[]*LandlordPayoutsRow, error)
+	GetRefunds(ctx context.Context, filter *ReportFilter, after ReportCursor, limit int) ([]*RefundRow, error)
+	// GetEscrow returns non-zero landlord balances at filter.To, filter.From isn't used
+	GetEscrow(ctx context.Context, filter *ReportFilter, after ReportCursor, limit int) ([]*EscrowRow, error)
+}
+
+type ReportRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewReportRepository(db *PostgresDB) ReportRepository {
+	return &ReportRepositoryImpl{
+		db: db,
+	}
+}
+
+// landlordPrefix is the prefix of landlord accounts, see LandlordAccount
+const landlordPrefix = "landlord:"
+
+func (r *ReportRepositoryImpl) GetTurnover(ctx context.Context, filter *ReportFilter) ([]*TurnoverRow, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select turnover by using empty db")
+	}
+
+	query := `
+		SELECT
+			date_trunc($1, time) AS period,
+			currency,
+			count(*) FILTER (WHERE kind = 'payment'),
+			COALESCE(sum(amount) FILTER (WHERE kind = 'payment'), 0)::text,
+			count(*) FILTER (WHERE kind = 'refund'),
+			COALESCE(sum(amount) FILTER (WHERE kind = 'refund'), 0)::text,
+			(COALESCE(sum(amount) FILTER (WHERE kind = 'payment'), 0) -
+				COALESCE(sum(amount) FILTER (WHERE kind = 'refund'), 0))::text
+		FROM public.logs
+		WHERE kind IN ('payment', 'refund')
+			AND status = 'succeeded'
+			AND time >= $2 AND time < $3
+			AND ($4 = '' OR currency = $4)
+		GROUP BY period, currency
+		ORDER BY period, currency
+	`
+	rows, err := r.db.db.QueryContext(ctx, query, filter.Period, filter.From, filter.To, filter.Currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]*TurnoverRow, 0)
+	for rows.Next() {
+		row := new(TurnoverRow)
+		err = rows.Scan(&row.Period, &row.Currency, &row.Payments, &row.PaymentsAmount, &row.Refunds,
+			&row.RefundsAmount, &row.Net)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func (r *ReportRepositoryImpl) GetCommissions(ctx context.Context, filter *ReportFilter) ([]*CommissionsRow, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select commissions by using empty db")
+	}
+
+	query := `
+		SELECT
+			date_trunc($1, created_at AT TIME ZONE 'UTC') AS period,
+			currency,
+			count(*),
+			sum(amount)::text
+		FROM public.ledger_entries
+		WHERE account = $2
+			AND kind = $3
+			AND created_at >= $4 AND created_at < $5
+			AND ($6 = '' OR currency = $6)
+		GROUP BY period, currency
+		ORDER BY period, currency
+	`
+	rows, err := r.db.db.QueryContext(ctx, query,
+		filter.Period, PlatformAccount, LedgerPlatformFee, filter.From, filter.To, filter.Currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]*CommissionsRow, 0)
+	for rows.Next() {
+		row := new(CommissionsRow)
+		if err = rows.Scan(&row.Period, &row.Currency, &row.Payments, &row.Amount); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func (r *ReportRepositoryImpl) GetLandlordPayouts(ctx context.Context, filter *ReportFilter, after ReportCursor,
+	limit int) ([]*LandlordPayoutsRow, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select landlord payouts by using empty db")
+	}
+
+	// Reversal of the canceled payout takes back both the payout and its amount
+	query := `
+		SELECT
+			substr(account, length($1) + 1),
+			currency,
+			count(*) FILTER (WHERE kind = $2) - count(*) FILTER (WHERE kind = $3),
+			(-sum(amount))::text
+		FROM public.ledger_entries
+		WHERE account LIKE $1 || '%'
+			AND kind IN ($2, $3)
+			AND created_at >= $4 AND created_at < $5
+			AND ($6 = '' OR currency = $6)
+			AND (account, currency) > ($7, $8)
+		GROUP BY account, currency
+		ORDER BY account, currency
+		LIMIT $9
+	`
+	rows, err := r.db.db.QueryContext(ctx, query, landlordPrefix, LedgerPayout, LedgerPayoutReversal,
+		filter.From, filter.To, filter.Currency, cursorAccount(after), after.Currency, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]*LandlordPayoutsRow, 0)
+	for rows.Next() {
+		row := new(LandlordPayoutsRow)
+		if err = rows.Scan(&row.LandlordID, &row.Currency, &row.Payouts, &row.Amount); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func (r *ReportRepositoryImpl) GetRefunds(ctx context.Context, filter *ReportFilter, after ReportCursor, limit int) (
+	[]*RefundRow, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select refunds by using empty db")
+	}
+
+	query := `
+		SELECT
+			id,
+			time,
+			transaction_id,
+			amount::text,
+			currency,
+			status
+		FROM public.logs
+		WHERE kind = $1
+			AND time >= $2 AND time < $3
+			AND ($4 = '' OR currency = $4)
+			AND id > $5
+		ORDER BY id
+		LIMIT $6
+	`
+	rows, err := r.db.db.QueryContext(ctx, query, LogKindRefund, filter.From, filter.To, filter.Currency, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]*RefundRow, 0)
+	for rows.Next() {
+		row := new(RefundRow)
+		err = rows.Scan(&row.ID, &row.Time, &row.TransactionID, &row.Amount, &row.Currency, &row.Status)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func (r *ReportRepositoryImpl) GetEscrow(ctx context.Context, filter *ReportFilter, after ReportCursor, limit int) (
+	[]*EscrowRow, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select escrow by using empty db")
+	}
+
+	query := `
+		SELECT
+			substr(account, length($1) + 1),
+			currency,
+			sum(amount)::text
+		FROM public.ledger_entries
+		WHERE account LIKE $1 || '%'
+			AND created_at < $2
+			AND ($3 = '' OR currency = $3)
+			AND (account, currency) > ($4, $5)
+		GROUP BY account, currency
+		HAVING sum(amount) <> 0
+		ORDER BY account, currency
+		LIMIT $6
+	`
+	rows, err := r.db.db.QueryContext(ctx, query, landlordPrefix, filter.To, filter.Currency, cursorAccount(after),
+		after.Currency, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]*EscrowRow, 0)
+	for rows.Next() {
+		row := new(EscrowRow)
+		if err = rows.Scan(&row.LandlordID, &row.Currency, &row.Balance); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// cursorAccount returns landlord account of the cursor, empty key is before all accounts
+func cursorAccount(after ReportCursor) string {
+	if after.Key == "" {
+		return ""
+	}
+	return LandlordAccount(after.Key)
+}
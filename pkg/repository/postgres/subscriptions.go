@@ -0,0 +1,337 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Subscription statuses
+const (
+	SubscriptionActive = "active"
+	// SubscriptionPastDue isn't charged anymore, its last cycle failed after all retries
+	SubscriptionPastDue   = "past_due"
+	SubscriptionCanceled  = "canceled"
+	SubscriptionCompleted = "completed"
+)
+
+// Billing cycle statuses
+const (
+	CyclePending = "pending"
+	// CycleCharging waits for the final status of the payment
+	CycleCharging  = "charging"
+	CycleSucceeded = "succeeded"
+	CycleFailed    = "failed"
+)
+
+// Subscription charges Amount from the saved payment method of the tenant every IntervalMonths
+// on AnchorDay (the last day of shorter months), from StartDate until EndDate (if it's set).
+// PaymentMethodID is internal ID of the method, clients get its PublicID.
+type Subscription struct {
+	ID                    int        `json:"id"`
+	TenantID              string     `json:"tenant_id"`
+	LandlordID            string     `json:"landlord_id"`
+	PaymentMethodID       int        `json:"-"`
+	PaymentMethodPublicID uuid.UUID  `json:"payment_method_id"`
+	BookingID             string     `json:"booking_id,omitempty"`
+	Amount                string     `json:"amount"`
+	Currency              string     `json:"currency"`
+	IntervalMonths        int        `json:"interval_months"`
+	AnchorDay             int        `json:"anchor_day"`
+	StartDate             time.Time  `json:"start_date"`
+	EndDate               *time.Time `json:"end_date,omitempty"`
+	NextChargeAt          time.Time  `json:"next_charge_at"`
+	Status                string     `json:"status"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+type BillingCycle struct {
+	ID             int       `json:"id"`
+	SubscriptionID int       `json:"subscription_id"`
+	DueDate        time.Time `json:"due_date"`
+	Status         string    `json:"status"`
+	Attempt        int       `json:"attempt"`
+	PaymentID      string    `json:"payment_id,omitempty"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type SubscriptionRepository interface {
+	InsertSubscription(ctx context.Context, sub *Subscription) error
+	// GetSubscription returns nil if there is no such subscription
+	GetSubscription(ctx context.Context, id int) (*Subscription, error)
+	GetSubscriptionsByTenant(ctx context.Context, tenantID string) ([]*Subscription, error)
+	// SetSubscriptionStatus changes status of active or past due subscription,
+	// false is returned if there is no such subscription
+	SetSubscriptionStatus(ctx context.Context, id int, status string) (bool, error)
+	// GetDueSubscriptions returns active subscriptions whose next charge is due by the date
+	GetDueSubscriptions(ctx context.Context, date time.Time) ([]*Subscription, error)
+	// OpenCycle creates billing cycle of the subscription due date and moves the subscription
+	// to the next due date, or completes it if next is nil. It's a no-op for existing cycle.
+	OpenCycle(ctx context.Context, sub *Subscription, next *time.Time) error
+	// GetOpenCycles returns cycles to be charged by the time and cycles waiting for payment status
+	GetOpenCycles(ctx context.Context, now time.Time) ([]*BillingCycle, error)
+	// UpdateCycle saves status, attempt, payment, next attempt and error of the cycle
+	UpdateCycle(ctx context.Context, cycle *BillingCycle) error
+	GetCycles(ctx context.Context, subscriptionID int) ([]*BillingCycle, error)
+}
+
+type SubscriptionRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewSubscriptionRepository(db *PostgresDB) SubscriptionRepository {
+	return &SubscriptionRepositoryImpl{
+		db: db,
+	}
+}
+
+const subscriptionColumns = `
+			id,
+			tenant_id,
+			landlord_id,
+			payment_method_id,
+			(SELECT public_id FROM public.payment_methods WHERE payment_methods.id = payment_method_id),
+			booking_id,
+			amount,
+			currency,
+			interval_months,
+			anchor_day,
+			start_date,
+			end_date,
+			next_charge_at,
+			status,
+			created_at,
+			updated_at`
+
+const cycleColumns = `
+			id,
+			subscription_id,
+			due_date,
+			status,
+			attempt,
+			payment_id,
+			next_attempt_at,
+			error,
+			created_at,
+			updated_at`
+
+func (r *SubscriptionRepositoryImpl) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+	if sub == nil || sub.TenantID == "" || sub.LandlordID == "" || sub.PaymentMethodID == 0 ||
+		sub.Amount == "" || sub.Currency == "" || sub.IntervalMonths <= 0 || sub.AnchorDay <= 0 {
+		return errors.New("try to insert not full subscription")
+	}
+	if sub.Status == "" {
+		sub.Status = SubscriptionActive
+	}
+
+	query := `INSERT INTO public.subscriptions (tenant_id, landlord_id, payment_method_id, booking_id, amount,
+			currency, interval_months, anchor_day, start_date, end_date, next_charge_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at, updated_at`
+	return r.db.db.QueryRowContext(ctx, query,
+		sub.TenantID,
+		sub.LandlordID,
+		sub.PaymentMethodID,
+		sub.BookingID,
+		sub.Amount,
+		sub.Currency,
+		sub.IntervalMonths,
+		sub.AnchorDay,
+		sub.StartDate,
+		sub.EndDate,
+		sub.NextChargeAt,
+		sub.Status,
+	).Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
+}
+
+func (r *SubscriptionRepositoryImpl) GetSubscription(ctx context.Context, id int) (*Subscription, error) {
+	subs, err := r.selectSubscriptions(ctx, `WHERE id = $1`, id)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+func (r *SubscriptionRepositoryImpl) GetSubscriptionsByTenant(ctx context.Context, tenantID string) (
+	[]*Subscription, error) {
+	return r.selectSubscriptions(ctx, `WHERE tenant_id = $1 ORDER BY id`, tenantID)
+}
+
+func (r *SubscriptionRepositoryImpl) SetSubscriptionStatus(ctx context.Context, id int, status string) (bool, error) {
+	if r.db == nil || r.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+
+	query := `UPDATE public.subscriptions SET status = $1, updated_at = now()
+		WHERE id = $2 AND status IN ('active', 'past_due')`
+	res, err := r.db.db.ExecContext(ctx, query, status, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *SubscriptionRepositoryImpl) GetDueSubscriptions(ctx context.Context, date time.Time) ([]*Subscription, error) {
+	return r.selectSubscriptions(ctx, `WHERE status = 'active' AND next_charge_at <= $1 ORDER BY next_charge_at, id`,
+		date)
+}
+
+func (r *SubscriptionRepositoryImpl) OpenCycle(ctx context.Context, sub *Subscription, next *time.Time) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+
+	tx, err := r.db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO public.billing_cycles (subscription_id, due_date)
+		VALUES ($1, $2)
+		ON CONFLICT (subscription_id, due_date) DO NOTHING`,
+		sub.ID, sub.NextChargeAt,
+	)
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE public.subscriptions SET status = $1, updated_at = now() WHERE id = $2`,
+			SubscriptionCompleted, sub.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE public.subscriptions SET next_charge_at = $1, updated_at = now() WHERE id = $2`,
+			*next, sub.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SubscriptionRepositoryImpl) GetOpenCycles(ctx context.Context, now time.Time) ([]*BillingCycle, error) {
+	return r.selectCycles(ctx, `WHERE (status = 'pending' AND next_attempt_at <= $1) OR status = 'charging'
+		ORDER BY next_attempt_at, id`, now)
+}
+
+func (r *SubscriptionRepositoryImpl) UpdateCycle(ctx context.Context, cycle *BillingCycle) error {
+	if r.db == nil || r.db.db == nil {
+		return errors.New("nil DB")
+	}
+
+	query := `UPDATE public.billing_cycles
+		SET status = $1, attempt = $2, payment_id = $3, next_attempt_at = $4, error = $5, updated_at = now()
+		WHERE id = $6
+		RETURNING updated_at`
+	return r.db.db.QueryRowContext(ctx, query,
+		cycle.Status,
+		cycle.Attempt,
+		cycle.PaymentID,
+		cycle.NextAttemptAt,
+		cycle.Error,
+		cycle.ID,
+	).Scan(&cycle.UpdatedAt)
+}
+
+func (r *SubscriptionRepositoryImpl) GetCycles(ctx context.Context, subscriptionID int) ([]*BillingCycle, error) {
+	return r.selectCycles(ctx, `WHERE subscription_id = $1 ORDER BY due_date`, subscriptionID)
+}
+
+func (r *SubscriptionRepositoryImpl) selectSubscriptions(ctx context.Context, where string, args ...any) (
+	[]*Subscription, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select subscriptions by using empty db")
+	}
+
+	query := `SELECT` + subscriptionColumns + `
+		FROM public.subscriptions
+		` + where
+	rows, err := r.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]*Subscription, 0)
+	for rows.Next() {
+		sub := new(Subscription)
+		var endDate sql.NullTime
+		err = rows.Scan(
+			&sub.ID,
+			&sub.TenantID,
+			&sub.LandlordID,
+			&sub.PaymentMethodID,
+			&sub.PaymentMethodPublicID,
+			&sub.BookingID,
+			&sub.Amount,
+			&sub.Currency,
+			&sub.IntervalMonths,
+			&sub.AnchorDay,
+			&sub.StartDate,
+			&endDate,
+			&sub.NextChargeAt,
+			&sub.Status,
+			&sub.CreatedAt,
+			&sub.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if endDate.Valid {
+			sub.EndDate = &endDate.Time
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (r *SubscriptionRepositoryImpl) selectCycles(ctx context.Context, where string, args ...any) (
+	[]*BillingCycle, error) {
+	if r.db == nil || r.db.db == nil {
+		return nil, errors.New("try to select billing cycles by using empty db")
+	}
+
+	query := `SELECT` + cycleColumns + `
+		FROM public.billing_cycles
+		` + where
+	rows, err := r.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cycles := make([]*BillingCycle, 0)
+	for rows.Next() {
+		cycle := new(BillingCycle)
+		err = rows.Scan(
+			&cycle.ID,
+			&cycle.SubscriptionID,
+			&cycle.DueDate,
+			&cycle.Status,
+			&cycle.Attempt,
+			&cycle.PaymentID,
+			&cycle.NextAttemptAt,
+			&cycle.Error,
+			&cycle.CreatedAt,
+			&cycle.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, rows.Err()
+}
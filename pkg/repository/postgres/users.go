@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type UserRepository interface {
+	// RegisterUser inserts the user, true is returned if it's inserted. If the user with the same
+	// external ID is already registered, it's returned in user instead.
+	RegisterUser(ctx context.Context, user *models.User) (bool, error)
+	// GetUser returns nil if there is no such user
+	GetUser(ctx context.Context, id uuid.UUID) (*models.User, error)
+	// GetUserByExternalID returns nil if there is no such user
+	GetUserByExternalID(ctx context.Context, externalID string) (*models.User, error)
+	// SetUserStatus returns false if there is no such user or it already has the status
+	SetUserStatus(ctx context.Context, id uuid.UUID, status string) (bool, error)
+}
+
+type UserRepositoryImpl struct {
+	db *PostgresDB
+}
+
+func NewUserRepository(db *PostgresDB) UserRepository {
+	return &UserRepositoryImpl{
+		db: db,
+	}
+}
+
+const selectUserQuery = `
+	SELECT
+		id,
+		external_id,
+		role,
+		status,
+		created_at
+	FROM public.` + usersTable
+
+func (u *UserRepositoryImpl) RegisterUser(ctx context.Context, user *models.User) (bool, error) {
+	if u.db == nil || u.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	if user == nil || user.ExternalID == "" || user.Role == "" || user.Status == "" {
+		return false, errors.New("try to register not full user")
+	}
+
+	// The row is locked by the no-op update, so the registered user is returned.
+	// xmax is zero for the inserted row.
+	query := `INSERT INTO public.` + usersTable + ` (id, external_id, role, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (external_id) DO UPDATE
+		SET external_id = EXCLUDED.external_id
+		RETURNING id, role, status, created_at, xmax = 0`
+	var inserted bool
+	err := u.db.db.QueryRowContext(ctx, query,
+		user.Id,
+		user.ExternalID,
+		user.Role,
+		user.Status,
+	).Scan(&user.Id, &user.Role, &user.Status, &user.CreatedAt, &inserted)
+	return inserted, err
+}
+
+func (u *UserRepositoryImpl) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return u.selectUser(ctx, selectUserQuery+` WHERE id = $1`, id)
+}
+
+func (u *UserRepositoryImpl) GetUserByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	return u.selectUser(ctx, selectUserQuery+` WHERE external_id = $1`, externalID)
+}
+
+func (u *UserRepositoryImpl) SetUserStatus(ctx context.Context, id uuid.UUID, status string) (bool, error) {
+	if u.db == nil || u.db.db == nil {
+		return false, errors.New("nil DB")
+	}
+	query := `UPDATE public.` + usersTable + ` SET status = $2 WHERE id = $1 AND status <> $2`
+	result, err := u.db.db.ExecContext(ctx, query, id, status)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (u *UserRepositoryImpl) selectUser(ctx context.Context, query string, args ...any) (*models.User, error) {
+	if u.db == nil || u.db.db == nil {
+		return nil, errors.New("try to select user by using empty db")
+	}
+	user := new(models.User)
+	err := u.db.db.QueryRowContext(ctx, query, args...).Scan(
+		&user.Id,
+		&user.ExternalID,
+		&user.Role,
+		&user.Status,
+		&user.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
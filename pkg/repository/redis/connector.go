@@ -5,34 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
 	"sync"
 
 	"github.com/redis/go-redis/v9"
 )
 
 type RedisConfig struct {
-	Host     string
-	Port     string
-	Password string
-	DB       int
-}
-
-func LoadRedisConfig() (*RedisConfig, error) {
-	host := os.Getenv("REDIS_DB_HOST")
-	port := os.Getenv("REDIS_DB_PORT")
-	password := os.Getenv("REDIS_DB_PASSWORD")
-	db, err := strconv.Atoi(os.Getenv("REDIS_DB_DB"))
-	if err != nil {
-		return nil, err
-	}
-	return &RedisConfig{
-		Host:     host,
-		Port:     port,
-		DB:       db,
-		Password: password,
-	}, nil
+	Host     string `yaml:"host" env:"REDIS_DB_HOST" required:"true"`
+	Port     string `yaml:"port" env:"REDIS_DB_PORT" default:"6379"`
+	Password string `yaml:"password" env:"REDIS_DB_PASSWORD" secret:"true"`
+	DB       int    `yaml:"db" env:"REDIS_DB_DB"`
 }
 
 type RedisDB struct {
@@ -95,6 +77,15 @@ func GetCurrRedisDB() (*RedisDB, bool) {
 	return currRedisDB, true
 }
 
+func (r *RedisDB) Ping(ctx context.Context) error {
+	return r.rdb.Ping(ctx).Err()
+}
+
+// PoolStats returns connection pool statistics
+func (r *RedisDB) PoolStats() *redis.PoolStats {
+	return r.rdb.PoolStats()
+}
+
 func Disconnect() error {
 	if currRedisDB == nil {
 		return errors.New("redis DB isn't initialized")
@@ -14,13 +14,30 @@ import (
 
 const (
 	TransactionTable = "transactionTable"
-	expiration       = time.Minute * metrics.CheckMaxMinutes
+	// PendingChecksTable is a hash of transactions whose status is still polled
+	PendingChecksTable = "pendingChecks"
 )
 
+func expiration() time.Duration {
+	return time.Minute * time.Duration(metrics.GetCheckMaxMinutes())
+}
+
 var (
 	ctx = context.Background()
 )
 
+// StatusStore keeps statuses of checked transactions and pending checks, so checks survive restart.
+// Missing transaction is reported by TransactionNotFoundError.
+type StatusStore interface {
+	CommitTransaction(serverTransactionID uuid.UUID, status metrics.Status) error
+	UpdateStatus(serverTransactionID uuid.UUID, status metrics.Status) error
+	GetTransactionStatus(serverTransactionID uuid.UUID, status metrics.Status) (metrics.Status, error)
+	SavePendingCheck(serverTransactionID uuid.UUID, data []byte) error
+	DelPendingCheck(serverTransactionID uuid.UUID) error
+	GetPendingChecks() (map[string]string, error)
+	Ping(ctx context.Context) error
+}
+
 func getKey(serverTransactionID uuid.UUID) string {
 	return TransactionTable + ":" + serverTransactionID.String()
 }
@@ -41,7 +58,7 @@ func (r *RedisDB) CommitTransaction(serverTransactionID uuid.UUID, status metric
 	}
 
 	pipe := r.rdb.TxPipeline()
-	pipe.Set(ctx, getKey(serverTransactionID), status, expiration)
+	pipe.Set(ctx, getKey(serverTransactionID), status, expiration())
 	_, err := pipe.Exec(ctx)
 	return err
 }
@@ -57,9 +74,8 @@ func (r *RedisDB) UpdateStatus(serverTransactionID uuid.UUID, status metrics.Sta
 	if err != nil {
 		return err
 	}
-	duration := lifeSpan * time.Second
 	pipe := r.rdb.TxPipeline()
-	pipe.Set(ctx, getKey(serverTransactionID), status, duration)
+	pipe.Set(ctx, getKey(serverTransactionID), status, lifeSpan)
 	_, err = pipe.Exec(ctx)
 	return err
 }
@@ -99,6 +115,24 @@ func (r *RedisDB) ExistsTransaction(serverTransactionID uuid.UUID) bool {
 	return err == nil && val == 1
 }
 
+// _______________
+// Pending checks
+// _______________
+
+// SavePendingCheck stores serialized state of the status check, so it can be resumed after restart
+func (r *RedisDB) SavePendingCheck(serverTransactionID uuid.UUID, data []byte) error {
+	return r.rdb.HSet(ctx, PendingChecksTable, serverTransactionID.String(), data).Err()
+}
+
+func (r *RedisDB) DelPendingCheck(serverTransactionID uuid.UUID) error {
+	return r.rdb.HDel(ctx, PendingChecksTable, serverTransactionID.String()).Err()
+}
+
+// GetPendingChecks returns serialized checks by server transaction ID
+func (r *RedisDB) GetPendingChecks() (map[string]string, error) {
+	return r.rdb.HGetAll(ctx, PendingChecksTable).Result()
+}
+
 // Deleter
 
 func (r *RedisDB) DelKey(serverTransactionID uuid.UUID) { r.rdb.Del(ctx, getKey(serverTransactionID)) }
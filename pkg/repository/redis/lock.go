@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const lockPrefix = "lock:"
+
+// unlockScript deletes the lock only if it's still held by the owner
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// extendScript resets ttl of the lock only if it's still held by the owner
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// TryLock acquires lock shared by replicas, it expires after ttl if it isn't released
+func (r *RedisDB) TryLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	return r.rdb.SetNX(ctx, lockPrefix+name, owner, ttl).Result()
+}
+
+// Extend resets ttl of the lock if it's held by the owner, it returns false otherwise
+func (r *RedisDB) Extend(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	extended, err := extendScript.Run(ctx, r.rdb, []string{lockPrefix + name}, owner, ttl.Milliseconds()).Int()
+	return extended == 1, err
+}
+
+// Unlock releases lock if it's held by the owner
+func (r *RedisDB) Unlock(ctx context.Context, name, owner string) error {
+	return unlockScript.Run(ctx, r.rdb, []string{lockPrefix + name}, owner).Err()
+}
@@ -0,0 +1,144 @@
+// Package tracing configures OpenTelemetry tracing: exporter, W3C trace-context
+// propagation and helpers to start spans.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	instrumentationName = "github.com/imperatorofdwelling/Website-backend"
+)
+
+type Config struct {
+	// Exporter is none, stdout (local debugging) or otlp
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" default:"none"`
+	// Endpoint is OTLP/HTTP collector URL, e.g. http://otel-collector:4318
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName string  `yaml:"serviceName" env:"OTEL_SERVICE_NAME" default:"iod-payment"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"OTEL_TRACES_SAMPLE_RATIO" default:"1"`
+}
+
+func (c *Config) Validate() error {
+	switch c.Exporter {
+	case ExporterNone, ExporterStdout:
+	case ExporterOTLP:
+		if c.Endpoint == "" {
+			return errors.New("endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) is required for otlp exporter")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q (should be none, stdout or otlp)", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("sampleRatio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Init sets global tracer provider and W3C propagator. The returned function flushes
+// and stops the exporter, it must be called on shutdown.
+func Init(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	// Propagation works even if spans aren't exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts span which is a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records error (if any) and ends span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns ID of the trace in ctx, empty if there is no sampled span
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Inject returns W3C traceparent of the span in ctx, it's persisted with background work
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// StartLinked starts a new root span linked to the span described by traceparent.
+// It's used by background work (status polling) which outlives the original request.
+func StartLinked(ctx context.Context, name, traceparent string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(attrs...),
+	}
+	if traceparent != "" {
+		carrier := propagation.MapCarrier{"traceparent": traceparent}
+		origin := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+		if origin.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: origin}))
+		}
+	}
+	return Tracer().Start(ctx, name, opts...)
+}
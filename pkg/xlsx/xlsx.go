@@ -0,0 +1,158 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ContentType of XLSX files
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// maxSheetName is the limit of Excel
+const maxSheetName = 31
+
+// number matches decimals written as numeric cells, leading zeros (e.g. codes) keep the value a string
+var number = regexp.MustCompile(`^-?(0|[1-9][0-9]{0,14})(\.[0-9]+)?$`)
+
+const contentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ` +
+	`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ` +
+	`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`</Types>`
+
+const rootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" ` +
+	`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" ` +
+	`Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const workbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" ` +
+	`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" ` +
+	`Target="worksheets/sheet1.xml"/>` +
+	`</Relationships>`
+
+const workbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="%v" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const sheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetFooter = `</sheetData></worksheet>`
+
+// Writer writes a workbook of one sheet row by row. The sheet is the last part of the archive,
+// so rows are compressed and written to the output as they come, they aren't kept in memory.
+type Writer struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{name: "[Content_Types].xml", content: contentTypes},
+		{name: "_rels/.rels", content: rootRels},
+		{name: "xl/workbook.xml", content: fmt.Sprintf(workbook, escape(sheetTitle(sheetName)))},
+		{name: "xl/_rels/workbook.xml.rels", content: workbookRels},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(sheet, sheetHeader); err != nil {
+		return nil, err
+	}
+	return &Writer{zw: zw, sheet: sheet}, nil
+}
+
+// Write writes the row, decimals are numeric cells and other values are strings
+func (w *Writer) Write(record []string) error {
+	if w.sheet == nil {
+		return errors.New("xlsx: write to closed writer")
+	}
+	w.rows++
+	var b strings.Builder
+	b.WriteString(`<row r="` + strconv.Itoa(w.rows) + `">`)
+	for i, value := range record {
+		ref := column(i) + strconv.Itoa(w.rows)
+		if number.MatchString(value) {
+			b.WriteString(`<c r="` + ref + `"><v>` + value + `</v></c>`)
+			continue
+		}
+		b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+		b.WriteString(escape(value))
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(w.sheet, b.String())
+	return err
+}
+
+// Close finishes the workbook, the underlying writer isn't closed
+func (w *Writer) Close() error {
+	if w.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(w.sheet, sheetFooter)
+	w.sheet = nil
+	return errors.Join(err, w.zw.Close())
+}
+
+// column returns the column name of zero-based index: A, B, ..., Z, AA, ...
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// sheetTitle removes characters forbidden by Excel and shortens the name
+func sheetTitle(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxSheetName {
+		name = string(runes[:maxSheetName])
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
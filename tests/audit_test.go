@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditTestCase struct {
+	name           string
+	query          string
+	expectedStatus int
+	expectedError  string
+}
+
+func TestAuditEvents(t *testing.T) {
+	Init()
+	testCases := []auditTestCase{
+		{
+			name:           "OK",
+			query:          "?subject_type=card&limit=10",
+			expectedStatus: http.StatusOK,
+			expectedError:  "",
+		},
+		{
+			name:           "Bad request invalid from",
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "bad request, invalid filter",
+		},
+		{
+			name:           "Bad request invalid limit",
+			query:          "?limit=ten",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "bad request, invalid filter",
+		},
+	}
+
+	for _, tc := range testCases {
+		newTc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest("GET", "/admin/audit_events"+newTc.query, nil)
+
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, authorize(req, adminToken))
+
+			assert.Equal(t, newTc.expectedStatus, rr.Code)
+
+			if newTc.expectedError != "" {
+				respBody := new(endpoints.ErrorResponse)
+				_ = json.NewDecoder(rr.Body).Decode(respBody)
+				assert.Equal(t, newTc.expectedError, respBody.Error)
+			}
+		})
+	}
+}
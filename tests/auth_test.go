@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthentication(t *testing.T) {
+	Init()
+
+	testCases := []struct {
+		name           string
+		method         string
+		url            string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "Main site route without token",
+			method:         "GET",
+			url:            "/payment_methods?user_id=" + activeUserID,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Admin route without token",
+			method:         "GET",
+			url:            "/admin/audit_events",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin route with unknown token",
+			method:         "GET",
+			url:            "/admin/audit_events",
+			token:          "unknown-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Block without token",
+			method:         "POST",
+			url:            "/users/" + activeUserID + "/block",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Payout batch without token",
+			method:         "POST",
+			url:            "/payouts/batch",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin route with admin token",
+			method:         "GET",
+			url:            "/admin/audit_events",
+			token:          adminToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Probe without token",
+			method:         "GET",
+			url:            "/healthz",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest(tc.method, tc.url, nil)
+			if tc.token != "" {
+				authorize(req, tc.token)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestAuditActorIsPrincipal(t *testing.T) {
+	Init()
+
+	body, _ := json.Marshal(&endpoints.RegisterUser{ExternalID: "site-" + uuid.NewString()})
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	user := new(models.User)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(user))
+
+	// The actor header of the caller isn't trusted
+	req, _ = http.NewRequest("POST", "/users/"+user.Id.String()+"/block", nil)
+	req.Header.Set("X-Actor-ID", "someone-else")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorize(req, adminToken))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/admin/audit_events?subject_type=user&subject_id="+user.Id.String(), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, authorize(req, adminToken))
+	require.Equal(t, http.StatusOK, rr.Code)
+	resp := new(endpoints.AuditEventsResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(resp))
+
+	actors := make(map[string]string)
+	for _, event := range resp.Events {
+		actors[event.EventType] = event.ActorID
+	}
+	assert.Equal(t, user.Id.String(), actors[postgres.AuditUserRegistered])
+	assert.Equal(t, adminOperator, actors[postgres.AuditUserBlocked])
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/billing"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestDueDates(t *testing.T) {
+	testCases := []struct {
+		name      string
+		start     string
+		anchorDay int
+		interval  int
+		expected  []string
+	}{
+		{
+			name:      "Monthly from anchor day",
+			start:     "2024-01-15",
+			anchorDay: 15,
+			interval:  1,
+			expected:  []string{"2024-01-15", "2024-02-15", "2024-03-15"},
+		},
+		{
+			name:      "Anchor day passed in start month",
+			start:     "2024-01-20",
+			anchorDay: 5,
+			interval:  1,
+			expected:  []string{"2024-02-05", "2024-03-05"},
+		},
+		{
+			name:      "Last day of shorter months",
+			start:     "2024-01-31",
+			anchorDay: 31,
+			interval:  1,
+			expected:  []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"},
+		},
+		{
+			name:      "Quarterly",
+			start:     "2024-11-01",
+			anchorDay: 1,
+			interval:  3,
+			expected:  []string{"2024-11-01", "2025-02-01", "2025-05-01"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			due := billing.FirstDueDate(date(tc.start), tc.anchorDay)
+			for _, expected := range tc.expected {
+				assert.Equal(t, expected, due.Format("2006-01-02"))
+				due = billing.NextDueDate(due, tc.anchorDay, tc.interval)
+			}
+		})
+	}
+}
+
+// fakeCharger returns statuses in order, keys are the idempotence keys of the charges
+type fakeCharger struct {
+	statuses []metrics.Status
+	keys     []string
+}
+
+func (f *fakeCharger) Charge(_ context.Context, _ *postgres.Subscription, _ *postgres.BillingCycle,
+	key string) (*billing.Charge, error) {
+	status := f.statuses[len(f.keys)]
+	f.keys = append(f.keys, key)
+	return &billing.Charge{PaymentID: key, Status: status}, nil
+}
+
+func (f *fakeCharger) Status(context.Context, string) (metrics.Status, error) {
+	return metrics.Succeeded, nil
+}
+
+// fakeLocker is lost by the extension after lostAfter ones if lostAfter is set
+type fakeLocker struct {
+	held       bool
+	lostAfter  int
+	extensions int
+}
+
+func (f *fakeLocker) TryLock(context.Context, string, string, time.Duration) (bool, error) {
+	if f.held {
+		return false, nil
+	}
+	f.held = true
+	return true, nil
+}
+
+func (f *fakeLocker) Extend(context.Context, string, string, time.Duration) (bool, error) {
+	if f.lostAfter > 0 && f.extensions == f.lostAfter {
+		f.held = false
+	}
+	f.extensions++
+	return f.held, nil
+}
+
+func (f *fakeLocker) Unlock(context.Context, string, string) error {
+	f.held = false
+	return nil
+}
+
+func TestBillingDunning(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &billing.Config{TickInterval: time.Minute, MaxRetries: 1, RetryInterval: 24 * time.Hour, LockTTL: time.Minute}
+	// Cycles are opened with the current time as their first attempt, so the schedule is in the next year
+	day := func(monthDay string) time.Time {
+		return date(fmt.Sprintf("%v-%v", time.Now().Year()+1, monthDay))
+	}
+	end := day("02-15")
+	newSubs := func(t *testing.T) *memory.SubscriptionStore {
+		subs := memory.NewSubscriptionStore()
+		require.NoError(t, subs.InsertSubscription(context.Background(), &postgres.Subscription{
+			TenantID:        "tenant-1",
+			LandlordID:      "landlord-1",
+			PaymentMethodID: 1,
+			Amount:          "50000.00",
+			Currency:        "RUB",
+			IntervalMonths:  1,
+			AnchorDay:       15,
+			NextChargeAt:    day("01-15"),
+			EndDate:         &end,
+		}))
+		return subs
+	}
+	cycles := func(t *testing.T, subs *memory.SubscriptionStore) []*postgres.BillingCycle {
+		cycles, err := subs.GetCycles(context.Background(), 1)
+		require.NoError(t, err)
+		return cycles
+	}
+	status := func(t *testing.T, subs *memory.SubscriptionStore) string {
+		sub, err := subs.GetSubscription(context.Background(), 1)
+		require.NoError(t, err)
+		return sub.Status
+	}
+	now := day("01-15").Add(10 * time.Hour)
+
+	t.Run("Retry succeeds", func(t *testing.T) {
+		subs := newSubs(t)
+		charger := &fakeCharger{statuses: []metrics.Status{metrics.Canceled, metrics.Succeeded}}
+		scheduler := billing.NewScheduler(log, cfg, subs, charger, &fakeLocker{})
+
+		require.NoError(t, scheduler.Tick(context.Background(), now))
+		require.Len(t, cycles(t, subs), 1)
+		assert.Equal(t, postgres.CyclePending, cycles(t, subs)[0].Status)
+		assert.Equal(t, now.Add(cfg.RetryInterval), cycles(t, subs)[0].NextAttemptAt)
+
+		// Retry isn't due yet
+		require.NoError(t, scheduler.Tick(context.Background(), now.Add(time.Hour)))
+		assert.Len(t, charger.keys, 1)
+
+		require.NoError(t, scheduler.Tick(context.Background(), now.Add(cfg.RetryInterval)))
+		assert.Equal(t, postgres.CycleSucceeded, cycles(t, subs)[0].Status)
+		assert.Equal(t, []string{"billing-cycle-1-1", "billing-cycle-1-2"}, charger.keys)
+		assert.Equal(t, postgres.SubscriptionActive, status(t, subs))
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		subs := newSubs(t)
+		charger := &fakeCharger{statuses: []metrics.Status{metrics.Canceled, metrics.Canceled}}
+		scheduler := billing.NewScheduler(log, cfg, subs, charger, &fakeLocker{})
+
+		require.NoError(t, scheduler.Tick(context.Background(), now))
+		require.NoError(t, scheduler.Tick(context.Background(), now.Add(cfg.RetryInterval)))
+		assert.Equal(t, postgres.CycleFailed, cycles(t, subs)[0].Status)
+		assert.Equal(t, 2, cycles(t, subs)[0].Attempt)
+		assert.Equal(t, postgres.SubscriptionPastDue, status(t, subs))
+	})
+
+	t.Run("Missed cycles are opened until the end date", func(t *testing.T) {
+		subs := newSubs(t)
+		charger := &fakeCharger{statuses: []metrics.Status{metrics.Succeeded, metrics.Succeeded}}
+		scheduler := billing.NewScheduler(log, cfg, subs, charger, &fakeLocker{})
+
+		require.NoError(t, scheduler.Tick(context.Background(), day("04-01")))
+		require.Len(t, cycles(t, subs), 2)
+		assert.Equal(t, day("02-15"), cycles(t, subs)[1].DueDate)
+		assert.Equal(t, postgres.SubscriptionCompleted, status(t, subs))
+	})
+
+	t.Run("Lock is lost during the tick", func(t *testing.T) {
+		subs := newSubs(t)
+		charger := &fakeCharger{statuses: []metrics.Status{metrics.Succeeded, metrics.Succeeded}}
+		scheduler := billing.NewScheduler(log, cfg, subs, charger, &fakeLocker{lostAfter: 1})
+
+		err := scheduler.Tick(context.Background(), day("04-01"))
+		require.ErrorIs(t, err, billing.ErrLockLost)
+		require.Len(t, cycles(t, subs), 2)
+		// The second cycle is left to the replica holding the lock
+		assert.Equal(t, []string{"billing-cycle-1-1"}, charger.keys)
+	})
+
+	t.Run("Lock is held by another replica", func(t *testing.T) {
+		subs := newSubs(t)
+		charger := &fakeCharger{}
+		scheduler := billing.NewScheduler(log, cfg, subs, charger, &fakeLocker{held: true})
+
+		require.NoError(t, scheduler.Tick(context.Background(), now))
+		assert.Empty(t, cycles(t, subs))
+	})
+}
+
+func TestRecurringChargeOfBlockedTenant(t *testing.T) {
+	Init()
+
+	users := memory.NewUserStore()
+	user := models.NewUser()
+	user.ExternalID = "site-blocked-tenant"
+	_, err := users.RegisterUser(context.Background(), user)
+	require.NoError(t, err)
+	_, err = users.SetUserStatus(context.Background(), user.Id, models.UserStatusBlocked)
+	require.NoError(t, err)
+
+	// The charge is canceled before the payment method is read or YooKassa is called
+	charger := endpoints.NewRecurringCharger(logger, nil, nil, users, nil, nil, nil)
+	sub := &postgres.Subscription{ID: 1, TenantID: user.Id.String(), PaymentMethodID: 1}
+	charge, err := charger.Charge(context.Background(), sub, &postgres.BillingCycle{ID: 1}, "key")
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Canceled, charge.Status)
+	assert.Equal(t, endpoints.ErrUserBlocked.Error(), charge.Reason)
+
+	sub.TenantID = uuid.NewString()
+	charge, err = charger.Charge(context.Background(), sub, &postgres.BillingCycle{ID: 1}, "key")
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Canceled, charge.Status)
+	assert.Equal(t, endpoints.ErrUserNotFound.Error(), charge.Reason)
+}
@@ -0,0 +1,191 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestDatabase creates an empty database on the Postgres server of .env (see docker-compose.yaml)
+// and drops it when the test is finished.
+//
+//	go test -tags integration ./tests/
+func createTestDatabase(t *testing.T) *postgres.PostgresSQLConfig {
+	server, err := sqlx.Open("postgres", cfg.PostgresSQLConfig.URL())
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	require.NoError(t, err)
+	dbCfg := *cfg.PostgresSQLConfig
+	dbCfg.DBName = "iod_payment_test_" + hex.EncodeToString(suffix)
+	_, err = server.Exec(`CREATE DATABASE ` + dbCfg.DBName)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := server.Exec(`DROP DATABASE IF EXISTS ` + dbCfg.DBName + ` WITH (FORCE)`)
+		assert.NoError(t, err)
+	})
+	return &dbCfg
+}
+
+// newTestDatabase creates an empty database and applies the migrations to it
+func newTestDatabase(t *testing.T) *postgres.PostgresSQLConfig {
+	dbCfg := createTestDatabase(t)
+	m, err := postgres.NewMigrate(dbCfg)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Up())
+	return dbCfg
+}
+
+func TestUsersCardUUIDMigration(t *testing.T) {
+	dbCfg := createTestDatabase(t)
+	m, err := postgres.NewMigrate(dbCfg)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Migrate(12))
+
+	db, err := sqlx.Open("postgres", dbCfg.URL())
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Card of bigint user_id of the first schema
+	_, err = db.Exec(`INSERT INTO public.users_card (user_id, card_synonym, card_mask) VALUES (42, 'legacy', '4444')`)
+	require.NoError(t, err)
+	// Deployments which changed the column to text by hand saved UUID of the user
+	_, err = db.Exec(`ALTER TABLE public.users_card ALTER COLUMN user_id TYPE text`)
+	require.NoError(t, err)
+	owner := uuid.New()
+	_, err = db.Exec(`INSERT INTO public.users_card (user_id, card_synonym, card_mask) VALUES ($1, 'older', '1111')`,
+		owner.String())
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO public.users_card (user_id, card_synonym, card_mask) VALUES ($1, 'newer', NULL)`,
+		owner.String())
+	require.NoError(t, err)
+
+	require.NoError(t, m.Migrate(13))
+
+	var columnType string
+	require.NoError(t, db.Get(&columnType, `SELECT data_type FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name = 'users_card' AND column_name = 'user_id'`))
+	assert.Equal(t, "uuid", columnType)
+
+	// The latest card of the user is kept
+	var cards []struct {
+		UserID      string    `db:"user_id"`
+		CardSynonym string    `db:"card_synonym"`
+		CardMask    string    `db:"card_mask"`
+		CreatedAt   time.Time `db:"created_at"`
+		UpdatedAt   time.Time `db:"updated_at"`
+	}
+	require.NoError(t, db.Select(&cards,
+		`SELECT user_id, card_synonym, card_mask, created_at, updated_at FROM public.users_card`))
+	require.Len(t, cards, 1)
+	assert.Equal(t, owner.String(), cards[0].UserID)
+	assert.Equal(t, "newer", cards[0].CardSynonym)
+	assert.Equal(t, "", cards[0].CardMask)
+	assert.False(t, cards[0].CreatedAt.IsZero())
+	assert.False(t, cards[0].UpdatedAt.IsZero())
+
+	_, err = db.Exec(`INSERT INTO public.users_card (user_id, card_synonym, card_mask) VALUES ($1, 'second', '2222')`,
+		owner.String())
+	assert.Error(t, err, "the user has one card")
+
+	// The card which isn't bound to UUID of the user is moved aside
+	var legacy []string
+	require.NoError(t, db.Select(&legacy, `SELECT card_synonym FROM public.users_card_legacy`))
+	assert.Equal(t, []string{"legacy"}, legacy)
+
+	require.NoError(t, m.Up())
+}
+
+func TestCardRepository(t *testing.T) {
+	ctx := context.Background()
+	dbCfg := newTestDatabase(t)
+	db, err := postgres.Connect(dbCfg)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CheckSchema(ctx))
+
+	ring, err := keyring.New(ctx, postgres.NewKeyRepository(db), newMasterKey(t, "test"))
+	require.NoError(t, err)
+	db.SetCardCipher(ring)
+
+	owner := &models.User{Id: uuid.New()}
+	card, err := db.GetRefillableCardByUserID(ctx, owner.Id)
+	require.NoError(t, err)
+	assert.Nil(t, card)
+
+	_, err = db.InsertOrUpdateRefillableCard(ctx, models.NewRefillableCard(owner, "", "555555******4444"))
+	assert.Error(t, err)
+
+	isUpdated, err := db.InsertOrUpdateRefillableCard(ctx,
+		models.NewRefillableCard(owner, "synonym-1", "555555******4444"))
+	require.NoError(t, err)
+	assert.False(t, isUpdated)
+
+	card, err = db.GetRefillableCardByUserID(ctx, owner.Id)
+	require.NoError(t, err)
+	require.NotNil(t, card)
+	assert.Equal(t, owner.Id.String(), card.UserId)
+	assert.Equal(t, "synonym-1", card.CardSynonym)
+	assert.Equal(t, "555555******4444", card.CardMask)
+	assert.Equal(t, ring.ActiveKeyID(), card.KeyID)
+	assert.False(t, card.CreatedAt.IsZero())
+
+	isUpdated, err = db.InsertOrUpdateRefillableCard(ctx,
+		models.NewRefillableCard(owner, "synonym-2", "444444******1111"))
+	require.NoError(t, err)
+	assert.True(t, isUpdated)
+
+	// user_id is unique, so the card is replaced instead of inserting another one
+	_, err = db.InsertNewRefillableCard(ctx, models.NewRefillableCard(owner, "synonym-3", "444444******1111"))
+	assert.Error(t, err)
+
+	updated, err := db.UpdateRefillableCardInfo(ctx, models.NewRefillableCard(owner, "synonym-4", "333333******2222"))
+	require.NoError(t, err)
+	assert.Equal(t, card.Id, updated.Id)
+	assert.Equal(t, "synonym-4", updated.CardSynonym)
+	assert.Equal(t, "333333******2222", updated.CardMask)
+	assert.True(t, card.CreatedAt.Equal(updated.CreatedAt))
+	assert.False(t, updated.UpdatedAt.Before(card.UpdatedAt))
+
+	_, err = db.UpdateRefillableCardInfo(ctx,
+		models.NewRefillableCard(&models.User{Id: uuid.New()}, "synonym-5", "333333******2222"))
+	assert.Error(t, err)
+
+	other := &models.User{Id: uuid.New()}
+	inserted, err := db.InsertNewRefillableCard(ctx, models.NewRefillableCard(other, "synonym-6", "222222******3333"))
+	require.NoError(t, err)
+	assert.Equal(t, other.Id.String(), inserted.UserId)
+
+	// Every card is re-encrypted with the new data key
+	_, err = ring.Rotate(ctx)
+	require.NoError(t, err)
+	reEncrypted, err := db.ReEncryptRefillableCards(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reEncrypted)
+	card, err = db.GetRefillableCardByUser(ctx, owner)
+	require.NoError(t, err)
+	assert.Equal(t, "synonym-4", card.CardSynonym)
+	assert.Equal(t, ring.ActiveKeyID(), card.KeyID)
+
+	m, err := postgres.NewMigrate(dbCfg)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Down())
+}
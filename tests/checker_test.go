@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckerMaxActiveChecks(t *testing.T) {
+	Init()
+
+	const maxActive = 5
+	limited := *cfg.Checker
+	limited.MaxActiveChecks = maxActive
+	metrics.Init(nil, &limited)
+	t.Cleanup(func() { metrics.Init(nil, cfg.Checker) })
+
+	checker := webhook.NewChecker(logger, memory.NewStatusStore(), yookassatest.NewFake())
+	t.Cleanup(func() { _ = checker.Shutdown(context.Background()) })
+
+	// Checks are started at once, none of them is finished before the first poll (a minute later)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	started, rejected := 0, 0
+	for i := 0; i < 10*maxActive; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			whData := webhook.NewWebhookData(metrics.PayoutsEndpoint, uuid.NewString(), uuid.New(), "100.00")
+			err := checker.StartCheck(context.Background(), whData, metrics.Pending)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				started++
+			case errors.Is(err, webhook.TooManyChecks):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, maxActive, started)
+	assert.Equal(t, 9*maxActive, rejected)
+	require.EqualValues(t, maxActive, checker.ActiveChecks())
+}
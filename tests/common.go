@@ -1,26 +1,123 @@
 package tests
 
 import (
-	"github.com/imperatorofdwelling/Website-backend/config"
+	"context"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"sync"
 
+	"github.com/imperatorofdwelling/Website-backend/config"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
 	srv "github.com/imperatorofdwelling/Website-backend/internal/server/http"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
 	internalLogger "github.com/imperatorofdwelling/Website-backend/pkg/logger"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
 	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+)
+
+// Users registered by Init. boundCardUserID and blockedUserID have cards bound.
+const (
+	boundCardUserID = "69c1f84f-8fd8-480b-b5fe-4aaf96826791"
+	activeUserID    = "0f8e8a4e-5d6f-4c1b-9d55-2a4f6e3c7b10"
+	blockedUserID   = "b3d7c2a1-8e4f-4a6b-9c0d-1e2f3a4b5c6d"
+)
+
+// Bearer tokens of the router built by Init
+const (
+	adminOperator = "operator-1"
+	adminToken    = "admin-token"
 )
 
 var (
-	dbCfg  = config.LoadConfig("../.env").PostgresSQLConfig
-	logger = internalLogger.New(internalLogger.EnvLocal)
-	router http.Handler
+	cfg               = loadConfig()
+	logger, logCloser = newLogger()
+	router            http.Handler
+	initOnce          sync.Once
 )
 
+// Init builds the router once. Repositories are kept in memory and YooKassa is faked, so tests run
+// without Postgres, Redis and the sandbox store. Reports are aggregated by SQL, report tests use reportRouter.
 func Init() {
-	if err := postgres.InitPostgresDB(dbCfg); err != nil {
-		logger.Error("failed to init DB instance", slog.String("error", err.Error()))
+	initOnce.Do(func() {
+		metrics.Init(cfg.YooKassa, cfg.Checker)
+		router = newRouter(yookassatest.NewFake(), memory.NewLogRepository())
+	})
+}
+
+// newRouter builds the router of in-memory repositories with users of Init and the gateway,
+// logs are passed to check what the handlers record
+func newRouter(gateway yookassa.Gateway, logs postgres.LogRepository) http.Handler {
+	ctx := context.Background()
+	users := memory.NewUserStore()
+	cards := memory.NewCardStore()
+	for _, id := range []string{boundCardUserID, activeUserID, blockedUserID} {
+		user := models.NewUser()
+		user.Id = uuid.MustParse(id)
+		user.ExternalID = "site-" + id
+		_, _ = users.RegisterUser(ctx, user)
+		if id != activeUserID {
+			card := models.NewRefillableCard(user, "fake-synonym", "555555******4444")
+			_, _ = cards.InsertOrUpdateRefillableCard(ctx, card)
+		}
 	}
-	db, _ := postgres.GetDB()
-	logRepo := postgres.NewLogRepository(db)
-	router = srv.NewRouter(logger, logRepo)
+	_, _ = users.SetUserStatus(ctx, uuid.MustParse(blockedUserID), models.UserStatusBlocked)
+	statuses := memory.NewStatusStore()
+
+	auth := srv.AuthConfig{AdminTokens: []string{adminOperator + ":" + adminToken}}
+	return srv.NewRouter(logger, auth, &srv.Dependencies{
+		Logs:          logs,
+		Audit:         memory.NewAuditRepository(),
+		Receipts:      memory.NewReceiptStore(),
+		Methods:       memory.NewPaymentMethodStore(),
+		Subscriptions: memory.NewSubscriptionStore(),
+		Ledger:        memory.NewLedgerStore(),
+		Commissions:   memory.NewCommissionStore(),
+		Batches:       memory.NewPayoutBatchStore(),
+		Destinations:  memory.NewPayoutDestinationStore(),
+		Users:         users,
+		Cards:         cards,
+		Database:      memory.Database{},
+		Statuses:      statuses,
+		Gateway:       gateway,
+		Checker:       webhook.NewChecker(logger, statuses, gateway),
+		Background:    endpoints.NewBackground(),
+	})
+}
+
+// loadConfig loads ../.env, the master key isn't committed, so a random one is used if it isn't set
+func loadConfig() *config.Config {
+	if os.Getenv("CARD_MASTER_KEY") == "" && os.Getenv("CARD_MASTER_KEY_FILE") == "" {
+		key, err := keyring.GenerateKey()
+		if err != nil {
+			panic(err)
+		}
+		if err = os.Setenv("CARD_MASTER_KEY", key); err != nil {
+			panic(err)
+		}
+	}
+	return config.LoadConfig("../.env")
+}
+
+// newLogger builds the logger of the config, tests don't run with a broken logger config
+func newLogger() (*slog.Logger, io.Closer) {
+	log, closer, err := internalLogger.New(internalLogger.EnvLocal, cfg.Logger)
+	if err != nil {
+		panic(err)
+	}
+	return log, closer
+}
+
+// authorize sets bearer token of the request
+func authorize(req *http.Request, token string) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
 }
@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSections(t *testing.T) {
+	// The migrator has no master key
+	t.Setenv("CARD_MASTER_KEY", "")
+	t.Setenv("CARD_MASTER_KEY_FILE", "")
+
+	_, err := config.Load("../.env")
+	assert.ErrorContains(t, err, "CARD_MASTER_KEY")
+
+	loaded, err := config.Load("../.env", config.SectionPostgres)
+	require.NoError(t, err)
+	assert.NotEmpty(t, loaded.PostgresSQLConfig.DBHost)
+
+	_, err = config.Load("../.env", config.SectionPostgres, config.SectionKeyring)
+	assert.ErrorContains(t, err, "CARD_MASTER_KEY")
+}
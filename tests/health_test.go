@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthz(t *testing.T) {
+	Init()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	respBody := new(endpoints.HealthResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(respBody))
+	assert.Equal(t, endpoints.StatusUp, respBody.Status)
+}
+
+// fakeDatabase is Postgres of readiness checks, it's down if err is set
+type fakeDatabase struct {
+	err     error
+	version uint
+	dirty   bool
+}
+
+func (f *fakeDatabase) PingContext(context.Context) error {
+	return f.err
+}
+
+func (f *fakeDatabase) GetSchemaVersion(context.Context) (uint, bool, error) {
+	return f.version, f.dirty, f.err
+}
+
+// fakePinger is Redis of readiness checks, it's down if err is set
+type fakePinger struct {
+	err error
+}
+
+func (f *fakePinger) Ping(context.Context) error {
+	return f.err
+}
+
+// downGateway is YooKassa which can't be reached
+type downGateway struct{}
+
+func (downGateway) Do(context.Context, string, string, any, string) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestReadyz(t *testing.T) {
+	down := errors.New("connection refused")
+
+	testCases := []struct {
+		name           string
+		db             *fakeDatabase
+		redis          *fakePinger
+		gateway        yookassa.Gateway
+		expectedStatus int
+		// expectedDown are the checks which are down
+		expectedDown []string
+	}{
+		{
+			name:           "All dependencies are up",
+			db:             &fakeDatabase{version: postgres.SchemaVersion},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Postgres is down",
+			db:             &fakeDatabase{err: down},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDown:   []string{"postgres", "migrations"},
+		},
+		{
+			name:           "Redis is down",
+			db:             &fakeDatabase{version: postgres.SchemaVersion},
+			redis:          &fakePinger{err: down},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDown:   []string{"redis"},
+		},
+		{
+			name:           "Schema isn't migrated",
+			db:             &fakeDatabase{version: postgres.SchemaVersion - 1},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDown:   []string{"migrations"},
+		},
+		{
+			name:           "Schema of the next release",
+			db:             &fakeDatabase{version: postgres.SchemaVersion + 1},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Schema is newer than supported",
+			db:             &fakeDatabase{version: postgres.MaxSchemaVersion + 1},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDown:   []string{"migrations"},
+		},
+		{
+			name:           "Migration is dirty",
+			db:             &fakeDatabase{version: postgres.SchemaVersion, dirty: true},
+			redis:          &fakePinger{},
+			gateway:        yookassatest.NewFake(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDown:   []string{"migrations"},
+		},
+		{
+			name:           "YooKassa is down",
+			db:             &fakeDatabase{version: postgres.SchemaVersion},
+			redis:          &fakePinger{},
+			gateway:        downGateway{},
+			expectedStatus: http.StatusOK,
+			expectedDown:   []string{"yookassa"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := endpoints.NewHealthHandler(logger, tc.db, tc.redis, tc.gateway)
+			req, _ := http.NewRequest("GET", "/readyz", nil)
+			rr := httptest.NewRecorder()
+			handler.Readyz(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			respBody := new(endpoints.HealthResponse)
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(respBody))
+			expectedBody := endpoints.StatusUp
+			if tc.expectedStatus != http.StatusOK {
+				expectedBody = endpoints.StatusDown
+			}
+			assert.Equal(t, expectedBody, respBody.Status)
+
+			downChecks := make([]string, 0)
+			for _, name := range []string{"postgres", "redis", "migrations", "yookassa"} {
+				require.Contains(t, respBody.Checks, name)
+				if respBody.Checks[name].Status == endpoints.StatusDown {
+					downChecks = append(downChecks, name)
+				}
+			}
+			assert.ElementsMatch(t, tc.expectedDown, downChecks)
+			assert.False(t, respBody.Checks["yookassa"].Critical)
+		})
+	}
+}
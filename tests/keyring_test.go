@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryKeyStore struct {
+	keys []*keyring.DataKey
+}
+
+func (s *memoryKeyStore) GetDataKeys(context.Context) ([]*keyring.DataKey, error) {
+	return s.keys, nil
+}
+
+func (s *memoryKeyStore) InsertActiveDataKey(_ context.Context, key *keyring.DataKey) error {
+	for _, k := range s.keys {
+		k.IsActive = false
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func newMasterKey(t *testing.T, id string) *keyring.MasterKey {
+	encoded, err := keyring.GenerateKey()
+	require.NoError(t, err)
+	master, err := keyring.LoadMasterKey(&keyring.Config{MasterKeyID: id, MasterKey: encoded})
+	require.NoError(t, err)
+	return master
+}
+
+func TestKeyring(t *testing.T) {
+	store := &memoryKeyStore{}
+	master := newMasterKey(t, "first")
+
+	ring, err := keyring.New(context.Background(), store, master)
+	require.NoError(t, err)
+	require.Len(t, store.keys, 1)
+
+	ciphertext, keyID, err := ring.Encrypt("testSinonim1")
+	require.NoError(t, err)
+	assert.NotContains(t, ciphertext, "testSinonim1")
+	assert.Equal(t, ring.ActiveKeyID(), keyID)
+
+	// Data keys survive restart
+	ring, err = keyring.New(context.Background(), store, master)
+	require.NoError(t, err)
+	synonym, err := ring.Decrypt(context.Background(), ciphertext, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, "testSinonim1", synonym)
+
+	// Rotation of master key: old data keys are readable with the previous master
+	newMaster := newMasterKey(t, "second")
+	rotated, err := keyring.New(context.Background(), store, newMaster, master)
+	require.NoError(t, err)
+	assert.NotEqual(t, keyID, rotated.ActiveKeyID())
+	synonym, err = rotated.Decrypt(context.Background(), ciphertext, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, "testSinonim1", synonym)
+
+	// Without the previous master old rows can't be read
+	withoutPrevious, err := keyring.New(context.Background(), store, newMaster)
+	require.NoError(t, err)
+	_, err = withoutPrevious.Decrypt(context.Background(), ciphertext, keyID)
+	assert.ErrorIs(t, err, keyring.ErrUnknownDataKey)
+
+	// Tampered ciphertext
+	_, err = ring.Decrypt(context.Background(), "AAAA"+ciphertext[4:], keyID)
+	assert.ErrorIs(t, err, keyring.ErrInvalidCipher)
+}
+
+func TestKeyringLoadsKeyOfAnotherReplica(t *testing.T) {
+	store := &memoryKeyStore{}
+	master := newMasterKey(t, "first")
+	ring, err := keyring.New(context.Background(), store, master)
+	require.NoError(t, err)
+	replica, err := keyring.New(context.Background(), store, master)
+	require.NoError(t, err)
+
+	// The replica rotates the data key after the keyring was loaded
+	keyID, err := replica.Rotate(context.Background())
+	require.NoError(t, err)
+	ciphertext, _, err := replica.Encrypt("testSinonim1")
+	require.NoError(t, err)
+
+	synonym, err := ring.Decrypt(context.Background(), ciphertext, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, "testSinonim1", synonym)
+	assert.Equal(t, keyID, ring.ActiveKeyID())
+
+	_, err = ring.Decrypt(context.Background(), ciphertext, "unknown")
+	assert.ErrorIs(t, err, keyring.ErrUnknownDataKey)
+}
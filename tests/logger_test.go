@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	httpServer "github.com/imperatorofdwelling/Website-backend/internal/server/http"
+	internalLogger "github.com/imperatorofdwelling/Website-backend/pkg/logger"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := slog.New(slog.NewJSONHandler(buf, nil))
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(httpServer.RequestLogger(log))
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		internalLogger.AddAttrs(r.Context(), slog.String("user_id", chi.URLParam(r, "id")))
+		internalLogger.FromContext(r.Context(), nil).Info("handler called")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req, _ := http.NewRequest("GET", "/users/42", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	handlerLine := make(map[string]any)
+	require.NoError(t, json.Unmarshal(lines[0], &handlerLine))
+	assert.Equal(t, "/users/{id}", handlerLine["route"])
+	assert.Equal(t, "10.0.0.1", handlerLine["remote_ip"])
+	assert.NotEmpty(t, handlerLine["request_id"])
+	assert.Equal(t, "42", handlerLine["user_id"])
+
+	accessLine := make(map[string]any)
+	require.NoError(t, json.Unmarshal(lines[1], &accessLine))
+	assert.Equal(t, "request completed", accessLine["msg"])
+	assert.Equal(t, handlerLine["request_id"], accessLine["request_id"])
+	assert.Equal(t, "42", accessLine["user_id"])
+	assert.Equal(t, float64(http.StatusTeapot), accessLine["status"])
+}
+
+func TestRedactHandler(t *testing.T) {
+	type card struct {
+		Synonym string `json:"card_synonym"`
+		Mask    string `json:"card_mask"`
+	}
+
+	testCases := []struct {
+		name     string
+		attr     slog.Attr
+		expected any
+	}{
+		{
+			name:     "card synonym",
+			attr:     slog.String("card_synonym", "1da5c87d-0984-50e8-a7f3-8de646dd9ec9"),
+			expected: "[REDACTED]",
+		},
+		{
+			name:     "secret key",
+			attr:     slog.String("SecretKey", "test_secret"),
+			expected: "[REDACTED]",
+		},
+		{
+			name:     "password in group",
+			attr:     slog.Group("db", slog.String("password", "qwerty")),
+			expected: map[string]any{"password": "[REDACTED]"},
+		},
+		{
+			name:     "PAN in text",
+			attr:     slog.String("error", "card 4111 1111 1111 1111 declined"),
+			expected: "card 411111******1111 declined",
+		},
+		{
+			name:     "not a PAN",
+			attr:     slog.String("transaction_id", "1234567890123456"),
+			expected: "1234567890123456",
+		},
+		{
+			name:     "struct",
+			attr:     slog.Any("card", card{Synonym: "secret", Mask: "411111******1111"}),
+			expected: map[string]any{"card_synonym": "[REDACTED]", "card_mask": "411111******1111"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			log := slog.New(internalLogger.NewRedactHandler(slog.NewJSONHandler(buf, nil)))
+			log.Info("test", tc.attr)
+
+			line := make(map[string]any)
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+			assert.Equal(t, tc.expected, line[tc.attr.Key])
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	file, err := internalLogger.OpenRotatingFile(path, 10, time.Hour, 2)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = file.Write([]byte("12345678\n"))
+		require.NoError(t, err)
+		// Backups are named by time with milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Len(t, backups, 2)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "12345678\n", string(data))
+}
@@ -0,0 +1,15 @@
+package tests
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain closes the log output opened by newLogger after the tests
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if err := logCloser.Close(); err != nil {
+		logger.Error("failed to close log output", "error", err)
+	}
+	os.Exit(code)
+}
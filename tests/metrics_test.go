@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	Init()
+	// Request to be measured
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `iod_payment_http_requests_total{code="200",method="GET",route="/healthz"}`)
+	assert.Contains(t, body, "iod_payment_http_request_duration_seconds_bucket")
+}
+
+func TestDescriptionDefaults(t *testing.T) {
+	Init()
+	t.Cleanup(func() { metrics.Init(cfg.YooKassa, nil) })
+
+	yooKassa := *cfg.YooKassa
+	yooKassa.Descriptions = metrics.DescriptionConfig{Payout: "Payout {{.TransactionID}}"}
+	metrics.Init(&yooKassa, nil)
+	assert.Equal(t, metrics.DescriptionConfig{
+		Payment: metrics.DefaultPaymentDescription,
+		Payout:  "Payout {{.TransactionID}}",
+	}, metrics.GetDescriptionTemplates())
+
+	// Templates of the previous config aren't kept
+	yooKassa.Descriptions = metrics.DescriptionConfig{}
+	metrics.Init(&yooKassa, nil)
+	assert.Equal(t, metrics.DescriptionConfig{
+		Payment: metrics.DefaultPaymentDescription,
+		Payout:  metrics.DefaultPayoutDescription,
+	}, metrics.GetDescriptionTemplates())
+}
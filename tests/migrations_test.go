@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	src, err := postgres.MigrationsSource()
+	require.NoError(t, err)
+	defer src.Close()
+
+	var last uint
+	version, err := src.First()
+	for err == nil {
+		for name, read := range map[string]func(uint) (io.ReadCloser, string, error){
+			"up":   src.ReadUp,
+			"down": src.ReadDown,
+		} {
+			r, _, readErr := read(version)
+			if assert.NoError(t, readErr, "%v migration of version %v", name, version) {
+				r.Close()
+			}
+		}
+		last = version
+		version, err = src.Next(version)
+	}
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+	assert.Equal(t, uint(postgres.SchemaVersion), last, "SchemaVersion must be the last migration")
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	assert.NoError(t, postgres.CheckSchemaVersion(postgres.SchemaVersion, false))
+	assert.Error(t, postgres.CheckSchemaVersion(postgres.SchemaVersion, true))
+	assert.Error(t, postgres.CheckSchemaVersion(postgres.SchemaVersion-1, false))
+	// The next release is migrated while this one is running
+	assert.NoError(t, postgres.CheckSchemaVersion(postgres.SchemaVersion+1, false))
+	assert.NoError(t, postgres.CheckSchemaVersion(postgres.MaxSchemaVersion, false))
+	assert.Error(t, postgres.CheckSchemaVersion(postgres.MaxSchemaVersion, true))
+	assert.Error(t, postgres.CheckSchemaVersion(postgres.MaxSchemaVersion+1, false))
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationBadRequest(t *testing.T) {
+	Init()
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "Invalid JSON",
+			body: `{"event":`,
+		},
+		{
+			name: "Unknown event",
+			body: `{"type":"notification","event":"deal.closed","object":{"id":"2d0a4a3c"}}`,
+		},
+		{
+			name: "Empty object id",
+			body: `{"type":"notification","event":"payment.succeeded","object":{"status":"succeeded"}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest("POST", "/webhook/yookassa", bytes.NewBufferString(tc.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+// createFakePayment creates pending payment in the fake gateway, metadata is sent if it isn't nil
+func createFakePayment(t *testing.T, gateway *yookassatest.Fake, metadata map[string]string) string {
+	body := map[string]any{"amount": map[string]string{"value": "100.00", "currency": yookassatest.FakeCurrency}}
+	if metadata != nil {
+		body["metadata"] = metadata
+	}
+	resp, err := gateway.Do(context.Background(), http.MethodPost, metrics.PaymentsEndpoint, body, uuid.NewString())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	payment := struct {
+		ID string `json:"id"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&payment))
+	return payment.ID
+}
+
+func notify(handler *endpoints.NotificationHandler, event, id string, status metrics.Status) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"type":"notification","event":%q,"object":{"id":%q,"status":%q}}`, event, id, status)
+	req, _ := http.NewRequest("POST", "/webhook/yookassa", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handler.Notify(rr, req)
+	return rr
+}
+
+func TestNotification(t *testing.T) {
+	Init()
+
+	gateway := yookassatest.NewFake()
+	logs := memory.NewLogRepository()
+	checker := webhook.NewChecker(logger, memory.NewStatusStore(), gateway)
+	t.Cleanup(func() { _ = checker.Shutdown(context.Background()) })
+	splitter := endpoints.NewSplitter(logger, memory.NewLedgerStore(), memory.NewCommissionStore())
+	handler := endpoints.NewNotificationHandler(logger, logs, memory.NewPaymentMethodStore(),
+		memory.NewAuditRepository(), splitter, checker)
+
+	t.Run("Transaction is resolved by metadata", func(t *testing.T) {
+		transactionID := uuid.New()
+		id := createFakePayment(t, gateway, map[string]string{"transaction_id": transactionID.String()})
+		require.NoError(t, logs.InsertLog(context.Background(),
+			postgres.NewLog(id, "100.00", string(metrics.Pending), time.Now())))
+		// The check doesn't know the YooKassa ID, the transaction is found only by metadata
+		whData := webhook.NewWebhookData(metrics.PaymentsEndpoint, "", transactionID, "100.00")
+		require.NoError(t, checker.StartCheck(context.Background(), whData, metrics.Pending))
+		require.True(t, gateway.SetStatus(metrics.PaymentsEndpoint, id, metrics.Succeeded))
+
+		// The notified status isn't trusted, the actual one is fetched from YooKassa
+		rr := notify(handler, "payment.succeeded", id, metrics.Canceled)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		status, err := checker.Status(transactionID)
+		require.NoError(t, err)
+		assert.Equal(t, metrics.Succeeded, status)
+		l, err := logs.GetLog(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, string(metrics.Succeeded), l.Status)
+	})
+
+	t.Run("Object of unknown transaction", func(t *testing.T) {
+		id := createFakePayment(t, gateway, nil)
+
+		rr := notify(handler, "payment.succeeded", id, metrics.Succeeded)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Unknown object", func(t *testing.T) {
+		rr := notify(handler, "payment.succeeded", uuid.NewString(), metrics.Succeeded)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		respBody := new(endpoints.ErrorResponse)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(respBody))
+		assert.Equal(t, "unknown object", respBody.Error)
+	})
+}
@@ -2,13 +2,22 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type payloadTest struct {
@@ -33,6 +42,18 @@ func TestPayload(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedError:  "",
 		},
+		{
+			name: "Blocked user",
+			requestBody: &endpoints.PayoutRequestEndpoint{
+				ToUserId: blockedUserID,
+				Amount: endpoints.Amount{
+					Currency: "RUB",
+					Value:    "100",
+				},
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedError:  endpoints.ErrUserBlocked.Error(),
+		},
 		{
 			name:           "Bad request empty body",
 			requestBody:    &endpoints.PayoutRequestEndpoint{},
@@ -63,6 +84,19 @@ func TestPayload(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "bad request",
 		},
+		{
+			name: "Bad request unknown destination",
+			requestBody: &endpoints.PayoutRequestEndpoint{
+				ToUserId: "69c1f84f-8fd8-480b-b5fe-4aaf96826791",
+				Amount: endpoints.Amount{
+					Currency: "RUB",
+					Value:    "100",
+				},
+				Destination: "qiwi",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrPayoutDestination.Error(),
+		},
 		{
 			name: "Bad request invalid id",
 			requestBody: &endpoints.PayoutRequestEndpoint{
@@ -99,3 +133,35 @@ func TestPayload(t *testing.T) {
 		})
 	}
 }
+
+func TestPayloadSettledOnShutdown(t *testing.T) {
+	Init()
+	users, cards, user := newCardOwner(t)
+	logs := memory.NewLogRepository()
+	gateway := yookassatest.NewFake()
+	background := endpoints.NewBackground()
+	handler := endpoints.NewPayloadHandler(logger, logs, memory.NewAuditRepository(), users,
+		endpoints.NewSplitter(logger, memory.NewLedgerStore(), memory.NewCommissionStore()),
+		memory.NewPayoutDestinationStore(), cards, gateway,
+		webhook.NewChecker(logger, memory.NewStatusStore(), gateway), background)
+
+	// The result of the payout is unknown, it's resent in background
+	gateway.Fail(metrics.PayoutsEndpoint, http.StatusInternalServerError, 1, nil)
+	body, _ := json.Marshal(&endpoints.PayoutRequestEndpoint{
+		ToUserId: user.Id.String(),
+		Amount:   endpoints.Amount{Currency: "RUB", Value: "100"},
+	})
+	req, _ := http.NewRequest("POST", "/payload/create", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	handler.Payload(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	// Shutdown doesn't wait for the resend delays, the payout is recorded before it returns
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, background.Shutdown(ctx))
+	payouts, err := logs.GetLogs(context.Background(), postgres.LogKindPayout, time.Time{}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, payouts, 1)
+	assert.Len(t, gateway.IdempotenceKeys(metrics.PayoutsEndpoint), 2)
+}
@@ -24,10 +24,22 @@ func TestPayment(t *testing.T) {
 	testCases := []paymentTestCase{
 		{
 			name:           "OK",
-			requestBody:    endpoints.NewCreate(uuid.New().String(), "100.00", "RUB"),
+			requestBody:    endpoints.NewCreate(activeUserID, "100.00", "RUB"),
 			expectedStatus: http.StatusOK,
 			expectedError:  "",
 		},
+		{
+			name:           "Unknown user",
+			requestBody:    endpoints.NewCreate(uuid.New().String(), "100.00", "RUB"),
+			expectedStatus: http.StatusNotFound,
+			expectedError:  endpoints.ErrUserNotFound.Error(),
+		},
+		{
+			name:           "Blocked user",
+			requestBody:    endpoints.NewCreate(blockedUserID, "100.00", "RUB"),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  endpoints.ErrUserBlocked.Error(),
+		},
 		{
 			name:           "Bad request empty body",
 			requestBody:    &endpoints.Create{},
@@ -36,13 +48,13 @@ func TestPayment(t *testing.T) {
 		},
 		{
 			name:           "Bad request invalid currency",
-			requestBody:    endpoints.NewCreate(uuid.New().String(), "345.5", "USD"),
+			requestBody:    endpoints.NewCreate(activeUserID, "345.5", "USD"),
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Incorrect currency of payment. The value of the amount.currency parameter doesn't correspond with the settings of your store. Specify another currency value in the request or contact the YooMoney manager to change the settings",
 		},
 		{
 			name:           "Bad request invalid value",
-			requestBody:    endpoints.NewCreate(uuid.New().String(), "-123.43", "RUB"),
+			requestBody:    endpoints.NewCreate(activeUserID, "-123.43", "RUB"),
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Error in the payment amount. Specify the amount in correct format. For example, 100.00",
 		},
@@ -57,9 +69,62 @@ func TestPayment(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "userId or amount is empty",
 		},
+		{
+			name: "Bad request redirect without return url",
+			requestBody: &endpoints.Create{
+				UserId:       uuid.New().String(),
+				Amount:       endpoints.Amount{Value: "100.00", Currency: "RUB"},
+				Confirmation: &endpoints.Confirmation{Type: endpoints.ConfirmationRedirect},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrReturnURL.Error(),
+		},
+		{
+			name: "Bad request unknown confirmation type",
+			requestBody: &endpoints.Create{
+				UserId:       uuid.New().String(),
+				Amount:       endpoints.Amount{Value: "100.00", Currency: "RUB"},
+				Confirmation: &endpoints.Confirmation{Type: "mobile_application"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrConfirmationType.Error(),
+		},
+		{
+			name: "Bad request unknown payment method",
+			requestBody: &endpoints.Create{
+				UserId:        uuid.New().String(),
+				Amount:        endpoints.Amount{Value: "100.00", Currency: "RUB"},
+				PaymentMethod: "cash",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrPaymentMethod.Error(),
+		},
+		{
+			name: "Bad request saved method with confirmation",
+			requestBody: &endpoints.Create{
+				UserId:               uuid.New().String(),
+				Amount:               endpoints.Amount{Value: "100.00", Currency: "RUB"},
+				Confirmation:         &endpoints.Confirmation{Type: endpoints.ConfirmationEmbedded},
+				SavedPaymentMethodID: uuid.NewString(),
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrSavedMethodConfirmation.Error(),
+		},
+		{
+			name: "Bad request save saved method",
+			requestBody: &endpoints.Create{
+				UserId:               uuid.New().String(),
+				Amount:               endpoints.Amount{Value: "100.00", Currency: "RUB"},
+				SavePaymentMethod:    true,
+				SavedPaymentMethodID: uuid.NewString(),
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  endpoints.ErrSaveSavedMethod.Error(),
+		},
 	}
 
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
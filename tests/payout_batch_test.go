@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa/yookassatest"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayoutBatchBadRequest(t *testing.T) {
+	Init()
+
+	testCases := []struct {
+		name          string
+		body          string
+		expectedError string
+	}{
+		{
+			name:          "Invalid JSON",
+			body:          `{"items":`,
+			expectedError: "bad request",
+		},
+		{
+			name:          "Empty batch",
+			body:          `{"items":[]}`,
+			expectedError: endpoints.ErrBatchEmpty.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest("POST", "/payouts/batch", bytes.NewBufferString(tc.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, authorize(req, adminToken))
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			respBody := new(endpoints.ErrorResponse)
+			_ = json.NewDecoder(rr.Body).Decode(respBody)
+			assert.Equal(t, tc.expectedError, respBody.Error)
+		})
+	}
+}
+
+func TestPayoutBatchInvalidItems(t *testing.T) {
+	Init()
+
+	body, _ := json.Marshal(endpoints.PayoutBatchRequest{Items: []endpoints.PayoutRequestEndpoint{
+		{ToUserId: "69c1f84f-8fd8-480b-b5fe-4aaf96826791"},
+		{ToUserId: "&&&", Amount: endpoints.Amount{Value: "100.00", Currency: "RUB"}},
+		{ToUserId: "69c1f84f-8fd8-480b-b5fe-4aaf96826791", Amount: endpoints.Amount{Value: "-5", Currency: "RUB"}},
+		{
+			ToUserId:    "69c1f84f-8fd8-480b-b5fe-4aaf96826791",
+			Amount:      endpoints.Amount{Value: "100.00", Currency: "RUB"},
+			FromBalance: true,
+		},
+	}})
+	req, _ := http.NewRequest("POST", "/payouts/batch", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, authorize(req, adminToken))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	resp := new(endpoints.PayoutBatchResponse)
+	_ = json.NewDecoder(rr.Body).Decode(resp)
+	positions := make([]int, 0, len(resp.Errors))
+	for _, itemErr := range resp.Errors {
+		positions = append(positions, itemErr.Position)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3}, positions)
+}
+
+// newCardOwner registers a user with a bound card in new stores
+func newCardOwner(t *testing.T) (*memory.UserStore, *memory.CardStore, *models.User) {
+	ctx := context.Background()
+	users := memory.NewUserStore()
+	cards := memory.NewCardStore()
+	user := models.NewUser()
+	user.ExternalID = "site-" + user.Id.String()
+	_, err := users.RegisterUser(ctx, user)
+	require.NoError(t, err)
+	_, err = cards.InsertOrUpdateRefillableCard(ctx, models.NewRefillableCard(user, "fake-synonym", "555555******4444"))
+	require.NoError(t, err)
+	return users, cards, user
+}
+
+// batchRouter serves payout batches of its own stores and gateway, so faults of the gateway
+// don't affect other tests. It returns ID of the user who has a bound card.
+func batchRouter(t *testing.T, gateway *yookassatest.Fake) (http.Handler, *memory.PayoutBatchStore, string) {
+	users, cards, user := newCardOwner(t)
+
+	batches := memory.NewPayoutBatchStore()
+	statuses := memory.NewStatusStore()
+	handler := endpoints.NewPayoutBatchHandler(logger, memory.NewLogRepository(), batches, memory.NewAuditRepository(),
+		users, memory.NewPayoutDestinationStore(), cards, gateway, webhook.NewChecker(logger, statuses, gateway),
+		endpoints.NewBackground())
+
+	r := chi.NewRouter()
+	r.Post("/payouts/batch", handler.Create)
+	r.Post("/payouts/batch/{id}/retry", handler.Retry)
+	return r, batches, user.Id.String()
+}
+
+// waitBatchItems waits until every item is sent: it isn't queued or its send failed with unknown result
+func waitBatchItems(t *testing.T, batches *memory.PayoutBatchStore, batchID uuid.UUID) []*postgres.PayoutBatchItem {
+	var items []*postgres.PayoutBatchItem
+	require.Eventually(t, func() bool {
+		var err error
+		items, err = batches.GetBatchItems(context.Background(), batchID)
+		require.NoError(t, err)
+		for _, item := range items {
+			if item.Status == postgres.BatchItemQueued && item.Error == "" {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return items
+}
+
+func TestPayoutBatchRetry(t *testing.T) {
+	Init()
+
+	gateway := yookassatest.NewFake()
+	handler, batches, userID := batchRouter(t, gateway)
+	amountIs := func(value string) func(req map[string]any) bool {
+		return func(req map[string]any) bool {
+			amount, _ := req["amount"].(map[string]any)
+			return amount["value"] == value
+		}
+	}
+	// The second item is rejected once, the result of the third one is unknown once
+	gateway.Fail(metrics.PayoutsEndpoint, http.StatusBadRequest, 1, amountIs("20.00"))
+	gateway.Fail(metrics.PayoutsEndpoint, http.StatusInternalServerError, 1, amountIs("30.00"))
+
+	var items []endpoints.PayoutRequestEndpoint
+	for _, value := range []string{"10.00", "20.00", "30.00"} {
+		items = append(items, endpoints.PayoutRequestEndpoint{
+			ToUserId: userID,
+			Amount:   endpoints.Amount{Value: value, Currency: yookassatest.FakeCurrency},
+		})
+	}
+	body, _ := json.Marshal(endpoints.PayoutBatchRequest{Items: items})
+	req, _ := http.NewRequest("POST", "/payouts/batch", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	resp := new(endpoints.PayoutBatchResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(resp))
+
+	sent := waitBatchItems(t, batches, resp.BatchID)
+	require.Len(t, sent, 3)
+	assert.Equal(t, postgres.BatchItemPending, sent[0].Status)
+	assert.NotEmpty(t, sent[0].YooKassaID)
+	assert.Equal(t, postgres.BatchItemFailed, sent[1].Status)
+	assert.Contains(t, sent[1].Error, yookassatest.ErrDescriptionFault)
+	// 5xx doesn't mean the payout isn't created, the item waits for retry
+	assert.Equal(t, postgres.BatchItemQueued, sent[2].Status)
+	assert.NotEmpty(t, sent[2].Error)
+	firstKeys := gateway.IdempotenceKeys(metrics.PayoutsEndpoint)
+	require.Len(t, firstKeys, 3)
+
+	req, _ = http.NewRequest("POST", "/payouts/batch/"+resp.BatchID.String()+"/retry", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	require.Eventually(t, func() bool {
+		return len(gateway.IdempotenceKeys(metrics.PayoutsEndpoint)) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	retried := waitBatchItems(t, batches, resp.BatchID)
+	for _, item := range retried {
+		assert.Equal(t, postgres.BatchItemPending, item.Status, item.Position)
+		assert.NotEmpty(t, item.YooKassaID, item.Position)
+	}
+	// The pending item isn't resent
+	assert.Equal(t, sent[0].YooKassaID, retried[0].YooKassaID)
+	// The rejected item is created again by the next attempt with new key and transaction ID
+	assert.Equal(t, sent[1].Attempt+1, retried[1].Attempt)
+	assert.NotEqual(t, sent[1].TransactionID, retried[1].TransactionID)
+	// The item with unknown result is resent with the same key
+	assert.Equal(t, sent[2].Attempt, retried[2].Attempt)
+	assert.Equal(t, sent[2].TransactionID, retried[2].TransactionID)
+
+	// Only the key of the item with unknown result is used twice, so no payout is created twice
+	sends := make(map[string]int)
+	for _, key := range gateway.IdempotenceKeys(metrics.PayoutsEndpoint) {
+		sends[key]++
+	}
+	assert.Len(t, sends, 4)
+	resent := 0
+	for _, key := range firstKeys {
+		if sends[key] == 2 {
+			resent++
+		}
+	}
+	assert.Equal(t, 1, resent)
+	assert.Len(t, map[string]bool{
+		retried[0].YooKassaID: true,
+		retried[1].YooKassaID: true,
+		retried[2].YooKassaID: true,
+	}, 3)
+}
+
+func TestPayoutBatchResumedOnStart(t *testing.T) {
+	Init()
+
+	users, cards, user := newCardOwner(t)
+	batches := memory.NewPayoutBatchStore()
+	gateway := yookassatest.NewFake()
+	newHandler := func(background *endpoints.Background) *endpoints.PayoutBatchHandler {
+		return endpoints.NewPayoutBatchHandler(logger, memory.NewLogRepository(), batches,
+			memory.NewAuditRepository(), users, memory.NewPayoutDestinationStore(), cards, gateway,
+			webhook.NewChecker(logger, memory.NewStatusStore(), gateway), background)
+	}
+
+	// The service is stopping, items of the new batch aren't sent
+	stopped := endpoints.NewBackground()
+	require.NoError(t, stopped.Shutdown(context.Background()))
+	body, _ := json.Marshal(endpoints.PayoutBatchRequest{Items: []endpoints.PayoutRequestEndpoint{{
+		ToUserId: user.Id.String(),
+		Amount:   endpoints.Amount{Value: "10.00", Currency: yookassatest.FakeCurrency},
+	}}})
+	req, _ := http.NewRequest("POST", "/payouts/batch", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	newHandler(stopped).Create(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	resp := new(endpoints.PayoutBatchResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(resp))
+	require.NoError(t, stopped.Shutdown(context.Background()))
+	items, err := batches.GetBatchItems(context.Background(), resp.BatchID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, postgres.BatchItemQueued, items[0].Status)
+	assert.Empty(t, gateway.IdempotenceKeys(metrics.PayoutsEndpoint))
+
+	// The next start sends them
+	resumed, err := newHandler(endpoints.NewBackground()).ResumeQueuedBatches(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, resumed)
+	items = waitBatchItems(t, batches, resp.BatchID)
+	assert.Equal(t, postgres.BatchItemPending, items[0].Status)
+	assert.Len(t, gateway.IdempotenceKeys(metrics.PayoutsEndpoint), 1)
+}
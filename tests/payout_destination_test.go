@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavePayoutDestinationBadRequest(t *testing.T) {
+	Init()
+
+	const userID = "69c1f84f-8fd8-480b-b5fe-4aaf96826791"
+	testCases := []struct {
+		name          string
+		requestBody   *endpoints.SavePayoutDestination
+		expectedError string
+	}{
+		{
+			name:          "Invalid user id",
+			requestBody:   &endpoints.SavePayoutDestination{UserID: "&&&", Type: endpoints.DestinationSBP},
+			expectedError: "bad request",
+		},
+		{
+			name:          "Unknown type",
+			requestBody:   &endpoints.SavePayoutDestination{UserID: userID, Type: endpoints.DestinationBankCard},
+			expectedError: endpoints.ErrPayoutDestination.Error(),
+		},
+		{
+			name: "SBP phone isn't russian",
+			requestBody: &endpoints.SavePayoutDestination{
+				UserID: userID,
+				Type:   endpoints.DestinationSBP,
+				Phone:  "+79001234567",
+				BankID: "100000000111",
+			},
+			expectedError: endpoints.ErrSBPPhone.Error(),
+		},
+		{
+			name: "SBP without bank",
+			requestBody: &endpoints.SavePayoutDestination{
+				UserID: userID,
+				Type:   endpoints.DestinationSBP,
+				Phone:  "79001234567",
+			},
+			expectedError: endpoints.ErrSBPBankID.Error(),
+		},
+		{
+			name: "Short YooMoney wallet",
+			requestBody: &endpoints.SavePayoutDestination{
+				UserID:        userID,
+				Type:          endpoints.DestinationYooMoney,
+				AccountNumber: "4100116",
+			},
+			expectedError: endpoints.ErrYooMoneyAccount.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reqBodyBytes, _ := json.Marshal(tc.requestBody)
+			req, _ := http.NewRequest("PUT", "/payout_destinations", bytes.NewBuffer(reqBodyBytes))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			respBody := new(endpoints.ErrorResponse)
+			_ = json.NewDecoder(rr.Body).Decode(respBody)
+			assert.Equal(t, tc.expectedError, respBody.Error)
+		})
+	}
+}
+
+func TestGenerateMask(t *testing.T) {
+	mask, err := models.GenerateMask("79001234567", 1, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "7******4567", mask)
+
+	mask, err = models.GenerateMask("410011161616877", 4, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "4100*******6877", mask)
+
+	_, err = models.GenerateMask("1234", 1, 4)
+	assert.Error(t, err)
+}
+
+func TestSavePayoutDestinationOfInactiveUser(t *testing.T) {
+	Init()
+
+	testCases := []struct {
+		name           string
+		userID         string
+		expectedStatus int
+	}{
+		{name: "Active user", userID: activeUserID, expectedStatus: http.StatusOK},
+		{name: "Blocked user", userID: blockedUserID, expectedStatus: http.StatusForbidden},
+		{name: "Unknown user", userID: uuid.NewString(), expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reqBodyBytes, _ := json.Marshal(&endpoints.SavePayoutDestination{
+				UserID:        tc.userID,
+				Type:          endpoints.DestinationYooMoney,
+				AccountNumber: "41001123456789",
+			})
+			req, _ := http.NewRequest("PUT", "/payout_destinations", bytes.NewBuffer(reqBodyBytes))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
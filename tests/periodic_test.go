@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/periodic"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodicRunner(t *testing.T) {
+	var ticks atomic.Int32
+	release := make(chan struct{})
+	runner := periodic.NewRunner(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Millisecond,
+		func(ctx context.Context, _ time.Time) error {
+			if ticks.Add(1) == 3 {
+				<-release
+				// The started tick isn't canceled by the stop
+				return ctx.Err()
+			}
+			// Failed ticks don't stop the runner
+			return errors.New("tick failed")
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner.Start(ctx)
+	require.Eventually(t, func() bool { return ticks.Load() == 3 }, time.Second, time.Millisecond)
+	cancel()
+
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	assert.ErrorIs(t, runner.Wait(short), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, runner.Wait(context.Background()))
+	assert.Equal(t, int32(3), ticks.Load())
+}
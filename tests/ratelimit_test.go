@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	srv "github.com/imperatorofdwelling/Website-backend/internal/server/http"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(handler http.Handler, path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Limiting is disabled by default
+	assert.Zero(t, cfg.Server.RateLimit.RequestsPerSecond)
+	unlimited := srv.RateLimit(srv.RateLimitConfig{Burst: 1})(ok)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(unlimited, "/payment/create"))
+	}
+
+	limited := srv.RateLimit(srv.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1})(ok)
+	assert.Equal(t, http.StatusOK, serve(limited, "/payment/create"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(limited, "/payment/create"))
+	// Probes and notifications of the same client go through
+	for _, path := range []string{"/healthz", "/readyz", "/webhook/yookassa"} {
+		assert.Equal(t, http.StatusOK, serve(limited, path), path)
+	}
+}
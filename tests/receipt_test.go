@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/stretchr/testify/assert"
+)
+
+func bookingReceipt(items ...endpoints.BookingItem) *endpoints.BookingReceipt {
+	return &endpoints.BookingReceipt{
+		BookingID: "booking-1",
+		Customer:  endpoints.ReceiptCustomer{Email: "tenant@example.com"},
+		Items:     items,
+	}
+}
+
+func TestPaymentReceiptValidation(t *testing.T) {
+	Init()
+	night := endpoints.BookingItem{
+		Description: "Apartment rent, 1 night",
+		Quantity:    2,
+		Price:       endpoints.Amount{Value: "50.00", Currency: "RUB"},
+	}
+
+	testCases := []struct {
+		name          string
+		receipt       *endpoints.BookingReceipt
+		expectedError string
+	}{
+		{
+			name:          "No customer contact",
+			receipt:       &endpoints.BookingReceipt{Items: []endpoints.BookingItem{night}},
+			expectedError: endpoints.ErrNoReceiptContact.Error(),
+		},
+		{
+			name:          "No items",
+			receipt:       bookingReceipt(),
+			expectedError: endpoints.ErrNoReceiptItems.Error(),
+		},
+		{
+			name:          "Total mismatch",
+			receipt:       bookingReceipt(night, night),
+			expectedError: endpoints.ErrReceiptTotal.Error(),
+		},
+		{
+			name: "Invalid phone",
+			receipt: &endpoints.BookingReceipt{
+				Customer: endpoints.ReceiptCustomer{Phone: "+7 (900) 000-00-00"},
+				Items:    []endpoints.BookingItem{night},
+			},
+			expectedError: "receipt customer phone must contain only digits",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			body := endpoints.NewCreate(uuid.New().String(), "100.00", "RUB")
+			body.Receipt = tc.receipt
+			reqBodyBytes, _ := json.Marshal(body)
+			req, _ := http.NewRequest("POST", "/payment/create", bytes.NewBuffer(reqBodyBytes))
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			respBody := new(endpoints.ErrorResponse)
+			_ = json.NewDecoder(rr.Body).Decode(respBody)
+			assert.Equal(t, tc.expectedError, respBody.Error)
+		})
+	}
+}
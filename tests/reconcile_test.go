@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/reconcile"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource returns payments by pages of one object
+type fakeSource struct {
+	payments []*reconcile.Object
+}
+
+func (f *fakeSource) List(_ context.Context, resource string, _, _ time.Time, cursor string) (*reconcile.Page, error) {
+	if resource != "payments" || len(f.payments) == 0 {
+		return &reconcile.Page{}, nil
+	}
+	i := 0
+	if cursor != "" {
+		i = int(cursor[0] - '0')
+	}
+	page := &reconcile.Page{Items: f.payments[i : i+1]}
+	if i+1 < len(f.payments) {
+		page.NextCursor = string(rune('0' + i + 1))
+	}
+	return page, nil
+}
+
+func (f *fakeSource) Get(context.Context, string, string) (*reconcile.Object, error) {
+	return nil, reconcile.ErrNotFound
+}
+
+// newLogs returns the repository with the logs created in order from the start of the day
+func newLogs(t *testing.T, day time.Time, logs ...*postgres.Log) *memory.LogRepository {
+	repo := memory.NewLogRepository()
+	for i, log := range logs {
+		log.Time = day.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, repo.InsertLog(context.Background(), log))
+	}
+	return repo
+}
+
+// logStatus returns the status of the log
+func logStatus(t *testing.T, logs *memory.LogRepository, transactionID string) string {
+	log, err := logs.GetLog(context.Background(), transactionID)
+	require.NoError(t, err)
+	require.NotNil(t, log)
+	return log.Status
+}
+
+func TestReconcile(t *testing.T) {
+	remote := func(id, amount string, status metrics.Status) *reconcile.Object {
+		return &reconcile.Object{ID: id, Status: status, Amount: reconcile.Amount{Value: amount, Currency: "RUB"}}
+	}
+	local := func(id, amount, status string) *postgres.Log {
+		return &postgres.Log{TransactionID: id, Amount: amount, Status: status, Kind: postgres.LogKindPayment}
+	}
+	source := &fakeSource{payments: []*reconcile.Object{
+		remote("p1", "100.00", metrics.Succeeded),
+		remote("p2", "50.00", metrics.Succeeded),
+		remote("p3", "10.00", metrics.Canceled),
+		remote("p4", "20.00", metrics.Pending),
+		remote("p6", "30.00", metrics.Succeeded),
+	}}
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	logs := newLogs(t, from,
+		local("p1", "100", string(metrics.Pending)),
+		local("p2", "55.00", string(metrics.Succeeded)),
+		local("p3", "10.00", string(metrics.Succeeded)),
+		local("p4", "20.00", string(metrics.Pending)),
+		local("p5", "40.00", string(metrics.Pending)),
+	)
+	audit := memory.NewAuditRepository()
+
+	reconciler := reconcile.NewReconciler(slog.New(slog.NewTextHandler(io.Discard, nil)), source, logs, audit, true)
+	report, err := reconciler.Run(context.Background(), from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+
+	expected := []*reconcile.Discrepancy{
+		{Resource: "payments", ID: "p1", Type: reconcile.StatusMismatch, LocalStatus: "pending",
+			RemoteStatus: "succeeded", Fixed: true},
+		{Resource: "payments", ID: "p2", Type: reconcile.AmountMismatch, LocalAmount: "55.00", RemoteAmount: "50.00"},
+		{Resource: "payments", ID: "p3", Type: reconcile.StatusMismatch, LocalStatus: "succeeded",
+			RemoteStatus: "canceled"},
+		{Resource: "payments", ID: "p6", Type: reconcile.MissingLocally, RemoteAmount: "30.00",
+			RemoteStatus: "succeeded"},
+		{Resource: "payments", ID: "p5", Type: reconcile.MissingRemotely, LocalAmount: "40.00",
+			LocalStatus: "pending"},
+	}
+	assert.Equal(t, expected, report.Discrepancies)
+	assert.Equal(t, 5, report.Checked["payments"])
+	assert.Equal(t, 1, report.Fixed)
+
+	// Only the safe mismatch is corrected and audited, final local status isn't changed
+	assert.Equal(t, "succeeded", logStatus(t, logs, "p1"))
+	assert.Equal(t, "succeeded", logStatus(t, logs, "p3"))
+	events, err := audit.GetAuditEvents(context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, postgres.AuditReconcileFix, events[0].EventType)
+	assert.Equal(t, "p1", events[0].SubjectID)
+	assert.Equal(t, "pending", events[0].OldValue)
+	assert.Equal(t, "succeeded", events[0].NewValue)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, reconcile.WriteCSV(buf, report))
+	assert.Equal(t, 6, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+// failingAudit fails to write audit events
+type failingAudit struct {
+	postgres.AuditRepository
+}
+
+func (failingAudit) InsertAuditEvent(context.Context, *postgres.AuditEvent) error {
+	return errors.New("audit is unavailable")
+}
+
+func TestReconcileUnauditedFix(t *testing.T) {
+	source := &fakeSource{payments: []*reconcile.Object{{
+		ID: "p1", Status: metrics.Succeeded, Amount: reconcile.Amount{Value: "100.00", Currency: "RUB"},
+	}}}
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	logs := newLogs(t, from,
+		&postgres.Log{TransactionID: "p1", Amount: "100.00", Status: string(metrics.Pending), Kind: postgres.LogKindPayment})
+
+	reconciler := reconcile.NewReconciler(slog.New(slog.NewTextHandler(io.Discard, nil)), source, logs,
+		failingAudit{}, true)
+	report, err := reconciler.Run(context.Background(), from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+
+	// The status is corrected, the missing audit event is reported
+	assert.Equal(t, "succeeded", logStatus(t, logs, "p1"))
+	require.Len(t, report.Discrepancies, 1)
+	assert.True(t, report.Discrepancies[0].Fixed)
+	assert.True(t, report.Discrepancies[0].Unaudited)
+	assert.Equal(t, 1, report.Fixed)
+	assert.Equal(t, 1, report.Unaudited)
+}
+
+func TestReconcileFixUpdatesLedger(t *testing.T) {
+	testCases := []struct {
+		name    string
+		fix     bool
+		entries int
+	}{
+		{name: "Fixed payment is split", fix: true, entries: 2},
+		{name: "Reported payment isn't split", fix: false, entries: 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := &fakeSource{payments: []*reconcile.Object{{
+				ID:       "p1",
+				Status:   metrics.Succeeded,
+				Amount:   reconcile.Amount{Value: "1000.00", Currency: "RUB"},
+				Metadata: map[string]string{"transaction_id": uuid.NewString(), "landlord_id": "landlord-1"},
+			}}}
+			from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			logs := newLogs(t, from, &postgres.Log{TransactionID: "p1", Amount: "1000.00",
+				Status: string(metrics.Pending), Kind: postgres.LogKindPayment})
+			ledger := memory.NewLedgerStore()
+			commissions := newCommissions(t, &postgres.CommissionRule{Percent: "10", Fixed: "0"})
+			log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			reconciler := reconcile.NewReconciler(log, source, logs, memory.NewAuditRepository(), tc.fix)
+			reconciler.SetFinalHook(endpoints.NewSplitter(log, ledger, commissions).OnReconciledStatus)
+			_, err := reconciler.Run(context.Background(), from, from.AddDate(0, 0, 1))
+			require.NoError(t, err)
+
+			entries, err := ledger.GetEntriesByReference(context.Background(), "p1")
+			require.NoError(t, err)
+			assert.Len(t, entries, tc.entries)
+		})
+	}
+}
+
+// dayLocker holds locks by name, ttls are the last ttl of each lock
+type dayLocker struct {
+	ttls map[string]time.Duration
+}
+
+func (f *dayLocker) TryLock(_ context.Context, name, _ string, ttl time.Duration) (bool, error) {
+	if _, ok := f.ttls[name]; ok {
+		return false, nil
+	}
+	f.ttls[name] = ttl
+	return true, nil
+}
+
+func (f *dayLocker) Extend(_ context.Context, name, _ string, ttl time.Duration) (bool, error) {
+	if _, ok := f.ttls[name]; !ok {
+		return false, nil
+	}
+	f.ttls[name] = ttl
+	return true, nil
+}
+
+func (f *dayLocker) Unlock(_ context.Context, name, _ string) error {
+	delete(f.ttls, name)
+	return nil
+}
+
+// daySource has no objects, listing of the failing day fails
+type daySource struct {
+	fakeSource
+	failing time.Time
+	listed  []time.Time
+}
+
+func (f *daySource) List(ctx context.Context, resource string, from, to time.Time, cursor string) (*reconcile.Page, error) {
+	if from.Equal(f.failing) {
+		return nil, errors.New("yookassa is unavailable")
+	}
+	if resource == "payments" {
+		f.listed = append(f.listed, from)
+	}
+	return f.fakeSource.List(ctx, resource, from, to, cursor)
+}
+
+func TestReconcileJobBackfill(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &reconcile.Config{TickInterval: time.Hour, Delay: 3 * time.Hour, ReportDir: t.TempDir(),
+		LockTTL: time.Hour, BackfillDays: 3}
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		require.NoError(t, err)
+		return d
+	}
+	source := &daySource{failing: day("2024-05-02")}
+	locker := &dayLocker{ttls: map[string]time.Duration{}}
+	reconciler := reconcile.NewReconciler(log, source, memory.NewLogRepository(), memory.NewAuditRepository(), true)
+	job := reconcile.NewJob(log, cfg, reconciler, locker)
+	now := day("2024-05-04").Add(4 * time.Hour)
+
+	// Missed days are reconciled oldest first, the failed one is unlocked
+	require.Error(t, job.Tick(context.Background(), now))
+	assert.Equal(t, []time.Time{day("2024-05-01"), day("2024-05-03")}, source.listed)
+	assert.Equal(t, map[string]time.Duration{
+		"reconcile:2024-05-01": 4 * 24 * time.Hour,
+		"reconcile:2024-05-03": 4 * 24 * time.Hour,
+	}, locker.ttls)
+
+	// Only the failed day is reconciled again
+	source.failing = time.Time{}
+	source.listed = nil
+	require.NoError(t, job.Tick(context.Background(), now.Add(time.Hour)))
+	assert.Equal(t, []time.Time{day("2024-05-02")}, source.listed)
+	assert.Len(t, locker.ttls, 3)
+}
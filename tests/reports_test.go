@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/reports"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReports returns refunds by pages after the cursor
+type fakeReports struct {
+	refunds []*postgres.RefundRow
+	pages   int
+}
+
+func (f *fakeReports) GetTurnover(_ context.Context, filter *postgres.ReportFilter) ([]*postgres.TurnoverRow, error) {
+	return []*postgres.TurnoverRow{{
+		Period:         filter.From,
+		Currency:       "RUB",
+		Payments:       3,
+		PaymentsAmount: "1500.00",
+		Refunds:        1,
+		RefundsAmount:  "100.00",
+		Net:            "1400.00",
+	}}, nil
+}
+
+func (f *fakeReports) GetCommissions(context.Context, *postgres.ReportFilter) ([]*postgres.CommissionsRow, error) {
+	return nil, nil
+}
+
+func (f *fakeReports) GetLandlordPayouts(context.Context, *postgres.ReportFilter, postgres.ReportCursor,
+	int) ([]*postgres.LandlordPayoutsRow, error) {
+	return nil, nil
+}
+
+func (f *fakeReports) GetRefunds(_ context.Context, _ *postgres.ReportFilter, after postgres.ReportCursor,
+	limit int) ([]*postgres.RefundRow, error) {
+	f.pages++
+	page := make([]*postgres.RefundRow, 0, limit)
+	for _, row := range f.refunds {
+		if row.ID > after.ID && len(page) < limit {
+			page = append(page, row)
+		}
+	}
+	return page, nil
+}
+
+func (f *fakeReports) GetEscrow(context.Context, *postgres.ReportFilter, postgres.ReportCursor, int) (
+	[]*postgres.EscrowRow, error) {
+	return nil, nil
+}
+
+func reportRouter(repo postgres.ReportRepository) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/admin/reports/{name}", endpoints.NewReportHandler(logger, repo).Export)
+	return r
+}
+
+func TestExportReportBadRequest(t *testing.T) {
+	handler := reportRouter(&fakeReports{})
+
+	testCases := []struct {
+		name          string
+		url           string
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:          "Unknown report",
+			url:           "/admin/reports/profit?from=2024-05-01&to=2024-05-31",
+			expectedCode:  http.StatusNotFound,
+			expectedError: reports.ErrUnknownReport.Error(),
+		},
+		{
+			name:          "To before from",
+			url:           "/admin/reports/turnover?from=2024-05-31&to=2024-05-01",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: reports.ErrDateRange.Error(),
+		},
+		{
+			name:          "Unknown period",
+			url:           "/admin/reports/turnover?from=2024-05-01&to=2024-05-31&period=year",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: reports.ErrPeriod.Error(),
+		},
+		{
+			name:          "Invalid currency",
+			url:           "/admin/reports/turnover?from=2024-05-01&to=2024-05-31&currency=rub",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: reports.ErrCurrency.Error(),
+		},
+		{
+			name:          "Unknown format",
+			url:           "/admin/reports/turnover?from=2024-05-01&to=2024-05-31&format=pdf",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: reports.ErrFormat.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedCode, rr.Code)
+			respBody := new(endpoints.ErrorResponse)
+			_ = json.NewDecoder(rr.Body).Decode(respBody)
+			assert.Equal(t, tc.expectedError, respBody.Error)
+		})
+	}
+}
+
+func TestExportRefundsCSV(t *testing.T) {
+	repo := &fakeReports{}
+	for i := 1; i <= 1500; i++ {
+		repo.refunds = append(repo.refunds, &postgres.RefundRow{
+			ID:            i,
+			Time:          time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC),
+			TransactionID: fmt.Sprintf("refund-%v", i),
+			Amount:        "10.00",
+			Currency:      "RUB",
+			Status:        "succeeded",
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/reports/refunds?from=2024-05-01&to=2024-05-31", nil)
+	rr := httptest.NewRecorder()
+	reportRouter(repo).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `attachment; filename="refunds-2024-05-01-2024-05-31.csv"`,
+		rr.Header().Get("Content-Disposition"))
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1501)
+	assert.Equal(t, []string{"time", "yookassa_id", "amount", "currency", "status"}, records[0])
+	assert.Equal(t, "refund-1500", records[1500][1])
+	// Refunds are selected by pages, the last one is shorter than the page size
+	assert.Equal(t, 2, repo.pages)
+}
+
+func TestExportTurnoverXLSX(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reports/turnover?from=2024-05-01&to=2024-05-31&format=xlsx", nil)
+	rr := httptest.NewRecorder()
+	reportRouter(&fakeReports{}).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		rr.Header().Get("Content-Type"))
+
+	body := rr.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		sheet = string(data)
+	}
+	assert.True(t, strings.Contains(sheet, `<t xml:space="preserve">net_amount</t>`))
+	assert.True(t, strings.Contains(sheet, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">2024-05-01</t></is></c>`))
+	assert.True(t, strings.Contains(sheet, `<c r="G2"><v>1400.00</v></c>`))
+}
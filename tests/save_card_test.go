@@ -25,7 +25,7 @@ func TestSaveCard(t *testing.T) {
 		{
 			name: "OK",
 			requestBody: &endpoints.SaveCard{
-				UserId:   uuid.New().String(),
+				UserId:   activeUserID,
 				Synonym:  "testSinonim1",
 				FirstSix: "000000",
 				LastFour: "9999",
@@ -33,6 +33,28 @@ func TestSaveCard(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedError:  "",
 		},
+		{
+			name: "Unknown user",
+			requestBody: &endpoints.SaveCard{
+				UserId:   uuid.New().String(),
+				Synonym:  "testSinonim2",
+				FirstSix: "000000",
+				LastFour: "9999",
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedError:  endpoints.ErrUserNotFound.Error(),
+		},
+		{
+			name: "Blocked user",
+			requestBody: &endpoints.SaveCard{
+				UserId:   blockedUserID,
+				Synonym:  "testSinonim3",
+				FirstSix: "000000",
+				LastFour: "9999",
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedError:  endpoints.ErrUserBlocked.Error(),
+		},
 		{
 			name:           "Bad request empty body",
 			requestBody:    &endpoints.SaveCard{},
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/pkg/logger/slogpretty"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// logRecord writes record with fixed time, so output is reproducible
+func logRecord(t *testing.T, h slog.Handler, level slog.Level, msg string, attrs ...slog.Attr) {
+	r := slog.NewRecord(time.Date(2024, 3, 1, 15, 4, 5, 123e6, time.UTC), level, msg, 0)
+	r.AddAttrs(attrs...)
+	require.NoError(t, h.Handle(context.Background(), r))
+}
+
+func TestPrettyHandlerGolden(t *testing.T) {
+	testCases := []struct {
+		name      string
+		multiline bool
+		write     func(t *testing.T, h slog.Handler)
+	}{
+		{
+			name: "levels",
+			write: func(t *testing.T, h slog.Handler) {
+				logRecord(t, h, slog.LevelDebug, "debug message")
+				logRecord(t, h, slog.LevelInfo, "info message")
+				logRecord(t, h, slog.LevelWarn, "warn message")
+				logRecord(t, h, slog.LevelError, "error message", slog.Any("error", errors.New("boom")))
+			},
+		},
+		{
+			name: "with_attrs",
+			write: func(t *testing.T, h slog.Handler) {
+				h = h.WithAttrs([]slog.Attr{slog.String("fn", "endpoints.Payment")})
+				h = h.WithAttrs([]slog.Attr{slog.String("request_id", "host/abc-000001")})
+				logRecord(t, h, slog.LevelInfo, "payment created",
+					slog.String("user_id", "42"),
+					slog.Int("amount", 100),
+					slog.Duration("duration", 1500*time.Millisecond),
+				)
+			},
+		},
+		{
+			name: "groups",
+			write: func(t *testing.T, h slog.Handler) {
+				h = h.WithAttrs([]slog.Attr{slog.String("service", "payment")})
+				h = h.WithGroup("request").WithAttrs([]slog.Attr{slog.String("method", "POST")})
+				logRecord(t, h, slog.LevelInfo, "request completed",
+					slog.Group("response", slog.Int("status", 200), slog.Bool("cached", false)),
+					slog.Group("", slog.String("inlined", "yes")),
+					slog.Group("empty"),
+				)
+			},
+		},
+		{
+			name:      "multiline",
+			multiline: true,
+			write: func(t *testing.T, h slog.Handler) {
+				h = h.WithGroup("db")
+				logRecord(t, h, slog.LevelWarn, "slow query",
+					slog.String("table", "logs"),
+					slog.Float64("seconds", 1.25),
+				)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			h := slogpretty.PrettyHandlerOptions{
+				SlogOpts:  &slog.HandlerOptions{Level: slog.LevelDebug},
+				Multiline: tc.multiline,
+				NoColor:   true,
+			}.NewPrettyHandler(buf)
+			tc.write(t, h)
+
+			golden := filepath.Join("testdata", "slogpretty", tc.name+".golden")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, buf.Bytes(), 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), buf.String())
+		})
+	}
+}
+
+func TestPrettyHandlerLevelAndSource(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := slog.New(slogpretty.PrettyHandlerOptions{
+		SlogOpts: &slog.HandlerOptions{Level: slog.LevelInfo, AddSource: true},
+		NoColor:  true,
+	}.NewPrettyHandler(buf))
+
+	log.Debug("hidden")
+	_, _, line, _ := runtime.Caller(0)
+	log.Info("visible")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "INFO: visible slogpretty_test.go:")
+	assert.Contains(t, buf.String(), ":"+strconv.Itoa(line+1))
+}
+
+func TestPrettyHandlerConcurrent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := slog.New(slogpretty.PrettyHandlerOptions{NoColor: true}.NewPrettyHandler(buf))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l := log.With(slog.Int("worker", i))
+			for j := 0; j < 100; j++ {
+				l.Info("message", slog.Int("n", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1000)
+	for _, line := range lines {
+		assert.Contains(t, line, `INFO: message {"n":`)
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/webhook"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCommissions returns the store with a copy of the rule, there are no rules if it's nil
+func newCommissions(t *testing.T, rule *postgres.CommissionRule) *memory.CommissionStore {
+	commissions := memory.NewCommissionStore()
+	if rule != nil {
+		saved := *rule
+		require.NoError(t, commissions.SaveCommissionRule(context.Background(), &saved))
+	}
+	return commissions
+}
+
+func TestSplitPayment(t *testing.T) {
+	testCases := []struct {
+		name          string
+		amount        string
+		rule          *postgres.CommissionRule
+		landlordShare string
+		platformFee   string
+	}{
+		{
+			name:          "Percent and fixed fee",
+			amount:        "10000.00",
+			rule:          &postgres.CommissionRule{Percent: "5.5", Fixed: "30.00"},
+			landlordShare: "9420.00",
+			platformFee:   "580.00",
+		},
+		{
+			name:          "Percent is rounded to kopecks",
+			amount:        "333.33",
+			rule:          &postgres.CommissionRule{Percent: "10", Fixed: "0"},
+			landlordShare: "300.00",
+			platformFee:   "33.33",
+		},
+		{
+			name:          "Min fee",
+			amount:        "100.00",
+			rule:          &postgres.CommissionRule{Percent: "1", Fixed: "0", MinFee: "50.00"},
+			landlordShare: "50.00",
+			platformFee:   "50.00",
+		},
+		{
+			name:          "Max fee",
+			amount:        "1000000.00",
+			rule:          &postgres.CommissionRule{Percent: "10", Fixed: "0", MaxFee: "5000.00"},
+			landlordShare: "995000.00",
+			platformFee:   "5000.00",
+		},
+		{
+			name:          "Fee is at most the amount",
+			amount:        "10.00",
+			rule:          &postgres.CommissionRule{Percent: "0", Fixed: "50.00"},
+			landlordShare: "0.00",
+			platformFee:   "10.00",
+		},
+		{
+			name:          "No rule",
+			amount:        "500.00",
+			landlordShare: "500.00",
+			platformFee:   "0.00",
+		},
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ledger := memory.NewLedgerStore()
+			splitter := endpoints.NewSplitter(log, ledger, newCommissions(t, tc.rule))
+			amount := endpoints.Amount{Value: tc.amount, Currency: "RUB"}
+			metadata := &endpoints.Metadata{LandlordID: "42"}
+
+			require.NoError(t, splitter.SplitPayment(context.Background(), "payment-1", amount, metadata))
+			// Repeated notification isn't recorded twice
+			require.NoError(t, splitter.SplitPayment(context.Background(), "payment-1", amount, metadata))
+
+			entries, err := ledger.GetEntriesByReference(context.Background(), "payment-1")
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			assert.Equal(t, postgres.LandlordAccount("42"), entries[0].Account)
+			assert.Equal(t, tc.landlordShare, entries[0].Amount)
+			assert.Equal(t, postgres.PlatformAccount, entries[1].Account)
+			assert.Equal(t, tc.platformFee, entries[1].Amount)
+		})
+	}
+}
+
+func TestRefundPayment(t *testing.T) {
+	testCases := []struct {
+		name          string
+		refunds       []string
+		landlordShare []string
+		platformFee   []string
+	}{
+		{
+			name:          "Full refund",
+			refunds:       []string{"10000.00"},
+			landlordShare: []string{"-9420.00"},
+			platformFee:   []string{"-580.00"},
+		},
+		{
+			name:          "Partial refunds are prorated",
+			refunds:       []string{"5000.00", "333.33"},
+			landlordShare: []string{"-4710.00", "-314.00"},
+			platformFee:   []string{"-290.00", "-19.33"},
+		},
+		{
+			name:          "Refund is at most the payment",
+			refunds:       []string{"20000.00"},
+			landlordShare: []string{"-9420.00"},
+			platformFee:   []string{"-580.00"},
+		},
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rule := &postgres.CommissionRule{Percent: "5.5", Fixed: "30.00"}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			ledger := memory.NewLedgerStore()
+			splitter := endpoints.NewSplitter(log, ledger, newCommissions(t, rule))
+			amount := endpoints.Amount{Value: "10000.00", Currency: "RUB"}
+			require.NoError(t, splitter.SplitPayment(ctx, "payment-1", amount, &endpoints.Metadata{LandlordID: "42"}))
+
+			for i, refund := range tc.refunds {
+				refundID := fmt.Sprint("refund-", i)
+				// Refund succeeded later is reversed by the status check
+				splitter.OnFinalStatus(ctx, metrics.RefundsEndpoint, refundID, &webhook.CheckResponse{
+					Status:    metrics.Succeeded,
+					Amount:    &webhook.Amount{Value: refund, Currency: "RUB"},
+					PaymentID: "payment-1",
+				})
+				// Repeated notification isn't recorded twice
+				require.NoError(t, splitter.RefundPayment(ctx, refundID, "payment-1",
+					endpoints.Amount{Value: refund, Currency: "RUB"}))
+
+				entries, _ := ledger.GetEntriesByReference(ctx, refundID)
+				require.Len(t, entries, 2)
+				assert.Equal(t, postgres.LandlordAccount("42"), entries[0].Account)
+				assert.Equal(t, tc.landlordShare[i], entries[0].Amount)
+				assert.Equal(t, postgres.PlatformAccount, entries[1].Account)
+				assert.Equal(t, tc.platformFee[i], entries[1].Amount)
+			}
+		})
+	}
+
+	// Payment without landlord isn't split, so there is nothing to reverse
+	ledger := memory.NewLedgerStore()
+	splitter := endpoints.NewSplitter(log, ledger, newCommissions(t, rule))
+	require.NoError(t, splitter.RefundPayment(context.Background(), "refund-1", "payment-2",
+		endpoints.Amount{Value: "100.00", Currency: "RUB"}))
+	entries, err := ledger.GetEntriesByReference(context.Background(), "refund-1")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/metrics"
+	"github.com/imperatorofdwelling/Website-backend/internal/yookassa"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/memory"
+	"github.com/imperatorofdwelling/Website-backend/pkg/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sets global tracer provider which keeps ended spans in memory,
+// the previous provider and propagator are restored after the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+// endedSpans returns ended spans of the trace by name
+func endedSpans(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx, parent := tracing.Start(context.Background(), "parent", attribute.String("transaction_id", "t1"))
+	_, child := tracing.Start(ctx, "child")
+	tracing.End(child, errors.New("failed"))
+	traceparent := tracing.Inject(ctx)
+	tracing.End(parent, nil)
+
+	traceID := parent.SpanContext().TraceID()
+	assert.Equal(t, traceID.String(), tracing.TraceID(ctx))
+	spans := endedSpans(recorder, traceID)
+	require.Contains(t, spans, "parent")
+	require.Contains(t, spans, "child")
+	assert.Contains(t, spans["parent"].Attributes(), attribute.String("transaction_id", "t1"))
+	assert.Equal(t, codes.Unset, spans["parent"].Status().Code)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["child"].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans["child"].Status().Code)
+	assert.Equal(t, "failed", spans["child"].Status().Description)
+
+	// Background work starts a new trace linked to the request
+	_, linked := tracing.StartLinked(context.Background(), "linked", traceparent)
+	tracing.End(linked, nil)
+	assert.NotEqual(t, traceID, linked.SpanContext().TraceID())
+	spans = endedSpans(recorder, linked.SpanContext().TraceID())
+	require.Contains(t, spans, "linked")
+	require.Len(t, spans["linked"].Links(), 1)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["linked"].Links()[0].SpanContext.SpanID())
+
+	assert.Empty(t, tracing.TraceID(context.Background()))
+}
+
+func TestTracingPropagation(t *testing.T) {
+	Init()
+	recorder := recordSpans(t)
+
+	// YooKassa API records traceparent of the requests
+	var mu sync.Mutex
+	var sent []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent = append(sent, r.Header.Get("traceparent"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":         "2d0a4a3c-000f-5000-8000-1a2b3c4d5e6f",
+			"status":     metrics.Pending,
+			"amount":     map[string]string{"value": "100.00", "currency": "RUB"},
+			"created_at": time.Now().UTC().Format(time.RFC3339),
+			"confirmation": map[string]string{
+				"type":               endpoints.ConfirmationEmbedded,
+				"confirmation_token": "ct-2d0a4a3c",
+			},
+		})
+	}))
+	defer api.Close()
+	gateway := yookassa.NewClient(&metrics.YooKassaConfig{BaseURL: api.URL, StoreID: "store", SecretKey: "secret"})
+	logs := memory.NewLogRepository()
+	handler := newRouter(gateway, logs)
+
+	// The trace is continued from the caller
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	body, _ := json.Marshal(endpoints.NewCreate(activeUserID, "100.00", "RUB"))
+	req, _ := http.NewRequest("POST", "/payment/create", bytes.NewBuffer(body))
+	req.Header.Set("traceparent", "00-"+traceID.String()+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	spans := endedSpans(recorder, traceID)
+	require.Contains(t, spans, "POST /payment/create")
+	require.Contains(t, spans, "yookassa POST payments")
+	assert.Equal(t, "00f067aa0ba902b7", spans["POST /payment/create"].Parent().SpanID().String())
+
+	// The request to YooKassa carries the trace of the payment request
+	mu.Lock()
+	require.Len(t, sent, 1)
+	sentContext := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(),
+		propagation.MapCarrier{"traceparent": sent[0]}))
+	mu.Unlock()
+	assert.Equal(t, traceID, sentContext.TraceID())
+
+	// The transaction is linked to the trace in logs
+	l, err := logs.GetLog(context.Background(), "2d0a4a3c-000f-5000-8000-1a2b3c4d5e6f")
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	assert.Equal(t, traceID.String(), l.TraceID)
+}
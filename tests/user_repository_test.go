@@ -0,0 +1,61 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/imperatorofdwelling/Website-backend/pkg/repository/postgres"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserRepository(t *testing.T) {
+	ctx := context.Background()
+	db, err := postgres.Connect(newTestDatabase(t))
+	require.NoError(t, err)
+	defer db.Close()
+	users := postgres.NewUserRepository(db)
+
+	user := models.NewUser()
+	user.ExternalID = "site-42"
+	inserted, err := users.RegisterUser(ctx, user)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	assert.False(t, user.CreatedAt.IsZero())
+
+	// The user registered before is returned
+	again := models.NewUser()
+	again.ExternalID = "site-42"
+	again.Role = models.UserRoleLandlord
+	inserted, err = users.RegisterUser(ctx, again)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+	assert.Equal(t, user.Id, again.Id)
+	assert.Equal(t, models.UserRoleTenant, again.Role)
+
+	found, err := users.GetUserByExternalID(ctx, "site-42")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, user.Id, found.Id)
+	found, err = users.GetUser(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	changed, err := users.SetUserStatus(ctx, user.Id, models.UserStatusBlocked)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = users.SetUserStatus(ctx, user.Id, models.UserStatusBlocked)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	found, err = users.GetUser(ctx, user.Id)
+	require.NoError(t, err)
+	assert.True(t, found.IsBlocked())
+
+	_, err = users.SetUserStatus(ctx, user.Id, "deleted")
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imperatorofdwelling/Website-backend/internal/endpoints"
+	"github.com/imperatorofdwelling/Website-backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveUsers(token, method, url string, body any) *httptest.ResponseRecorder {
+	reqBodyBytes, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewBuffer(reqBodyBytes))
+	rr := httptest.NewRecorder()
+	if token != "" {
+		authorize(req, token)
+	}
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRegisterUserBadRequest(t *testing.T) {
+	Init()
+
+	testCases := []struct {
+		name          string
+		requestBody   *endpoints.RegisterUser
+		expectedError string
+	}{
+		{
+			name:          "Empty external id",
+			requestBody:   &endpoints.RegisterUser{},
+			expectedError: endpoints.ErrExternalID.Error(),
+		},
+		{
+			name:          "Unknown role",
+			requestBody:   &endpoints.RegisterUser{ExternalID: "site-42", Role: "admin"},
+			expectedError: endpoints.ErrUserRole.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := serveUsers("", "POST", "/users", tc.requestBody)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			respBody := new(endpoints.ErrorResponse)
+			_ = json.NewDecoder(rr.Body).Decode(respBody)
+			assert.Equal(t, tc.expectedError, respBody.Error)
+		})
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	Init()
+
+	externalID := "site-" + uuid.NewString()
+	rr := serveUsers("", "POST", "/users", &endpoints.RegisterUser{ExternalID: externalID, Role: models.UserRoleLandlord})
+	require.Equal(t, http.StatusCreated, rr.Code)
+	user := new(models.User)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(user))
+	assert.Equal(t, externalID, user.ExternalID)
+	assert.Equal(t, models.UserRoleLandlord, user.Role)
+	assert.Equal(t, models.UserStatusActive, user.Status)
+
+	// Registration is idempotent by external id
+	rr = serveUsers("", "POST", "/users", &endpoints.RegisterUser{ExternalID: externalID})
+	require.Equal(t, http.StatusOK, rr.Code)
+	registered := new(models.User)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(registered))
+	assert.Equal(t, user.Id, registered.Id)
+	assert.Equal(t, models.UserRoleLandlord, registered.Role)
+
+	rr = serveUsers("", "GET", "/users?external_id="+externalID, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serveUsers("", "GET", "/users/"+uuid.NewString(), nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	userURL := "/users/" + user.Id.String()
+	rr = serveUsers(adminToken, "POST", userURL+"/block", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serveUsers(adminToken, "POST", userURL+"/block", nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = serveUsers("", "GET", userURL, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(user))
+	assert.Equal(t, models.UserStatusBlocked, user.Status)
+
+	card := &endpoints.SaveCard{UserId: user.Id.String(), Synonym: "synonym", FirstSix: "555555", LastFour: "4444"}
+	rr = serveUsers("", "POST", "/save_card", card)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serveUsers(adminToken, "POST", userURL+"/unblock", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serveUsers("", "POST", "/save_card", card)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}